@@ -0,0 +1,62 @@
+// Package caller handles the services calling auth-api and which routes
+// they may call.
+package caller
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+)
+
+// AllRoutes allows a caller to call every route.
+const AllRoutes = "*"
+
+// Caller is a service calling auth-api.
+type Caller struct {
+	Name string
+	// Routes are the names of the routes the caller may call.
+	Routes []string
+}
+
+// Allowed tells whether the caller may call the route with the given name.
+func (c *Caller) Allowed(route string) bool {
+	for _, r := range c.Routes {
+		if r == route || r == AllRoutes {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fetcher is an interface for getting a caller by the hash of its API key
+// or by its name. Returns nil if there is no such caller.
+type Fetcher interface {
+	GetCallerByAPIKeyHash(ctx context.Context, apiKeyHash string) (*Caller, error)
+	NameFetcher
+}
+
+// Creator is an interface for registering a caller.
+type Creator interface {
+	CreateCaller(ctx context.Context, caller Caller, apiKeyHash string) error
+}
+
+// HashAPIKey returns the hash of an API key, which is what we store.
+// API keys are long random strings, so a plain SHA-256 is enough.
+func HashAPIKey(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateAPIKey generates a new random API key.
+func GenerateAPIKey() (string, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return "", fmt.Errorf("error generating api key: %w", err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(key), nil
+}
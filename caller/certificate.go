@@ -0,0 +1,46 @@
+package caller
+
+import (
+	"context"
+	"crypto/x509"
+)
+
+// NameFetcher is an interface for getting a caller by its name.
+// Returns nil if there is no such caller.
+type NameFetcher interface {
+	GetCallerByName(ctx context.Context, name string) (*Caller, error)
+}
+
+// CertificateIdentities maps certificate identities (URI SANs, DNS SANs or
+// the subject common name) to caller names.
+type CertificateIdentities map[string]string
+
+// NewCertificateIdentities builds the identities from a map of caller names
+// to certificate identities, as configured.
+func NewCertificateIdentities(callers map[string]string) CertificateIdentities {
+	identities := make(CertificateIdentities, len(callers))
+	for name, identity := range callers {
+		identities[identity] = name
+	}
+
+	return identities
+}
+
+// CallerName returns the name of the caller a verified client certificate
+// belongs to, or an empty string if the certificate is not mapped to any.
+// URI SANs are checked first, then DNS SANs and last the common name.
+func (ci CertificateIdentities) CallerName(cert *x509.Certificate) string {
+	for _, uri := range cert.URIs {
+		if name, ok := ci[uri.String()]; ok {
+			return name
+		}
+	}
+
+	for _, dnsName := range cert.DNSNames {
+		if name, ok := ci[dnsName]; ok {
+			return name
+		}
+	}
+
+	return ci[cert.Subject.CommonName]
+}
@@ -0,0 +1,402 @@
+// Package authapi is the Go client of auth-api, for services that issue
+// tokens or look up the users behind them.
+//
+//	c := &authapi.Client{BaseURL: "https://auth-api.internal", APIKey: key}
+//	userID, err := c.Identity(ctx, token)
+//	if errors.Is(err, authapi.ErrInvalidToken) {
+//		...
+//	}
+//
+// Errors responded by auth-api are returned as *Error, which matches the
+// errors of this package by code with errors.Is. The package has its own
+// types of what auth-api sends, so services don't depend on the server.
+// Requests that only read are retried with backoff when auth-api is
+// unavailable, and every request carries the span of ctx.
+package authapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+)
+
+const (
+	v1API string = "/api/v1"
+
+	defaultTimeout    = 10 * time.Second
+	defaultMaxRetries = 2
+	defaultRetryBase  = 100 * time.Millisecond
+	defaultRetryMax   = 2 * time.Second
+)
+
+// Client calls auth-api. The zero value of each field but BaseURL is a
+// sensible default. A Client is safe for concurrent use.
+type Client struct {
+	// BaseURL is the URL auth-api is served at, e.g. "https://auth-api.internal".
+	BaseURL string
+	// APIKey is sent in the X-Api-Key header, for the internal routes.
+	// Services authenticating with a client certificate set it in the
+	// transport of HTTPClient instead.
+	APIKey string
+	// HTTPClient sends the requests. Defaults to a client with a 10s timeout.
+	HTTPClient *http.Client
+	// MaxRetries is how many times a failed read is retried. Defaults to 2,
+	// a negative value disables retries.
+	MaxRetries int
+	// RetryBase is the delay before the first retry, doubled for each next
+	// one up to RetryMax. Default to 100ms and 2s.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+	// Cache, if set, keeps the users of tokens looked up with Identity and
+	// IdentityBatch.
+	Cache *TokenCache
+}
+
+var defaultHTTPClient = &http.Client{Timeout: defaultTimeout}
+
+// CreateToken creates a token for the user with the login, creating the user
+// if it does not exist yet.
+//
+// Creating tokens is not idempotent, so failed requests are not retried.
+func (c *Client) CreateToken(ctx context.Context, payload CreateTokenPayload) (string, error) {
+	var response struct {
+		Token string `json:"token"`
+	}
+	if err := c.do(ctx, "CreateToken", http.MethodPost, "/tokens", nil, payload, &response, false); err != nil {
+		return "", err
+	}
+
+	return response.Token, nil
+}
+
+// RefreshToken exchanges a token for a new one. The old token is no longer
+// valid afterwards, so failed requests are not retried.
+func (c *Client) RefreshToken(ctx context.Context, token string) (string, error) {
+	var response struct {
+		Token string `json:"token"`
+	}
+	query := url.Values{"token": {token}}
+	if err := c.do(ctx, "RefreshToken", http.MethodPost, "/refresh-tokens", query, nil, &response, false); err != nil {
+		return "", err
+	}
+
+	if c.Cache != nil {
+		c.Cache.Remove(token)
+	}
+
+	return response.Token, nil
+}
+
+// Identity returns the id of the user of a token. Returns an error matching
+// ErrInvalidToken if the token does not exist.
+func (c *Client) Identity(ctx context.Context, token string) (string, error) {
+	if c.Cache != nil {
+		if userID, ok := c.Cache.Get(token); ok {
+			return userID, nil
+		}
+	}
+
+	var response struct {
+		UserID string `json:"user_id"`
+	}
+	query := url.Values{"token": {token}}
+	if err := c.do(ctx, "Identity", http.MethodGet, "/tokens", query, nil, &response, true); err != nil {
+		return "", err
+	}
+
+	// auth-api responds with an empty user id for tokens it does not know.
+	if response.UserID == "" {
+		return "", fmt.Errorf("authapi Identity: %w", ErrInvalidToken)
+	}
+
+	if c.Cache != nil {
+		c.Cache.Set(token, response.UserID)
+	}
+
+	return response.UserID, nil
+}
+
+// TokenIdentity returns the user of a token with the auth user types and
+// methods of the user's logins, e.g. to authorize the user by type without
+// fetching their personal data. Returns an error matching ErrInvalidToken if
+// the token does not exist.
+func (c *Client) TokenIdentity(ctx context.Context, token string) (*TokenIdentity, error) {
+	if c.Cache != nil {
+		if identity, ok := c.Cache.GetIdentity(token); ok {
+			return identity, nil
+		}
+	}
+
+	var identity TokenIdentity
+	query := url.Values{"token": {token}}
+	if err := c.do(ctx, "TokenIdentity", http.MethodGet, "/tokens/identity", query, nil, &identity, true); err != nil {
+		return nil, err
+	}
+
+	if identity.UserID == "" {
+		return nil, fmt.Errorf("authapi TokenIdentity: %w", ErrInvalidToken)
+	}
+
+	if c.Cache != nil {
+		c.Cache.SetIdentity(token, &identity)
+	}
+
+	return &identity, nil
+}
+
+// IdentityBatch returns the ids of the users of many tokens at once. Tokens
+// that do not exist are left out of the returned map.
+func (c *Client) IdentityBatch(ctx context.Context, tokens []string) (map[string]string, error) {
+	userIDs := make(map[string]string, len(tokens))
+
+	missing := tokens
+	if c.Cache != nil {
+		missing = make([]string, 0, len(tokens))
+		for _, token := range tokens {
+			if userID, ok := c.Cache.Get(token); ok {
+				userIDs[token] = userID
+			} else {
+				missing = append(missing, token)
+			}
+		}
+	}
+
+	if len(missing) == 0 {
+		return userIDs, nil
+	}
+
+	var response struct {
+		Results []struct {
+			Token  string `json:"token"`
+			Found  bool   `json:"found"`
+			UserID string `json:"user_id"`
+		} `json:"results"`
+	}
+	payload := struct {
+		Tokens []string `json:"tokens"`
+	}{
+		Tokens: missing,
+	}
+	if err := c.do(ctx, "IdentityBatch", http.MethodPost, "/tokens/batch", nil, payload, &response, true); err != nil {
+		return nil, err
+	}
+
+	for _, result := range response.Results {
+		if !result.Found {
+			continue
+		}
+
+		userIDs[result.Token] = result.UserID
+		if c.Cache != nil {
+			c.Cache.Set(result.Token, result.UserID)
+		}
+	}
+
+	return userIDs, nil
+}
+
+// PersonalData returns the personal data of a user. Returns an error
+// matching ErrNotFound if the user does not exist.
+func (c *Client) PersonalData(ctx context.Context, userID string) (*PersonalData, error) {
+	var personalData PersonalData
+	query := url.Values{"userId": {userID}}
+	if err := c.do(ctx, "PersonalData", http.MethodGet, "/personal-data", query, nil, &personalData, true); err != nil {
+		return nil, err
+	}
+
+	return &personalData, nil
+}
+
+// PersonalDataBatch returns the personal data of many users at once. Users
+// that do not exist are left out of the returned map.
+func (c *Client) PersonalDataBatch(ctx context.Context, userIDs []string) (map[string]*PersonalData, error) {
+	var response struct {
+		Results []struct {
+			UserID       string        `json:"user_id"`
+			Found        bool          `json:"found"`
+			PersonalData *PersonalData `json:"personal_data"`
+		} `json:"results"`
+	}
+	payload := struct {
+		UserIDs []string `json:"user_ids"`
+	}{
+		UserIDs: userIDs,
+	}
+	if err := c.do(ctx, "PersonalDataBatch", http.MethodPost, "/personal-data/batch", nil, payload, &response, true); err != nil {
+		return nil, err
+	}
+
+	personalData := make(map[string]*PersonalData, len(response.Results))
+	for _, result := range response.Results {
+		if result.Found {
+			personalData[result.UserID] = result.PersonalData
+		}
+	}
+
+	return personalData, nil
+}
+
+// do sends a request to the route at path and decodes the response into
+// response. Reads are retried while the failure is temporary.
+func (c *Client) do(
+	ctx context.Context,
+	operation, method, path string,
+	query url.Values,
+	payload, response interface{},
+	retry bool,
+) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "authapi."+operation)
+	defer span.Finish()
+	ext.SpanKindRPCClient.Set(span)
+	ext.HTTPMethod.Set(span, method)
+
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return fmt.Errorf("error marshalling %s payload: %w", operation, err)
+		}
+	}
+
+	u := strings.TrimSuffix(c.BaseURL, "/") + v1API + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+
+	maxRetries := c.MaxRetries
+	if maxRetries == 0 {
+		maxRetries = defaultMaxRetries
+	}
+	if !retry {
+		maxRetries = 0
+	}
+
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := c.send(ctx, span, method, u, body, response)
+		if err == nil {
+			return nil
+		}
+
+		if attempt >= maxRetries || !temporary(ctx, err) {
+			span.LogFields(otlog.Error(err))
+			ext.Error.Set(span, true)
+			return fmt.Errorf("authapi %s: %w", operation, err)
+		}
+
+		delay := c.retryDelay(attempt)
+		if retryAfter > delay {
+			delay = retryAfter
+		}
+		span.LogFields(otlog.Error(err), otlog.String("retry_in", delay.String()))
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("authapi %s: %w", operation, ctx.Err())
+		case <-time.After(delay):
+		}
+	}
+}
+
+// send sends a request once. retryAfter is the delay asked for by a 429 or
+// 503 response.
+func (c *Client) send(
+	ctx context.Context,
+	span opentracing.Span,
+	method, u string,
+	body []byte,
+	response interface{},
+) (retryAfter time.Duration, err error) {
+	req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if c.APIKey != "" {
+		req.Header.Set("X-Api-Key", c.APIKey)
+	}
+
+	_ = opentracing.GlobalTracer().Inject(
+		span.Context(),
+		opentracing.HTTPHeaders,
+		opentracing.HTTPHeadersCarrier(req.Header),
+	)
+
+	httpClient := c.HTTPClient
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	ext.HTTPStatusCode.Set(span, uint16(resp.StatusCode))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return retryAfter, decodeError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+		return 0, fmt.Errorf("error decoding response: %w", err)
+	}
+
+	return 0, nil
+}
+
+// retryDelay returns how long to wait before retrying a request that failed
+// attempts+1 times, with jitter so clients don't retry in lockstep.
+func (c *Client) retryDelay(attempts int) time.Duration {
+	base, max := c.RetryBase, c.RetryMax
+	if base == 0 {
+		base = defaultRetryBase
+	}
+	if max == 0 {
+		max = defaultRetryMax
+	}
+
+	delay := float64(base) * math.Pow(2, float64(attempts))
+	if delay > float64(max) {
+		delay = float64(max)
+	}
+
+	return time.Duration(delay/2 + rand.Float64()*delay/2)
+}
+
+// temporary tells whether a request failing with err may succeed if retried.
+// Nothing is retried once ctx is done.
+func temporary(ctx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+
+	// Errors from the transport, like refused connections.
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
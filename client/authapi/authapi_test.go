@@ -0,0 +1,171 @@
+package authapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		body      string
+		call      func(c *Client) error
+		wantErr   error
+		wantCode  ErrorCode
+		wantCalls int
+	}{
+		{
+			name:   "unknown token",
+			status: http.StatusOK,
+			body:   `{"user_id":""}`,
+			call: func(c *Client) error {
+				_, err := c.Identity(context.Background(), "token")
+				return err
+			},
+			wantErr:   ErrInvalidToken,
+			wantCode:  CodeInvalidToken,
+			wantCalls: 1,
+		},
+		{
+			name:   "error envelope matches by code",
+			status: http.StatusForbidden,
+			body:   `{"namespace":"auth-api","error":"user_blocked","message":"user is blocked"}`,
+			call: func(c *Client) error {
+				_, err := c.CreateToken(context.Background(), CreateTokenPayload{Login: "+77011234567"})
+				return err
+			},
+			wantErr:   ErrUserBlocked,
+			wantCode:  CodeUserBlocked,
+			wantCalls: 1,
+		},
+		{
+			name:   "unknown user",
+			status: http.StatusNotFound,
+			body:   `{"error":"not_found","message":"user not found"}`,
+			call: func(c *Client) error {
+				_, err := c.PersonalData(context.Background(), "user")
+				return err
+			},
+			wantErr:   ErrNotFound,
+			wantCode:  CodeNotFound,
+			wantCalls: 1,
+		},
+		{
+			name:   "reads are retried while unavailable",
+			status: http.StatusServiceUnavailable,
+			body:   `upstream unavailable`,
+			call: func(c *Client) error {
+				_, err := c.Identity(context.Background(), "token")
+				return err
+			},
+			wantCalls: 3,
+		},
+		{
+			name:   "token creation is not retried",
+			status: http.StatusServiceUnavailable,
+			call: func(c *Client) error {
+				_, err := c.CreateToken(context.Background(), CreateTokenPayload{Login: "+77011234567"})
+				return err
+			},
+			wantCalls: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				calls++
+				w.WriteHeader(tt.status)
+				_, _ = w.Write([]byte(tt.body))
+			}))
+			defer server.Close()
+
+			c := &Client{BaseURL: server.URL, RetryBase: time.Millisecond, RetryMax: time.Millisecond}
+
+			err := tt.call(c)
+			if err == nil {
+				t.Fatal("call succeeded, want an error")
+			}
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if errors.Is(err, ErrRateLimited) {
+				t.Errorf("error = %v matches %v", err, ErrRateLimited)
+			}
+
+			var apiErr *Error
+			if !errors.As(err, &apiErr) {
+				t.Fatalf("error = %v, want an *Error", err)
+			}
+			if apiErr.Code != tt.wantCode {
+				t.Errorf("code = %q, want %q", apiErr.Code, tt.wantCode)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("auth-api called %d times, want %d", calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestPersonalDataBatch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"results":[
+			{"user_id":"a","found":true,"personal_data":{"user_id":"a","email":"jane@example.com","logins":[{"login":"jane@example.com","type":"email","auth_user_type":"client","auth_method":"password","verified":true,"verified_at":"2024-01-02T03:04:05Z"}]}},
+			{"user_id":"b","found":false}
+		]}`))
+	}))
+	defer server.Close()
+
+	c := &Client{BaseURL: server.URL}
+	personalData, err := c.PersonalDataBatch(context.Background(), []string{"a", "b"})
+	if err != nil {
+		t.Fatalf("PersonalDataBatch() error = %v", err)
+	}
+
+	if len(personalData) != 1 || personalData["a"] == nil {
+		t.Fatalf("PersonalDataBatch() = %v, want only a", personalData)
+	}
+	logins := personalData["a"].Logins
+	if len(logins) != 1 || logins[0].Type != "email" || logins[0].AuthUserType != "client" || logins[0].VerifiedAt == nil {
+		t.Errorf("logins = %+v", logins)
+	}
+}
+
+func TestTokenIdentityCache(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path != "/api/v1/tokens/identity" || r.URL.Query().Get("token") != "token" {
+			t.Errorf("request = %s, want the identity of token", r.URL)
+		}
+		_, _ = w.Write([]byte(`{"user_id":"user","auth_user_types":["driver"],"auth_methods":["sms"]}`))
+	}))
+	defer server.Close()
+
+	c := &Client{BaseURL: server.URL, Cache: &TokenCache{}}
+
+	for i := 0; i < 2; i++ {
+		identity, err := c.TokenIdentity(context.Background(), "token")
+		if err != nil {
+			t.Fatalf("TokenIdentity() error = %v", err)
+		}
+		if identity.UserID != "user" || len(identity.AuthUserTypes) != 1 || identity.AuthUserTypes[0] != "driver" {
+			t.Errorf("TokenIdentity() = %+v", identity)
+		}
+	}
+
+	// The identity also answers Identity.
+	if userID, err := c.Identity(context.Background(), "token"); err != nil || userID != "user" {
+		t.Errorf("Identity() = %q, %v, want user", userID, err)
+	}
+
+	if calls != 1 {
+		t.Errorf("auth-api called %d times, want 1", calls)
+	}
+}
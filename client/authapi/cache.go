@@ -0,0 +1,115 @@
+package authapi
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	defaultCacheTTL  = time.Minute
+	defaultCacheSize = 10000
+)
+
+// TokenCache keeps the users of tokens, and their identities once looked up
+// with TokenIdentity, in memory, so tokens used again and again are not
+// looked up every time. A token revoked in auth-api is still
+// accepted until its entry expires, so TTL should be short. The zero value
+// is ready to use.
+type TokenCache struct {
+	// TTL is how long a token is kept. Defaults to a minute.
+	TTL time.Duration
+	// MaxSize is how many tokens are kept at most. Defaults to 10000.
+	MaxSize int
+
+	mu      sync.Mutex
+	entries map[string]cachedUser
+}
+
+type cachedUser struct {
+	userID   string
+	identity *TokenIdentity
+	expires  time.Time
+}
+
+// Get returns the user id of a token, if it is cached and not expired.
+func (tc *TokenCache) Get(token string) (string, bool) {
+	entry, ok := tc.get(token)
+	return entry.userID, ok
+}
+
+// GetIdentity returns the identity of a token, if it is cached and not
+// expired.
+func (tc *TokenCache) GetIdentity(token string) (*TokenIdentity, bool) {
+	entry, ok := tc.get(token)
+	if !ok || entry.identity == nil {
+		return nil, false
+	}
+	return entry.identity, true
+}
+
+func (tc *TokenCache) get(token string) (cachedUser, bool) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	entry, ok := tc.entries[token]
+	if !ok || time.Now().After(entry.expires) {
+		return cachedUser{}, false
+	}
+
+	return entry, true
+}
+
+// Set caches the user id of a token.
+func (tc *TokenCache) Set(token, userID string) {
+	tc.set(token, cachedUser{userID: userID})
+}
+
+// SetIdentity caches the identity of a token, and so its user id.
+func (tc *TokenCache) SetIdentity(token string, identity *TokenIdentity) {
+	tc.set(token, cachedUser{userID: identity.UserID, identity: identity})
+}
+
+func (tc *TokenCache) set(token string, entry cachedUser) {
+	ttl := tc.TTL
+	if ttl == 0 {
+		ttl = defaultCacheTTL
+	}
+	maxSize := tc.MaxSize
+	if maxSize == 0 {
+		maxSize = defaultCacheSize
+	}
+
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.entries == nil {
+		tc.entries = make(map[string]cachedUser)
+	}
+
+	// Drop expired entries when full, and any entries if that is not enough.
+	if len(tc.entries) >= maxSize {
+		now := time.Now()
+		for key, entry := range tc.entries {
+			if now.After(entry.expires) {
+				delete(tc.entries, key)
+			}
+		}
+		for key := range tc.entries {
+			if len(tc.entries) < maxSize {
+				break
+			}
+			delete(tc.entries, key)
+		}
+	}
+
+	entry.expires = time.Now().Add(ttl)
+	tc.entries[token] = entry
+}
+
+// Remove forgets a token, e.g. once it was refreshed.
+func (tc *TokenCache) Remove(token string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	delete(tc.entries, token)
+}
@@ -0,0 +1,91 @@
+package authapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	"gitlab.com/route-kz/auth-api/client"
+)
+
+// maxErrorBody is how much of an error response is read.
+const maxErrorBody = 64 << 10
+
+// Error is an error responded by auth-api. Code is empty when the response
+// had no error envelope, e.g. when it came from a proxy in front of auth-api.
+type Error struct {
+	StatusCode int
+	Namespace  string
+	Code       ErrorCode
+	Message    string
+	Fields     map[string]string
+}
+
+func (e *Error) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("status %d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("status %d: %s: %s", e.StatusCode, e.Code, e.Message)
+}
+
+// Is makes errors.Is match errors by code, e.g. errors.Is(err,
+// ErrInvalidToken).
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && e.Code != "" && t.Code == e.Code
+}
+
+// Temporary tells whether the request may succeed if retried: auth-api was
+// unavailable or throttled it.
+func (e *Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests,
+		http.StatusBadGateway,
+		http.StatusServiceUnavailable,
+		http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// Wrapper returns the error as a client.ErrorCodeWrapper, for services that
+// propagate the errors of auth-api to their own clients as they are.
+func (e *Error) Wrapper() client.ErrorCodeWrapper {
+	return client.ErrorCodeWrapper{
+		Err:        e,
+		StatusCode: e.StatusCode,
+		ResponseBody: client.ErrorCodeResponseBody{
+			Namespace: e.Namespace,
+			Error:     string(e.Code),
+			Message:   e.Message,
+			Fields:    e.Fields,
+			Propagate: true,
+		},
+	}
+}
+
+// decodeError reads the error envelope of a failed response.
+func decodeError(resp *http.Response) error {
+	apiErr := &Error{
+		StatusCode: resp.StatusCode,
+		Message:    http.StatusText(resp.StatusCode),
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return apiErr
+	}
+
+	var envelope client.ErrorCodeResponseBody
+	if err := json.Unmarshal(body, &envelope); err != nil || envelope.Error == "" {
+		return apiErr
+	}
+
+	apiErr.Namespace = envelope.Namespace
+	apiErr.Code = ErrorCode(envelope.Error)
+	apiErr.Message = envelope.Message
+	apiErr.Fields = envelope.Fields
+
+	return apiErr
+}
@@ -0,0 +1,272 @@
+// Package middleware authenticates the end users of downstream services by
+// the tokens auth-api issued them, so services don't each look tokens up by
+// hand:
+//
+//	auth := &middleware.Authenticator{Client: &authapi.Client{BaseURL: url, APIKey: key}}
+//	r := mux.NewRouter()
+//	r.Use(auth.Middleware)
+//	r.Handle("/orders", auth.RequireUserTypes("client")(orders))
+//
+//	func orders(w http.ResponseWriter, r *http.Request) {
+//		principal, _ := middleware.PrincipalFromContext(r.Context())
+//		...
+//	}
+//
+// The middleware are plain func(http.Handler) http.Handler, so they work
+// with net/http as well as with gorilla/mux. Tokens of auth-api are opaque,
+// not signed, so they are always checked against auth-api; set a
+// authapi.TokenCache on the client to check each token once per TTL.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/client"
+	"gitlab.com/route-kz/auth-api/client/authapi"
+)
+
+type contextKey string
+
+const principalContextKey contextKey = "principal"
+
+// Principal is the end user a request was authenticated as.
+type Principal struct {
+	UserID string
+	// Token is the token the request was authenticated with.
+	Token string
+	// UserType is the auth user type the token was issued for, e.g.
+	// "client" or "driver", and empty if unknown. A user with logins of
+	// several types gets a token per type, so it is not any type of the
+	// user's logins. It is only looked up, with the token identity, for
+	// routes requiring user types.
+	UserType string
+	// Scopes are the scopes the service granted the user, see
+	// Authenticator.Scopes.
+	Scopes []string
+
+	identified bool
+}
+
+// HasUserType tells whether the token was issued for one of the user types.
+func (p *Principal) HasUserType(userTypes ...string) bool {
+	return p.UserType != "" && containsAny([]string{p.UserType}, userTypes)
+}
+
+// HasScopes tells whether the user was granted all the scopes.
+func (p *Principal) HasScopes(scopes ...string) bool {
+	for _, scope := range scopes {
+		if !containsAny(p.Scopes, []string{scope}) {
+			return false
+		}
+	}
+	return true
+}
+
+// PrincipalFromContext returns the principal a request was authenticated as.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalContextKey).(*Principal)
+	return principal, ok
+}
+
+// ContextWithPrincipal returns a copy of ctx carrying principal, e.g. for
+// tests of handlers behind the middleware.
+func ContextWithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalContextKey, principal)
+}
+
+// Client is the part of auth-api the middleware uses, implemented by
+// *authapi.Client.
+type Client interface {
+	Identity(ctx context.Context, token string) (string, error)
+	TokenIdentity(ctx context.Context, token string) (*authapi.TokenIdentity, error)
+}
+
+// Authenticator is the configuration for the middleware authenticating end
+// users by their "Authorization: Bearer <token>" header.
+type Authenticator struct {
+	Client Client
+	// Scopes, if set, returns the scopes the service grants a principal.
+	// auth-api knows nothing about scopes, they are up to each service.
+	Scopes func(ctx context.Context, principal *Principal) ([]string, error)
+	// Optional lets requests without a token through unauthenticated, for
+	// routes that serve anonymous users too. Requests with an invalid token
+	// are still rejected.
+	Optional bool
+	// WriteError, if set, responds with the errors of the middleware.
+	// Defaults to the error envelope of auth-api.
+	WriteError func(w http.ResponseWriter, r *http.Request, e client.ErrorCodeWrapper)
+}
+
+// Middleware rejects requests without a valid token and puts the principal
+// into the request context.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		token := bearerToken(r)
+		if token == "" {
+			if a.Optional {
+				next.ServeHTTP(w, r)
+				return
+			}
+			a.writeError(w, r, http.StatusUnauthorized, authapi.CodeUnauthorized, "missing bearer token")
+			return
+		}
+
+		userID, err := a.Client.Identity(ctx, token)
+		if err != nil {
+			a.handleError(w, r, err)
+			return
+		}
+
+		principal := &Principal{
+			UserID: userID,
+			Token:  token,
+		}
+
+		if a.Scopes != nil {
+			if principal.Scopes, err = a.Scopes(ctx, principal); err != nil {
+				a.handleError(w, r, err)
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r.WithContext(ContextWithPrincipal(ctx, principal)))
+	})
+}
+
+// RequireUserTypes returns middleware rejecting requests with tokens not
+// issued for one of the user types, including tokens of other types of the
+// same user and tokens of unknown type. It goes behind Middleware.
+func (a *Authenticator) RequireUserTypes(userTypes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				a.writeError(w, r, http.StatusUnauthorized, authapi.CodeUnauthorized, "missing bearer token")
+				return
+			}
+
+			if !principal.identified {
+				identity, err := a.Client.TokenIdentity(r.Context(), principal.Token)
+				if err != nil {
+					a.handleError(w, r, err)
+					return
+				}
+
+				principal.UserType = identity.AuthUserType
+				principal.identified = true
+			}
+
+			if !principal.HasUserType(userTypes...) {
+				a.writeError(w, r, http.StatusForbidden, authapi.CodeForbidden, "user type not allowed")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RequireScopes returns middleware rejecting requests of users not granted
+// all the scopes. It goes behind Middleware.
+func (a *Authenticator) RequireScopes(scopes ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := PrincipalFromContext(r.Context())
+			if !ok {
+				a.writeError(w, r, http.StatusUnauthorized, authapi.CodeUnauthorized, "missing bearer token")
+				return
+			}
+
+			if !principal.HasScopes(scopes...) {
+				a.writeError(w, r, http.StatusForbidden, authapi.CodeForbidden, "missing scope")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// handleError responds with an error of auth-api, or with a 503 if auth-api
+// could not be asked.
+func (a *Authenticator) handleError(w http.ResponseWriter, r *http.Request, err error) {
+	var apiErr *authapi.Error
+
+	switch {
+	case errors.Is(err, authapi.ErrInvalidToken):
+		a.writeError(w, r, http.StatusUnauthorized, authapi.CodeInvalidToken, authapi.ErrInvalidToken.Message)
+	case errors.Is(err, authapi.ErrTokenExpired):
+		a.writeError(w, r, http.StatusUnauthorized, authapi.CodeTokenExpired, authapi.ErrTokenExpired.Message)
+	case errors.Is(err, authapi.ErrUserBlocked):
+		a.writeError(w, r, http.StatusForbidden, authapi.CodeUserBlocked, authapi.ErrUserBlocked.Message)
+	case errors.As(err, &apiErr) && apiErr.StatusCode < http.StatusInternalServerError && !apiErr.Temporary():
+		// Anything else auth-api rejects is a misconfiguration of the service,
+		// e.g. an API key not allowed to call Identity.
+		log.Errorf("error authenticating request: %s", err)
+		a.writeError(w, r, http.StatusInternalServerError, authapi.CodeInternal, http.StatusText(http.StatusInternalServerError))
+	default:
+		log.Errorf("error authenticating request: %s", err)
+		a.writeError(w, r, http.StatusServiceUnavailable, authapi.CodeInternal, "authentication unavailable")
+	}
+}
+
+// writeError responds with an error of the middleware.
+func (a *Authenticator) writeError(w http.ResponseWriter, r *http.Request, statusCode int, code authapi.ErrorCode, message string) {
+	e := client.ErrorCodeWrapper{
+		StatusCode: statusCode,
+		ResponseBody: client.ErrorCodeResponseBody{
+			Error:   string(code),
+			Message: message,
+		},
+	}
+
+	switch {
+	case code == authapi.CodeUnauthorized:
+		w.Header().Set("WWW-Authenticate", "Bearer")
+	case statusCode == http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	}
+
+	if a.WriteError != nil {
+		a.WriteError(w, r, e)
+		return
+	}
+
+	body, err := e.GetResponseBody()
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(body)
+}
+
+func bearerToken(r *http.Request) string {
+	const prefix = "bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+func containsAny(values, wanted []string) bool {
+	for _, v := range values {
+		for _, w := range wanted {
+			if v == w {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,82 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/client/authapi"
+)
+
+type fakeClient struct {
+	identities      map[string]*authapi.TokenIdentity
+	identityCalls   int
+	tokenIdentities int
+}
+
+func (c *fakeClient) Identity(ctx context.Context, token string) (string, error) {
+	c.identityCalls++
+	identity, ok := c.identities[token]
+	if !ok {
+		return "", fmt.Errorf("authapi Identity: %w", authapi.ErrInvalidToken)
+	}
+	return identity.UserID, nil
+}
+
+func (c *fakeClient) TokenIdentity(ctx context.Context, token string) (*authapi.TokenIdentity, error) {
+	c.tokenIdentities++
+	identity, ok := c.identities[token]
+	if !ok {
+		return nil, fmt.Errorf("authapi TokenIdentity: %w", authapi.ErrInvalidToken)
+	}
+	return identity, nil
+}
+
+func TestRequireUserTypes(t *testing.T) {
+	client := &fakeClient{identities: map[string]*authapi.TokenIdentity{
+		"driver-token":        {UserID: "driver", AuthUserType: "driver", AuthUserTypes: []string{"client", "driver"}},
+		"driver-client-token": {UserID: "driver", AuthUserType: "client", AuthUserTypes: []string{"client", "driver"}},
+		"legacy-token":        {UserID: "driver", AuthUserTypes: []string{"client", "driver"}},
+		"client-token":        {UserID: "client", AuthUserType: "client", AuthUserTypes: []string{"client"}},
+	}}
+
+	tests := []struct {
+		name           string
+		authorization  string
+		wantStatus     int
+		wantIdentities int
+	}{
+		{"token of the type", "Bearer driver-token", http.StatusOK, 1},
+		{"token of another type of a user of the type", "Bearer driver-client-token", http.StatusForbidden, 1},
+		{"token of unknown type", "Bearer legacy-token", http.StatusForbidden, 1},
+		{"user of another type", "Bearer client-token", http.StatusForbidden, 1},
+		{"unknown token", "Bearer unknown", http.StatusUnauthorized, 0},
+		{"no token", "", http.StatusUnauthorized, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client.tokenIdentities = 0
+			auth := &Authenticator{Client: client}
+			h := auth.Middleware(auth.RequireUserTypes("driver")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})))
+
+			r := httptest.NewRequest(http.MethodGet, "/trips", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if client.tokenIdentities != tt.wantIdentities {
+				t.Errorf("TokenIdentity called %d times, want %d", client.tokenIdentities, tt.wantIdentities)
+			}
+		})
+	}
+}
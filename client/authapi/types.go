@@ -0,0 +1,83 @@
+package authapi
+
+import "time"
+
+// ErrorCode is the machine-readable code of an error responded by auth-api.
+type ErrorCode string
+
+// The codes auth-api responds with. New ones may be added.
+const (
+	CodeInvalidCredentials ErrorCode = "invalid_credentials"
+	CodeInvalidToken       ErrorCode = "invalid_token"
+	CodeTokenExpired       ErrorCode = "token_expired"
+	CodeUserBlocked        ErrorCode = "user_blocked"
+	CodeValidationFailed   ErrorCode = "validation_failed"
+	CodeLoginTaken         ErrorCode = "login_taken"
+	CodeLoginAlreadyLinked ErrorCode = "login_already_linked"
+	CodeLoginNotFound      ErrorCode = "login_not_found"
+	CodeLastLogin          ErrorCode = "last_login"
+	CodeInvalidCode        ErrorCode = "invalid_code"
+	CodeLoginCoolingDown   ErrorCode = "login_cooling_down"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeBadRequest         ErrorCode = "bad_request"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	CodeRequestTooLarge    ErrorCode = "request_too_large"
+	CodeInternal           ErrorCode = "internal_error"
+)
+
+// Errors to match the errors of the client with errors.Is, which compares
+// their codes.
+var (
+	ErrInvalidCredentials = &Error{Code: CodeInvalidCredentials, Message: "invalid credentials"}
+	ErrInvalidToken       = &Error{Code: CodeInvalidToken, Message: "invalid token"}
+	ErrTokenExpired       = &Error{Code: CodeTokenExpired, Message: "token expired"}
+	ErrUserBlocked        = &Error{Code: CodeUserBlocked, Message: "user is blocked"}
+	ErrValidationFailed   = &Error{Code: CodeValidationFailed, Message: "validation failed"}
+	ErrRateLimited        = &Error{Code: CodeRateLimited, Message: "rate limit exceeded"}
+	// ErrNotFound is returned for users that do not exist, among others.
+	ErrNotFound = &Error{Code: CodeNotFound, Message: "not found"}
+)
+
+// CreateTokenPayload is the login a token is created for.
+type CreateTokenPayload struct {
+	Login        string `json:"login"`
+	AuthUserType string `json:"auth_user_type"`
+	AuthCode     string `json:"auth_code"`
+	AuthMethod   string `json:"auth_method"`
+}
+
+// TokenIdentity is who a token identifies: the user, with the auth user
+// type the token was issued for, empty if unknown, and the auth user types
+// and methods of all the user's logins.
+type TokenIdentity struct {
+	UserID        string   `json:"user_id"`
+	AuthUserType  string   `json:"auth_user_type"`
+	AuthUserTypes []string `json:"auth_user_types"`
+	AuthMethods   []string `json:"auth_methods"`
+}
+
+// PersonalData is the personal data of a user: their logins, and the phone
+// number, email and username among them.
+type PersonalData struct {
+	UserID        string  `json:"user_id"`
+	PhoneNumber   string  `json:"phone_number"`
+	PhoneVerified bool    `json:"phone_verified"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	Username      string  `json:"username,omitempty"`
+	Logins        []Login `json:"logins"`
+}
+
+// Login is a login of a user. Type is "phone", "email", "username" or
+// "external".
+type Login struct {
+	Login        string     `json:"login"`
+	Type         string     `json:"type"`
+	AuthUserType string     `json:"auth_user_type"`
+	AuthMethod   string     `json:"auth_method"`
+	Verified     bool       `json:"verified"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+}
@@ -0,0 +1,115 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+func (c *Client) prepareGetUserIDsByTokensStmt() error {
+	stmt, err := c.DB.Preparex(fmt.Sprintf(`
+		SELECT
+			token,
+			user_id
+		FROM tokens
+		WHERE token = ANY($1)%s;
+	`, c.tokenLifetimeCondition()))
+	if err != nil {
+		return fmt.Errorf("error preparing get user ids by tokens statement: %w", err)
+	}
+	c.GetUserIDsByTokensStmt = stmt
+	return nil
+}
+
+func (c *Client) prepareFetchPersonalDataBatchStmt() error {
+	stmt, err := c.DB.Preparex(`
+		SELECT
+			user_id,
+			login,
+			auth_user_type,
+			auth_method,
+			coalesce(login_type, '') AS login_type,
+			verified_at,
+			login_ciphertext,
+			encryption_key_id
+		FROM user_ids
+		WHERE user_id = ANY($1)
+		ORDER BY user_id, verified_at NULLS LAST, login;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing fetch personal data batch statement: %w", err)
+	}
+	c.FetchPersonalDataBatchStmt = stmt
+	return nil
+}
+
+// GetUserIDs gets the user ids of many tokens in one query.
+// Tokens that are not found are left out of the returned map.
+func (c *Client) GetUserIDs(ctx context.Context, tokens []string) (map[string]string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GetUserIDs")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var array pgtype.TextArray
+	if err := array.Set(tokens); err != nil {
+		return nil, fmt.Errorf("error encoding tokens: %w", err)
+	}
+
+	var rows []struct {
+		Token  string `db:"token"`
+		UserID string `db:"user_id"`
+	}
+	if err := c.GetUserIDsByTokensStmt.SelectContext(cctx, &rows, &array); err != nil {
+		return nil, fmt.Errorf("error getting user ids by tokens: %w", err)
+	}
+
+	userIDs := make(map[string]string, len(rows))
+	for _, row := range rows {
+		userIDs[row.Token] = row.UserID
+	}
+
+	return userIDs, nil
+}
+
+// FetchPersonalDataBatch gets the personal data of many users in one query.
+// Users that are not found are left out of the returned map.
+func (c *Client) FetchPersonalDataBatch(ctx context.Context, userIDs []string) (map[string]*user.PersonalData, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "FetchPersonalDataBatch")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var array pgtype.TextArray
+	if err := array.Set(userIDs); err != nil {
+		return nil, fmt.Errorf("error encoding user ids: %w", err)
+	}
+
+	var rows []loginRow
+	if err := c.FetchPersonalDataBatchStmt.SelectContext(cctx, &rows, &array); err != nil {
+		return nil, fmt.Errorf("error fetching personal data batch: %w", err)
+	}
+
+	if err := c.decryptLogins(ctx, rows); err != nil {
+		return nil, err
+	}
+
+	logins := make(map[string][]user.Login)
+	for _, row := range rows {
+		logins[row.UserID] = append(logins[row.UserID], row.toLogin())
+	}
+
+	personalData := make(map[string]*user.PersonalData, len(logins))
+	for userID, userLogins := range logins {
+		personalData[userID] = user.NewPersonalData(userID, userLogins)
+	}
+
+	return personalData, nil
+}
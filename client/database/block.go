@@ -0,0 +1,194 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/event"
+)
+
+// RevokeTokens deletes all the tokens of a user and returns how many there
+// were. A token.revoked event is written if there were any.
+func (c *Client) RevokeTokens(ctx context.Context, userID string) (int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "RevokeTokens")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting revoke tokens transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	revoked, err := revokeTokens(cctx, tx, userID, event.ReasonRevoke)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing revoke tokens transaction: %w", err)
+	}
+
+	return revoked, nil
+}
+
+// BlockUser blocks a user and revokes their tokens. Blocking a blocked user
+// only revokes the tokens again.
+func (c *Client) BlockUser(ctx context.Context, userID, reason string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "BlockUser")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting block user transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := blockUser(cctx, tx, userID, reason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing block user transaction: %w", err)
+	}
+
+	return nil
+}
+
+// UnblockUser lets a blocked user get tokens again.
+func (c *Client) UnblockUser(ctx context.Context, userID string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UnblockUser")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting unblock user transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := unblockUser(cctx, tx, userID, ""); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing unblock user transaction: %w", err)
+	}
+
+	return nil
+}
+
+// userLockClass is the class of the advisory locks per user, see lockUser.
+const userLockClass = 7_340_003
+
+// lockUser takes an advisory lock on a user until tx ends. Blocking a user
+// and creating a token take it, so a token created while the user is being
+// blocked is either revoked by the block or not created at all.
+func lockUser(ctx context.Context, tx *sqlx.Tx, userID string) error {
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, hashtext($2));`, userLockClass, userID); err != nil {
+		return fmt.Errorf("error locking user: %w", err)
+	}
+
+	return nil
+}
+
+// blockUser blocks a user and revokes their tokens in tx.
+func blockUser(ctx context.Context, tx *sqlx.Tx, userID, reason string) error {
+	if err := lockUser(ctx, tx, userID); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+		INSERT INTO blocked_users (user_id, reason)
+		VALUES ($1, $2)
+		ON CONFLICT (user_id) DO NOTHING;
+	`, userID, reason)
+	if err != nil {
+		return fmt.Errorf("error blocking user: %w", err)
+	}
+
+	blocked, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error blocking user: %w", err)
+	}
+
+	if blocked > 0 {
+		e, err := event.New(event.TypeUserBlocked, userID, event.UserBlocked{Blocked: true, Reason: reason})
+		if err != nil {
+			return err
+		}
+		if err := insertEvents(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	if _, err := revokeTokens(ctx, tx, userID, event.ReasonBlocked); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// unblockUser unblocks a user in tx, only if they were blocked for reason
+// unless it is empty.
+func unblockUser(ctx context.Context, tx *sqlx.Tx, userID, reason string) error {
+	result, err := tx.ExecContext(ctx, `
+		DELETE FROM blocked_users
+		WHERE user_id = $1 AND ($2::text = '' OR reason = $2);
+	`, userID, reason)
+	if err != nil {
+		return fmt.Errorf("error unblocking user: %w", err)
+	}
+
+	unblocked, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error unblocking user: %w", err)
+	}
+
+	if unblocked > 0 {
+		e, err := event.New(event.TypeUserBlocked, userID, event.UserBlocked{Blocked: false})
+		if err != nil {
+			return err
+		}
+		if err := insertEvents(ctx, tx, e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func revokeTokens(ctx context.Context, tx *sqlx.Tx, userID, reason string) (int, error) {
+	result, err := tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1;`, userID)
+	if err != nil {
+		return 0, fmt.Errorf("error revoking tokens: %w", err)
+	}
+
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error revoking tokens: %w", err)
+	}
+
+	if revoked > 0 {
+		e, err := event.New(event.TypeTokenRevoked, userID, event.TokenRevoked{Reason: reason, All: true})
+		if err != nil {
+			return 0, err
+		}
+		if err := insertEvents(ctx, tx, e); err != nil {
+			return 0, err
+		}
+	}
+
+	return int(revoked), nil
+}
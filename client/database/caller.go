@@ -0,0 +1,119 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/caller"
+)
+
+func (c *Client) prepareGetCallerByAPIKeyHashStmt() error {
+	stmt, err := c.DB.Preparex(`
+		SELECT
+			name,
+			routes
+		FROM service_clients
+		WHERE api_key_hash = $1 AND disabled_at IS NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing get caller by api key hash statement: %w", err)
+	}
+	c.GetCallerByAPIKeyHashStmt = stmt
+	return nil
+}
+
+func (c *Client) prepareGetCallerByNameStmt() error {
+	stmt, err := c.DB.Preparex(`
+		SELECT
+			name,
+			routes
+		FROM service_clients
+		WHERE name = $1 AND disabled_at IS NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing get caller by name statement: %w", err)
+	}
+	c.GetCallerByNameStmt = stmt
+	return nil
+}
+
+// GetCallerByAPIKeyHash gets an enabled caller by the hash of its API key.
+// Returns nil if there is no such caller.
+func (c *Client) GetCallerByAPIKeyHash(ctx context.Context, apiKeyHash string) (*caller.Caller, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GetCallerByAPIKeyHash")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	return scanCaller(c.GetCallerByAPIKeyHashStmt.QueryRowContext(cctx, apiKeyHash))
+}
+
+// GetCallerByName gets an enabled caller by its name.
+// Returns nil if there is no such caller.
+func (c *Client) GetCallerByName(ctx context.Context, name string) (*caller.Caller, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GetCallerByName")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	return scanCaller(c.GetCallerByNameStmt.QueryRowContext(cctx, name))
+}
+
+func scanCaller(r *sql.Row) (*caller.Caller, error) {
+	var name string
+	var routes pgtype.TextArray
+	err := r.Scan(&name, &routes)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error scanning for caller: %w", err)
+	}
+
+	callerRoutes := make([]string, 0, len(routes.Elements))
+	for _, route := range routes.Elements {
+		callerRoutes = append(callerRoutes, route.String)
+	}
+
+	return &caller.Caller{
+		Name:   name,
+		Routes: callerRoutes,
+	}, nil
+}
+
+// CreateCaller registers a caller, or replaces the API key and routes of an
+// existing caller with the same name.
+func (c *Client) CreateCaller(ctx context.Context, cl caller.Caller, apiKeyHash string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateCaller")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var routes pgtype.TextArray
+	if err := routes.Set(cl.Routes); err != nil {
+		return fmt.Errorf("error encoding caller routes: %w", err)
+	}
+
+	_, err := c.DB.ExecContext(cctx, `
+		INSERT INTO
+			service_clients (name, api_key_hash, routes)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET
+			api_key_hash = excluded.api_key_hash,
+			routes = excluded.routes,
+			disabled_at = NULL;
+	`, cl.Name, apiKeyHash, &routes)
+	if err != nil {
+		return fmt.Errorf("error creating caller: %w", err)
+	}
+
+	return nil
+}
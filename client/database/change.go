@@ -0,0 +1,402 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/encryption"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// uniqueViolation is the Postgres error code for unique constraint violations.
+const uniqueViolation = "23505"
+
+func (c *Client) prepareCheckLoginAvailableStmt() error {
+	stmt, err := c.DB.Preparex(`
+		SELECT
+			EXISTS (
+				SELECT 1 FROM login_history
+				WHERE login = ANY($1) AND auth_user_type = $2 AND released_at > now()
+			) OR EXISTS (
+				SELECT 1 FROM login_changes
+				WHERE new_login = ANY($1) AND auth_user_type = $2
+					AND applied_at IS NULL AND cancelled_at IS NULL
+			);
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing check login available statement: %w", err)
+	}
+	c.CheckLoginAvailableStmt = stmt
+	return nil
+}
+
+// prepareReplaceLoginStmt prepares the statement replacing a login of a user
+// and recording the old one in the login history, releasing it for reuse
+// after the cooldown. Only the old login of the new login's auth user type is
+// replaced, as the user may have the same login with other types.
+func (c *Client) prepareReplaceLoginStmt() error {
+	stmt, err := c.DB.Preparex(`
+		WITH old AS (
+			SELECT user_id, login, auth_user_type, auth_method, login_type
+			FROM user_ids
+			WHERE user_id = $1 AND login = ANY($2) AND auth_user_type = $9
+			FOR UPDATE
+		), replaced AS (
+			UPDATE user_ids u SET
+				login = $3,
+				auth_method = $4,
+				login_type = $5,
+				verified_at = now(),
+				login_ciphertext = $7,
+				encryption_key_id = $8
+			FROM old
+			WHERE u.user_id = old.user_id
+				AND u.login = old.login
+				AND u.auth_user_type = old.auth_user_type
+			RETURNING u.user_id
+		)
+		INSERT INTO
+			login_history (user_id, login, auth_user_type, auth_method, login_type, replaced_by, released_at)
+		SELECT old.user_id, old.login, old.auth_user_type, old.auth_method, old.login_type, $3, now() + make_interval(secs => $6)
+		FROM old
+		JOIN replaced ON replaced.user_id = old.user_id;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing replace login statement: %w", err)
+	}
+	c.ReplaceLoginStmt = stmt
+	return nil
+}
+
+func (c *Client) prepareScheduleLoginChangeStmt() error {
+	stmt, err := c.DB.Preparex(`
+		INSERT INTO
+			login_changes (user_id, old_login, new_login, auth_user_type, auth_method, login_type, apply_at, new_login_ciphertext, encryption_key_id)
+		VALUES ($1, $2, $3, $4, $5, $6, now() + make_interval(secs => $7), $8, $9)
+		ON CONFLICT DO NOTHING
+		RETURNING apply_at;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing schedule login change statement: %w", err)
+	}
+	c.ScheduleLoginChangeStmt = stmt
+	return nil
+}
+
+func (c *Client) prepareCancelLoginChangeStmt() error {
+	stmt, err := c.DB.Preparex(`
+		UPDATE login_changes SET
+			cancelled_at = now()
+		WHERE user_id = $1 AND new_login = ANY($2)
+			AND applied_at IS NULL AND cancelled_at IS NULL;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing cancel login change statement: %w", err)
+	}
+	c.CancelLoginChangeStmt = stmt
+	return nil
+}
+
+// CheckLoginAvailable checks that a login is not cooling down after being
+// replaced, and not reserved by a scheduled login change.
+func (c *Client) CheckLoginAvailable(ctx context.Context, login, authUserType string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CheckLoginAvailable")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	keys, err := c.loginKeys(login)
+	if err != nil {
+		return err
+	}
+
+	var unavailable bool
+	if err := c.CheckLoginAvailableStmt.GetContext(cctx, &unavailable, keys, authUserType); err != nil {
+		return fmt.Errorf("error checking login availability: %w", err)
+	}
+
+	if unavailable {
+		return user.ErrLoginCoolingDown
+	}
+
+	return nil
+}
+
+// ChangeLogin replaces a login of a user right away.
+func (c *Client) ChangeLogin(ctx context.Context, userID, oldLogin string, newLogin user.Login) (*user.LoginChange, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ChangeLogin")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	oldKeys, err := c.loginKeys(oldLogin)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.replaceLogin(cctx, c.ReplaceLoginStmt, userID, oldKeys, newLogin); err != nil {
+		return nil, err
+	}
+
+	return &user.LoginChange{
+		UserID:   userID,
+		OldLogin: oldLogin,
+		NewLogin: newLogin,
+		ApplyAt:  time.Now(),
+		Applied:  true,
+	}, nil
+}
+
+// ScheduleLoginChange records a login change to be applied by
+// ApplyDueLoginChanges once the grace period is over. The new login is
+// reserved for the user in the meantime.
+func (c *Client) ScheduleLoginChange(ctx context.Context, userID, oldLogin string, newLogin user.Login) (*user.LoginChange, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ScheduleLoginChange")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ciphertext, keyID, err := c.encryptLogin(cctx, newLogin.Login)
+	if err != nil {
+		return nil, err
+	}
+
+	var applyAt []time.Time
+	err = c.ScheduleLoginChangeStmt.SelectContext(
+		cctx,
+		&applyAt,
+		userID,
+		c.loginKey(oldLogin),
+		c.loginKey(newLogin.Login),
+		newLogin.AuthUserType,
+		newLogin.AuthMethod,
+		newLogin.Type,
+		c.loginChangeGracePeriod.Seconds(),
+		ciphertext,
+		keyID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error scheduling login change: %w", err)
+	}
+
+	if len(applyAt) == 0 {
+		return nil, user.ErrLoginTaken
+	}
+
+	return &user.LoginChange{
+		UserID:   userID,
+		OldLogin: oldLogin,
+		NewLogin: newLogin,
+		ApplyAt:  applyAt[0],
+	}, nil
+}
+
+// CancelLoginChange cancels a scheduled login change.
+// Returns user.ErrLoginNotFound if there is no such change.
+func (c *Client) CancelLoginChange(ctx context.Context, userID, newLogin string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CancelLoginChange")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	keys, err := c.loginKeys(newLogin)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.CancelLoginChangeStmt.ExecContext(cctx, userID, keys)
+	if err != nil {
+		return fmt.Errorf("error cancelling login change: %w", err)
+	}
+
+	cancelled, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error cancelling login change: %w", err)
+	}
+
+	if cancelled == 0 {
+		return user.ErrLoginNotFound
+	}
+
+	return nil
+}
+
+type loginChangeRow struct {
+	ID                 int64  `db:"id"`
+	UserID             string `db:"user_id"`
+	OldLogin           string `db:"old_login"`
+	NewLogin           string `db:"new_login"`
+	AuthUserType       string `db:"auth_user_type"`
+	AuthMethod         string `db:"auth_method"`
+	LoginType          string `db:"login_type"`
+	NewLoginCiphertext []byte `db:"new_login_ciphertext"`
+	EncryptionKeyID    *int   `db:"encryption_key_id"`
+}
+
+// ApplyDueLoginChanges applies the scheduled login changes whose grace
+// period is over. Changes that can not be applied any more, e.g. because the
+// old login was unlinked, are cancelled.
+func (c *Client) ApplyDueLoginChanges(ctx context.Context) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ApplyDueLoginChanges")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	var due []loginChangeRow
+	err := c.DB.SelectContext(cctx, &due, `
+		SELECT id, user_id, old_login, new_login, auth_user_type, auth_method, login_type,
+			new_login_ciphertext, encryption_key_id
+		FROM login_changes
+		WHERE applied_at IS NULL AND cancelled_at IS NULL AND apply_at <= now()
+		ORDER BY apply_at
+		LIMIT 100;
+	`)
+	if err != nil {
+		return fmt.Errorf("error fetching due login changes: %w", err)
+	}
+
+	for _, change := range due {
+		if err := c.applyLoginChange(cctx, change); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) applyLoginChange(ctx context.Context, change loginChangeRow) error {
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting login change transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	status := "applied_at"
+
+	newLogin, err := c.decryptLogin(ctx, change.NewLogin, change.NewLoginCiphertext, change.EncryptionKeyID)
+	if err != nil {
+		return err
+	}
+
+	// The old login is stored the way it is stored in user_ids, unless it
+	// was scheduled before encryption was enabled and is still plaintext.
+	var oldKeys *pgtype.TextArray
+	if strings.HasPrefix(change.OldLogin, encryption.BlindIndexPrefix) {
+		oldKeys = &pgtype.TextArray{}
+		err = oldKeys.Set([]string{change.OldLogin})
+	} else {
+		oldKeys, err = c.loginKeys(change.OldLogin)
+	}
+	if err != nil {
+		return fmt.Errorf("error encoding old login: %w", err)
+	}
+
+	err = c.replaceLogin(ctx, tx.StmtxContext(ctx, c.ReplaceLoginStmt), change.UserID, oldKeys, user.Login{
+		Login:        newLogin,
+		Type:         user.LoginType(change.LoginType),
+		AuthUserType: change.AuthUserType,
+		AuthMethod:   change.AuthMethod,
+	})
+	if errors.Is(err, user.ErrLoginNotFound) || errors.Is(err, user.ErrLoginTaken) {
+		log.Infof("Cancelling login change %d: %s", change.ID, err)
+
+		// The failed statement aborted the transaction, start over.
+		if err := tx.Rollback(); err != nil {
+			return fmt.Errorf("error rolling back login change transaction: %w", err)
+		}
+		if tx, err = c.DB.BeginTxx(ctx, nil); err != nil {
+			return fmt.Errorf("error starting login change transaction: %w", err)
+		}
+		status = "cancelled_at"
+	} else if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE login_changes SET
+			%s = now()
+		WHERE id = $1 AND applied_at IS NULL AND cancelled_at IS NULL;`, status), change.ID)
+	if err != nil {
+		return fmt.Errorf("error updating login change: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing login change: %w", err)
+	}
+
+	return nil
+}
+
+// RunLoginChangeApplier calls ApplyDueLoginChanges every interval until ctx
+// is done.
+func (c *Client) RunLoginChangeApplier(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.ApplyDueLoginChanges(ctx); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+}
+
+func (c *Client) replaceLogin(ctx context.Context, stmt *sqlx.Stmt, userID string, oldKeys *pgtype.TextArray, newLogin user.Login) error {
+	ciphertext, keyID, err := c.encryptLogin(ctx, newLogin.Login)
+	if err != nil {
+		return err
+	}
+
+	result, err := stmt.ExecContext(
+		ctx,
+		userID,
+		oldKeys,
+		c.loginKey(newLogin.Login),
+		newLogin.AuthMethod,
+		newLogin.Type,
+		c.loginReuseCooldown.Seconds(),
+		ciphertext,
+		keyID,
+		newLogin.AuthUserType,
+	)
+	if isUniqueViolation(err) {
+		return user.ErrLoginTaken
+	}
+	if err != nil {
+		return fmt.Errorf("error replacing login: %w", err)
+	}
+
+	replaced, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error replacing login: %w", err)
+	}
+
+	if replaced == 0 {
+		return user.ErrLoginNotFound
+	}
+
+	return nil
+}
+
+func isUniqueViolation(err error) bool {
+	var pgErr pgx.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == uniqueViolation
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/stdlib"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+// connector opens database connections with the current password. When the
+// password changes the connections opened with the old one become stale and
+// the pool replaces them.
+type connector struct {
+	connString func(password string) string
+
+	mu         sync.RWMutex
+	password   string
+	generation uint64
+}
+
+// Connect implements driver.Connector.
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mu.RLock()
+	connString := c.connString(c.password)
+	generation := c.generation
+	c.mu.RUnlock()
+
+	pgConn, err := stdlib.GetDefaultDriver().Open(connString)
+	if err != nil {
+		return nil, err
+	}
+
+	return &conn{Conn: pgConn.(*stdlib.Conn), generation: generation, connector: c}, nil
+}
+
+// Driver implements driver.Connector.
+func (c *connector) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
+
+// setPassword makes new connections use password and the open ones stale.
+// Returns whether the password changed.
+func (c *connector) setPassword(password string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if password == c.password {
+		return false
+	}
+
+	c.password = password
+	atomic.AddUint64(&c.generation, 1)
+	return true
+}
+
+func (c *connector) currentGeneration() uint64 {
+	return atomic.LoadUint64(&c.generation)
+}
+
+// conn is a connection that knows the password it was opened with.
+type conn struct {
+	*stdlib.Conn
+	generation uint64
+	connector  *connector
+}
+
+// IsValid implements driver.Validator, so stale connections are closed
+// instead of going back to the pool.
+func (c *conn) IsValid() bool {
+	return c.generation == c.connector.currentGeneration()
+}
+
+// ResetSession implements driver.SessionResetter, so stale idle
+// connections are not reused.
+func (c *conn) ResetSession(ctx context.Context) error {
+	if !c.IsValid() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// RunPasswordReload reads the database password from file every interval
+// until ctx is done, and renews the connections when it changed.
+func (c *Client) RunPasswordReload(ctx context.Context, file string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var modTime time.Time
+	if info, err := os.Stat(file); err == nil {
+		modTime = info.ModTime()
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(file)
+			if err != nil {
+				log.Errorf("error checking database password file: %s", err)
+				continue
+			}
+
+			if info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+
+			password, err := config.ReadSecretFile(file)
+			if err != nil {
+				log.Error(err.Error())
+				continue
+			}
+
+			if c.connector.setPassword(password) {
+				log.Info("Database password changed, renewing connections")
+			}
+		}
+	}
+}
+
+// newConnector returns a connector for the database of the config.
+func newConnector(config *config.Config) *connector {
+	c := &connector{
+		connString: func(password string) string {
+			// The credentials are escaped, as secrets may hold any character.
+			u := url.URL{
+				Scheme:   "postgres",
+				User:     url.UserPassword(config.DatabaseUser, password),
+				Host:     net.JoinHostPort(config.DatabaseURL, config.DatabasePort),
+				Path:     "/" + config.DatabaseDB,
+				RawQuery: strings.TrimPrefix(config.DatabaseOptions, "?"),
+			}
+			return u.String()
+		},
+	}
+	c.setPassword(config.DatabasePassword)
+
+	return c
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx"
+
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+func TestConnectorConnString(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		password string
+	}{
+		{"plain", "auth", "secret"},
+		{"reserved characters", "auth@route", "p@ss:w/rd?#%"},
+		{"spaces and unicode", "auth", "пароль с пробелом"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newConnector(&config.Config{
+				DatabaseUser:     tt.user,
+				DatabasePassword: tt.password,
+				DatabaseURL:      "db.internal",
+				DatabasePort:     "5432",
+				DatabaseDB:       "auth",
+				DatabaseOptions:  "?sslmode=disable",
+			})
+
+			parsed, err := pgx.ParseURI(c.connString(c.password))
+			if err != nil {
+				t.Fatalf("ParseURI() error = %v", err)
+			}
+
+			if parsed.User != tt.user || parsed.Password != tt.password {
+				t.Errorf("credentials = %q, %q, want %q, %q", parsed.User, parsed.Password, tt.user, tt.password)
+			}
+			if parsed.Host != "db.internal" || parsed.Port != 5432 || parsed.Database != "auth" {
+				t.Errorf("address = %s:%d/%s, want db.internal:5432/auth", parsed.Host, parsed.Port, parsed.Database)
+			}
+			if parsed.TLSConfig != nil {
+				t.Error("sslmode=disable was not applied")
+			}
+		})
+	}
+}
@@ -2,11 +2,15 @@ package database
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+	"time"
 
 	_ "github.com/jackc/pgx/stdlib"
 	"github.com/jmoiron/sqlx"
 	"gitlab.com/route-kz/auth-api/config"
+	"gitlab.com/route-kz/auth-api/encryption"
+	"gitlab.com/route-kz/auth-api/user"
 )
 
 // Client holds the database client and prepared statements.
@@ -18,33 +22,80 @@ type Client struct {
 	GetUserIDByTokenStmt       *sqlx.Stmt
 	FetchPersonalDataStmt      *sqlx.Stmt
 	GetUserIDRemoveTokenStmt   *sqlx.Stmt
+	LinkLoginStmt              *sqlx.Stmt
+	UnlinkLoginStmt            *sqlx.Stmt
+	CheckLoginAvailableStmt    *sqlx.Stmt
+	ReplaceLoginStmt           *sqlx.Stmt
+	ScheduleLoginChangeStmt    *sqlx.Stmt
+	CancelLoginChangeStmt      *sqlx.Stmt
+	GetUserIDsByTokensStmt     *sqlx.Stmt
+	FetchPersonalDataBatchStmt *sqlx.Stmt
+	GetCallerByAPIKeyHashStmt  *sqlx.Stmt
+	GetCallerByNameStmt        *sqlx.Stmt
+	GetTokenIdentityStmt       *sqlx.Stmt
+
+	tokenMaxLifetime       time.Duration
+	tokensPartitioned      bool
+	tokensPartitionsAhead  int
+	loginChangeGracePeriod time.Duration
+	loginReuseCooldown     time.Duration
+	normalizer             user.Normalizer
+	outboxBatchSize        int
+	outboxRetryBase        time.Duration
+	outboxRetryMax         time.Duration
+	outboxRetention        time.Duration
+	webhookDisableAfter    int
+	keyring                *encryption.Keyring
+	connector              *connector
 }
 
 // Init sets up a new database client.
 func (c *Client) Init(ctx context.Context, config *config.Config) error {
-	connString := fmt.Sprintf("postgres://%s:%s@%s:%s/%s%s",
-		config.DatabaseUser,
-		config.DatabasePassword,
-		config.DatabaseURL,
-		config.DatabasePort,
-		config.DatabaseDB,
-		config.DatabaseOptions,
-	)
-
-	db, err := sqlx.ConnectContext(ctx, "pgx", connString)
-	if err != nil {
-		return fmt.Errorf("failed to connect to database: %w", err)
-	}
+	c.connector = newConnector(config)
+	db := sqlx.NewDb(sql.OpenDB(c.connector), "pgx")
 
-	err = db.Ping()
+	err := db.PingContext(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to ping database: %w", err)
+		return fmt.Errorf("failed to connect to database: %w", err)
 	}
 
 	db.SetMaxOpenConns(config.DatabaseMaxConnections)
 	db.SetMaxIdleConns(config.DatabaseMaxIdleConnections)
 
 	c.DB = db
+	c.tokenMaxLifetime = config.TokenMaxLifetime
+	c.tokensPartitioned = config.TokensPartitioned
+	c.tokensPartitionsAhead = config.TokensPartitionsAhead
+	c.loginChangeGracePeriod = config.LoginChangeGracePeriod
+	c.loginReuseCooldown = config.LoginReuseCooldown
+	c.normalizer = user.Normalizer{DefaultRegion: config.PhoneDefaultRegion}
+	c.outboxBatchSize = config.OutboxBatchSize
+	c.outboxRetryBase = config.OutboxRetryBase
+	c.outboxRetryMax = config.OutboxRetryMax
+	c.outboxRetention = config.OutboxRetention
+	c.webhookDisableAfter = config.WebhookDisableAfter
+
+	if c.tokensPartitioned {
+		if c.tokenMaxLifetime <= 0 {
+			return fmt.Errorf("partitioned tokens table requires TOKEN_MAX_LIFETIME to be set")
+		}
+
+		// Make sure partitions exist before the first token is inserted.
+		if err := c.MaintainTokenPartitions(ctx); err != nil {
+			return err
+		}
+	}
+
+	if config.MasterKeyFile != "" {
+		kms, err := encryption.NewFileKMS(config.MasterKeyFile, config.MasterKeyPreviousFiles)
+		if err != nil {
+			return err
+		}
+
+		if err := c.InitEncryption(ctx, kms); err != nil {
+			return err
+		}
+	}
 
 	if err := c.prepareRecordUserIDToObjectIDStmt(); err != nil {
 		return err
@@ -70,6 +121,50 @@ func (c *Client) Init(ctx context.Context, config *config.Config) error {
 		return err
 	}
 
+	if err := c.prepareLinkLoginStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareUnlinkLoginStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareCheckLoginAvailableStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareReplaceLoginStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareScheduleLoginChangeStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareCancelLoginChangeStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareGetUserIDsByTokensStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareFetchPersonalDataBatchStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareGetCallerByAPIKeyHashStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareGetCallerByNameStmt(); err != nil {
+		return err
+	}
+
+	if err := c.prepareGetTokenIdentityStmt(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
@@ -100,6 +195,50 @@ func (c *Client) Close() error {
 		return fmt.Errorf("error on closing get user id and remove token statement: %w", err)
 	}
 
+	if err := c.LinkLoginStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing link login statement: %w", err)
+	}
+
+	if err := c.UnlinkLoginStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing unlink login statement: %w", err)
+	}
+
+	if err := c.CheckLoginAvailableStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing check login available statement: %w", err)
+	}
+
+	if err := c.ReplaceLoginStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing replace login statement: %w", err)
+	}
+
+	if err := c.ScheduleLoginChangeStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing schedule login change statement: %w", err)
+	}
+
+	if err := c.CancelLoginChangeStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing cancel login change statement: %w", err)
+	}
+
+	if err := c.GetUserIDsByTokensStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing get user ids by tokens statement: %w", err)
+	}
+
+	if err := c.FetchPersonalDataBatchStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing fetch personal data batch statement: %w", err)
+	}
+
+	if err := c.GetCallerByAPIKeyHashStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing get caller by api key hash statement: %w", err)
+	}
+
+	if err := c.GetCallerByNameStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing get caller by name statement: %w", err)
+	}
+
+	if err := c.GetTokenIdentityStmt.Close(); err != nil {
+		return fmt.Errorf("error on closing get token identity statement: %w", err)
+	}
+
 	err := c.DB.Close()
 	if err != nil {
 		return fmt.Errorf("error closing database: %w", err)
@@ -0,0 +1,466 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/jackc/pgx/pgtype"
+
+	"gitlab.com/route-kz/auth-api/encryption"
+)
+
+// InitEncryption enables the encryption of logins with data keys wrapped
+// by the master key of kms.
+func (c *Client) InitEncryption(ctx context.Context, kms encryption.KMS) error {
+	keyring := &encryption.Keyring{KMS: kms, Store: c}
+	if err := keyring.Init(ctx); err != nil {
+		return fmt.Errorf("error initializing keyring: %w", err)
+	}
+
+	c.keyring = keyring
+	return nil
+}
+
+// Keyring returns the keyring logins are encrypted with, nil if encryption
+// is not enabled.
+func (c *Client) Keyring() *encryption.Keyring {
+	return c.keyring
+}
+
+// ActiveEncryptionKey returns the active key of the purpose, nil if there
+// is none.
+func (c *Client) ActiveEncryptionKey(ctx context.Context, purpose encryption.Purpose) (*encryption.StoredKey, error) {
+	var key encryption.StoredKey
+	err := c.DB.GetContext(ctx, &key, `
+		SELECT id, purpose, wrapped_key, master_key_id
+		FROM encryption_keys
+		WHERE purpose = $1 AND retired_at IS NULL;
+	`, purpose)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error getting active %s key: %w", purpose, err)
+	}
+
+	return &key, nil
+}
+
+// EncryptionKey returns a key by id.
+func (c *Client) EncryptionKey(ctx context.Context, id int) (*encryption.StoredKey, error) {
+	var key encryption.StoredKey
+	err := c.DB.GetContext(ctx, &key, `
+		SELECT id, purpose, wrapped_key, master_key_id
+		FROM encryption_keys
+		WHERE id = $1;
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting encryption key %d: %w", id, err)
+	}
+
+	return &key, nil
+}
+
+// EncryptionKeys returns all keys, retired ones included.
+func (c *Client) EncryptionKeys(ctx context.Context) ([]encryption.StoredKey, error) {
+	var keys []encryption.StoredKey
+	err := c.DB.SelectContext(ctx, &keys, `
+		SELECT id, purpose, wrapped_key, master_key_id
+		FROM encryption_keys
+		ORDER BY id;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error getting encryption keys: %w", err)
+	}
+
+	return keys, nil
+}
+
+// CreateEncryptionKey stores a key as the active key of its purpose and
+// retires the previous one.
+func (c *Client) CreateEncryptionKey(ctx context.Context, key encryption.StoredKey) (int, error) {
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting create key transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE encryption_keys SET
+			retired_at = now()
+		WHERE purpose = $1 AND retired_at IS NULL;
+	`, key.Purpose)
+	if err != nil {
+		return 0, fmt.Errorf("error retiring %s key: %w", key.Purpose, err)
+	}
+
+	var id int
+	err = tx.GetContext(ctx, &id, `
+		INSERT INTO
+			encryption_keys (purpose, wrapped_key, master_key_id)
+		VALUES ($1, $2, $3)
+		RETURNING id;
+	`, key.Purpose, key.WrappedKey, key.MasterKeyID)
+	if err != nil {
+		return 0, fmt.Errorf("error creating %s key: %w", key.Purpose, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing %s key: %w", key.Purpose, err)
+	}
+
+	return id, nil
+}
+
+// AddEncryptionKey stores the first key of its purpose. Returns
+// encryption.ErrActiveKeyExists if another instance stored one first.
+func (c *Client) AddEncryptionKey(ctx context.Context, key encryption.StoredKey) (int, error) {
+	var ids []int
+	err := c.DB.SelectContext(ctx, &ids, `
+		INSERT INTO
+			encryption_keys (purpose, wrapped_key, master_key_id)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (purpose) WHERE retired_at IS NULL DO NOTHING
+		RETURNING id;
+	`, key.Purpose, key.WrappedKey, key.MasterKeyID)
+	if err != nil {
+		return 0, fmt.Errorf("error adding %s key: %w", key.Purpose, err)
+	}
+
+	if len(ids) == 0 {
+		return 0, encryption.ErrActiveKeyExists
+	}
+
+	return ids[0], nil
+}
+
+// RewrapEncryptionKey replaces the wrapped form of a key.
+func (c *Client) RewrapEncryptionKey(ctx context.Context, key encryption.StoredKey) error {
+	_, err := c.DB.ExecContext(ctx, `
+		UPDATE encryption_keys SET
+			wrapped_key = $2,
+			master_key_id = $3
+		WHERE id = $1;
+	`, key.ID, key.WrappedKey, key.MasterKeyID)
+	if err != nil {
+		return fmt.Errorf("error rewrapping encryption key %d: %w", key.ID, err)
+	}
+
+	return nil
+}
+
+// loginKey returns what is stored in the login column for a login: its
+// blind index with encryption enabled, the login itself otherwise.
+func (c *Client) loginKey(login string) string {
+	if c.keyring == nil {
+		return login
+	}
+
+	return c.keyring.BlindIndex(login)
+}
+
+// loginKeys returns what may be stored in the login column for logins. It
+// includes the plaintext logins, so rows stored before encryption was
+// enabled are found until they are encrypted.
+func (c *Client) loginKeys(logins ...string) (*pgtype.TextArray, error) {
+	keys := make([]string, 0, 2*len(logins))
+	seen := make(map[string]bool, len(logins))
+	for _, login := range logins {
+		if seen[login] {
+			continue
+		}
+		seen[login] = true
+
+		keys = append(keys, login)
+		if c.keyring != nil {
+			keys = append(keys, c.keyring.BlindIndex(login))
+		}
+	}
+
+	var array pgtype.TextArray
+	if err := array.Set(keys); err != nil {
+		return nil, fmt.Errorf("error encoding login keys: %w", err)
+	}
+
+	return &array, nil
+}
+
+// encryptLogin encrypts a login with the active data key. Without
+// encryption it returns no ciphertext and no key id.
+func (c *Client) encryptLogin(ctx context.Context, login string) ([]byte, *int, error) {
+	if c.keyring == nil {
+		return nil, nil, nil
+	}
+
+	keyID, ciphertext, err := c.keyring.Encrypt(ctx, login)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error encrypting login: %w", err)
+	}
+
+	return ciphertext, &keyID, nil
+}
+
+// decryptLogin returns the plaintext of a stored login: the decrypted
+// ciphertext if there is one, else the login column, which is plaintext for
+// rows stored before encryption was enabled.
+func (c *Client) decryptLogin(ctx context.Context, login string, ciphertext []byte, keyID *int) (string, error) {
+	if ciphertext == nil || keyID == nil {
+		return login, nil
+	}
+
+	if c.keyring == nil {
+		return "", fmt.Errorf("login is encrypted, but encryption is not enabled")
+	}
+
+	plaintext, err := c.keyring.Decrypt(ctx, *keyID, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting login: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// decryptLogins decrypts the logins of rows in place.
+func (c *Client) decryptLogins(ctx context.Context, rows []loginRow) error {
+	for i := range rows {
+		login, err := c.decryptLogin(ctx, rows[i].Login, rows[i].LoginCiphertext, rows[i].EncryptionKeyID)
+		if err != nil {
+			return err
+		}
+		rows[i].Login = login
+	}
+
+	return nil
+}
+
+// hashedLoginColumns are the columns of other tables than user_ids holding
+// logins. They are only ever compared to logins, so they hold blind indexes
+// of them with encryption enabled, like user_ids.login.
+var hashedLoginColumns = []struct {
+	table  string
+	column string
+}{
+	{"login_history", "login"},
+	{"login_history", "replaced_by"},
+	{"login_changes", "old_login"},
+	{"user_merges", "login"},
+}
+
+// ReencryptLogins encrypts up to batchSize logins of user_ids, of the
+// pending and past login changes and webhook secrets that are not encrypted
+// with the active data key yet, plaintext ones included, and replaces up to
+// batchSize plaintext logins of each of hashedLoginColumns with their blind
+// index. Returns how many values were encrypted or hashed, zero once all
+// are.
+func (c *Client) ReencryptLogins(ctx context.Context, batchSize int) (int, error) {
+	if c.keyring == nil {
+		return 0, fmt.Errorf("encryption is not enabled")
+	}
+
+	total, err := c.reencryptUserLogins(ctx, batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	changes, err := c.reencryptLoginChanges(ctx, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	total += changes
+
+	secrets, err := c.reencryptWebhookSecrets(ctx, batchSize)
+	if err != nil {
+		return 0, err
+	}
+	total += secrets
+
+	for _, hc := range hashedLoginColumns {
+		hashed, err := c.hashLogins(ctx, hc.table, hc.column, batchSize)
+		if err != nil {
+			return 0, err
+		}
+		total += hashed
+	}
+
+	return total, nil
+}
+
+// reencryptUserLogins encrypts up to batchSize logins of user_ids with the
+// active data key.
+func (c *Client) reencryptUserLogins(ctx context.Context, batchSize int) (int, error) {
+	activeKeyID, err := c.keyring.ActiveKeyID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting reencrypt transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows []loginRow
+	err = tx.SelectContext(ctx, &rows, `
+		SELECT user_id, login, auth_user_type, auth_method, coalesce(login_type, '') AS login_type,
+			verified_at, login_ciphertext, encryption_key_id
+		FROM user_ids
+		WHERE encryption_key_id IS DISTINCT FROM $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED;
+	`, activeKeyID, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching logins to reencrypt: %w", err)
+	}
+
+	for _, row := range rows {
+		login, err := c.decryptLogin(ctx, row.Login, row.LoginCiphertext, row.EncryptionKeyID)
+		if err != nil {
+			return 0, err
+		}
+
+		ciphertext, keyID, err := c.encryptLogin(ctx, login)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE user_ids SET
+				login = $3,
+				login_ciphertext = $4,
+				encryption_key_id = $5
+			WHERE login = $1 AND auth_user_type = $2;
+		`, row.Login, row.AuthUserType, c.loginKey(login), ciphertext, keyID)
+		if err != nil {
+			return 0, fmt.Errorf("error reencrypting login: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing reencrypted logins: %w", err)
+	}
+
+	return len(rows), nil
+}
+
+// reencryptLoginChanges encrypts up to batchSize new logins of login
+// changes with the active data key.
+func (c *Client) reencryptLoginChanges(ctx context.Context, batchSize int) (int, error) {
+	activeKeyID, err := c.keyring.ActiveKeyID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting reencrypt transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows []struct {
+		ID                 int64  `db:"id"`
+		NewLogin           string `db:"new_login"`
+		NewLoginCiphertext []byte `db:"new_login_ciphertext"`
+		EncryptionKeyID    *int   `db:"encryption_key_id"`
+	}
+	err = tx.SelectContext(ctx, &rows, `
+		SELECT id, new_login, new_login_ciphertext, encryption_key_id
+		FROM login_changes
+		WHERE encryption_key_id IS DISTINCT FROM $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED;
+	`, activeKeyID, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching login changes to reencrypt: %w", err)
+	}
+
+	for _, row := range rows {
+		login, err := c.decryptLogin(ctx, row.NewLogin, row.NewLoginCiphertext, row.EncryptionKeyID)
+		if err != nil {
+			return 0, err
+		}
+
+		ciphertext, keyID, err := c.encryptLogin(ctx, login)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE login_changes SET
+				new_login = $2,
+				new_login_ciphertext = $3,
+				encryption_key_id = $4
+			WHERE id = $1;
+		`, row.ID, c.loginKey(login), ciphertext, keyID)
+		if err != nil {
+			return 0, fmt.Errorf("error reencrypting login change: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing reencrypted login changes: %w", err)
+	}
+
+	return len(rows), nil
+}
+
+// hashLogins replaces up to batchSize plaintext logins of a column with
+// their blind index. Rows sharing a login are updated together.
+func (c *Client) hashLogins(ctx context.Context, table, column string, batchSize int) (int, error) {
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting hash transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var logins []string
+	err = tx.SelectContext(ctx, &logins, fmt.Sprintf(`
+		SELECT DISTINCT %[2]s FROM (
+			SELECT %[2]s FROM %[1]s
+			WHERE %[2]s NOT LIKE $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) AS plaintext;
+	`, table, column), encryption.BlindIndexPrefix+"%", batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching %s.%s to hash: %w", table, column, err)
+	}
+
+	if len(logins) == 0 {
+		return 0, nil
+	}
+
+	hashes := make([]string, 0, len(logins))
+	for _, login := range logins {
+		hashes = append(hashes, c.keyring.BlindIndex(login))
+	}
+
+	var plaintexts, indexes pgtype.TextArray
+	if err := plaintexts.Set(logins); err != nil {
+		return 0, fmt.Errorf("error encoding logins: %w", err)
+	}
+	if err := indexes.Set(hashes); err != nil {
+		return 0, fmt.Errorf("error encoding blind indexes: %w", err)
+	}
+
+	result, err := tx.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %[1]s t SET
+			%[2]s = hashed.blind_index
+		FROM unnest($1::text[], $2::text[]) AS hashed (login, blind_index)
+		WHERE t.%[2]s = hashed.login;
+	`, table, column), &plaintexts, &indexes)
+	if err != nil {
+		return 0, fmt.Errorf("error hashing %s.%s: %w", table, column, err)
+	}
+
+	hashed, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error hashing %s.%s: %w", table, column, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing hashed %s.%s: %w", table, column, err)
+	}
+
+	return int(hashed), nil
+}
@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+func (c *Client) prepareLinkLoginStmt() error {
+	stmt, err := c.DB.Preparex(`
+		INSERT INTO
+			user_ids (user_id, login, auth_method, auth_user_type, login_type, verified_at, login_ciphertext, encryption_key_id)
+		VALUES ($1, $2, $3, $4, $5, now(), $6, $7)
+		ON CONFLICT (login, auth_user_type) DO NOTHING;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing link login statement: %w", err)
+	}
+	c.LinkLoginStmt = stmt
+	return nil
+}
+
+func (c *Client) prepareUnlinkLoginStmt() error {
+	stmt, err := c.DB.Preparex(`
+		DELETE FROM user_ids
+		WHERE user_id = $1
+			AND login = ANY($2)
+			AND auth_user_type = $3
+			AND (SELECT count(*) FROM user_ids WHERE user_id = $1) > 1;
+	`)
+	if err != nil {
+		return fmt.Errorf("error preparing unlink login statement: %w", err)
+	}
+	c.UnlinkLoginStmt = stmt
+	return nil
+}
+
+// FetchLogins gets all logins linked to a user.
+func (c *Client) FetchLogins(ctx context.Context, userID string) ([]user.Login, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "FetchLogins")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var rows []loginRow
+	if err := c.FetchPersonalDataStmt.SelectContext(cctx, &rows, userID); err != nil {
+		return nil, fmt.Errorf("error fetching logins: %w", err)
+	}
+
+	if err := c.decryptLogins(ctx, rows); err != nil {
+		return nil, err
+	}
+
+	logins := make([]user.Login, 0, len(rows))
+	for _, row := range rows {
+		logins = append(logins, row.toLogin())
+	}
+
+	return logins, nil
+}
+
+// NormalizeLogin returns the canonical form of a login, see user.Normalizer.
+func (c *Client) NormalizeLogin(login, authMethod string) string {
+	return c.normalizer.Normalize(login, authMethod)
+}
+
+// GetUserIDByLogin gets the id of the user a login is linked to.
+// Returns an empty user id if the login is not linked to anyone.
+func (c *Client) GetUserIDByLogin(ctx context.Context, login, authUserType string) (string, error) {
+	return c.getUserIDFromObjectID(ctx, user.CreateTokenPayload{
+		Login:        login,
+		AuthUserType: authUserType,
+	})
+}
+
+// LinkLogin links a verified login to an existing user.
+// Returns user.ErrLoginTaken if the login is linked to another user.
+func (c *Client) LinkLogin(ctx context.Context, userID string, login user.Login) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "LinkLogin")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	ciphertext, keyID, err := c.encryptLogin(cctx, login.Login)
+	if err != nil {
+		return err
+	}
+
+	result, err := c.LinkLoginStmt.ExecContext(
+		cctx,
+		userID,
+		c.loginKey(login.Login),
+		login.AuthMethod,
+		login.AuthUserType,
+		login.Type,
+		ciphertext,
+		keyID,
+	)
+	if err != nil {
+		return fmt.Errorf("error linking login: %w", err)
+	}
+
+	linked, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error linking login: %w", err)
+	}
+
+	if linked == 0 {
+		return user.ErrLoginTaken
+	}
+
+	return nil
+}
+
+// UnlinkLogin removes a login of an auth user type from a user, with the
+// user's logins locked so that concurrent unlinks never remove the last one.
+// Returns user.ErrLastLogin if it is the only login of the user and
+// user.ErrLoginNotFound if the login is not linked to the user.
+func (c *Client) UnlinkLogin(ctx context.Context, userID, login, authUserType string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UnlinkLogin")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	keys, err := c.loginKeys(login)
+	if err != nil {
+		return err
+	}
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting unlink login transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Locking the logins of the user first makes concurrent unlinks wait
+	// for each other, or both could count two logins and leave none.
+	var count int
+	err = tx.GetContext(cctx, &count, `
+		SELECT count(*) FROM (SELECT 1 FROM user_ids WHERE user_id = $1 FOR UPDATE) AS logins;
+	`, userID)
+	if err != nil {
+		return fmt.Errorf("error locking logins to unlink: %w", err)
+	}
+
+	var unlinked int64
+	if count > 1 {
+		result, err := tx.StmtxContext(cctx, c.UnlinkLoginStmt).ExecContext(cctx, userID, keys, authUserType)
+		if err != nil {
+			return fmt.Errorf("error unlinking login: %w", err)
+		}
+
+		if unlinked, err = result.RowsAffected(); err != nil {
+			return fmt.Errorf("error unlinking login: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing unlink login transaction: %w", err)
+	}
+
+	if unlinked > 0 {
+		return nil
+	}
+
+	logins, err := c.FetchLogins(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, l := range logins {
+		if l.Login == login && l.AuthUserType == authUserType {
+			return user.ErrLastLogin
+		}
+	}
+
+	return user.ErrLoginNotFound
+}
@@ -9,14 +9,15 @@ import (
 	"github.com/opentracing/opentracing-go"
 
 	"github.com/google/uuid"
+	"gitlab.com/route-kz/auth-api/event"
 	"gitlab.com/route-kz/auth-api/user"
 )
 
 func (c *Client) prepareRecordUserIDToObjectIDStmt() error {
 	stmt, err := c.DB.Preparex(`
 		INSERT INTO
-			user_ids (user_id, login, auth_method, auth_user_type)
-		VALUES ($1, $2, $3, $4)
+			user_ids (user_id, login, auth_method, auth_user_type, login_type, verified_at, login_ciphertext, encryption_key_id)
+		VALUES ($1, $2, $3, $4, $5, now(), $6, $7)
 		ON CONFLICT (login, auth_user_type) DO NOTHING;
 	`)
 	if err != nil {
@@ -31,7 +32,9 @@ func (c *Client) prepareGetUserIDByObjectIDStmt() error {
 		SELECT
 			user_id
 		FROM user_ids
-		WHERE login = $1 and auth_user_type=$2;
+		WHERE login = ANY($1) and auth_user_type=$2
+		ORDER BY login = ANY($3) DESC
+		LIMIT 1;
 	`)
 	if err != nil {
 		return fmt.Errorf("error preparing get user id by object id statement: %w", err)
@@ -43,9 +46,11 @@ func (c *Client) prepareGetUserIDByObjectIDStmt() error {
 func (c *Client) prepareRecordTokenToUserIDStmt() error {
 	stmt, err := c.DB.Preparex(`
 		INSERT INTO
-			tokens as t (token, user_id, created_at)
-		VALUES ($1, $2, now())
-		ON CONFLICT (token) DO NOTHING;
+			tokens as t (token, user_id, auth_user_type, created_at)
+		SELECT $1, $2, NULLIF($3, ''), now()
+		WHERE NOT EXISTS (SELECT 1 FROM blocked_users WHERE user_id = $2)
+			AND NOT EXISTS (SELECT 1 FROM tokens WHERE token = $1)
+		ON CONFLICT DO NOTHING;
 	`)
 	if err != nil {
 		return fmt.Errorf("error preparing record token to user id statement: %w", err)
@@ -54,54 +59,123 @@ func (c *Client) prepareRecordTokenToUserIDStmt() error {
 	return nil
 }
 
-func (c *Client) GetOrCreateUserID(ctx context.Context, payload user.CreateTokenPayload) (string, error) {
+// GetOrCreateUserID gets the user id of the login in the payload, creating a
+// new user if nobody has the login yet. The login is normalized first, but
+// is also looked up as typed, as it may be stored that way until
+// cmd/mergelogins has run. A new user is announced with a user.created
+// event.
+func (c *Client) GetOrCreateUserID(ctx context.Context, payload user.CreateTokenPayload) (string, bool, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetOrCreateUserID")
 	defer span.Finish()
 
+	typed := payload.Login
+	payload.Login = c.NormalizeLogin(payload.Login, payload.AuthMethod)
+
 	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	userID, err := c.getUserIDFromObjectID(ctx, payload)
+	userID, err := c.getUserIDFromObjectID(ctx, payload, typed)
 	if err != nil {
-		return "", fmt.Errorf(
+		return "", false, fmt.Errorf(
 			"error getting user id from object id: %w", err)
 	}
 
 	if userID != "" {
-		return userID, nil
+		return userID, false, nil
+	}
+
+	if err := c.CheckLoginAvailable(ctx, payload.Login, payload.AuthUserType); err != nil {
+		return "", false, err
 	}
 
 	userID, err = generateUUID()
 	if err != nil {
-		return "", fmt.Errorf("error generating new user id: %w", err)
+		return "", false, fmt.Errorf("error generating new user id: %w", err)
+	}
+
+	ciphertext, keyID, err := c.encryptLogin(cctx, payload.Login)
+	if err != nil {
+		return "", false, err
 	}
 
-	_, err = c.RecordUserIDToObjectIDStmt.ExecContext(
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return "", false, fmt.Errorf("error starting create user transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.StmtxContext(cctx, c.RecordUserIDToObjectIDStmt).ExecContext(
 		cctx,
 		userID,
-		payload.Login,
+		c.loginKey(payload.Login),
 		payload.AuthMethod,
 		payload.AuthUserType,
+		user.ClassifyLogin(payload.Login, payload.AuthMethod),
+		ciphertext,
+		keyID,
 	)
 	if err != nil {
-		return "", fmt.Errorf("error recording token to user id: %w", err)
+		return "", false, fmt.Errorf("error recording token to user id: %w", err)
 	}
 
-	return userID, nil
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return "", false, fmt.Errorf("error getting rows affected by recording user id: %w", err)
+	}
+
+	if rows == 0 {
+		// A concurrent request created the user first.
+		userID, err = c.getUserIDFromObjectID(ctx, payload, typed)
+		if err != nil {
+			return "", false, fmt.Errorf("error getting user id from object id: %w", err)
+		}
+		return userID, false, nil
+	}
+
+	created, err := event.New(event.TypeUserCreated, userID, event.UserCreated{
+		AuthUserType: payload.AuthUserType,
+		AuthMethod:   payload.AuthMethod,
+		LoginType:    string(user.ClassifyLogin(payload.Login, payload.AuthMethod)),
+	})
+	if err != nil {
+		return "", false, err
+	}
+
+	if err := insertEvents(cctx, tx, created); err != nil {
+		return "", false, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", false, fmt.Errorf("error committing create user transaction: %w", err)
+	}
 
+	return userID, true, nil
 }
 
-func (c *Client) getUserIDFromObjectID(ctx context.Context, payload user.CreateTokenPayload) (string, error) {
+// getUserIDFromObjectID gets the id of the user of the normalized login in
+// the payload, or of one of the other forms of it. A row of the normalized
+// login wins over the others, which only remain until they are merged.
+func (c *Client) getUserIDFromObjectID(ctx context.Context, payload user.CreateTokenPayload, forms ...string) (string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "getUserIDFromObjectID")
 	defer span.Finish()
 
 	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	r := c.GetUserIDByObjectIDStmt.QueryRowContext(cctx, payload.Login, payload.AuthUserType)
+	keys, err := c.loginKeys(append([]string{payload.Login}, forms...)...)
+	if err != nil {
+		return "", err
+	}
+
+	normalizedKeys, err := c.loginKeys(payload.Login)
+	if err != nil {
+		return "", err
+	}
+
+	r := c.GetUserIDByObjectIDStmt.QueryRowContext(cctx, keys, payload.AuthUserType, normalizedKeys)
 
 	var userID string
-	err := r.Scan(&userID)
+	err = r.Scan(&userID)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return "", nil
@@ -112,7 +186,9 @@ func (c *Client) getUserIDFromObjectID(ctx context.Context, payload user.CreateT
 	return userID, nil
 }
 
-func (c *Client) CreateToken(ctx context.Context, userID string) (string, error) {
+// CreateToken stores a new token of the user, issued for the auth user type,
+// along with the events about it. Blocked users get user.ErrUserBlocked.
+func (c *Client) CreateToken(ctx context.Context, userID, authUserType string, events ...event.Event) (string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateToken")
 	defer span.Finish()
 
@@ -124,15 +200,46 @@ func (c *Client) CreateToken(ctx context.Context, userID string) (string, error)
 		return "", fmt.Errorf("error generating token: %w", err)
 	}
 
-	_, err = c.RecordTokenToUserIDStmt.ExecContext(
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting create token transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	// Wait for a concurrent block of the user, to see it.
+	if err := lockUser(cctx, tx, userID); err != nil {
+		return "", err
+	}
+
+	result, err := tx.StmtxContext(cctx, c.RecordTokenToUserIDStmt).ExecContext(
 		cctx,
 		token,
 		userID,
+		authUserType,
 	)
 	if err != nil {
 		return "", fmt.Errorf("error recording token to user id: %w", err)
 	}
 
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return "", fmt.Errorf("error recording token to user id: %w", err)
+	}
+
+	if recorded == 0 {
+		// Tokens are random UUIDs, so a taken token is not worth telling
+		// apart: the user is blocked.
+		return "", user.ErrUserBlocked
+	}
+
+	if err := insertEvents(cctx, tx, events...); err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing create token transaction: %w", err)
+	}
+
 	return token, nil
 }
 
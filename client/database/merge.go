@@ -0,0 +1,333 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jmoiron/sqlx"
+)
+
+// StoredLogin is a login of user_ids, decrypted.
+type StoredLogin struct {
+	UserID       string
+	Login        string
+	AuthUserType string
+	AuthMethod   string
+
+	// stored is the login column: the login or its blind index.
+	stored string
+}
+
+// UnnormalizedLogin is a login stored in a form other than its normalized
+// one, possibly in several forms by several users.
+type UnnormalizedLogin struct {
+	Login        string
+	AuthUserType string
+	Stored       []StoredLogin
+}
+
+// UserIDs returns the ids of the users having the login, in order.
+func (u UnnormalizedLogin) UserIDs() []string {
+	seen := map[string]bool{}
+	var userIDs []string
+	for _, s := range u.Stored {
+		if !seen[s.UserID] {
+			seen[s.UserID] = true
+			userIDs = append(userIDs, s.UserID)
+		}
+	}
+	sort.Strings(userIDs)
+	return userIDs
+}
+
+// FindUnnormalizedLogins finds the logins stored before logins were
+// normalized: those stored in another form than the normalized one, and
+// those stored in several forms, which created duplicate users.
+func (c *Client) FindUnnormalizedLogins(ctx context.Context) ([]UnnormalizedLogin, error) {
+	rows, err := c.DB.QueryxContext(ctx, `
+		SELECT user_id, login, auth_user_type, auth_method, login_ciphertext, encryption_key_id
+		FROM user_ids
+		ORDER BY auth_user_type, login;
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching logins: %w", err)
+	}
+	defer rows.Close()
+
+	type key struct{ login, authUserType string }
+	groups := map[key]*UnnormalizedLogin{}
+	var keys []key
+
+	for rows.Next() {
+		var row loginRow
+		if err := rows.StructScan(&row); err != nil {
+			return nil, fmt.Errorf("error scanning login: %w", err)
+		}
+
+		login, err := c.decryptLogin(ctx, row.Login, row.LoginCiphertext, row.EncryptionKeyID)
+		if err != nil {
+			return nil, err
+		}
+
+		s := StoredLogin{
+			UserID:       row.UserID,
+			Login:        login,
+			AuthUserType: row.AuthUserType,
+			AuthMethod:   row.AuthMethod,
+			stored:       row.Login,
+		}
+
+		k := key{c.NormalizeLogin(s.Login, s.AuthMethod), s.AuthUserType}
+		g, ok := groups[k]
+		if !ok {
+			g = &UnnormalizedLogin{Login: k.login, AuthUserType: k.authUserType}
+			groups[k] = g
+			keys = append(keys, k)
+		}
+		g.Stored = append(g.Stored, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error fetching logins: %w", err)
+	}
+
+	var unnormalized []UnnormalizedLogin
+	for _, k := range keys {
+		g := groups[k]
+		if len(g.Stored) > 1 || g.Stored[0].stored != c.loginKey(g.Login) {
+			unnormalized = append(unnormalized, *g)
+		}
+	}
+
+	return unnormalized, nil
+}
+
+// MergeLogin stores the login in its normalized form only. If it was stored
+// by several users, they are merged into the user who got a token last:
+// their other logins, tokens and pending login changes move to that user
+// and the merge is recorded in user_merges.
+// Returns the id of the user left with the login, or an empty id if the
+// login is gone.
+func (c *Client) MergeLogin(ctx context.Context, u UnnormalizedLogin) (string, error) {
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("error starting merge transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var stored pgtype.TextArray
+	logins := make([]string, 0, len(u.Stored)+1)
+	for _, s := range u.Stored {
+		logins = append(logins, s.stored)
+	}
+	logins = append(logins, c.loginKey(u.Login))
+	if err := stored.Set(logins); err != nil {
+		return "", fmt.Errorf("error encoding logins: %w", err)
+	}
+
+	// The users of u were read before any login was merged. Merging another
+	// login since may have merged one of them into another user, so the
+	// users are read again, and locked, here and resolved through
+	// user_merges.
+	owners, err := loginOwners(ctx, tx, u.AuthUserType, &stored)
+	if err != nil {
+		return "", err
+	}
+	if len(owners) == 0 {
+		return "", nil
+	}
+
+	userIDs, err := resolveMerges(ctx, tx, owners)
+	if err != nil {
+		return "", err
+	}
+
+	into, err := lastActiveUser(ctx, tx, userIDs)
+	if err != nil {
+		return "", err
+	}
+
+	// Merge the users first, so all the rows of the login belong to into.
+	merged := map[string]bool{into: true}
+	for _, userID := range append(owners, userIDs...) {
+		if merged[userID] {
+			continue
+		}
+		merged[userID] = true
+
+		if err := c.mergeUser(ctx, tx, userID, into, u); err != nil {
+			return "", err
+		}
+	}
+
+	ciphertext, keyID, err := c.encryptLogin(ctx, u.Login)
+	if err != nil {
+		return "", err
+	}
+
+	// Keep a single row of the login.
+	_, err = tx.ExecContext(ctx, `
+		DELETE FROM user_ids
+		WHERE auth_user_type = $1 AND login = ANY($2)
+			AND login <> (
+				SELECT login FROM user_ids
+				WHERE auth_user_type = $1 AND login = ANY($2)
+				ORDER BY login = $3 DESC, verified_at NULLS LAST
+				LIMIT 1
+			);
+	`, u.AuthUserType, &stored, c.loginKey(u.Login))
+	if err != nil {
+		return "", fmt.Errorf("error deleting duplicate logins: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		UPDATE user_ids SET
+			login = $3,
+			login_ciphertext = $4,
+			encryption_key_id = $5
+		WHERE auth_user_type = $1 AND login = ANY($2);
+	`, u.AuthUserType, &stored, c.loginKey(u.Login), ciphertext, keyID)
+	if err != nil {
+		return "", fmt.Errorf("error normalizing login: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", fmt.Errorf("error committing merge: %w", err)
+	}
+
+	return into, nil
+}
+
+// loginOwners returns the users having one of the stored forms of a login,
+// in order, locking the rows of the login.
+func loginOwners(ctx context.Context, tx *sqlx.Tx, authUserType string, stored *pgtype.TextArray) ([]string, error) {
+	var owners []string
+	err := tx.SelectContext(ctx, &owners, `
+		SELECT DISTINCT user_id FROM (
+			SELECT user_id FROM user_ids
+			WHERE auth_user_type = $1 AND login = ANY($2)
+			FOR UPDATE
+		) AS owners
+		ORDER BY user_id;
+	`, authUserType, stored)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching users of login: %w", err)
+	}
+
+	return owners, nil
+}
+
+// resolveMerges replaces the users merged into another one by the user they
+// were finally merged into. Returns the users in order.
+func resolveMerges(ctx context.Context, tx *sqlx.Tx, userIDs []string) ([]string, error) {
+	resolved := map[string]bool{}
+	seen := map[string]bool{}
+
+	for len(userIDs) > 0 {
+		var array pgtype.TextArray
+		if err := array.Set(userIDs); err != nil {
+			return nil, fmt.Errorf("error encoding user ids: %w", err)
+		}
+
+		var merges []struct {
+			MergedUserID string `db:"merged_user_id"`
+			IntoUserID   string `db:"into_user_id"`
+		}
+		err := tx.SelectContext(ctx, &merges, `
+			SELECT merged_user_id, into_user_id FROM user_merges
+			WHERE merged_user_id = ANY($1);
+		`, &array)
+		if err != nil {
+			return nil, fmt.Errorf("error resolving merged users: %w", err)
+		}
+
+		mergedInto := make(map[string]string, len(merges))
+		for _, m := range merges {
+			mergedInto[m.MergedUserID] = m.IntoUserID
+		}
+
+		var next []string
+		for _, userID := range userIDs {
+			if seen[userID] {
+				continue
+			}
+			seen[userID] = true
+
+			if into, ok := mergedInto[userID]; ok {
+				next = append(next, into)
+			} else {
+				resolved[userID] = true
+			}
+		}
+		userIDs = next
+	}
+
+	result := make([]string, 0, len(resolved))
+	for userID := range resolved {
+		result = append(result, userID)
+	}
+	sort.Strings(result)
+
+	return result, nil
+}
+
+// lastActiveUser returns the user who got a token last, or the first one if
+// none has a token.
+func lastActiveUser(ctx context.Context, tx *sqlx.Tx, userIDs []string) (string, error) {
+	if len(userIDs) == 1 {
+		return userIDs[0], nil
+	}
+
+	var array pgtype.TextArray
+	if err := array.Set(userIDs); err != nil {
+		return "", fmt.Errorf("error encoding user ids: %w", err)
+	}
+
+	var active []string
+	err := tx.SelectContext(ctx, &active, `
+		SELECT user_id FROM tokens
+		WHERE user_id = ANY($1)
+		ORDER BY created_at DESC
+		LIMIT 1;
+	`, &array)
+	if err != nil {
+		return "", fmt.Errorf("error finding last active user: %w", err)
+	}
+
+	if len(active) == 0 {
+		return userIDs[0], nil
+	}
+
+	return active[0], nil
+}
+
+// mergeUser moves everything of user from to user into.
+func (c *Client) mergeUser(ctx context.Context, tx *sqlx.Tx, from, into string, u UnnormalizedLogin) error {
+	statements := []struct {
+		query string
+		what  string
+	}{
+		{`UPDATE user_ids SET user_id = $2 WHERE user_id = $1;`, "logins"},
+		{`UPDATE tokens SET user_id = $2 WHERE user_id = $1;`, "tokens"},
+		{`UPDATE login_changes SET user_id = $2 WHERE user_id = $1 AND applied_at IS NULL AND cancelled_at IS NULL;`, "login changes"},
+	}
+
+	for _, s := range statements {
+		if _, err := tx.ExecContext(ctx, s.query, from, into); err != nil {
+			return fmt.Errorf("error moving %s of user %s to %s: %w", s.what, from, into, err)
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO
+			user_merges (merged_user_id, into_user_id, login, auth_user_type)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (merged_user_id) DO NOTHING;
+	`, from, into, c.loginKey(u.Login), u.AuthUserType)
+	if err != nil {
+		return fmt.Errorf("error recording merge of user %s into %s: %w", from, into, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/event"
+	"gitlab.com/route-kz/auth-api/monitoring/metrics"
+	"gitlab.com/route-kz/auth-api/monitoring/trace"
+)
+
+// outboxRelayLock is the advisory lock making only one instance relay the
+// outbox at a time, so the events of a user are published in order.
+const outboxRelayLock = 7_340_001
+
+// insertEvents writes events to the outbox in the transaction of the change
+// they are about, with the span context of ctx. They are published by
+// RelayOutboxEvents once the transaction is committed, and the deliveries to
+// the webhooks subscribed to them are queued along.
+func insertEvents(ctx context.Context, tx *sqlx.Tx, events ...event.Event) error {
+	for _, e := range events {
+		if e.Trace == nil {
+			e.Trace = trace.InjectIntoCarrier(ctx)
+		}
+
+		payload, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("error marshalling %s event: %w", e.Type, err)
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO event_outbox (event_id, user_id, type, payload, created_at)
+			VALUES ($1, $2, $3, $4, $5);
+		`, e.ID, e.UserID, e.Type, payload, e.OccurredAt)
+		if err != nil {
+			return fmt.Errorf("error writing %s event to the outbox: %w", e.Type, err)
+		}
+
+		// Partners get the event without the span context, which is
+		// internal.
+		e.Trace = nil
+		webhookPayload, err := json.Marshal(e)
+		if err != nil {
+			return fmt.Errorf("error marshalling %s event: %w", e.Type, err)
+		}
+
+		if err := enqueueWebhooks(ctx, tx, e.ID, string(e.Type), webhookPayload); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type outboxRow struct {
+	ID        int64     `db:"id"`
+	UserID    string    `db:"user_id"`
+	Type      string    `db:"type"`
+	Payload   []byte    `db:"payload"`
+	CreatedAt time.Time `db:"created_at"`
+	Attempts  int       `db:"attempts"`
+}
+
+// RelayOutboxEvents publishes the events waiting in the outbox, oldest
+// first, and returns how many were published. Events are delivered at least
+// once: they are marked published only after the publisher accepted them.
+// A failed event is retried with exponential backoff, and the later events
+// of its user wait for it, so that each user's events stay in order.
+func (c *Client) RelayOutboxEvents(ctx context.Context, publisher event.Publisher) (int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "RelayOutboxEvents")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting outbox transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var locked bool
+	if err := tx.GetContext(cctx, &locked, `SELECT pg_try_advisory_xact_lock($1);`, outboxRelayLock); err != nil {
+		return 0, fmt.Errorf("error locking the outbox: %w", err)
+	}
+	if !locked {
+		// Another instance is relaying.
+		return 0, nil
+	}
+
+	var rows []outboxRow
+	err = tx.SelectContext(cctx, &rows, `
+		SELECT id, user_id, type, payload, created_at, attempts
+		FROM event_outbox o
+		WHERE published_at IS NULL AND next_attempt_at <= now()
+			AND NOT EXISTS (
+				SELECT 1 FROM event_outbox earlier
+				WHERE earlier.user_id = o.user_id AND earlier.published_at IS NULL AND earlier.id < o.id
+					AND earlier.next_attempt_at > now()
+			)
+		ORDER BY id
+		LIMIT $1;
+	`, c.outboxBatchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching outbox events: %w", err)
+	}
+
+	published := 0
+	failedUsers := make(map[string]bool)
+	for _, row := range rows {
+		if failedUsers[row.UserID] {
+			continue
+		}
+
+		if err := c.relayOutboxEvent(cctx, publisher, row); err != nil {
+			log.Errorf("error publishing outbox event %d: %s", row.ID, err)
+			metrics.FailedToPublishEvent(row.Type)
+			failedUsers[row.UserID] = true
+
+			_, err = tx.ExecContext(cctx, `
+				UPDATE event_outbox SET
+					attempts = attempts + 1,
+					last_error = $2,
+					next_attempt_at = now() + $3 * interval '1 second'
+				WHERE id = $1;
+			`, row.ID, err.Error(), c.outboxRetryDelay(row.Attempts).Seconds())
+			if err != nil {
+				return published, fmt.Errorf("error recording outbox event failure: %w", err)
+			}
+			continue
+		}
+
+		_, err = tx.ExecContext(cctx, `UPDATE event_outbox SET published_at = now() WHERE id = $1;`, row.ID)
+		if err != nil {
+			return published, fmt.Errorf("error marking outbox event published: %w", err)
+		}
+
+		metrics.PublishedEvent(row.Type, row.Attempts, time.Since(row.CreatedAt).Seconds())
+		published++
+	}
+
+	_, err = tx.ExecContext(cctx, `
+		DELETE FROM event_outbox
+		WHERE published_at < now() - $1 * interval '1 second';
+	`, c.outboxRetention.Seconds())
+	if err != nil {
+		return published, fmt.Errorf("error deleting published outbox events: %w", err)
+	}
+
+	var pending int
+	if err := tx.GetContext(cctx, &pending, `SELECT count(*) FROM event_outbox WHERE published_at IS NULL;`); err != nil {
+		return published, fmt.Errorf("error counting pending outbox events: %w", err)
+	}
+	metrics.PendingEvents(pending)
+
+	if err := tx.Commit(); err != nil {
+		return published, fmt.Errorf("error committing outbox transaction: %w", err)
+	}
+
+	return published, nil
+}
+
+// relayOutboxEvent publishes an event from the outbox, in a span following
+// the one of the request that wrote it.
+func (c *Client) relayOutboxEvent(ctx context.Context, publisher event.Publisher, row outboxRow) error {
+	var e event.Event
+	if err := json.Unmarshal(row.Payload, &e); err != nil {
+		return fmt.Errorf("error unmarshalling event: %w", err)
+	}
+
+	span, ctx := trace.ExtractFromCarrier(ctx, e.Trace, "RelayOutboxEvent")
+	defer span.Finish()
+
+	return publisher.Publish(ctx, e)
+}
+
+// outboxRetryDelay returns how long to wait before the next attempt to
+// publish an event that failed attempts times before.
+func (c *Client) outboxRetryDelay(attempts int) time.Duration {
+	delay := float64(c.outboxRetryBase) * math.Pow(2, float64(attempts))
+	if delay > float64(c.outboxRetryMax) {
+		return c.outboxRetryMax
+	}
+	return time.Duration(delay)
+}
+
+// RunOutboxRelay calls RelayOutboxEvents every interval until ctx is done,
+// right away again while full batches are published.
+func (c *Client) RunOutboxRelay(ctx context.Context, publisher event.Publisher, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for {
+				published, err := c.RelayOutboxEvents(ctx, publisher)
+				if err != nil {
+					log.Error(err.Error())
+				}
+				if err != nil || published < c.outboxBatchSize || ctx.Err() != nil {
+					break
+				}
+			}
+		}
+	}
+}
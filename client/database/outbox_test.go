@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"gitlab.com/route-kz/auth-api/event"
+)
+
+func TestRelayOutboxEvent(t *testing.T) {
+	e, err := event.New(event.TypeTokenRevoked, "user", event.TokenRevoked{Reason: event.ReasonRevoke})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publishErr := errors.New("nats: connection closed")
+
+	tests := []struct {
+		name       string
+		payload    []byte
+		publishErr error
+		wantErr    bool
+		wantEvents int
+	}{
+		{name: "published", payload: payload, wantEvents: 1},
+		{name: "malformed payload", payload: []byte(`{"id":`), wantErr: true},
+		{name: "publish error", payload: payload, publishErr: publishErr, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			publisher := &event.MemoryPublisher{Err: tt.publishErr}
+			c := &Client{}
+
+			err := c.relayOutboxEvent(context.Background(), publisher, outboxRow{ID: 1, Payload: tt.payload})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("relayOutboxEvent() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.publishErr != nil && !errors.Is(err, tt.publishErr) {
+				t.Errorf("relayOutboxEvent() error = %v, want %v", err, tt.publishErr)
+			}
+
+			events := publisher.Events()
+			if len(events) != tt.wantEvents {
+				t.Fatalf("published %d events, want %d", len(events), tt.wantEvents)
+			}
+			if tt.wantEvents > 0 && (events[0].ID != e.ID || events[0].Type != e.Type || !events[0].OccurredAt.Equal(e.OccurredAt)) {
+				t.Errorf("published %+v, want %+v", events[0], e)
+			}
+		})
+	}
+}
+
+func TestOutboxRetryDelay(t *testing.T) {
+	c := &Client{outboxRetryBase: time.Second, outboxRetryMax: 5 * time.Minute}
+
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{0, time.Second},
+		{1, 2 * time.Second},
+		{4, 16 * time.Second},
+		{8, 256 * time.Second},
+		{9, 5 * time.Minute},
+		{1000, 5 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := c.outboxRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("outboxRetryDelay(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// tokensPartitionLayout is the time layout of monthly tokens partition names,
+// e.g. tokens_p2023_06.
+const tokensPartitionLayout = "tokens_p2006_01"
+
+// tokenPartitionsLock is the advisory lock making only one instance
+// maintain the tokens partitions at a time.
+const tokenPartitionsLock = 7_340_002
+
+// MaintainTokenPartitions creates the monthly tokens partitions for the
+// current month and the configured number of months ahead, and drops the
+// partitions whose newest possible token is older than the max token lifetime.
+// It does nothing unless the tokens table is partitioned, or while another
+// instance is maintaining the partitions.
+//
+// Expired partitions are detached concurrently before they are dropped, so
+// tokens is not locked against reads and writes while they go. DETACH ...
+// CONCURRENTLY can not run in a transaction, so the lock is held by the
+// session of a dedicated connection rather than a transaction.
+func (c *Client) MaintainTokenPartitions(ctx context.Context) error {
+	if !c.tokensPartitioned {
+		return nil
+	}
+
+	span, ctx := opentracing.StartSpanFromContext(ctx, "MaintainTokenPartitions")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Minute)
+	defer cancel()
+
+	conn, err := c.DB.Connx(cctx)
+	if err != nil {
+		return fmt.Errorf("error getting a connection for tokens partitions: %w", err)
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.GetContext(cctx, &locked, `SELECT pg_try_advisory_lock($1);`, tokenPartitionsLock); err != nil {
+		return fmt.Errorf("error locking tokens partitions: %w", err)
+	}
+	if !locked {
+		// Another instance is maintaining them.
+		return nil
+	}
+	defer func() {
+		// The connection goes back to the pool, so the lock must not stay
+		// with it. ctx may be done by now.
+		if _, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1);`, tokenPartitionsLock); err != nil {
+			log.Errorf("error unlocking tokens partitions: %s", err)
+		}
+	}()
+
+	now := time.Now().UTC()
+	month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i <= c.tokensPartitionsAhead; i++ {
+		from := month.AddDate(0, i, 0)
+		if err := createTokenPartition(cctx, conn, from); err != nil {
+			return err
+		}
+	}
+
+	partitions, err := listTokenPartitions(cctx, conn)
+	if err != nil {
+		return err
+	}
+
+	expiredBefore := now.Add(-c.tokenMaxLifetime)
+	for name, partition := range partitions {
+		// The partition holds tokens created before the start of the next month.
+		if partition.from.AddDate(0, 1, 0).After(expiredBefore) {
+			continue
+		}
+
+		// A detach interrupted before, e.g. by a restart, is left pending
+		// and can only be finalized.
+		detach := `ALTER TABLE tokens DETACH PARTITION %s CONCURRENTLY;`
+		if partition.detachPending {
+			detach = `ALTER TABLE tokens DETACH PARTITION %s FINALIZE;`
+		}
+		if _, err := conn.ExecContext(cctx, fmt.Sprintf(detach, name)); err != nil {
+			return fmt.Errorf("error detaching tokens partition %s: %w", name, err)
+		}
+
+		if _, err := conn.ExecContext(cctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s;`, name)); err != nil {
+			return fmt.Errorf("error dropping tokens partition %s: %w", name, err)
+		}
+
+		log.Infof("Dropped expired tokens partition %s", name)
+	}
+
+	return nil
+}
+
+// RunTokenPartitionMaintenance calls MaintainTokenPartitions every interval
+// until ctx is done.
+func (c *Client) RunTokenPartitionMaintenance(ctx context.Context, interval time.Duration) {
+	if !c.tokensPartitioned {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.MaintainTokenPartitions(ctx); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+}
+
+func createTokenPartition(ctx context.Context, conn *sqlx.Conn, from time.Time) error {
+	name := from.Format(tokensPartitionLayout)
+	to := from.AddDate(0, 1, 0)
+
+	_, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s
+		PARTITION OF tokens
+		FOR VALUES FROM ('%s') TO ('%s');`,
+		name,
+		from.Format(time.RFC3339),
+		to.Format(time.RFC3339),
+	))
+	if err != nil {
+		return fmt.Errorf("error creating tokens partition %s: %w", name, err)
+	}
+
+	return nil
+}
+
+// tokenPartition is a monthly partition of the tokens table.
+type tokenPartition struct {
+	// from is the first day of the month the partition holds.
+	from time.Time
+	// detachPending is set for partitions left half detached.
+	detachPending bool
+}
+
+// listTokenPartitions returns the monthly partitions of the tokens table by
+// name. Partitions not following the naming scheme (e.g. a default
+// partition) are left alone.
+func listTokenPartitions(ctx context.Context, conn *sqlx.Conn) (map[string]tokenPartition, error) {
+	var rows []struct {
+		Name          string `db:"relname"`
+		DetachPending bool   `db:"inhdetachpending"`
+	}
+	err := conn.SelectContext(ctx, &rows, `
+		SELECT
+			child.relname,
+			pg_inherits.inhdetachpending
+		FROM pg_inherits
+		JOIN pg_class parent ON parent.oid = pg_inherits.inhparent
+		JOIN pg_class child ON child.oid = pg_inherits.inhrelid
+		WHERE parent.relname = 'tokens';
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("error listing tokens partitions: %w", err)
+	}
+
+	partitions := make(map[string]tokenPartition, len(rows))
+	for _, row := range rows {
+		if !strings.HasPrefix(row.Name, "tokens_p") {
+			continue
+		}
+
+		from, err := time.Parse(tokensPartitionLayout, row.Name)
+		if err != nil {
+			continue
+		}
+		partitions[row.Name] = tokenPartition{from: from, detachPending: row.DetachPending}
+	}
+
+	return partitions, nil
+}
@@ -0,0 +1,473 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/event"
+	"gitlab.com/route-kz/auth-api/scim"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+type scimUserRow struct {
+	UserID          string    `db:"user_id"`
+	ExternalID      *string   `db:"external_id"`
+	GivenName       string    `db:"given_name"`
+	FamilyName      string    `db:"family_name"`
+	DisplayName     string    `db:"display_name"`
+	Active          bool      `db:"active"`
+	CreatedAt       time.Time `db:"created_at"`
+	UpdatedAt       time.Time `db:"updated_at"`
+	Login           string    `db:"login"`
+	LoginCiphertext []byte    `db:"login_ciphertext"`
+	EncryptionKeyID *int      `db:"encryption_key_id"`
+}
+
+const scimUserColumns = `
+	p.user_id, p.external_id, p.given_name, p.family_name, p.display_name, p.active, p.created_at, p.updated_at,
+	u.login, u.login_ciphertext, u.encryption_key_id`
+
+// scimUsersFrom joins the profiles of the users of tenant $1 to their
+// logins of auth user type $2 and auth method $3, the userName. Deleted
+// users are left out.
+const scimUsersFrom = `
+	FROM user_profiles p
+	JOIN user_ids u ON u.user_id = p.user_id AND u.auth_user_type = $2 AND u.auth_method = $3
+	WHERE p.tenant_id = $1 AND p.deleted_at IS NULL`
+
+// GetSCIMTenantByTokenHash gets an enabled tenant by the hash of its token.
+// Returns nil if there is no such tenant.
+func (c *Client) GetSCIMTenantByTokenHash(ctx context.Context, tokenHash string) (*scim.Tenant, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GetSCIMTenantByTokenHash")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var t scim.Tenant
+	err := c.DB.QueryRowxContext(cctx, `
+		SELECT id, name, auth_user_type
+		FROM scim_tenants
+		WHERE token_hash = $1 AND disabled_at IS NULL;
+	`, tokenHash).Scan(&t.ID, &t.Name, &t.AuthUserType)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting scim tenant: %w", err)
+	}
+
+	return &t, nil
+}
+
+// CreateSCIMTenant registers a tenant, or replaces the token and auth user
+// type of an existing tenant with the same name.
+func (c *Client) CreateSCIMTenant(ctx context.Context, t scim.Tenant, tokenHash string) (*scim.Tenant, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateSCIMTenant")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	err := c.DB.QueryRowxContext(cctx, `
+		INSERT INTO
+			scim_tenants (name, token_hash, auth_user_type)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (name) DO UPDATE SET
+			token_hash = excluded.token_hash,
+			auth_user_type = excluded.auth_user_type,
+			disabled_at = NULL
+		RETURNING id;
+	`, t.Name, tokenHash, t.AuthUserType).Scan(&t.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error creating scim tenant: %w", err)
+	}
+
+	return &t, nil
+}
+
+// CreateSCIMUser provisions a new user of the tenant: their userName,
+// normalized, becomes a login of the tenant's auth user type and the rest
+// goes to their profile. Inactive users are blocked right away.
+// Returns scim.ErrUniqueness if the login or the externalId is taken.
+func (c *Client) CreateSCIMUser(ctx context.Context, tenant *scim.Tenant, u scim.User) (*scim.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateSCIMUser")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	u.UserName = c.NormalizeLogin(u.UserName, scim.AuthMethod)
+
+	existing, err := c.GetUserIDByLogin(ctx, u.UserName, tenant.AuthUserType)
+	if err != nil {
+		return nil, fmt.Errorf("error getting user id of scim user: %w", err)
+	}
+	if existing != "" {
+		return nil, scim.ErrUniqueness
+	}
+
+	if err := c.CheckLoginAvailable(ctx, u.UserName, tenant.AuthUserType); err != nil {
+		return nil, scimLoginError(err)
+	}
+
+	u.ID, err = generateUUID()
+	if err != nil {
+		return nil, fmt.Errorf("error generating new user id: %w", err)
+	}
+
+	ciphertext, keyID, err := c.encryptLogin(cctx, u.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting create scim user transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	loginType := user.ClassifyLogin(u.UserName, scim.AuthMethod)
+
+	result, err := tx.StmtxContext(cctx, c.RecordUserIDToObjectIDStmt).ExecContext(
+		cctx,
+		u.ID,
+		c.loginKey(u.UserName),
+		scim.AuthMethod,
+		tenant.AuthUserType,
+		loginType,
+		ciphertext,
+		keyID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error recording scim user login: %w", err)
+	}
+
+	recorded, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("error recording scim user login: %w", err)
+	}
+	if recorded == 0 {
+		// A concurrent request took the login first.
+		return nil, scim.ErrUniqueness
+	}
+
+	err = tx.QueryRowxContext(cctx, `
+		INSERT INTO
+			user_profiles (user_id, tenant_id, external_id, given_name, family_name, display_name, active)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING created_at, updated_at;
+	`, u.ID, tenant.ID, nullString(u.ExternalID), u.GivenName, u.FamilyName, u.DisplayName, u.Active).Scan(&u.Created, &u.LastModified)
+	if isUniqueViolation(err) {
+		return nil, scim.ErrUniqueness.Wrap(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error creating user profile: %w", err)
+	}
+
+	created, err := event.New(event.TypeUserCreated, u.ID, event.UserCreated{
+		AuthUserType: tenant.AuthUserType,
+		AuthMethod:   scim.AuthMethod,
+		LoginType:    string(loginType),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := insertEvents(cctx, tx, created); err != nil {
+		return nil, err
+	}
+
+	if !u.Active {
+		if err := blockUser(cctx, tx, u.ID, scim.BlockReason); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing create scim user transaction: %w", err)
+	}
+
+	return &u, nil
+}
+
+// SCIMUser gets a user provisioned by the tenant.
+// Returns scim.ErrNotFound if there is no such user.
+func (c *Client) SCIMUser(ctx context.Context, tenant *scim.Tenant, id string) (*scim.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SCIMUser")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var rows []scimUserRow
+	err := c.DB.SelectContext(cctx, &rows, `SELECT `+scimUserColumns+scimUsersFrom+` AND p.user_id = $4;`,
+		tenant.ID, tenant.AuthUserType, scim.AuthMethod, id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching scim user: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return nil, scim.ErrNotFound
+	}
+
+	users, err := c.toSCIMUsers(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return &users[0], nil
+}
+
+// SCIMUsers returns a page of the users provisioned by the tenant matching
+// filter, oldest first, and how many match in all. startIndex is 1-based.
+func (c *Client) SCIMUsers(ctx context.Context, tenant *scim.Tenant, filter scim.Filter, startIndex, count int) ([]scim.User, int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SCIMUsers")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	args := []interface{}{tenant.ID, tenant.AuthUserType, scim.AuthMethod}
+	var conditions string
+	if filter.UserName != nil {
+		keys, err := c.loginKeys(c.NormalizeLogin(*filter.UserName, scim.AuthMethod))
+		if err != nil {
+			return nil, 0, err
+		}
+		args = append(args, keys)
+		conditions += fmt.Sprintf(" AND u.login = ANY($%d)", len(args))
+	}
+	if filter.ExternalID != nil {
+		args = append(args, *filter.ExternalID)
+		conditions += fmt.Sprintf(" AND p.external_id = $%d", len(args))
+	}
+	if filter.Active != nil {
+		args = append(args, *filter.Active)
+		conditions += fmt.Sprintf(" AND p.active = $%d", len(args))
+	}
+
+	var total int
+	if err := c.DB.GetContext(cctx, &total, `SELECT count(*)`+scimUsersFrom+conditions+`;`, args...); err != nil {
+		return nil, 0, fmt.Errorf("error counting scim users: %w", err)
+	}
+
+	if total == 0 || count == 0 {
+		return []scim.User{}, total, nil
+	}
+
+	args = append(args, startIndex-1, count)
+	var rows []scimUserRow
+	err := c.DB.SelectContext(cctx, &rows, fmt.Sprintf(
+		`SELECT `+scimUserColumns+scimUsersFrom+conditions+` ORDER BY p.created_at, p.user_id OFFSET $%d LIMIT $%d;`,
+		len(args)-1, len(args),
+	), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error fetching scim users: %w", err)
+	}
+
+	users, err := c.toSCIMUsers(ctx, rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return users, total, nil
+}
+
+// UpdateSCIMUser applies update to a user provisioned by the tenant. The
+// user is read and locked in the transaction that writes them, so concurrent
+// updates apply one after the other. A new userName replaces the login like
+// a login change. Deactivated users are blocked, which revokes their tokens,
+// and activated users are unblocked if they were blocked by deactivation.
+// Returns scim.ErrNotFound if there is no such user, the error of update if
+// it fails and scim.ErrUniqueness if the new login or externalId is taken.
+func (c *Client) UpdateSCIMUser(ctx context.Context, tenant *scim.Tenant, id string, update func(u *scim.User) error) (*scim.User, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "UpdateSCIMUser")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error starting update scim user transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows []scimUserRow
+	err = tx.SelectContext(cctx, &rows, `SELECT `+scimUserColumns+scimUsersFrom+` AND p.user_id = $4 FOR UPDATE OF p;`,
+		tenant.ID, tenant.AuthUserType, scim.AuthMethod, id)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching scim user: %w", err)
+	}
+
+	if len(rows) == 0 {
+		return nil, scim.ErrNotFound
+	}
+
+	users, err := c.toSCIMUsers(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	old := users[0]
+
+	u := old
+	if err := update(&u); err != nil {
+		return nil, err
+	}
+	u.ID = old.ID
+	u.UserName = c.NormalizeLogin(u.UserName, scim.AuthMethod)
+
+	if u.UserName != old.UserName {
+		if err := c.CheckLoginAvailable(ctx, u.UserName, tenant.AuthUserType); err != nil {
+			return nil, scimLoginError(err)
+		}
+
+		oldKeys, err := c.loginKeys(old.UserName)
+		if err != nil {
+			return nil, err
+		}
+
+		err = c.replaceLogin(cctx, tx.StmtxContext(cctx, c.ReplaceLoginStmt), u.ID, oldKeys, user.Login{
+			Login:        u.UserName,
+			Type:         user.ClassifyLogin(u.UserName, scim.AuthMethod),
+			AuthUserType: tenant.AuthUserType,
+			AuthMethod:   scim.AuthMethod,
+		})
+		if err != nil {
+			return nil, scimLoginError(err)
+		}
+	}
+
+	err = tx.QueryRowxContext(cctx, `
+		UPDATE user_profiles SET
+			external_id = $3,
+			given_name = $4,
+			family_name = $5,
+			display_name = $6,
+			active = $7,
+			updated_at = now()
+		WHERE user_id = $1 AND tenant_id = $2
+		RETURNING created_at, updated_at;
+	`, u.ID, tenant.ID, nullString(u.ExternalID), u.GivenName, u.FamilyName, u.DisplayName, u.Active).Scan(&u.Created, &u.LastModified)
+	if isUniqueViolation(err) {
+		return nil, scim.ErrUniqueness.Wrap(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error updating user profile: %w", err)
+	}
+
+	// Both are no-ops when the user already is in that state.
+	if u.Active {
+		err = unblockUser(cctx, tx, u.ID, scim.BlockReason)
+	} else {
+		err = blockUser(cctx, tx, u.ID, scim.BlockReason)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing update scim user transaction: %w", err)
+	}
+
+	return &u, nil
+}
+
+// DeleteSCIMUser deletes a user provisioned by the tenant: their profile is
+// marked deleted, so the tenant no longer finds them, and they are blocked
+// like when deactivated, which revokes their tokens. Their login is kept, so
+// they can not log in again as a new user.
+// Returns scim.ErrNotFound if there is no such user.
+func (c *Client) DeleteSCIMUser(ctx context.Context, tenant *scim.Tenant, id string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "DeleteSCIMUser")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting delete scim user transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	result, err := tx.ExecContext(cctx, `
+		UPDATE user_profiles SET
+			active = false,
+			deleted_at = now(),
+			updated_at = now()
+		WHERE user_id = $1 AND tenant_id = $2 AND deleted_at IS NULL;
+	`, id, tenant.ID)
+	if err != nil {
+		return fmt.Errorf("error deleting user profile: %w", err)
+	}
+
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error deleting user profile: %w", err)
+	}
+	if deleted == 0 {
+		return scim.ErrNotFound
+	}
+
+	if err := blockUser(cctx, tx, id, scim.BlockReason); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing delete scim user transaction: %w", err)
+	}
+
+	return nil
+}
+
+func (c *Client) toSCIMUsers(ctx context.Context, rows []scimUserRow) ([]scim.User, error) {
+	users := make([]scim.User, 0, len(rows))
+	for _, row := range rows {
+		login, err := c.decryptLogin(ctx, row.Login, row.LoginCiphertext, row.EncryptionKeyID)
+		if err != nil {
+			return nil, err
+		}
+
+		u := scim.User{
+			ID:           row.UserID,
+			UserName:     login,
+			GivenName:    row.GivenName,
+			FamilyName:   row.FamilyName,
+			DisplayName:  row.DisplayName,
+			Active:       row.Active,
+			Created:      row.CreatedAt,
+			LastModified: row.UpdatedAt,
+		}
+		if row.ExternalID != nil {
+			u.ExternalID = *row.ExternalID
+		}
+
+		users = append(users, u)
+	}
+
+	return users, nil
+}
+
+// scimLoginError reports logins that are taken or cooling down as
+// scim.ErrUniqueness.
+func scimLoginError(err error) error {
+	if errors.Is(err, user.ErrLoginTaken) || errors.Is(err, user.ErrLoginCoolingDown) {
+		return scim.ErrUniqueness.Wrap(err)
+	}
+	return err
+}
+
+// nullString stores empty strings as NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
@@ -6,18 +6,21 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/jackc/pgx/pgtype"
 	"github.com/opentracing/opentracing-go"
 
 	"gitlab.com/route-kz/auth-api/user"
 )
 
 func (c *Client) prepareGetUserIDByTokenStmt() error {
-	stmt, err := c.DB.Preparex(`
+	query := fmt.Sprintf(`
 		SELECT
 			user_id
 		FROM tokens
-		WHERE token = $1;
-	`)
+		WHERE token = $1%s;
+	`, c.tokenLifetimeCondition())
+
+	stmt, err := c.DB.Preparex(query)
 	if err != nil {
 		return fmt.Errorf("error preparing get user id by token statement: %w", err)
 	}
@@ -25,6 +28,18 @@ func (c *Client) prepareGetUserIDByTokenStmt() error {
 	return nil
 }
 
+// tokenLifetimeCondition returns the condition to add to queries on the
+// tokens table so that only tokens younger than the max lifetime are found.
+// The bound on created_at also lets Postgres prune partitions of a
+// partitioned tokens table.
+func (c *Client) tokenLifetimeCondition() string {
+	if c.tokenMaxLifetime <= 0 {
+		return ""
+	}
+
+	return fmt.Sprintf(" AND created_at > now() - interval '%d seconds'", int64(c.tokenMaxLifetime.Seconds()))
+}
+
 func (c *Client) GetUserID(ctx context.Context, token string) (string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetUserID")
 	defer span.Finish()
@@ -46,8 +61,91 @@ func (c *Client) GetUserID(ctx context.Context, token string) (string, error) {
 	return userID, nil
 }
 
+func (c *Client) prepareGetTokenIdentityStmt() error {
+	query := fmt.Sprintf(`
+		SELECT
+			t.user_id,
+			coalesce(
+				t.auth_user_type,
+				CASE WHEN count(DISTINCT u.auth_user_type) = 1 THEN min(u.auth_user_type) END,
+				''
+			) AS auth_user_type,
+			array_remove(array_agg(DISTINCT u.auth_user_type ORDER BY u.auth_user_type), NULL) AS auth_user_types,
+			array_remove(array_agg(DISTINCT u.auth_method ORDER BY u.auth_method), NULL) AS auth_methods
+		FROM (
+			SELECT user_id, auth_user_type
+			FROM tokens
+			WHERE token = $1%s
+		) t
+		LEFT JOIN user_ids u ON u.user_id = t.user_id
+		GROUP BY t.user_id, t.auth_user_type;
+	`, c.tokenLifetimeCondition())
+
+	stmt, err := c.DB.Preparex(query)
+	if err != nil {
+		return fmt.Errorf("error preparing get token identity statement: %w", err)
+	}
+	c.GetTokenIdentityStmt = stmt
+	return nil
+}
+
+// GetTokenIdentity returns the user of a token with the auth user type the
+// token was issued for and the auth user types and methods of the user's
+// logins, or nil if the token does not exist. Tokens issued before their type
+// was recorded take the user's type if all the user's logins are of one.
+func (c *Client) GetTokenIdentity(ctx context.Context, token string) (*user.TokenIdentity, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "GetTokenIdentity")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var row struct {
+		UserID        string           `db:"user_id"`
+		AuthUserType  string           `db:"auth_user_type"`
+		AuthUserTypes pgtype.TextArray `db:"auth_user_types"`
+		AuthMethods   pgtype.TextArray `db:"auth_methods"`
+	}
+
+	err := c.GetTokenIdentityStmt.GetContext(cctx, &row, token)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("error getting token identity: %w", err)
+	}
+
+	identity := &user.TokenIdentity{UserID: row.UserID, AuthUserType: row.AuthUserType}
+	if err := row.AuthUserTypes.AssignTo(&identity.AuthUserTypes); err != nil {
+		return nil, fmt.Errorf("error reading auth user types: %w", err)
+	}
+	if err := row.AuthMethods.AssignTo(&identity.AuthMethods); err != nil {
+		return nil, fmt.Errorf("error reading auth methods: %w", err)
+	}
+
+	return identity, nil
+}
+
+// prepareGetUserIDRemoveTokenStmt prepares the statement removing a token
+// to refresh it, returning its user and the auth user type it was issued
+// for. Tokens older than the max lifetime are not refreshed, or they could be
+// renewed forever. The type is read with the snapshot of the statement, so
+// before get_user_id_token_remover removes the token.
 func (c *Client) prepareGetUserIDRemoveTokenStmt() error {
-	stmt, err := c.DB.Preparex(`select get_user_id_token_remover($1);`)
+	userID := `get_user_id_token_remover($1)`
+	if condition := c.tokenLifetimeCondition(); condition != "" {
+		userID = fmt.Sprintf(`
+			CASE
+				WHEN EXISTS (SELECT 1 FROM tokens WHERE token = $1%s)
+				THEN get_user_id_token_remover($1)
+			END`, condition)
+	}
+
+	stmt, err := c.DB.Preparex(fmt.Sprintf(`
+		SELECT
+			(SELECT auth_user_type FROM tokens WHERE token = $1 LIMIT 1) AS auth_user_type,
+			%s AS user_id;
+	`, userID))
 	if err != nil {
 		return fmt.Errorf("error preparing get user id and token remover statement: %w", err)
 	}
@@ -56,25 +154,44 @@ func (c *Client) prepareGetUserIDRemoveTokenStmt() error {
 	return nil
 }
 
-func (c *Client) GetUserIDRemoveToken(ctx context.Context, token string) (string, error) {
+// GetUserIDRemoveToken removes a token, returning its user and the auth user
+// type it was issued for, or an empty user id if the token does not exist.
+func (c *Client) GetUserIDRemoveToken(ctx context.Context, token string) (string, string, error) {
 	span, ctx := opentracing.StartSpanFromContext(ctx, "GetUserIDRemoveToken")
 	defer span.Finish()
 
 	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	var userID *string
-	err := c.GetUserIDRemoveTokenStmt.GetContext(cctx, &userID, token)
-	if err != nil || userID == nil {
-		return "", err
+	var row struct {
+		UserID       *string `db:"user_id"`
+		AuthUserType *string `db:"auth_user_type"`
+	}
+	err := c.GetUserIDRemoveTokenStmt.GetContext(cctx, &row, token)
+	if err != nil || row.UserID == nil {
+		return "", "", err
 	}
 
-	return *userID, nil
+	if row.AuthUserType == nil {
+		return *row.UserID, "", nil
+	}
+	return *row.UserID, *row.AuthUserType, nil
 }
 
 func (c *Client) prepareFetchPersonalDataStmt() error {
 	stmt, err := c.DB.Preparex(`
-		select user_id, login, auth_user_type, auth_method from user_ids where user_id=$1;`)
+		SELECT
+			user_id,
+			login,
+			auth_user_type,
+			auth_method,
+			coalesce(login_type, '') AS login_type,
+			verified_at,
+			login_ciphertext,
+			encryption_key_id
+		FROM user_ids
+		WHERE user_id = $1
+		ORDER BY verified_at NULLS LAST, login;`)
 
 	if err != nil {
 		return fmt.Errorf("error preparing get personal data: %w", err)
@@ -91,25 +208,52 @@ func (c *Client) FetchPersonalData(ctx context.Context, userID string) (*user.Pe
 	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	defer cancel()
 
-	var row personalDataRow
+	var rows []loginRow
 
-	err := c.FetchPersonalDataStmt.GetContext(cctx, &row, userID)
+	err := c.FetchPersonalDataStmt.SelectContext(cctx, &rows, userID)
 	if err != nil {
 		return nil, err
 	}
 
-	personalData := &user.PersonalData{
-		UserID:      userID,
-		PhoneNumber: row.Login, //@TODO check for object type phoneNumber, email, etc...
-		Email:       "",
+	if len(rows) == 0 {
+		return nil, user.ErrUserNotFound.Wrap(fmt.Errorf("no logins for user %s", userID))
+	}
+
+	if err := c.decryptLogins(ctx, rows); err != nil {
+		return nil, err
+	}
+
+	logins := make([]user.Login, 0, len(rows))
+	for _, row := range rows {
+		logins = append(logins, row.toLogin())
 	}
 
-	return personalData, nil
+	return user.NewPersonalData(userID, logins), nil
 }
 
-type personalDataRow struct {
-	UserID       string `db:"user_id"`
-	Login        string `db:"login"`
-	AuthUserType string `db:"auth_user_type"`
-	AuthMethod   string `db:"auth_method"`
+type loginRow struct {
+	UserID          string     `db:"user_id"`
+	Login           string     `db:"login"`
+	AuthUserType    string     `db:"auth_user_type"`
+	AuthMethod      string     `db:"auth_method"`
+	LoginType       string     `db:"login_type"`
+	VerifiedAt      *time.Time `db:"verified_at"`
+	LoginCiphertext []byte     `db:"login_ciphertext"`
+	EncryptionKeyID *int       `db:"encryption_key_id"`
+}
+
+func (r loginRow) toLogin() user.Login {
+	loginType := user.LoginType(r.LoginType)
+	if loginType == "" {
+		loginType = user.ClassifyLogin(r.Login, r.AuthMethod)
+	}
+
+	return user.Login{
+		Login:        r.Login,
+		Type:         loginType,
+		AuthUserType: r.AuthUserType,
+		AuthMethod:   r.AuthMethod,
+		Verified:     r.VerifiedAt != nil,
+		VerifiedAt:   r.VerifiedAt,
+	}
 }
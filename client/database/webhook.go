@@ -0,0 +1,560 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/pgtype"
+	"github.com/jmoiron/sqlx"
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/webhook"
+)
+
+type webhookRow struct {
+	ID                  int64            `db:"id"`
+	Name                string           `db:"name"`
+	URL                 string           `db:"url"`
+	EventTypes          pgtype.TextArray `db:"event_types"`
+	CreatedAt           time.Time        `db:"created_at"`
+	ConsecutiveFailures int              `db:"consecutive_failures"`
+	DisabledAt          *time.Time       `db:"disabled_at"`
+	DisabledReason      *string          `db:"disabled_reason"`
+}
+
+func (r webhookRow) toSubscription() (webhook.Subscription, error) {
+	s := webhook.Subscription{
+		ID:                  r.ID,
+		Name:                r.Name,
+		URL:                 r.URL,
+		CreatedAt:           r.CreatedAt,
+		ConsecutiveFailures: r.ConsecutiveFailures,
+		DisabledAt:          r.DisabledAt,
+	}
+	if r.DisabledReason != nil {
+		s.DisabledReason = *r.DisabledReason
+	}
+	if err := r.EventTypes.AssignTo(&s.EventTypes); err != nil {
+		return s, fmt.Errorf("error decoding webhook event types: %w", err)
+	}
+	return s, nil
+}
+
+const webhookColumns = `id, name, url, event_types, created_at, consecutive_failures, disabled_at, disabled_reason`
+
+// enqueueWebhooks queues the deliveries of an event to the subscriptions to
+// its type, in the transaction writing the event.
+func enqueueWebhooks(ctx context.Context, tx *sqlx.Tx, eventID, eventType string, payload []byte) error {
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO webhook_deliveries (subscription_id, event_id, event_type, payload)
+		SELECT id, $1, $2, $3
+		FROM webhook_subscriptions
+		WHERE disabled_at IS NULL AND $2 = ANY(event_types);
+	`, eventID, eventType, payload)
+	if err != nil {
+		return fmt.Errorf("error queueing %s webhooks: %w", eventType, err)
+	}
+
+	return nil
+}
+
+// CreateWebhook stores a new subscription, with a new secret, which is
+// encrypted with encryption enabled.
+func (c *Client) CreateWebhook(ctx context.Context, s webhook.Subscription) (*webhook.Subscription, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CreateWebhook")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	secret, err := webhook.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, ciphertext, keyID, err := c.encryptWebhookSecret(ctx, secret)
+	if err != nil {
+		return nil, err
+	}
+
+	var eventTypes pgtype.TextArray
+	if err := eventTypes.Set(s.EventTypes); err != nil {
+		return nil, fmt.Errorf("error encoding webhook event types: %w", err)
+	}
+
+	var row webhookRow
+	err = c.DB.GetContext(cctx, &row, `
+		INSERT INTO webhook_subscriptions (name, url, secret, secret_ciphertext, encryption_key_id, event_types)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+webhookColumns+`;
+	`, s.Name, s.URL, plaintext, ciphertext, keyID, &eventTypes)
+	if err != nil {
+		return nil, fmt.Errorf("error creating webhook: %w", err)
+	}
+
+	created, err := row.toSubscription()
+	if err != nil {
+		return nil, err
+	}
+	created.Secret = secret
+
+	return &created, nil
+}
+
+// Webhooks returns all the subscriptions.
+func (c *Client) Webhooks(ctx context.Context) ([]webhook.Subscription, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Webhooks")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var rows []webhookRow
+	err := c.DB.SelectContext(cctx, &rows, `SELECT `+webhookColumns+` FROM webhook_subscriptions ORDER BY id;`)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhooks: %w", err)
+	}
+
+	subscriptions := make([]webhook.Subscription, 0, len(rows))
+	for _, row := range rows {
+		s, err := row.toSubscription()
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+
+	return subscriptions, nil
+}
+
+// Webhook returns a subscription.
+func (c *Client) Webhook(ctx context.Context, id int64) (*webhook.Subscription, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Webhook")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var row webhookRow
+	err := c.DB.GetContext(cctx, &row, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id = $1;`, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, webhook.ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook: %w", err)
+	}
+
+	s, err := row.toSubscription()
+	if err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// DeleteWebhook deletes a subscription along with its deliveries.
+func (c *Client) DeleteWebhook(ctx context.Context, id int64) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "DeleteWebhook")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(cctx, `DELETE FROM webhook_subscriptions WHERE id = $1;`, id)
+	return checkWebhookUpdated(result, err, "deleting")
+}
+
+// EnableWebhook enables a subscription disabled because it kept failing.
+// Events published while it was disabled are not delivered.
+func (c *Client) EnableWebhook(ctx context.Context, id int64) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "EnableWebhook")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	result, err := c.DB.ExecContext(cctx, `
+		UPDATE webhook_subscriptions SET
+			disabled_at = NULL,
+			disabled_reason = NULL,
+			consecutive_failures = 0
+		WHERE id = $1;
+	`, id)
+	return checkWebhookUpdated(result, err, "enabling")
+}
+
+func checkWebhookUpdated(result sql.Result, err error, action string) error {
+	if err != nil {
+		return fmt.Errorf("error %s webhook: %w", action, err)
+	}
+
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error %s webhook: %w", action, err)
+	}
+
+	if updated == 0 {
+		return webhook.ErrNotFound
+	}
+
+	return nil
+}
+
+type deliveryLogRow struct {
+	ID            int64      `db:"id"`
+	EventID       string     `db:"event_id"`
+	EventType     string     `db:"event_type"`
+	Status        string     `db:"status"`
+	CreatedAt     time.Time  `db:"created_at"`
+	DeliveredAt   *time.Time `db:"delivered_at"`
+	NextAttemptAt time.Time  `db:"next_attempt_at"`
+}
+
+type attemptRow struct {
+	DeliveryID  int64     `db:"delivery_id"`
+	AttemptedAt time.Time `db:"attempted_at"`
+	StatusCode  int       `db:"status_code"`
+	Error       *string   `db:"error"`
+	DurationMS  int64     `db:"duration_ms"`
+}
+
+// WebhookDeliveries returns the last limit deliveries of a subscription,
+// newest first, with their attempts.
+func (c *Client) WebhookDeliveries(ctx context.Context, id int64, limit int) ([]webhook.DeliveryLog, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "WebhookDeliveries")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	if _, err := c.Webhook(cctx, id); err != nil {
+		return nil, err
+	}
+
+	var rows []deliveryLogRow
+	err := c.DB.SelectContext(cctx, &rows, `
+		SELECT id, event_id, event_type, status, created_at, delivered_at, next_attempt_at
+		FROM webhook_deliveries
+		WHERE subscription_id = $1
+		ORDER BY id DESC
+		LIMIT $2;
+	`, id, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook deliveries: %w", err)
+	}
+
+	logs := make([]webhook.DeliveryLog, 0, len(rows))
+	index := make(map[int64]int, len(rows))
+	ids := make([]int64, 0, len(rows))
+	for i, row := range rows {
+		l := webhook.DeliveryLog{
+			ID:          row.ID,
+			EventID:     row.EventID,
+			EventType:   row.EventType,
+			Status:      webhook.DeliveryStatus(row.Status),
+			CreatedAt:   row.CreatedAt,
+			DeliveredAt: row.DeliveredAt,
+			Attempts:    []webhook.Attempt{},
+		}
+		if l.Status == webhook.StatusPending {
+			nextAttemptAt := row.NextAttemptAt
+			l.NextAttemptAt = &nextAttemptAt
+		}
+		logs = append(logs, l)
+		index[row.ID] = i
+		ids = append(ids, row.ID)
+	}
+
+	if len(ids) == 0 {
+		return logs, nil
+	}
+
+	var deliveryIDs pgtype.Int8Array
+	if err := deliveryIDs.Set(ids); err != nil {
+		return nil, fmt.Errorf("error encoding delivery ids: %w", err)
+	}
+
+	var attempts []attemptRow
+	err = c.DB.SelectContext(cctx, &attempts, `
+		SELECT delivery_id, attempted_at, status_code, error, duration_ms
+		FROM webhook_delivery_attempts
+		WHERE delivery_id = ANY($1)
+		ORDER BY id;
+	`, &deliveryIDs)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching webhook delivery attempts: %w", err)
+	}
+
+	for _, a := range attempts {
+		attempt := webhook.Attempt{
+			StatusCode: a.StatusCode,
+			DurationMS: a.DurationMS,
+			At:         a.AttemptedAt,
+		}
+		if a.Error != nil {
+			attempt.Error = *a.Error
+		}
+		l := &logs[index[a.DeliveryID]]
+		l.Attempts = append(l.Attempts, attempt)
+	}
+
+	return logs, nil
+}
+
+type deliveryRow struct {
+	ID               int64   `db:"id"`
+	SubscriptionID   int64   `db:"subscription_id"`
+	URL              string  `db:"url"`
+	Secret           *string `db:"secret"`
+	SecretCiphertext []byte  `db:"secret_ciphertext"`
+	EncryptionKeyID  *int    `db:"encryption_key_id"`
+	EventID          string  `db:"event_id"`
+	EventType        string  `db:"event_type"`
+	Payload          []byte  `db:"payload"`
+	Attempts         int     `db:"attempts"`
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries of enabled
+// subscriptions, and pushes their next attempt back by claimFor so that
+// other dispatchers leave them alone until they are recorded.
+func (c *Client) ClaimWebhookDeliveries(ctx context.Context, limit int, claimFor time.Duration) ([]webhook.Delivery, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ClaimWebhookDeliveries")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	var rows []deliveryRow
+	err := c.DB.SelectContext(cctx, &rows, `
+		WITH claimed AS (
+			UPDATE webhook_deliveries SET
+				next_attempt_at = now() + $2 * interval '1 second'
+			WHERE id IN (
+				SELECT d.id
+				FROM webhook_deliveries d
+				JOIN webhook_subscriptions s ON s.id = d.subscription_id
+				WHERE d.status = 'pending' AND d.next_attempt_at <= now() AND s.disabled_at IS NULL
+				ORDER BY d.next_attempt_at
+				LIMIT $1
+				FOR UPDATE OF d SKIP LOCKED
+			)
+			RETURNING id, subscription_id, event_id, event_type, payload, attempts
+		)
+		SELECT claimed.*, s.url, s.secret, s.secret_ciphertext, s.encryption_key_id
+		FROM claimed
+		JOIN webhook_subscriptions s ON s.id = claimed.subscription_id
+		ORDER BY claimed.id;
+	`, limit, claimFor.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+
+	deliveries := make([]webhook.Delivery, 0, len(rows))
+	for _, row := range rows {
+		secret, err := c.decryptWebhookSecret(ctx, row.Secret, row.SecretCiphertext, row.EncryptionKeyID)
+		if err != nil {
+			return nil, err
+		}
+
+		deliveries = append(deliveries, webhook.Delivery{
+			ID:             row.ID,
+			SubscriptionID: row.SubscriptionID,
+			URL:            row.URL,
+			Secret:         secret,
+			EventID:        row.EventID,
+			EventType:      row.EventType,
+			Payload:        row.Payload,
+			Attempts:       row.Attempts,
+		})
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt logs an attempt and updates its delivery, unless it
+// was cancelled meanwhile. Each failure counts against the subscription,
+// which is disabled with its pending deliveries cancelled after
+// webhookDisableAfter failures in a row. Returns whether the subscription
+// got disabled.
+func (c *Client) RecordWebhookAttempt(ctx context.Context, d webhook.Delivery, a webhook.Attempt) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "RecordWebhookAttempt")
+	defer span.Finish()
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+
+	tx, err := c.DB.BeginTxx(cctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("error starting webhook attempt transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var disabled bool
+	var attemptErr *string
+	if a.Error != "" {
+		attemptErr = &a.Error
+	}
+
+	_, err = tx.ExecContext(cctx, `
+		INSERT INTO webhook_delivery_attempts (delivery_id, attempted_at, status_code, error, duration_ms)
+		VALUES ($1, $2, $3, $4, $5);
+	`, d.ID, a.At, a.StatusCode, attemptErr, a.DurationMS)
+	if err != nil {
+		return false, fmt.Errorf("error logging webhook attempt: %w", err)
+	}
+
+	nextAttemptAt := a.NextAttemptAt
+	if nextAttemptAt.IsZero() {
+		nextAttemptAt = a.At
+	}
+
+	_, err = tx.ExecContext(cctx, `
+		UPDATE webhook_deliveries SET
+			status = $2,
+			attempts = attempts + 1,
+			next_attempt_at = $3,
+			delivered_at = CASE WHEN $2 = 'delivered' THEN now() END
+		WHERE id = $1 AND status = 'pending';
+	`, d.ID, string(a.Status), nextAttemptAt)
+	if err != nil {
+		return false, fmt.Errorf("error updating webhook delivery: %w", err)
+	}
+
+	if a.Succeeded() {
+		_, err = tx.ExecContext(cctx, `UPDATE webhook_subscriptions SET consecutive_failures = 0 WHERE id = $1;`, d.SubscriptionID)
+		if err != nil {
+			return false, fmt.Errorf("error resetting webhook failures: %w", err)
+		}
+	} else {
+		err = tx.GetContext(cctx, &disabled, `
+			UPDATE webhook_subscriptions SET
+				consecutive_failures = consecutive_failures + 1,
+				disabled_at = CASE WHEN consecutive_failures + 1 >= $2 THEN now() END,
+				disabled_reason = CASE WHEN consecutive_failures + 1 >= $2 THEN $3 END
+			WHERE id = $1 AND disabled_at IS NULL
+			RETURNING disabled_at IS NOT NULL;
+		`, d.SubscriptionID, c.webhookDisableAfter, fmt.Sprintf("%d failed deliveries in a row, last: %s", c.webhookDisableAfter, a.Error))
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return false, fmt.Errorf("error counting webhook failures: %w", err)
+		}
+
+		if disabled {
+			_, err = tx.ExecContext(cctx, `
+				UPDATE webhook_deliveries SET
+					status = 'cancelled'
+				WHERE subscription_id = $1 AND status = 'pending';
+			`, d.SubscriptionID)
+			if err != nil {
+				return false, fmt.Errorf("error cancelling webhook deliveries: %w", err)
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("error committing webhook attempt: %w", err)
+	}
+
+	return disabled, nil
+}
+
+// encryptWebhookSecret encrypts a secret with the active data key. Without
+// encryption it returns the plaintext secret to store instead.
+func (c *Client) encryptWebhookSecret(ctx context.Context, secret string) (*string, []byte, *int, error) {
+	if c.keyring == nil {
+		return &secret, nil, nil, nil
+	}
+
+	keyID, ciphertext, err := c.keyring.Encrypt(ctx, secret)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("error encrypting webhook secret: %w", err)
+	}
+
+	return nil, ciphertext, &keyID, nil
+}
+
+// decryptWebhookSecret returns the plaintext of a stored secret: the
+// decrypted ciphertext if there is one, else the secret column, which is
+// plaintext for subscriptions created before encryption was enabled.
+func (c *Client) decryptWebhookSecret(ctx context.Context, secret *string, ciphertext []byte, keyID *int) (string, error) {
+	if ciphertext == nil || keyID == nil {
+		if secret == nil {
+			return "", fmt.Errorf("webhook secret is missing")
+		}
+		return *secret, nil
+	}
+
+	if c.keyring == nil {
+		return "", fmt.Errorf("webhook secret is encrypted, but encryption is not enabled")
+	}
+
+	plaintext, err := c.keyring.Decrypt(ctx, *keyID, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting webhook secret: %w", err)
+	}
+
+	return plaintext, nil
+}
+
+// reencryptWebhookSecrets encrypts up to batchSize webhook secrets with the
+// active data key.
+func (c *Client) reencryptWebhookSecrets(ctx context.Context, batchSize int) (int, error) {
+	activeKeyID, err := c.keyring.ActiveKeyID(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := c.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error starting reencrypt transaction: %w", err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var rows []struct {
+		ID               int64   `db:"id"`
+		Secret           *string `db:"secret"`
+		SecretCiphertext []byte  `db:"secret_ciphertext"`
+		EncryptionKeyID  *int    `db:"encryption_key_id"`
+	}
+	err = tx.SelectContext(ctx, &rows, `
+		SELECT id, secret, secret_ciphertext, encryption_key_id
+		FROM webhook_subscriptions
+		WHERE encryption_key_id IS DISTINCT FROM $1
+		LIMIT $2
+		FOR UPDATE SKIP LOCKED;
+	`, activeKeyID, batchSize)
+	if err != nil {
+		return 0, fmt.Errorf("error fetching webhook secrets to reencrypt: %w", err)
+	}
+
+	for _, row := range rows {
+		secret, err := c.decryptWebhookSecret(ctx, row.Secret, row.SecretCiphertext, row.EncryptionKeyID)
+		if err != nil {
+			return 0, err
+		}
+
+		plaintext, ciphertext, keyID, err := c.encryptWebhookSecret(ctx, secret)
+		if err != nil {
+			return 0, err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			UPDATE webhook_subscriptions SET
+				secret = $2,
+				secret_ciphertext = $3,
+				encryption_key_id = $4
+			WHERE id = $1;
+		`, row.ID, plaintext, ciphertext, keyID)
+		if err != nil {
+			return 0, fmt.Errorf("error reencrypting webhook secret: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing reencrypted webhook secrets: %w", err)
+	}
+
+	return len(rows), nil
+}
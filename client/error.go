@@ -5,11 +5,15 @@ import (
 	"fmt"
 )
 
+// Namespace is the namespace of the errors this service responds with.
+var Namespace = "sms-api"
+
 type ErrorCodeResponseBody struct {
-	Namespace string `json:"namespace"`
-	Error     string `json:"error"`
-	Message   string `json:"message"`
-	Propagate bool   `json:"propagate,omitempty"`
+	Namespace string            `json:"namespace"`
+	Error     string            `json:"error"`
+	Message   string            `json:"message"`
+	Fields    map[string]string `json:"fields,omitempty"`
+	Propagate bool              `json:"propagate,omitempty"`
 }
 
 // ErrorCodeWrapper is an error type that we want to
@@ -32,7 +36,7 @@ func (e ErrorCodeWrapper) GetResponseBody() ([]byte, error) {
 	// We don't want to overwrite the namespace
 	// if we are already propagating something here
 	if e.ResponseBody.Namespace == "" {
-		e.ResponseBody.Namespace = "sms-api"
+		e.ResponseBody.Namespace = Namespace
 	}
 	resp, err := json.Marshal(&e.ResponseBody)
 	if err != nil {
@@ -0,0 +1,220 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/monitoring/trace"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// Command is a command other services send about the account of a user.
+type Command string
+
+const (
+	CommandRevokeTokens Command = "revoke_tokens"
+	CommandBlockUser    Command = "block_user"
+	CommandUnblockUser  Command = "unblock_user"
+)
+
+// commandVersion is the version of commandMessage we understand.
+const commandVersion = 1
+
+// commandMessage is the body of a command. Trace is a text map carrier with
+// the span context of the sender; a span context in the headers of the
+// message is used when it is missing.
+type commandMessage struct {
+	Version int               `json:"version"`
+	UserID  string            `json:"user_id"`
+	Reason  string            `json:"reason"`
+	Trace   map[string]string `json:"trace"`
+}
+
+// errMalformedCommand marks commands that will never succeed, which are
+// dead-lettered right away.
+var errMalformedCommand = errors.New("malformed command")
+
+// CommandConfig is the configuration of the command consumers.
+type CommandConfig struct {
+	// Subjects are the subjects of each command. Commands without a subject
+	// are not consumed.
+	Subjects map[Command]string
+	// Stream is the JetStream stream holding the subjects.
+	Stream string
+	// Durable is the prefix of the names of the durable consumers.
+	Durable string
+	// MaxDeliver is how many times a command is tried before it is
+	// dead-lettered.
+	MaxDeliver int
+	AckWait    time.Duration
+	// DeadLetterSubject is where commands that failed are published, with
+	// the reason in the Auth-Dead-Letter-Reason header.
+	DeadLetterSubject string
+}
+
+// ConsumeCommands consumes the commands of cfg from JetStream with durable
+// pull consumers until ctx is done. A command is acknowledged once it is
+// handled, retried with backoff when handling it fails, and dead-lettered
+// when it is malformed or failed MaxDeliver times.
+func (c *Client) ConsumeCommands(ctx context.Context, cfg CommandConfig, accounts user.AccountCommander) error {
+	js, err := c.Conn.JetStream()
+	if err != nil {
+		return fmt.Errorf("error getting jetstream context: %w", err)
+	}
+
+	for command, subject := range cfg.Subjects {
+		if subject == "" {
+			continue
+		}
+
+		sub, err := js.PullSubscribe(
+			subject,
+			cfg.Durable+"-"+string(command),
+			nats.BindStream(cfg.Stream),
+			nats.ManualAck(),
+			nats.AckExplicit(),
+			nats.AckWait(cfg.AckWait),
+			nats.MaxDeliver(cfg.MaxDeliver),
+		)
+		if err != nil {
+			return fmt.Errorf("error subscribing to %s commands on %s: %w", command, subject, err)
+		}
+
+		go c.consumeCommands(ctx, sub, command, cfg, accounts)
+	}
+
+	return nil
+}
+
+func (c *Client) consumeCommands(ctx context.Context, sub *nats.Subscription, command Command, cfg CommandConfig, accounts user.AccountCommander) {
+	defer func() { _ = sub.Unsubscribe() }()
+
+	for ctx.Err() == nil {
+		fctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		msgs, err := sub.Fetch(10, nats.Context(fctx))
+		cancel()
+		if err != nil {
+			if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) && !errors.Is(err, nats.ErrTimeout) {
+				log.Errorf("error fetching %s commands: %s", command, err)
+				time.Sleep(time.Second)
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			c.handleCommand(ctx, msg, command, cfg, accounts)
+		}
+	}
+}
+
+func (c *Client) handleCommand(ctx context.Context, msg *nats.Msg, command Command, cfg CommandConfig, accounts user.AccountCommander) {
+	var cmd commandMessage
+	err := json.Unmarshal(msg.Data, &cmd)
+	switch {
+	case err != nil:
+		err = fmt.Errorf("%w: %s", errMalformedCommand, err)
+	case cmd.Version != commandVersion:
+		err = fmt.Errorf("%w: unknown version %d", errMalformedCommand, cmd.Version)
+	case cmd.UserID == "":
+		err = fmt.Errorf("%w: user_id is required", errMalformedCommand)
+	default:
+		err = c.runCommand(ctx, msg, command, cmd, accounts)
+	}
+
+	if err == nil {
+		if err := msg.Ack(); err != nil {
+			log.Errorf("error acknowledging %s command: %s", command, err)
+		}
+		return
+	}
+
+	delivered := uint64(1)
+	if meta, metaErr := msg.Metadata(); metaErr == nil {
+		delivered = meta.NumDelivered
+	}
+
+	if !errors.Is(err, errMalformedCommand) && delivered < uint64(cfg.MaxDeliver) {
+		log.Errorf("error handling %s command, attempt %d: %s", command, delivered, err)
+		if err := msg.NakWithDelay(commandRetryDelay(delivered)); err != nil {
+			log.Errorf("error requeuing %s command: %s", command, err)
+		}
+		return
+	}
+
+	log.Errorf("dead-lettering %s command: %s", command, err)
+	if err := c.deadLetter(msg, cfg.DeadLetterSubject, err); err != nil {
+		// Leave the command to be redelivered after AckWait.
+		log.Errorf("error dead-lettering %s command: %s", command, err)
+		return
+	}
+
+	if err := msg.Term(); err != nil {
+		log.Errorf("error terminating %s command: %s", command, err)
+	}
+}
+
+func (c *Client) runCommand(ctx context.Context, msg *nats.Msg, command Command, cmd commandMessage, accounts user.AccountCommander) error {
+	carrier := opentracing.TextMapCarrier(cmd.Trace)
+	if len(carrier) == 0 {
+		carrier = opentracing.TextMapCarrier{}
+		for key := range msg.Header {
+			carrier.Set(key, msg.Header.Get(key))
+		}
+	}
+
+	span, ctx := trace.ExtractFromCarrier(ctx, carrier, "Command "+string(command))
+	defer span.Finish()
+	span.SetTag("user_id", cmd.UserID)
+
+	log.Infof("Running %s command for user %s: %s", command, cmd.UserID, cmd.Reason)
+
+	switch command {
+	case CommandRevokeTokens:
+		_, err := accounts.RevokeTokens(ctx, cmd.UserID)
+		return err
+	case CommandBlockUser:
+		return accounts.BlockUser(ctx, cmd.UserID, cmd.Reason)
+	case CommandUnblockUser:
+		return accounts.UnblockUser(ctx, cmd.UserID)
+	default:
+		return fmt.Errorf("%w: unknown command %s", errMalformedCommand, command)
+	}
+}
+
+// deadLetter publishes a failed command to subject, with why it failed and
+// where it came from in the headers.
+func (c *Client) deadLetter(msg *nats.Msg, subject string, reason error) error {
+	dead := nats.NewMsg(subject)
+	dead.Data = msg.Data
+	for key, values := range msg.Header {
+		dead.Header[key] = values
+	}
+	dead.Header.Set("Auth-Dead-Letter-Reason", reason.Error())
+	dead.Header.Set("Auth-Dead-Letter-Subject", msg.Subject)
+
+	if err := c.Conn.PublishMsg(dead); err != nil {
+		return err
+	}
+
+	return c.Conn.Flush()
+}
+
+// commandRetryDelay returns how long to wait before redelivering a command
+// delivered delivered times: 1s, 2s, 4s, ... up to a minute.
+func commandRetryDelay(delivered uint64) time.Duration {
+	if delivered == 0 {
+		return time.Second
+	}
+	delay := time.Second << (delivered - 1)
+	if delivered > 6 || delay > time.Minute {
+		return time.Minute
+	}
+	return delay
+}
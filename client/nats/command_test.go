@@ -0,0 +1,28 @@
+package nats
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommandRetryDelay(t *testing.T) {
+	tests := []struct {
+		delivered uint64
+		want      time.Duration
+	}{
+		{0, time.Second},
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{6, 32 * time.Second},
+		{7, time.Minute},
+		{64, time.Minute},
+		{1 << 63, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := commandRetryDelay(tt.delivered); got != tt.want {
+			t.Errorf("commandRetryDelay(%d) = %s, want %s", tt.delivered, got, tt.want)
+		}
+	}
+}
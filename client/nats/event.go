@@ -0,0 +1,59 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nats-io/nats.go"
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/event"
+	"gitlab.com/route-kz/auth-api/monitoring/trace"
+)
+
+// Publish publishes an event on "<NatsEventSubjectPrefix>.<type>", with the
+// span context of ctx. It returns once the event is stored by the JetStream
+// stream NatsEventStream, if set, or else once the server has received it:
+// nats.Conn.Publish only buffers the message, which a broken connection
+// loses.
+func (c *Client) Publish(ctx context.Context, e event.Event) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "PublishEvent")
+	defer span.Finish()
+	span.SetTag("event.type", string(e.Type))
+
+	e.Trace = trace.InjectIntoCarrier(ctx)
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("error marshalling %s event: %w", e.Type, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.eventPublishTimeout)
+	defer cancel()
+
+	subject := c.eventSubjectPrefix + "." + string(e.Type)
+
+	if c.eventStream != "" {
+		js, err := c.Conn.JetStream()
+		if err != nil {
+			return fmt.Errorf("error getting jetstream context: %w", err)
+		}
+
+		if _, err := js.Publish(subject, data, nats.Context(ctx), nats.ExpectStream(c.eventStream), nats.MsgId(e.ID)); err != nil {
+			return fmt.Errorf("error publishing %s event: %w", e.Type, err)
+		}
+
+		return nil
+	}
+
+	if err := c.Conn.Publish(subject, data); err != nil {
+		return fmt.Errorf("error publishing %s event: %w", e.Type, err)
+	}
+
+	if err := c.Conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("error flushing %s event: %w", e.Type, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+// Package nats provides a client for publishing messages on NATS.
+package nats
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+// Client holds the NATS connection and the subjects we publish on.
+type Client struct {
+	Conn *nats.Conn
+
+	verificationSubject string
+	eventSubjectPrefix  string
+	eventStream         string
+	eventPublishTimeout time.Duration
+}
+
+// Init sets up a new NATS client.
+func (c *Client) Init(ctx context.Context, config *config.Config) error {
+	conn, err := nats.Connect(config.NatsURL, nats.Name("auth-api"))
+	if err != nil {
+		return fmt.Errorf("failed to connect to nats: %w", err)
+	}
+
+	c.Conn = conn
+	c.verificationSubject = config.NatsVerificationSubject
+	c.eventSubjectPrefix = config.NatsEventSubjectPrefix
+	c.eventStream = config.NatsEventStream
+	c.eventPublishTimeout = config.NatsEventPublishTimeout
+
+	return nil
+}
+
+// Close drains and closes the NATS connection.
+func (c *Client) Close() error {
+	if err := c.Conn.Drain(); err != nil {
+		return fmt.Errorf("error draining nats connection: %w", err)
+	}
+
+	return nil
+}
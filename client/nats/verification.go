@@ -0,0 +1,49 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/opentracing/opentracing-go"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+type verificationMessage struct {
+	Purpose   user.VerificationPurpose `json:"purpose"`
+	UserID    string                   `json:"user_id"`
+	Login     string                   `json:"login"`
+	LoginType user.LoginType           `json:"login_type"`
+	Code      string                   `json:"code"`
+}
+
+// SendCode publishes a verification code for the services that deliver
+// them (sms, email, ...) to send to the login.
+func (c *Client) SendCode(
+	ctx context.Context,
+	purpose user.VerificationPurpose,
+	userID string,
+	login user.Login,
+	code string,
+) error {
+	span, _ := opentracing.StartSpanFromContext(ctx, "SendCode")
+	defer span.Finish()
+
+	data, err := json.Marshal(verificationMessage{
+		Purpose:   purpose,
+		UserID:    userID,
+		Login:     login.Login,
+		LoginType: login.Type,
+		Code:      code,
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling verification message: %w", err)
+	}
+
+	if err := c.Conn.Publish(c.verificationSubject, data); err != nil {
+		return fmt.Errorf("error publishing verification message: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// ProblemContentType is the content type of RFC 7807 problem details.
+const ProblemContentType = "application/problem+json"
+
+// Problem is an RFC 7807 problem details object. The error code, namespace,
+// trace id and field errors are extension members.
+type Problem struct {
+	Type      string            `json:"type"`
+	Title     string            `json:"title"`
+	Status    int               `json:"status"`
+	Detail    string            `json:"detail,omitempty"`
+	Instance  string            `json:"instance,omitempty"`
+	TraceID   string            `json:"trace_id,omitempty"`
+	Code      string            `json:"code"`
+	Namespace string            `json:"namespace"`
+	Fields    map[string]string `json:"fields,omitempty"`
+}
+
+// GetProblem returns the problem details of the error, for the request
+// path instance.
+func (e ErrorCodeWrapper) GetProblem(instance, traceID string) ([]byte, error) {
+	namespace := e.ResponseBody.Namespace
+	if namespace == "" {
+		namespace = Namespace
+	}
+
+	resp, err := json.Marshal(&Problem{
+		Type:      fmt.Sprintf("urn:%s:error:%s", namespace, e.ResponseBody.Error),
+		Title:     http.StatusText(e.StatusCode),
+		Status:    e.StatusCode,
+		Detail:    e.ResponseBody.Message,
+		Instance:  instance,
+		TraceID:   traceID,
+		Code:      e.ResponseBody.Error,
+		Namespace: namespace,
+		Fields:    e.ResponseBody.Fields,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error marshalling problem details: %w", err)
+	}
+	return resp, nil
+}
@@ -0,0 +1,133 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/opentracing/opentracing-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// slidingWindow counts requests in a sorted set scored by time. It records
+// the request and returns 0 if it is within the limit, otherwise it returns
+// the milliseconds until the oldest request in the window expires.
+var slidingWindow = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+
+redis.call('ZREMRANGEBYSCORE', key, '-inf', now - window)
+
+if redis.call('ZCARD', key) < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	return 0
+end
+
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return tonumber(oldest[2]) + window - now
+`)
+
+// Allow records a request for key and tells whether it is within limit
+// requests per window. If it is not, it returns how long to wait.
+func (c *Client) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "Allow")
+	defer span.Finish()
+
+	retryAfter, err := slidingWindow.Run(
+		ctx,
+		c.Redis,
+		[]string{"ratelimit:" + key},
+		time.Now().UnixMilli(),
+		window.Milliseconds(),
+		limit,
+		uuid.NewString(),
+	).Int64()
+	if err != nil {
+		return false, 0, fmt.Errorf("error checking rate limit: %w", err)
+	}
+
+	if retryAfter > 0 {
+		return false, time.Duration(retryAfter) * time.Millisecond, nil
+	}
+
+	return true, 0, nil
+}
+
+// LockedOut returns how long key is still locked out, or 0.
+func (c *Client) LockedOut(ctx context.Context, key string) (time.Duration, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "LockedOut")
+	defer span.Finish()
+
+	ttl, err := c.Redis.PTTL(ctx, "lockout:"+key).Result()
+	if err != nil {
+		return 0, fmt.Errorf("error checking lockout: %w", err)
+	}
+
+	// Negative TTLs mean there is no lockout.
+	if ttl < 0 {
+		return 0, nil
+	}
+
+	return ttl, nil
+}
+
+// RecordFailure counts a failed attempt for key. Once there were threshold
+// failures within the failure window, key is locked out for base, doubling
+// with every further failure up to max. Returns the lockout, or 0.
+func (c *Client) RecordFailure(
+	ctx context.Context,
+	key string,
+	threshold int,
+	failureWindow, base, max time.Duration,
+) (time.Duration, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "RecordFailure")
+	defer span.Finish()
+
+	failuresKey := "failures:" + key
+
+	var failures *redis.IntCmd
+	_, err := c.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		failures = pipe.Incr(ctx, failuresKey)
+		pipe.PExpire(ctx, failuresKey, failureWindow)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error recording failure: %w", err)
+	}
+
+	over := failures.Val() - int64(threshold)
+	if over < 0 {
+		return 0, nil
+	}
+
+	lockout := base
+	for i := int64(0); i < over && lockout < max; i++ {
+		lockout *= 2
+	}
+	if lockout > max {
+		lockout = max
+	}
+
+	if err := c.Redis.Set(ctx, "lockout:"+key, strconv.FormatInt(failures.Val(), 10), lockout).Err(); err != nil {
+		return 0, fmt.Errorf("error locking out: %w", err)
+	}
+
+	return lockout, nil
+}
+
+// ResetFailures forgets the failed attempts of key after a successful one.
+func (c *Client) ResetFailures(ctx context.Context, key string) error {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "ResetFailures")
+	defer span.Finish()
+
+	if err := c.Redis.Del(ctx, "failures:"+key).Err(); err != nil {
+		return fmt.Errorf("error resetting failures: %w", err)
+	}
+
+	return nil
+}
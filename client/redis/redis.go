@@ -0,0 +1,51 @@
+// Package redis provides a client for Redis, used for short-lived state
+// such as verification codes.
+package redis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+// Client holds the Redis client and the settings for the data we keep there.
+type Client struct {
+	Redis *redis.Client
+
+	codeLength      int
+	codeTTL         time.Duration
+	codeMaxAttempts int
+}
+
+// Init sets up a new Redis client.
+func (c *Client) Init(ctx context.Context, config *config.Config) error {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:     config.RedisAddress,
+		Password: config.RedisPassword,
+		DB:       config.RedisDB,
+	})
+
+	if err := rdb.Ping(ctx).Err(); err != nil {
+		return fmt.Errorf("failed to ping redis: %w", err)
+	}
+
+	c.Redis = rdb
+	c.codeLength = config.VerificationCodeLength
+	c.codeTTL = config.VerificationCodeTTL
+	c.codeMaxAttempts = config.VerificationCodeMaxAttempts
+
+	return nil
+}
+
+// Close closes the Redis connection.
+func (c *Client) Close() error {
+	if err := c.Redis.Close(); err != nil {
+		return fmt.Errorf("error closing redis: %w", err)
+	}
+
+	return nil
+}
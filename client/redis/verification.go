@@ -0,0 +1,120 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/redis/go-redis/v9"
+)
+
+// IssueCode generates a new numeric verification code for key, replacing any
+// previous one. Only a hash of the code is stored.
+func (c *Client) IssueCode(ctx context.Context, key string) (string, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "IssueCode")
+	defer span.Finish()
+
+	code, err := generateCode(c.codeLength)
+	if err != nil {
+		return "", err
+	}
+
+	redisKey := verificationKey(key)
+
+	_, err = c.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, redisKey)
+		pipe.HSet(ctx, redisKey, "hash", hashCode(code), "attempts", 0)
+		pipe.Expire(ctx, redisKey, c.codeTTL)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("error storing verification code: %w", err)
+	}
+
+	return code, nil
+}
+
+// checkCodes checks the hashes of codes, ARGV[2..], against the ones stored
+// in the hashes KEYS. The codes are used up only if they are all right. A
+// wrong code counts as an attempt against its key, which is thrown away
+// after ARGV[1] attempts; the right ones are left for another try. Returns
+// 1 if all the codes are right.
+var checkCodes = redis.NewScript(`
+local maxAttempts = tonumber(ARGV[1])
+local ok = true
+
+for i, key in ipairs(KEYS) do
+	local hash = redis.call('HGET', key, 'hash')
+	if not hash then
+		ok = false
+	elseif hash ~= ARGV[i + 1] then
+		ok = false
+		if redis.call('HINCRBY', key, 'attempts', 1) >= maxAttempts then
+			redis.call('DEL', key)
+		end
+	end
+end
+
+if not ok then
+	return 0
+end
+
+for _, key in ipairs(KEYS) do
+	redis.call('DEL', key)
+end
+return 1
+`)
+
+// CheckCode checks a verification code for key. A code can be used once and
+// is thrown away after too many wrong attempts.
+func (c *Client) CheckCode(ctx context.Context, key, code string) (bool, error) {
+	return c.CheckCodes(ctx, map[string]string{key: code})
+}
+
+// CheckCodes checks the verification codes of several keys, keyed by key,
+// at once, so a wrong one does not use up the others. The check is atomic,
+// so concurrent requests can not both redeem a code.
+func (c *Client) CheckCodes(ctx context.Context, codes map[string]string) (bool, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "CheckCodes")
+	defer span.Finish()
+
+	keys := make([]string, 0, len(codes))
+	args := make([]interface{}, 0, len(codes)+1)
+	args = append(args, c.codeMaxAttempts)
+	for key, code := range codes {
+		keys = append(keys, verificationKey(key))
+		args = append(args, hashCode(code))
+	}
+
+	ok, err := checkCodes.Run(ctx, c.Redis, keys, args...).Int()
+	if err != nil {
+		return false, fmt.Errorf("error checking verification codes: %w", err)
+	}
+
+	return ok == 1, nil
+}
+
+func verificationKey(key string) string {
+	return "verification:" + key
+}
+
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+func generateCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", fmt.Errorf("error generating verification code: %w", err)
+		}
+		code[i] = byte('0' + n.Int64())
+	}
+	return string(code), nil
+}
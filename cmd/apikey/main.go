@@ -0,0 +1,56 @@
+// Command apikey registers a service allowed to call auth-api and prints its
+// new API key. Running it again for the same service replaces the key.
+//
+//	go run ./cmd/apikey -name billing -routes PersonalData,PersonalDataBatch
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/caller"
+	"gitlab.com/route-kz/auth-api/client/database"
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the calling service")
+	routes := flag.String("routes", "", "comma separated names of the routes the service may call, * for all")
+	flag.Parse()
+
+	if *name == "" || *routes == "" {
+		log.Fatal("-name and -routes are required")
+	}
+
+	ctx := context.Background()
+	config, err := config.LoadConfig()
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to load config")
+	}
+
+	var db database.Client
+	if err := db.Init(ctx, config); err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+
+	apiKey, err := caller.GenerateAPIKey()
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to generate api key")
+	}
+
+	c := caller.Caller{
+		Name:   *name,
+		Routes: strings.Split(*routes, ","),
+	}
+
+	if err := db.CreateCaller(ctx, c, caller.HashAPIKey(apiKey)); err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to create caller")
+	}
+
+	fmt.Println(apiKey)
+}
@@ -0,0 +1,76 @@
+// Command mergelogins normalizes the logins stored before auth-api
+// normalized them, and merges the duplicate users that the same login typed
+// in different ways created. Without -apply it only prints what it would do.
+//
+//	go run ./cmd/mergelogins
+//	go run ./cmd/mergelogins -apply
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/client/database"
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+func main() {
+	apply := flag.Bool("apply", false, "merge the users and normalize the logins instead of only printing them")
+	flag.Parse()
+
+	ctx := context.Background()
+	config, err := config.LoadConfig()
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to load config")
+	}
+
+	var db database.Client
+	if err := db.Init(ctx, config); err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+
+	logins, err := db.FindUnnormalizedLogins(ctx)
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to find unnormalized logins")
+	}
+
+	merges := 0
+	for _, login := range logins {
+		stored := make([]string, 0, len(login.Stored))
+		for _, s := range login.Stored {
+			stored = append(stored, fmt.Sprintf("%q (user %s)", s.Login, s.UserID))
+		}
+
+		userIDs := login.UserIDs()
+		merges += len(userIDs) - 1
+
+		fmt.Printf("%s %q: %s\n", login.AuthUserType, login.Login, strings.Join(stored, ", "))
+
+		if !*apply {
+			continue
+		}
+
+		into, err := db.MergeLogin(ctx, login)
+		if err != nil {
+			log.WithField("err", err.Error()).Fatalf("Failed to merge login %q", login.Login)
+		}
+
+		switch {
+		case into == "":
+			fmt.Printf("\tskipped, the login is gone\n")
+		case len(userIDs) > 1:
+			fmt.Printf("\tmerged %d users into %s\n", len(userIDs)-1, into)
+		}
+	}
+
+	verb := "would be"
+	if *apply {
+		verb = "were"
+	}
+	fmt.Printf("%d logins %s normalized, %d users %s merged\n", len(logins), verb, merges, verb)
+}
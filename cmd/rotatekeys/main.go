@@ -0,0 +1,81 @@
+// Command rotatekeys rotates the keys logins and webhook secrets are
+// encrypted with. It creates a new data key and re-encrypts all logins and
+// webhook secrets with it in batches, which also encrypts the ones stored
+// before encryption was enabled and replaces the plaintext logins of the
+// login history, login changes and user merges with their blind index.
+// With -rewrap it first wraps all data keys with the current master key,
+// after MASTER_KEY_FILE was changed to a new master key and the old one
+// added to MASTER_KEY_PREVIOUS_FILES.
+//
+//	go run ./cmd/rotatekeys
+//	go run ./cmd/rotatekeys -rewrap -new-key=false
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/client/database"
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+func main() {
+	rewrap := flag.Bool("rewrap", false, "wrap all keys with the current master key")
+	newKey := flag.Bool("new-key", true, "create a new data key before re-encrypting")
+	batchSize := flag.Int("batch", 500, "number of logins to re-encrypt per transaction")
+	flag.Parse()
+
+	ctx := context.Background()
+	config, err := config.LoadConfig()
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to load config")
+	}
+
+	if config.MasterKeyFile == "" {
+		log.Fatal("MASTER_KEY_FILE is not set")
+	}
+
+	var db database.Client
+	if err := db.Init(ctx, config); err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+
+	keyring := db.Keyring()
+
+	if *rewrap {
+		rewrapped, err := keyring.Rewrap(ctx)
+		if err != nil {
+			log.WithField("err", err.Error()).Fatal("Failed to rewrap keys")
+		}
+		fmt.Printf("%d keys rewrapped with master key %s\n", rewrapped, keyring.KMS.MasterKeyID())
+	}
+
+	if *newKey {
+		keyID, err := keyring.Rotate(ctx)
+		if err != nil {
+			log.WithField("err", err.Error()).Fatal("Failed to create data key")
+		}
+		fmt.Printf("data key %d created\n", keyID)
+	}
+
+	total := 0
+	for {
+		reencrypted, err := db.ReencryptLogins(ctx, *batchSize)
+		if err != nil {
+			log.WithField("err", err.Error()).Fatal("Failed to re-encrypt logins")
+		}
+
+		if reencrypted == 0 {
+			break
+		}
+
+		total += reencrypted
+		log.Infof("Re-encrypted %d logins", total)
+	}
+
+	fmt.Printf("%d logins re-encrypted\n", total)
+}
@@ -0,0 +1,69 @@
+// Command scimtenant registers a tenant provisioning users of an auth user
+// type with SCIM and prints its new bearer token. Running it again for the
+// same tenant replaces the token.
+//
+//	go run ./cmd/scimtenant -name acme -user-type business
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/client/database"
+	"gitlab.com/route-kz/auth-api/config"
+	"gitlab.com/route-kz/auth-api/scim"
+)
+
+func main() {
+	name := flag.String("name", "", "name of the tenant")
+	userType := flag.String("user-type", "", "auth user type of the users the tenant provisions")
+	flag.Parse()
+
+	if *name == "" || *userType == "" {
+		log.Fatal("-name and -user-type are required")
+	}
+
+	ctx := context.Background()
+	config, err := config.LoadConfig()
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to load config")
+	}
+
+	if len(config.AuthUserTypes) > 0 && !contains(config.AuthUserTypes, *userType) {
+		log.Fatalf("-user-type must be one of AUTH_USER_TYPES %v", config.AuthUserTypes)
+	}
+
+	var db database.Client
+	if err := db.Init(ctx, config); err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to connect to database")
+	}
+	defer db.Close()
+
+	token, err := scim.GenerateToken()
+	if err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to generate scim token")
+	}
+
+	t := scim.Tenant{
+		Name:         *name,
+		AuthUserType: *userType,
+	}
+
+	if _, err := db.CreateSCIMTenant(ctx, t, scim.HashToken(token)); err != nil {
+		log.WithField("err", err.Error()).Fatal("Failed to create scim tenant")
+	}
+
+	fmt.Println(token)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
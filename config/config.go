@@ -2,6 +2,12 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
 	log "github.com/sirupsen/logrus"
@@ -14,8 +20,8 @@ type Config struct {
 	JaegerAgentPort            string  `envconfig:"JAEGER_AGENT_PORT" default:"6831"`
 	JaegerSamplerType          string  `envconfig:"JAEGER_SAMPLER_TYPE" default:"const"`
 	JaegerSamplerParam         float64 `envconfig:"JAEGER_SAMPLER_PARAM" default:"1"`
-	DatabasePassword           string  `envconfig:"DATABASE_PASSWORD" required:"true"`
-	DatabaseUser               string  `envconfig:"DATABASE_USER" required:"true"`
+	DatabasePassword           string  `envconfig:"DATABASE_PASSWORD" secret:"required"`
+	DatabaseUser               string  `envconfig:"DATABASE_USER" secret:"required"`
 	DatabaseURL                string  `envconfig:"DATABASE_URL" default:"127.0.0.1"`
 	DatabasePort               string  `envconfig:"DATABASE_PORT" default:"5432"`
 	DatabaseDB                 string  `envconfig:"DATABASE_DB" default:"postgres"`
@@ -23,7 +29,324 @@ type Config struct {
 	DatabaseMaxConnections     int     `envconfig:"DATABASE_MAX_CONNECTIONS" default:"12"`
 	DatabaseMaxIdleConnections int     `envconfig:"DATABASE_MAX_IDLE_CONNECTIONS" default:"3"`
 	RedisAddress               string  `envconfig:"REDIS_ADDRESS" required:"true"`
-	NatsURL                    string  `envconfig:"NATS_URL" required:"true"`
+	RedisPassword              string  `envconfig:"REDIS_PASSWORD" secret:"true"`
+	RedisDB                    int     `envconfig:"REDIS_DB" default:"0"`
+	NatsURL                    string  `envconfig:"NATS_URL" secret:"required"`
+
+	// Secrets (the fields tagged secret: the database and Redis credentials,
+	// the NATS URL, which may hold credentials, and the Vault token) are
+	// read from the file named by <NAME>_FILE, else from the environment,
+	// else from the Vault KV v2
+	// secret at SecretsVaultPath, e.g. "secret/data/auth-api", if
+	// SecretsVaultAddress is set. The database connections are renewed
+	// when DatabasePasswordFile changes.
+	DatabasePasswordFile        string        `envconfig:"DATABASE_PASSWORD_FILE"`
+	DatabasePasswordReloadEvery time.Duration `envconfig:"DATABASE_PASSWORD_RELOAD_EVERY" default:"30s"`
+	SecretsVaultAddress         string        `envconfig:"SECRETS_VAULT_ADDRESS"`
+	SecretsVaultToken           string        `envconfig:"SECRETS_VAULT_TOKEN" secret:"true"`
+	SecretsVaultPath            string        `envconfig:"SECRETS_VAULT_PATH"`
+
+	// With TLSCertFile and TLSKeyFile set the server terminates TLS itself.
+	// With TLSClientCAFile set it verifies client certificates against that
+	// CA; TLSClientIdentities maps caller names to the URI SAN, DNS SAN or
+	// common name of their certificates, e.g. "billing=billing.internal".
+	// The listener also serves end users, so certificates are only required
+	// on every connection with TLSRequireClientCert; otherwise the internal
+	// routes require them or an API key. The files are reloaded when they
+	// change.
+	TLSCertFile          string        `envconfig:"TLS_CERT_FILE"`
+	TLSKeyFile           string        `envconfig:"TLS_KEY_FILE"`
+	TLSClientCAFile      string        `envconfig:"TLS_CLIENT_CA_FILE"`
+	TLSRequireClientCert bool          `envconfig:"TLS_REQUIRE_CLIENT_CERT" default:"false"`
+	TLSClientIdentities  Map           `envconfig:"TLS_CLIENT_IDENTITIES"`
+	TLSReloadEvery       time.Duration `envconfig:"TLS_RELOAD_EVERY" default:"1m"`
+
+	// Browser clients and the origins they are served from, e.g.
+	// "web=https://app.route.kz|https://m.route.kz". Requests from these
+	// origins are allowed by CORS and rate limited as the client.
+	CORSAllowedOrigins   ListMap       `envconfig:"CORS_ALLOWED_ORIGINS"`
+	CORSAllowCredentials bool          `envconfig:"CORS_ALLOW_CREDENTIALS" default:"true"`
+	CORSMaxAge           time.Duration `envconfig:"CORS_MAX_AGE" default:"10m"`
+
+	// HSTSMaxAge is the max-age of the Strict-Transport-Security header, zero
+	// to leave it out.
+	HSTSMaxAge time.Duration `envconfig:"HSTS_MAX_AGE" default:"8760h"`
+
+	// SessionCookieName is the cookie browsers are authenticated by, e.g. by
+	// a proxy in front of the API turning it into a token. Unsafe requests
+	// with it must repeat the CSRF token of the CSRFCookieName cookie in the
+	// X-CSRF-Token header. Empty, as no route authenticates by cookie by
+	// itself, means no CSRF protection.
+	SessionCookieName string `envconfig:"SESSION_COOKIE_NAME"`
+	CSRFCookieName    string `envconfig:"CSRF_COOKIE_NAME" default:"csrf_token"`
+
+	// ForwardAuthPolicies are the auth user types allowed per route of the
+	// requests authenticated for reverse proxies, keyed by
+	// "[METHOD ]<path prefix>", e.g. "/admin/=staff,GET /public/=anonymous".
+	// Successful responses may be cached by the proxy for ForwardAuthCacheTTL.
+	ForwardAuthPolicies ListMap       `envconfig:"FORWARD_AUTH_POLICIES"`
+	ForwardAuthCacheTTL time.Duration `envconfig:"FORWARD_AUTH_CACHE_TTL" default:"30s"`
+
+	// ServiceAuthRequired rejects calls to internal routes without an API key.
+	ServiceAuthRequired bool `envconfig:"SERVICE_AUTH_REQUIRED" default:"true"`
+
+	// Rate limits of token issuance (POST /api/v1/tokens, /refresh-tokens and
+	// the code verification routes), as "<requests>/<window>", e.g. "5/1m".
+	// The per login limit can be set per auth user type, e.g. "driver=3/1m".
+	// The client is the X-Client-Id header. RateLimitTrustForwardedFor takes
+	// the IP from X-Forwarded-For, for when we run behind proxies:
+	// RateLimitTrustedProxies is how many of them append to the header, so
+	// the entries the client sent itself are skipped.
+	RateLimitPerLogin           Rate    `envconfig:"RATE_LIMIT_PER_LOGIN" default:"5/1m"`
+	RateLimitPerLoginByUserType RateMap `envconfig:"RATE_LIMIT_PER_LOGIN_BY_USER_TYPE"`
+	RateLimitPerIP              Rate    `envconfig:"RATE_LIMIT_PER_IP" default:"30/1m"`
+	RateLimitPerClient          Rate    `envconfig:"RATE_LIMIT_PER_CLIENT" default:"600/1m"`
+	RateLimitTrustForwardedFor  bool    `envconfig:"RATE_LIMIT_TRUST_FORWARDED_FOR" default:"false"`
+	RateLimitTrustedProxies     int     `envconfig:"RATE_LIMIT_TRUSTED_PROXIES" default:"1"`
+
+	// After LockoutThreshold failed attempts within LockoutFailureWindow a
+	// login is locked out from an IP (or the IP, when there is no login) for
+	// LockoutBase, doubling with every further failure up to LockoutMax.
+	LockoutThreshold     int           `envconfig:"LOCKOUT_THRESHOLD" default:"5"`
+	LockoutFailureWindow time.Duration `envconfig:"LOCKOUT_FAILURE_WINDOW" default:"1h"`
+	LockoutBase          time.Duration `envconfig:"LOCKOUT_BASE" default:"1m"`
+	LockoutMax           time.Duration `envconfig:"LOCKOUT_MAX" default:"24h"`
+
+	// With MasterKeyFile set logins are encrypted with data keys wrapped by
+	// the base64 encoded 32 byte key in the file. MasterKeyPreviousFiles
+	// hold master keys that were rotated out, to unwrap keys wrapped by them
+	// until they are rewrapped.
+	MasterKeyFile          string   `envconfig:"MASTER_KEY_FILE"`
+	MasterKeyPreviousFiles []string `envconfig:"MASTER_KEY_PREVIOUS_FILES"`
+
+	// PhoneDefaultRegion is the region of phone number logins given without
+	// a country code.
+	PhoneDefaultRegion string `envconfig:"PHONE_DEFAULT_REGION" default:"KZ"`
+
+	// The auth user types and auth methods POST /api/v1/tokens accepts, as
+	// they are sent. Any are accepted when none are configured.
+	AuthUserTypes []string `envconfig:"AUTH_USER_TYPES"`
+	AuthMethods   []string `envconfig:"AUTH_METHODS"`
+
+	// TokenAuthCodeRequired makes POST /api/v1/tokens check the auth code
+	// against the code POST /api/v1/tokens/codes sent to the login. Without
+	// it the auth code is not checked, for the services that verify logins
+	// themselves.
+	TokenAuthCodeRequired bool `envconfig:"TOKEN_AUTH_CODE_REQUIRED" default:"false"`
+
+	// RequestMaxBytes is the maximum size of a validated request body.
+	RequestMaxBytes int64 `envconfig:"REQUEST_MAX_BYTES" default:"65536"`
+
+	// OpenAPIValidation validates requests against the OpenAPI document
+	// ("requests"), responses too ("strict"), or nothing ("off").
+	OpenAPIValidation string `envconfig:"OPENAPI_VALIDATION" default:"off"`
+
+	// BatchMaxSize is the maximum number of items in a batch lookup request.
+	BatchMaxSize int `envconfig:"BATCH_MAX_SIZE" default:"500"`
+
+	// ErrorNamespace is the namespace of the errors we respond with. It
+	// defaults to the namespace v1 has always sent.
+	ErrorNamespace string `envconfig:"ERROR_NAMESPACE" default:"sms-api"`
+
+	// TokenMaxLifetime is how long a token stays valid after it was created.
+	// Zero means tokens never expire.
+	TokenMaxLifetime time.Duration `envconfig:"TOKEN_MAX_LIFETIME" default:"0"`
+
+	// TokensPartitioned tells the database client that the tokens table is
+	// partitioned by created_at month (see migrations/0001_partition_tokens.sql).
+	TokensPartitioned               bool          `envconfig:"TOKENS_PARTITIONED" default:"false"`
+	TokensPartitionsAhead           int           `envconfig:"TOKENS_PARTITIONS_AHEAD" default:"2"`
+	TokensPartitionMaintenanceEvery time.Duration `envconfig:"TOKENS_PARTITION_MAINTENANCE_EVERY" default:"1h"`
+
+	// Verification codes are sent when a user links or changes a login, or
+	// asks for an auth code. They are
+	// published on NatsVerificationSubject for the sms and email services
+	// to deliver.
+	VerificationCodeLength      int           `envconfig:"VERIFICATION_CODE_LENGTH" default:"6"`
+	VerificationCodeTTL         time.Duration `envconfig:"VERIFICATION_CODE_TTL" default:"10m"`
+	VerificationCodeMaxAttempts int           `envconfig:"VERIFICATION_CODE_MAX_ATTEMPTS" default:"5"`
+	NatsVerificationSubject     string        `envconfig:"NATS_VERIFICATION_SUBJECT" default:"auth.verification.requested"`
+
+	// Domain events (see the event package) are published on
+	// "<NatsEventSubjectPrefix>.<type>". An event counts as published once
+	// the JetStream stream NatsEventStream acknowledged it, if set, or else
+	// once the server received it, within NatsEventPublishTimeout.
+	NatsEventSubjectPrefix  string        `envconfig:"NATS_EVENT_SUBJECT_PREFIX" default:"auth.events"`
+	NatsEventStream         string        `envconfig:"NATS_EVENT_STREAM"`
+	NatsEventPublishTimeout time.Duration `envconfig:"NATS_EVENT_PUBLISH_TIMEOUT" default:"5s"`
+
+	// Events are written to the event_outbox table with the change they are
+	// about, and published from there every OutboxRelayEvery. Failed events
+	// are retried after OutboxRetryBase, doubling up to OutboxRetryMax.
+	// Published events are kept for OutboxRetention.
+	OutboxRelayEvery time.Duration `envconfig:"OUTBOX_RELAY_EVERY" default:"1s"`
+	OutboxBatchSize  int           `envconfig:"OUTBOX_BATCH_SIZE" default:"100"`
+	OutboxRetryBase  time.Duration `envconfig:"OUTBOX_RETRY_BASE" default:"1s"`
+	OutboxRetryMax   time.Duration `envconfig:"OUTBOX_RETRY_MAX" default:"5m"`
+	OutboxRetention  time.Duration `envconfig:"OUTBOX_RETENTION" default:"24h"`
+
+	// Commands about user accounts are consumed from the subjects below of
+	// the JetStream stream NatsCommandStream, if set. Commands failing
+	// NatsCommandMaxDeliver times or malformed ones are published on
+	// NatsCommandDeadLetterSubject.
+	NatsCommandStream              string        `envconfig:"NATS_COMMAND_STREAM"`
+	NatsCommandDurable             string        `envconfig:"NATS_COMMAND_DURABLE" default:"auth-api"`
+	NatsCommandRevokeTokensSubject string        `envconfig:"NATS_COMMAND_REVOKE_TOKENS_SUBJECT" default:"auth.commands.revoke_tokens"`
+	NatsCommandBlockUserSubject    string        `envconfig:"NATS_COMMAND_BLOCK_USER_SUBJECT" default:"auth.commands.block_user"`
+	NatsCommandUnblockUserSubject  string        `envconfig:"NATS_COMMAND_UNBLOCK_USER_SUBJECT" default:"auth.commands.unblock_user"`
+	NatsCommandMaxDeliver          int           `envconfig:"NATS_COMMAND_MAX_DELIVER" default:"5"`
+	NatsCommandAckWait             time.Duration `envconfig:"NATS_COMMAND_ACK_WAIT" default:"30s"`
+	NatsCommandDeadLetterSubject   string        `envconfig:"NATS_COMMAND_DEAD_LETTER_SUBJECT" default:"auth.commands.dead_letter"`
+
+	// GRPCPort is the port of the gRPC API, empty (the default) to not
+	// serve it.
+	GRPCPort string `envconfig:"GRPC_PORT"`
+
+	// Webhook deliveries are sent every WebhookDispatchEvery, and failed
+	// ones retried after WebhookRetryBase, doubling up to WebhookRetryMax,
+	// WebhookMaxAttempts times. Subscriptions are disabled after
+	// WebhookDisableAfter failed attempts in a row.
+	WebhookDispatchEvery time.Duration `envconfig:"WEBHOOK_DISPATCH_EVERY" default:"1s"`
+	WebhookBatchSize     int           `envconfig:"WEBHOOK_BATCH_SIZE" default:"50"`
+	WebhookTimeout       time.Duration `envconfig:"WEBHOOK_TIMEOUT" default:"10s"`
+	WebhookMaxAttempts   int           `envconfig:"WEBHOOK_MAX_ATTEMPTS" default:"10"`
+	WebhookRetryBase     time.Duration `envconfig:"WEBHOOK_RETRY_BASE" default:"10s"`
+	WebhookRetryMax      time.Duration `envconfig:"WEBHOOK_RETRY_MAX" default:"1h"`
+	WebhookDisableAfter  int           `envconfig:"WEBHOOK_DISABLE_AFTER" default:"50"`
+	// WebhookAllowInsecure allows http:// endpoints, for development.
+	WebhookAllowInsecure bool `envconfig:"WEBHOOK_ALLOW_INSECURE" default:"false"`
+	// Webhooks are only delivered to public addresses, and to the networks
+	// in WebhookAllowedNetworks, e.g. "10.20.0.0/16,127.0.0.1/32".
+	WebhookAllowedNetworks Networks `envconfig:"WEBHOOK_ALLOWED_NETWORKS"`
+
+	// A login change verified only by the new login is applied after
+	// LoginChangeGracePeriod. A replaced login can not be used by anyone
+	// else until LoginReuseCooldown has passed.
+	LoginChangeGracePeriod time.Duration `envconfig:"LOGIN_CHANGE_GRACE_PERIOD" default:"72h"`
+	LoginChangeApplyEvery  time.Duration `envconfig:"LOGIN_CHANGE_APPLY_EVERY" default:"1m"`
+	LoginReuseCooldown     time.Duration `envconfig:"LOGIN_REUSE_COOLDOWN" default:"720h"`
+}
+
+// Map is a map read from a "key=value,key=value" environment variable.
+// Unlike envconfig's own maps, values may contain colons (e.g. URIs).
+type Map map[string]string
+
+// Decode implements envconfig.Decoder.
+func (m *Map) Decode(value string) error {
+	*m = make(Map)
+
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("invalid map item: %q", pair)
+		}
+		(*m)[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+	}
+
+	return nil
+}
+
+// Rate is a number of requests allowed per window, read from a
+// "<requests>/<window>" environment variable, e.g. "10/1m".
+type Rate struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Decode implements envconfig.Decoder.
+func (r *Rate) Decode(value string) error {
+	parts := strings.SplitN(value, "/", 2)
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid rate: %q", value)
+	}
+
+	limit, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid rate limit: %w", err)
+	}
+
+	window, err := time.ParseDuration(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid rate window: %w", err)
+	}
+
+	r.Limit = limit
+	r.Window = window
+
+	return nil
+}
+
+// RateMap is a map of rates read from a "key=rate,key=rate" environment
+// variable.
+type RateMap map[string]Rate
+
+// Decode implements envconfig.Decoder.
+func (rm *RateMap) Decode(value string) error {
+	var m Map
+	if err := m.Decode(value); err != nil {
+		return err
+	}
+
+	*rm = make(RateMap, len(m))
+	for key, rate := range m {
+		var r Rate
+		if err := r.Decode(rate); err != nil {
+			return err
+		}
+		(*rm)[key] = r
+	}
+
+	return nil
+}
+
+// ListMap is a map of lists read from a "key=a|b,key=c" environment
+// variable.
+type ListMap map[string][]string
+
+// Decode implements envconfig.Decoder.
+func (lm *ListMap) Decode(value string) error {
+	var m Map
+	if err := m.Decode(value); err != nil {
+		return err
+	}
+
+	*lm = make(ListMap, len(m))
+	for key, list := range m {
+		for _, item := range strings.Split(list, "|") {
+			if item = strings.TrimSpace(item); item != "" {
+				(*lm)[key] = append((*lm)[key], item)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Networks is a list of networks read from a "<cidr>,<cidr>" environment
+// variable.
+type Networks []*net.IPNet
+
+// Decode implements envconfig.Decoder.
+func (n *Networks) Decode(value string) error {
+	*n = nil
+
+	for _, cidr := range strings.Split(value, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid network: %w", err)
+		}
+		*n = append(*n, network)
+	}
+
+	return nil
 }
 
 // LoadConfig reads environment variables and populates Config.
@@ -34,7 +357,13 @@ func LoadConfig() (*Config, error) {
 
 	var c Config
 
-	err := envconfig.Process("", &c)
+	if err := envconfig.Process("", &c); err != nil {
+		return nil, err
+	}
+
+	if err := c.loadSecrets(); err != nil {
+		return nil, err
+	}
 
-	return &c, err
+	return &c, nil
 }
@@ -0,0 +1,165 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SecretProvider is an interface for fetching secrets by the name of their
+// environment variable, e.g. "DATABASE_PASSWORD". Returns an empty string
+// for secrets the provider does not have.
+type SecretProvider interface {
+	Secret(ctx context.Context, name string) (string, error)
+}
+
+// FileSecretProvider reads a secret from the file named by the <NAME>_FILE
+// environment variable, e.g. a mounted Kubernetes or Docker secret.
+type FileSecretProvider struct{}
+
+// Secret reads the secret from its file, if <NAME>_FILE is set.
+func (FileSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	file := os.Getenv(name + "_FILE")
+	if file == "" {
+		return "", nil
+	}
+
+	return ReadSecretFile(file)
+}
+
+// ReadSecretFile reads a secret from a file, without the trailing newline
+// editors and echo add.
+func ReadSecretFile(file string) (string, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret file: %w", err)
+	}
+
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// VaultSecretProvider reads secrets from a Vault KV v2 secret, or anything
+// serving the same HTTP API. The secret is fetched once and holds the
+// secrets under their names.
+type VaultSecretProvider struct {
+	Address string
+	Token   string
+	// Path is the path of the secret, e.g. "secret/data/auth-api".
+	Path   string
+	Client *http.Client
+
+	once    sync.Once
+	secrets map[string]string
+	err     error
+}
+
+// Secret returns the secret from the Vault secret.
+func (v *VaultSecretProvider) Secret(ctx context.Context, name string) (string, error) {
+	v.once.Do(func() {
+		v.secrets, v.err = v.fetch(ctx)
+	})
+
+	return v.secrets[name], v.err
+}
+
+func (v *VaultSecretProvider) fetch(ctx context.Context) (map[string]string, error) {
+	url := strings.TrimRight(v.Address, "/") + "/v1/" + strings.TrimLeft(v.Path, "/")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating vault request: %w", err)
+	}
+	req.Header.Set("X-Vault-Token", v.Token)
+
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("error fetching secrets from vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error fetching secrets from vault: status %d", resp.StatusCode)
+	}
+
+	var body struct {
+		Data struct {
+			Data map[string]string `json:"data"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("error decoding vault secrets: %w", err)
+	}
+
+	return body.Data.Data, nil
+}
+
+// loadSecrets fills in the secrets that are not set in the environment
+// from their files and Vault, and checks the required ones are set.
+func (c *Config) loadSecrets() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The Vault token is a secret too, so it can only come from a file.
+	if err := c.resolveSecrets(ctx, FileSecretProvider{}, false); err != nil {
+		return err
+	}
+
+	if c.SecretsVaultAddress == "" {
+		return c.resolveSecrets(ctx, nil, true)
+	}
+
+	vault := &VaultSecretProvider{
+		Address: c.SecretsVaultAddress,
+		Token:   c.SecretsVaultToken,
+		Path:    c.SecretsVaultPath,
+	}
+
+	return c.resolveSecrets(ctx, vault, true)
+}
+
+// resolveSecrets sets the secret fields of the config from the provider.
+// Files override the environment; other providers only fill in what is
+// not set. With check set, missing required secrets are an error.
+func (c *Config) resolveSecrets(ctx context.Context, provider SecretProvider, check bool) error {
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		secret, ok := field.Tag.Lookup("secret")
+		if !ok {
+			continue
+		}
+
+		name := field.Tag.Get("envconfig")
+		value := v.Field(i)
+
+		_, isFile := provider.(FileSecretProvider)
+		if provider != nil && (isFile || value.String() == "") {
+			s, err := provider.Secret(ctx, name)
+			if err != nil {
+				return fmt.Errorf("error loading secret %s: %w", name, err)
+			}
+			if s != "" {
+				value.SetString(s)
+			}
+		}
+
+		if check && secret == "required" && value.String() == "" {
+			return fmt.Errorf("required secret %s is not set, set %s or %s_FILE", name, name, name)
+		}
+	}
+
+	return nil
+}
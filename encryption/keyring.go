@@ -0,0 +1,348 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// keySize is the size of master and data keys, for AES-256.
+const keySize = 32
+
+// BlindIndexPrefix marks blind indexes, so they can not be mistaken for the
+// values they index.
+const BlindIndexPrefix = "bi1:"
+
+// activeKeyTTL is how long the keyring uses the active data key before
+// checking whether a rotation made another key active.
+const activeKeyTTL = time.Minute
+
+// Purpose is what a key is used for.
+type Purpose string
+
+const (
+	// PurposeData keys encrypt values. They are rotated.
+	PurposeData Purpose = "data"
+	// PurposeBlindIndex keys compute blind indexes. Rotating one would mean
+	// recomputing every index, so there is only ever one.
+	PurposeBlindIndex Purpose = "blind_index"
+)
+
+// ErrActiveKeyExists is returned by KeyStore.AddEncryptionKey when the
+// purpose already has an active key.
+var ErrActiveKeyExists = errors.New("there already is an active key")
+
+// StoredKey is a key as it is stored, wrapped by a master key.
+type StoredKey struct {
+	ID          int     `db:"id"`
+	Purpose     Purpose `db:"purpose"`
+	WrappedKey  []byte  `db:"wrapped_key"`
+	MasterKeyID string  `db:"master_key_id"`
+}
+
+// KeyStore is an interface for storing wrapped keys.
+type KeyStore interface {
+	// ActiveEncryptionKey returns the key in use for the purpose, or nil if
+	// there is none yet.
+	ActiveEncryptionKey(ctx context.Context, purpose Purpose) (*StoredKey, error)
+	EncryptionKey(ctx context.Context, id int) (*StoredKey, error)
+	EncryptionKeys(ctx context.Context) ([]StoredKey, error)
+	// CreateEncryptionKey stores a key as the active key of its purpose,
+	// retiring the previous one, and returns its id.
+	CreateEncryptionKey(ctx context.Context, key StoredKey) (int, error)
+	// AddEncryptionKey stores the first key of its purpose and returns its
+	// id, or ErrActiveKeyExists if the purpose has an active key.
+	AddEncryptionKey(ctx context.Context, key StoredKey) (int, error)
+	RewrapEncryptionKey(ctx context.Context, key StoredKey) error
+}
+
+// Keyring encrypts and decrypts values with data keys from a KeyStore,
+// unwrapped by a KMS.
+type Keyring struct {
+	KMS   KMS
+	Store KeyStore
+
+	mu             sync.Mutex
+	keys           map[int][]byte
+	activeID       int
+	activeLoadedAt time.Time
+	indexKey       []byte
+}
+
+// Init loads the blind index key and the active data key, creating them
+// if they do not exist yet.
+func (k *Keyring) Init(ctx context.Context) error {
+	k.keys = map[int][]byte{}
+
+	indexKey, err := k.loadOrCreate(ctx, PurposeBlindIndex)
+	if err != nil {
+		return err
+	}
+	k.indexKey = k.keys[indexKey]
+
+	activeID, err := k.loadOrCreate(ctx, PurposeData)
+	if err != nil {
+		return err
+	}
+	k.activeID = activeID
+	k.activeLoadedAt = time.Now()
+
+	return nil
+}
+
+// BlindIndex returns the blind index of a value: a keyed hash that is the
+// same for the same value, for lookups without decrypting.
+func (k *Keyring) BlindIndex(value string) string {
+	mac := hmac.New(sha256.New, k.indexKey)
+	mac.Write([]byte(value))
+	return BlindIndexPrefix + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypt encrypts a value with the active data key and returns the id of
+// the key with the ciphertext.
+func (k *Keyring) Encrypt(ctx context.Context, plaintext string) (int, []byte, error) {
+	keyID, err := k.ActiveKeyID(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	key, err := k.key(ctx, keyID)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	ciphertext, err := seal(key, []byte(plaintext))
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return keyID, ciphertext, nil
+}
+
+// Decrypt decrypts a value encrypted with the data key keyID.
+func (k *Keyring) Decrypt(ctx context.Context, keyID int, ciphertext []byte) (string, error) {
+	key, err := k.key(ctx, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	plaintext, err := open(key, ciphertext)
+	if err != nil {
+		return "", fmt.Errorf("error decrypting with key %d: %w", keyID, err)
+	}
+
+	return string(plaintext), nil
+}
+
+// ActiveKeyID returns the id of the data key new values are encrypted with.
+func (k *Keyring) ActiveKeyID(ctx context.Context) (int, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if time.Since(k.activeLoadedAt) < activeKeyTTL {
+		return k.activeID, nil
+	}
+
+	stored, err := k.Store.ActiveEncryptionKey(ctx, PurposeData)
+	if err != nil {
+		return 0, err
+	}
+	if stored == nil {
+		return 0, errors.New("no active data key")
+	}
+
+	k.activeID = stored.ID
+	k.activeLoadedAt = time.Now()
+
+	return k.activeID, nil
+}
+
+// Rotate creates a new data key and makes it the active one. Values
+// encrypted with the previous keys can still be decrypted.
+func (k *Keyring) Rotate(ctx context.Context) (int, error) {
+	id, err := k.create(ctx, PurposeData, k.Store.CreateEncryptionKey)
+	if err != nil {
+		return 0, err
+	}
+
+	k.mu.Lock()
+	k.activeID = id
+	k.activeLoadedAt = time.Now()
+	k.mu.Unlock()
+
+	return id, nil
+}
+
+// Rewrap wraps all stored keys with the current master key of the KMS, so
+// previous master keys can be retired. Returns how many keys were rewrapped.
+func (k *Keyring) Rewrap(ctx context.Context) (int, error) {
+	stored, err := k.Store.EncryptionKeys(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rewrapped := 0
+	for _, s := range stored {
+		if s.MasterKeyID == k.KMS.MasterKeyID() {
+			continue
+		}
+
+		key, err := k.KMS.Unwrap(ctx, s.MasterKeyID, s.WrappedKey)
+		if err != nil {
+			return rewrapped, fmt.Errorf("error unwrapping key %d: %w", s.ID, err)
+		}
+
+		if s.WrappedKey, err = k.KMS.Wrap(ctx, key); err != nil {
+			return rewrapped, fmt.Errorf("error wrapping key %d: %w", s.ID, err)
+		}
+		s.MasterKeyID = k.KMS.MasterKeyID()
+
+		if err := k.Store.RewrapEncryptionKey(ctx, s); err != nil {
+			return rewrapped, err
+		}
+		rewrapped++
+	}
+
+	return rewrapped, nil
+}
+
+// key returns the unwrapped data key keyID, loading it from the store the
+// first time.
+func (k *Keyring) key(ctx context.Context, keyID int) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if key, ok := k.keys[keyID]; ok {
+		return key, nil
+	}
+
+	stored, err := k.Store.EncryptionKey(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := k.KMS.Unwrap(ctx, stored.MasterKeyID, stored.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("error unwrapping key %d: %w", keyID, err)
+	}
+
+	k.keys[keyID] = key
+	return key, nil
+}
+
+// loadOrCreate loads the active key of purpose, creating it if there is
+// none yet. Instances starting at the same time for the first time race to
+// create it; the ones losing the race load the key of the winner.
+func (k *Keyring) loadOrCreate(ctx context.Context, purpose Purpose) (int, error) {
+	stored, err := k.Store.ActiveEncryptionKey(ctx, purpose)
+	if err != nil {
+		return 0, err
+	}
+
+	if stored == nil {
+		id, err := k.create(ctx, purpose, k.Store.AddEncryptionKey)
+		if !errors.Is(err, ErrActiveKeyExists) {
+			return id, err
+		}
+
+		stored, err = k.Store.ActiveEncryptionKey(ctx, purpose)
+		if err != nil {
+			return 0, err
+		}
+		if stored == nil {
+			return 0, fmt.Errorf("no active %s key", purpose)
+		}
+	}
+
+	key, err := k.KMS.Unwrap(ctx, stored.MasterKeyID, stored.WrappedKey)
+	if err != nil {
+		return 0, fmt.Errorf("error unwrapping %s key %d: %w", purpose, stored.ID, err)
+	}
+
+	k.keys[stored.ID] = key
+	return stored.ID, nil
+}
+
+// create generates a key of purpose and stores it with store.
+func (k *Keyring) create(
+	ctx context.Context,
+	purpose Purpose,
+	store func(context.Context, StoredKey) (int, error),
+) (int, error) {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return 0, fmt.Errorf("error generating %s key: %w", purpose, err)
+	}
+
+	wrapped, err := k.KMS.Wrap(ctx, key)
+	if err != nil {
+		return 0, fmt.Errorf("error wrapping %s key: %w", purpose, err)
+	}
+
+	id, err := store(ctx, StoredKey{
+		Purpose:     purpose,
+		WrappedKey:  wrapped,
+		MasterKeyID: k.KMS.MasterKeyID(),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	k.mu.Lock()
+	k.keys[id] = key
+	k.mu.Unlock()
+
+	return id, nil
+}
+
+// seal encrypts plaintext with AES-GCM, prefixing the nonce.
+func seal(key, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("error generating nonce: %w", err)
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// open decrypts what seal encrypted.
+func open(key, ciphertext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext is too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, sealed, nil)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher: %w", err)
+	}
+
+	return gcm, nil
+}
@@ -0,0 +1,283 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// memoryStore is a KeyStore in memory. raceWinner, if set, is stored by
+// another instance right after the first ActiveEncryptionKey of a purpose.
+type memoryStore struct {
+	mu         sync.Mutex
+	keys       []StoredKey
+	raceWinner func(purpose Purpose) *StoredKey
+	looked     map[Purpose]bool
+}
+
+func (s *memoryStore) ActiveEncryptionKey(ctx context.Context, purpose Purpose) (*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	active := s.active(purpose)
+
+	if s.raceWinner != nil && !s.looked[purpose] {
+		if s.looked == nil {
+			s.looked = map[Purpose]bool{}
+		}
+		s.looked[purpose] = true
+		if winner := s.raceWinner(purpose); winner != nil {
+			winner.ID = len(s.keys) + 1
+			s.keys = append(s.keys, *winner)
+		}
+	}
+
+	return active, nil
+}
+
+func (s *memoryStore) active(purpose Purpose) *StoredKey {
+	for i := len(s.keys) - 1; i >= 0; i-- {
+		if s.keys[i].Purpose == purpose {
+			key := s.keys[i]
+			return &key
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) EncryptionKey(ctx context.Context, id int) (*StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := s.keys[id-1]
+	return &key, nil
+}
+
+func (s *memoryStore) EncryptionKeys(ctx context.Context) ([]StoredKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]StoredKey(nil), s.keys...), nil
+}
+
+func (s *memoryStore) CreateEncryptionKey(ctx context.Context, key StoredKey) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key.ID = len(s.keys) + 1
+	s.keys = append(s.keys, key)
+	return key.ID, nil
+}
+
+func (s *memoryStore) AddEncryptionKey(ctx context.Context, key StoredKey) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.active(key.Purpose) != nil {
+		return 0, ErrActiveKeyExists
+	}
+
+	key.ID = len(s.keys) + 1
+	s.keys = append(s.keys, key)
+	return key.ID, nil
+}
+
+func (s *memoryStore) RewrapEncryptionKey(ctx context.Context, key StoredKey) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys[key.ID-1] = key
+	return nil
+}
+
+func randomKey(t *testing.T) []byte {
+	t.Helper()
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newFileKMS(t *testing.T) *FileKMS {
+	t.Helper()
+
+	file := filepath.Join(t.TempDir(), "master.key")
+	if err := os.WriteFile(file, []byte(base64.StdEncoding.EncodeToString(randomKey(t))), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	kms, err := NewFileKMS(file, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kms
+}
+
+func TestSealOpen(t *testing.T) {
+	key := randomKey(t)
+
+	tests := []struct {
+		name    string
+		tamper  func(ciphertext []byte) []byte
+		key     []byte
+		wantErr bool
+	}{
+		{
+			name:   "round trip",
+			tamper: func(c []byte) []byte { return c },
+			key:    key,
+		},
+		{
+			name:    "other key",
+			tamper:  func(c []byte) []byte { return c },
+			key:     randomKey(t),
+			wantErr: true,
+		},
+		{
+			name: "tampered",
+			tamper: func(c []byte) []byte {
+				c[len(c)-1] ^= 1
+				return c
+			},
+			key:     key,
+			wantErr: true,
+		},
+		{
+			name:    "too short",
+			tamper:  func(c []byte) []byte { return c[:4] },
+			key:     key,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plaintext := []byte("+77011234567")
+
+			ciphertext, err := seal(key, plaintext)
+			if err != nil {
+				t.Fatalf("seal() error = %v", err)
+			}
+
+			got, err := open(tt.key, tt.tamper(ciphertext))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("open() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("open() error = %v", err)
+			}
+			if !bytes.Equal(got, plaintext) {
+				t.Errorf("open() = %q, want %q", got, plaintext)
+			}
+		})
+	}
+}
+
+func TestSealUsesFreshNonces(t *testing.T) {
+	key := randomKey(t)
+
+	a, err := seal(key, []byte("jane@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := seal(key, []byte("jane@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bytes.Equal(a, b) {
+		t.Error("seal() returned the same ciphertext twice")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	ctx := context.Background()
+	store := &memoryStore{}
+	keyring := &Keyring{KMS: newFileKMS(t), Store: store}
+
+	if err := keyring.Init(ctx); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	keyID, ciphertext, err := keyring.Encrypt(ctx, "jane@example.com")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+
+	// A rotation leaves the values encrypted with the previous key readable.
+	rotatedID, err := keyring.Rotate(ctx)
+	if err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	if rotatedID == keyID {
+		t.Errorf("Rotate() = %d, the key it replaced", rotatedID)
+	}
+
+	// Another instance reads the keys from the store.
+	other := &Keyring{KMS: keyring.KMS, Store: store}
+	if err := other.Init(ctx); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	plaintext, err := other.Decrypt(ctx, keyID, ciphertext)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	if plaintext != "jane@example.com" {
+		t.Errorf("Decrypt() = %q, want %q", plaintext, "jane@example.com")
+	}
+
+	index := keyring.BlindIndex("jane@example.com")
+	if !strings.HasPrefix(index, BlindIndexPrefix) {
+		t.Errorf("BlindIndex() = %q, want the %q prefix", index, BlindIndexPrefix)
+	}
+	if other.BlindIndex("jane@example.com") != index {
+		t.Error("BlindIndex() differs between instances")
+	}
+	if keyring.BlindIndex("john@example.com") == index {
+		t.Error("BlindIndex() is the same for different values")
+	}
+}
+
+func TestKeyringInitRace(t *testing.T) {
+	ctx := context.Background()
+	kms := newFileKMS(t)
+
+	// Another instance stores its keys between our lookup and our insert.
+	winnerKeys := map[Purpose][]byte{}
+	store := &memoryStore{raceWinner: func(purpose Purpose) *StoredKey {
+		key := randomKey(t)
+		wrapped, err := kms.Wrap(ctx, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		winnerKeys[purpose] = key
+		return &StoredKey{Purpose: purpose, WrappedKey: wrapped, MasterKeyID: kms.MasterKeyID()}
+	}}
+
+	keyring := &Keyring{KMS: kms, Store: store}
+	if err := keyring.Init(ctx); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	if len(store.keys) != 2 {
+		t.Errorf("store has %d keys, want only the 2 of the other instance", len(store.keys))
+	}
+	if !bytes.Equal(keyring.indexKey, winnerKeys[PurposeBlindIndex]) {
+		t.Error("Init() did not load the blind index key of the other instance")
+	}
+	if !bytes.Equal(keyring.keys[keyring.activeID], winnerKeys[PurposeData]) {
+		t.Error("Init() did not load the data key of the other instance")
+	}
+}
@@ -0,0 +1,98 @@
+// Package encryption provides envelope encryption of personal identifiers:
+// data keys encrypt the values and are themselves wrapped by a master key
+// held by a KMS. Blind indexes make the encrypted values searchable.
+package encryption
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// KMS is an interface for wrapping and unwrapping data keys with a master
+// key that never leaves the KMS.
+type KMS interface {
+	// MasterKeyID identifies the master key Wrap uses.
+	MasterKeyID() string
+	Wrap(ctx context.Context, key []byte) ([]byte, error)
+	// Unwrap unwraps a key wrapped by the master key with the given id,
+	// which may be a previous master key.
+	Unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error)
+}
+
+// FileKMS is a KMS with master keys loaded from local files. Each file holds
+// a base64 encoded 32 byte key.
+type FileKMS struct {
+	current string
+	keys    map[string][]byte
+}
+
+// NewFileKMS loads the current master key and the previous ones, which are
+// only used to unwrap keys wrapped before the master key was rotated.
+func NewFileKMS(currentFile string, previousFiles []string) (*FileKMS, error) {
+	kms := &FileKMS{keys: map[string][]byte{}}
+
+	for i, file := range append([]string{currentFile}, previousFiles...) {
+		key, err := readMasterKey(file)
+		if err != nil {
+			return nil, err
+		}
+
+		id := masterKeyID(key)
+		kms.keys[id] = key
+		if i == 0 {
+			kms.current = id
+		}
+	}
+
+	return kms, nil
+}
+
+// MasterKeyID identifies the current master key.
+func (k *FileKMS) MasterKeyID() string {
+	return k.current
+}
+
+// Wrap wraps a key with the current master key.
+func (k *FileKMS) Wrap(ctx context.Context, key []byte) ([]byte, error) {
+	return seal(k.keys[k.current], key)
+}
+
+// Unwrap unwraps a key with the master key it was wrapped with.
+func (k *FileKMS) Unwrap(ctx context.Context, masterKeyID string, wrapped []byte) ([]byte, error) {
+	masterKey, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %s", masterKeyID)
+	}
+
+	return open(masterKey, wrapped)
+}
+
+func readMasterKey(file string) ([]byte, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("error reading master key: %w", err)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding master key %s: %w", file, err)
+	}
+
+	if len(key) != keySize {
+		return nil, fmt.Errorf("master key %s has %d bytes, want %d", file, len(key), keySize)
+	}
+
+	return key, nil
+}
+
+// masterKeyID derives the id of a master key from the key, so the same key
+// always has the same id.
+func masterKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
@@ -0,0 +1,104 @@
+// Package event defines the domain events auth-api publishes about users
+// and tokens, for other services to learn about them without polling.
+package event
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SchemaVersion is the version of the event envelope and data below, see
+// schema/v1.json. Fields may be added within a version; renaming or removing
+// one needs a new version.
+const SchemaVersion = 1
+
+// Type is the type of an event. Events are published on the subject
+// "<prefix>.<type>", e.g. "auth.events.user.created".
+type Type string
+
+const (
+	TypeUserCreated    Type = "user.created"
+	TypeUserLogin      Type = "user.login"
+	TypeUserBlocked    Type = "user.blocked"
+	TypeTokenIssued    Type = "token.issued"
+	TypeTokenRefreshed Type = "token.refreshed"
+	TypeTokenRevoked   Type = "token.revoked"
+)
+
+// Event is the envelope of every event. Trace holds the span context of the
+// request that caused the event, injected with trace.InjectIntoCarrier, for
+// consumers to continue the trace with trace.ExtractFromCarrier.
+type Event struct {
+	ID         string            `json:"id"`
+	Type       Type              `json:"type"`
+	Version    int               `json:"version"`
+	OccurredAt time.Time         `json:"occurred_at"`
+	UserID     string            `json:"user_id"`
+	Data       interface{}       `json:"data"`
+	Trace      map[string]string `json:"trace,omitempty"`
+}
+
+// New returns an event of the type about the user with data, one of the
+// data types below.
+func New(eventType Type, userID string, data interface{}) (Event, error) {
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return Event{}, fmt.Errorf("error generating event id: %w", err)
+	}
+
+	return Event{
+		ID:         id.String(),
+		Type:       eventType,
+		Version:    SchemaVersion,
+		OccurredAt: time.Now().UTC(),
+		UserID:     userID,
+		Data:       data,
+	}, nil
+}
+
+// UserCreated is the data of a user.created event, sent when a token is
+// first issued for a login nobody had.
+type UserCreated struct {
+	AuthUserType string `json:"auth_user_type"`
+	AuthMethod   string `json:"auth_method"`
+	LoginType    string `json:"login_type"`
+}
+
+// UserLogin is the data of a user.login event, sent when a token is issued
+// for a login.
+type UserLogin struct {
+	AuthUserType string `json:"auth_user_type"`
+	AuthMethod   string `json:"auth_method"`
+}
+
+// UserBlocked is the data of a user.blocked event.
+type UserBlocked struct {
+	Blocked bool   `json:"blocked"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// TokenIssued is the data of a token.issued event, sent when a token is
+// issued for a login. Tokens are secrets and are never part of events.
+type TokenIssued struct {
+	AuthMethod string `json:"auth_method"`
+}
+
+// TokenRefreshed is the data of a token.refreshed event, sent when a token
+// is exchanged for a new one: the old token is gone without a
+// token.revoked event.
+type TokenRefreshed struct{}
+
+// Reasons tokens are revoked for.
+const (
+	ReasonRevoke  = "revoke"
+	ReasonBlocked = "blocked"
+)
+
+// TokenRevoked is the data of a token.revoked event. All is set when all
+// the tokens of the user were revoked.
+type TokenRevoked struct {
+	Reason string `json:"reason"`
+	All    bool   `json:"all"`
+}
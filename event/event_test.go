@@ -0,0 +1,215 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+// schemaV1 is the part of schema/v1.json the tests check events against.
+type schemaV1 struct {
+	Required   []string `json:"required"`
+	Properties struct {
+		Type struct {
+			Enum []Type `json:"enum"`
+		} `json:"type"`
+	} `json:"properties"`
+	AllOf []struct {
+		If struct {
+			Properties struct {
+				Type struct {
+					Const Type `json:"const"`
+				} `json:"type"`
+			} `json:"properties"`
+		} `json:"if"`
+		Then struct {
+			Properties struct {
+				Data struct {
+					Ref string `json:"$ref"`
+				} `json:"data"`
+			} `json:"properties"`
+		} `json:"then"`
+	} `json:"allOf"`
+	Defs map[string]struct {
+		Required   []string `json:"required"`
+		Properties map[string]struct {
+			Enum []string `json:"enum"`
+		} `json:"properties"`
+	} `json:"$defs"`
+}
+
+func loadSchema(t *testing.T) schemaV1 {
+	t.Helper()
+
+	content, err := os.ReadFile("schema/v1.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var schema schemaV1
+	if err := json.Unmarshal(content, &schema); err != nil {
+		t.Fatal(err)
+	}
+	return schema
+}
+
+func TestEventsMatchSchema(t *testing.T) {
+	schema := loadSchema(t)
+	userID := uuid.New().String()
+
+	tests := []struct {
+		eventType Type
+		data      interface{}
+	}{
+		{TypeUserCreated, UserCreated{AuthUserType: "client", AuthMethod: "sms", LoginType: "phone"}},
+		{TypeUserLogin, UserLogin{AuthUserType: "client", AuthMethod: "sms"}},
+		{TypeUserBlocked, UserBlocked{Blocked: false}},
+		{TypeTokenIssued, TokenIssued{AuthMethod: "sms"}},
+		{TypeTokenRefreshed, TokenRefreshed{}},
+		{TypeTokenRevoked, TokenRevoked{Reason: ReasonBlocked, All: true}},
+	}
+
+	if len(tests) != len(schema.Properties.Type.Enum) {
+		t.Errorf("the schema has %d event types, the test %d", len(schema.Properties.Type.Enum), len(tests))
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.eventType), func(t *testing.T) {
+			e, err := New(tt.eventType, userID, tt.data)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+
+			if _, err := uuid.Parse(e.ID); err != nil {
+				t.Errorf("id %q is not a uuid", e.ID)
+			}
+			if e.Version != SchemaVersion || e.OccurredAt.IsZero() || e.OccurredAt.Location().String() != "UTC" {
+				t.Errorf("New() = version %d, occurred at %s", e.Version, e.OccurredAt)
+			}
+
+			var envelope map[string]json.RawMessage
+			marshalled, err := json.Marshal(e)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := json.Unmarshal(marshalled, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			for _, field := range schema.Required {
+				if _, ok := envelope[field]; !ok {
+					t.Errorf("event has no %s", field)
+				}
+			}
+			if _, ok := envelope["trace"]; ok {
+				t.Error("event without a span context has a trace")
+			}
+
+			ref := ""
+			for _, rule := range schema.AllOf {
+				if rule.If.Properties.Type.Const == tt.eventType {
+					ref = rule.Then.Properties.Data.Ref
+				}
+			}
+			def, ok := schema.Defs[ref[len("#/$defs/"):]]
+			if !ok {
+				t.Fatalf("the schema has no data of %s", tt.eventType)
+			}
+
+			var data map[string]interface{}
+			if err := json.Unmarshal(envelope["data"], &data); err != nil {
+				t.Fatalf("data is not an object: %s", envelope["data"])
+			}
+			for _, field := range def.Required {
+				if _, ok := data[field]; !ok {
+					t.Errorf("data has no %s", field)
+				}
+			}
+			for field, value := range data {
+				property, ok := def.Properties[field]
+				if !ok {
+					t.Errorf("data has %s, which the schema has not", field)
+					continue
+				}
+				if len(property.Enum) > 0 && !contains(property.Enum, value) {
+					t.Errorf("data %s = %v, want one of %v", field, value, property.Enum)
+				}
+			}
+		})
+	}
+}
+
+func contains(values []string, value interface{}) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMemoryPublisher(t *testing.T) {
+	ctx := context.Background()
+	p := &MemoryPublisher{}
+
+	for _, eventType := range []Type{TypeUserCreated, TypeTokenIssued, TypeUserLogin, TypeTokenIssued} {
+		e, err := New(eventType, "user", struct{}{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := p.Publish(ctx, e); err != nil {
+			t.Fatalf("Publish() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		types []Type
+		want  []Type
+	}{
+		{"all", nil, []Type{TypeUserCreated, TypeTokenIssued, TypeUserLogin, TypeTokenIssued}},
+		{"one type", []Type{TypeTokenIssued}, []Type{TypeTokenIssued, TypeTokenIssued}},
+		{"several types", []Type{TypeUserLogin, TypeUserCreated}, []Type{TypeUserCreated, TypeUserLogin}},
+		{"none published", []Type{TypeTokenRevoked}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []Type
+			for _, e := range p.Events(tt.types...) {
+				got = append(got, e.Type)
+			}
+			if !equalTypes(got, tt.want) {
+				t.Errorf("Events(%v) = %v, want %v", tt.types, got, tt.want)
+			}
+		})
+	}
+
+	p.Err = errors.New("nats: connection closed")
+	if err := p.Publish(ctx, Event{Type: TypeTokenRevoked}); !errors.Is(err, p.Err) {
+		t.Errorf("Publish() error = %v, want %v", err, p.Err)
+	}
+	if events := p.Events(TypeTokenRevoked); len(events) != 0 {
+		t.Errorf("failed Publish() kept %d events", len(events))
+	}
+
+	p.Reset()
+	if events := p.Events(); len(events) != 0 {
+		t.Errorf("Events() after Reset() = %d events", len(events))
+	}
+}
+
+func equalTypes(a, b []Type) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,66 @@
+package event
+
+import (
+	"context"
+	"sync"
+)
+
+// Publisher is an interface for publishing events.
+type Publisher interface {
+	Publish(ctx context.Context, e Event) error
+}
+
+// MemoryPublisher is a Publisher keeping the events in memory, for tests
+// and for running without NATS.
+type MemoryPublisher struct {
+	mu     sync.Mutex
+	events []Event
+	// Err, if set, is returned by Publish instead of keeping the event.
+	Err error
+}
+
+// Publish keeps the event.
+func (p *MemoryPublisher) Publish(ctx context.Context, e Event) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.Err != nil {
+		return p.Err
+	}
+
+	p.events = append(p.events, e)
+	return nil
+}
+
+// Events returns the events published so far, optionally only those of the
+// types.
+func (p *MemoryPublisher) Events(types ...Type) []Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var events []Event
+	for _, e := range p.events {
+		if len(types) == 0 || hasType(types, e.Type) {
+			events = append(events, e)
+		}
+	}
+
+	return events
+}
+
+// Reset forgets the events published so far.
+func (p *MemoryPublisher) Reset() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.events = nil
+}
+
+func hasType(types []Type, t Type) bool {
+	for _, tt := range types {
+		if tt == t {
+			return true
+		}
+	}
+	return false
+}
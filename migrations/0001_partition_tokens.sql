@@ -0,0 +1,46 @@
+-- Converts the tokens table into a table partitioned by created_at month.
+--
+-- Run it once, during a maintenance window, before starting auth-api with
+-- TOKENS_PARTITIONED=true and TOKEN_MAX_LIFETIME set. auth-api creates the
+-- upcoming monthly partitions itself and drops the ones older than
+-- TOKEN_MAX_LIFETIME.
+
+BEGIN;
+
+ALTER TABLE tokens RENAME TO tokens_unpartitioned;
+
+CREATE TABLE tokens (
+    LIKE tokens_unpartitioned INCLUDING DEFAULTS,
+    PRIMARY KEY (token, created_at)
+) PARTITION BY RANGE (created_at);
+
+-- Postgres can not enforce a unique index on a partitioned table without
+-- the partition key, so token alone is not unique here. Tokens are random
+-- v4 UUIDs generated by auth-api, which never collide in practice, and the
+-- insert checks this index for an existing token anyway.
+CREATE INDEX tokens_token_idx ON tokens (token);
+CREATE INDEX tokens_user_id_idx ON tokens (user_id);
+
+-- Monthly partitions covering the existing tokens and the next two months,
+-- named the way auth-api expects them (tokens_pYYYY_MM).
+DO $$
+DECLARE
+    month timestamp := date_trunc('month', coalesce((SELECT min(created_at) FROM tokens_unpartitioned), now()) AT TIME ZONE 'UTC');
+    last timestamp := date_trunc('month', now() AT TIME ZONE 'UTC') + interval '2 months';
+BEGIN
+    WHILE month <= last LOOP
+        EXECUTE format(
+            'CREATE TABLE IF NOT EXISTS %I PARTITION OF tokens FOR VALUES FROM (%L) TO (%L)',
+            'tokens_p' || to_char(month, 'YYYY_MM'),
+            month AT TIME ZONE 'UTC',
+            (month + interval '1 month') AT TIME ZONE 'UTC'
+        );
+        month := month + interval '1 month';
+    END LOOP;
+END $$;
+
+INSERT INTO tokens SELECT * FROM tokens_unpartitioned;
+
+DROP TABLE tokens_unpartitioned;
+
+COMMIT;
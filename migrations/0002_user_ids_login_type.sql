@@ -0,0 +1,22 @@
+-- Records the type of each login and when it was verified.
+--
+-- Logins that already exist got a token, so they are considered verified.
+
+BEGIN;
+
+ALTER TABLE user_ids
+    ADD COLUMN login_type text,
+    ADD COLUMN verified_at timestamptz;
+
+UPDATE user_ids SET
+    verified_at = now(),
+    login_type = CASE
+        WHEN lower(auth_method) IN ('apple', 'facebook', 'google', 'oauth', 'oidc', 'telegram') THEN 'external'
+        WHEN login ~ '^\+?[0-9 ()-]+$' AND length(regexp_replace(login, '[^0-9]', '', 'g')) BETWEEN 7 AND 15 THEN 'phone'
+        WHEN login ~ '^[^@\s]+@[^@\s]+\.[^@\s]+$' THEN 'email'
+        ELSE 'username'
+    END;
+
+CREATE INDEX IF NOT EXISTS user_ids_user_id_idx ON user_ids (user_id);
+
+COMMIT;
@@ -0,0 +1,45 @@
+-- Login changes: a user replacing a login (e.g. a new phone number) with a
+-- new one while keeping their user id.
+
+BEGIN;
+
+-- Logins that were replaced. A login can not be used by anyone else until
+-- released_at.
+CREATE TABLE login_history (
+    id bigserial PRIMARY KEY,
+    user_id text NOT NULL,
+    login text NOT NULL,
+    auth_user_type text NOT NULL,
+    auth_method text,
+    login_type text,
+    replaced_by text NOT NULL,
+    replaced_at timestamptz NOT NULL DEFAULT now(),
+    released_at timestamptz NOT NULL
+);
+
+CREATE INDEX login_history_login_idx ON login_history (login, auth_user_type, released_at);
+CREATE INDEX login_history_user_id_idx ON login_history (user_id);
+
+-- Login changes verified only by the new login, applied at apply_at unless
+-- cancelled before.
+CREATE TABLE login_changes (
+    id bigserial PRIMARY KEY,
+    user_id text NOT NULL,
+    old_login text NOT NULL,
+    new_login text NOT NULL,
+    auth_user_type text NOT NULL,
+    auth_method text,
+    login_type text,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    apply_at timestamptz NOT NULL,
+    applied_at timestamptz,
+    cancelled_at timestamptz
+);
+
+-- A new login can only be reserved by one pending change.
+CREATE UNIQUE INDEX login_changes_pending_new_login_idx ON login_changes (new_login, auth_user_type)
+    WHERE applied_at IS NULL AND cancelled_at IS NULL;
+CREATE INDEX login_changes_pending_apply_at_idx ON login_changes (apply_at)
+    WHERE applied_at IS NULL AND cancelled_at IS NULL;
+
+COMMIT;
@@ -0,0 +1,16 @@
+-- Services allowed to call the internal routes of auth-api. Register them
+-- with `go run ./cmd/apikey`.
+
+BEGIN;
+
+CREATE TABLE service_clients (
+    name text PRIMARY KEY,
+    -- SHA-256 of the API key, hex encoded.
+    api_key_hash text NOT NULL UNIQUE,
+    -- Names of the routes the service may call, '*' for all.
+    routes text[] NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL DEFAULT now(),
+    disabled_at timestamptz
+);
+
+COMMIT;
@@ -0,0 +1,17 @@
+-- Users merged into another user because they were created by the same
+-- login typed in different ways. Written by `go run ./cmd/mergelogins`.
+
+BEGIN;
+
+CREATE TABLE user_merges (
+    merged_user_id text PRIMARY KEY,
+    into_user_id text NOT NULL,
+    -- The normalized login the users shared, as stored in user_ids.login.
+    login text NOT NULL,
+    auth_user_type text NOT NULL,
+    merged_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX user_merges_into_user_id_idx ON user_merges (into_user_id);
+
+COMMIT;
@@ -0,0 +1,30 @@
+-- Envelope encryption of logins. Data keys, wrapped by the master key, live
+-- in encryption_keys. With encryption enabled user_ids.login holds the blind
+-- index of the login and login_ciphertext the login encrypted with the data
+-- key encryption_key_id. Existing rows are encrypted by
+-- `go run ./cmd/rotatekeys`.
+
+BEGIN;
+
+CREATE TABLE encryption_keys (
+    id serial PRIMARY KEY,
+    purpose text NOT NULL CHECK (purpose IN ('data', 'blind_index')),
+    wrapped_key bytea NOT NULL,
+    master_key_id text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    retired_at timestamptz
+);
+
+CREATE UNIQUE INDEX encryption_keys_active_idx ON encryption_keys (purpose) WHERE retired_at IS NULL;
+
+ALTER TABLE user_ids
+    ADD COLUMN login_ciphertext bytea,
+    ADD COLUMN encryption_key_id integer REFERENCES encryption_keys (id);
+
+CREATE INDEX user_ids_encryption_key_id_idx ON user_ids (encryption_key_id);
+
+ALTER TABLE login_changes
+    ADD COLUMN new_login_ciphertext bytea,
+    ADD COLUMN encryption_key_id integer REFERENCES encryption_keys (id);
+
+COMMIT;
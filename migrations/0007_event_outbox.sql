@@ -0,0 +1,24 @@
+-- Events written in the same transaction as the change they are about and
+-- published to NATS by the outbox relay of auth-api.
+
+BEGIN;
+
+CREATE TABLE event_outbox (
+    id bigserial PRIMARY KEY,
+    event_id uuid NOT NULL UNIQUE,
+    user_id text NOT NULL,
+    type text NOT NULL,
+    -- The event envelope, see event/schema/v1.json.
+    payload jsonb NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    last_error text,
+    published_at timestamptz
+);
+
+CREATE INDEX event_outbox_pending_idx ON event_outbox (id) WHERE published_at IS NULL;
+CREATE INDEX event_outbox_user_id_pending_idx ON event_outbox (user_id, id) WHERE published_at IS NULL;
+CREATE INDEX event_outbox_published_at_idx ON event_outbox (published_at) WHERE published_at IS NOT NULL;
+
+COMMIT;
@@ -0,0 +1,12 @@
+-- Users blocked by a block_user command on NATS. Blocked users have no
+-- tokens and can not get new ones until they are unblocked.
+
+BEGIN;
+
+CREATE TABLE blocked_users (
+    user_id text PRIMARY KEY,
+    reason text NOT NULL DEFAULT '',
+    blocked_at timestamptz NOT NULL DEFAULT now()
+);
+
+COMMIT;
@@ -0,0 +1,52 @@
+-- Webhook subscriptions of partners, and the queue and log of the events
+-- delivered to them. Deliveries are queued with the event in the outbox
+-- transaction and sent by the webhook dispatcher of auth-api.
+
+BEGIN;
+
+CREATE TABLE webhook_subscriptions (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL,
+    url text NOT NULL,
+    -- Key of the HMAC signatures of the deliveries.
+    secret text NOT NULL,
+    event_types text[] NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    -- Failed attempts since the last successful one. The subscription is
+    -- disabled when they reach WEBHOOK_DISABLE_AFTER.
+    consecutive_failures int NOT NULL DEFAULT 0,
+    disabled_at timestamptz,
+    disabled_reason text
+);
+
+CREATE TABLE webhook_deliveries (
+    id bigserial PRIMARY KEY,
+    subscription_id bigint NOT NULL REFERENCES webhook_subscriptions (id) ON DELETE CASCADE,
+    event_id uuid NOT NULL,
+    event_type text NOT NULL,
+    payload jsonb NOT NULL,
+    -- pending, delivered, failed (out of attempts) or cancelled (the
+    -- subscription was disabled).
+    status text NOT NULL DEFAULT 'pending',
+    attempts int NOT NULL DEFAULT 0,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    created_at timestamptz NOT NULL DEFAULT now(),
+    delivered_at timestamptz,
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+
+CREATE TABLE webhook_delivery_attempts (
+    id bigserial PRIMARY KEY,
+    delivery_id bigint NOT NULL REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    attempted_at timestamptz NOT NULL DEFAULT now(),
+    -- 0 when no response was received.
+    status_code int NOT NULL,
+    error text,
+    duration_ms int NOT NULL
+);
+
+CREATE INDEX webhook_delivery_attempts_delivery_id_idx ON webhook_delivery_attempts (delivery_id);
+
+COMMIT;
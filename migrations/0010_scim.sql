@@ -0,0 +1,35 @@
+-- SCIM 2.0 provisioning. Each tenant is a customer directory provisioning
+-- the users of one auth user type, authenticated by a bearer token created
+-- with `go run ./cmd/scimtenant`. Provisioned users get their userName as a
+-- login in user_ids with the auth method 'scim', and a profile here.
+
+BEGIN;
+
+CREATE TABLE scim_tenants (
+    id bigserial PRIMARY KEY,
+    name text NOT NULL UNIQUE,
+    -- SHA-256 of the bearer token, hex encoded.
+    token_hash text NOT NULL UNIQUE,
+    auth_user_type text NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    disabled_at timestamptz
+);
+
+CREATE TABLE user_profiles (
+    user_id text PRIMARY KEY,
+    tenant_id bigint NOT NULL REFERENCES scim_tenants (id),
+    -- The id of the user in the tenant's directory.
+    external_id text,
+    given_name text NOT NULL DEFAULT '',
+    family_name text NOT NULL DEFAULT '',
+    display_name text NOT NULL DEFAULT '',
+    active boolean NOT NULL DEFAULT true,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE UNIQUE INDEX user_profiles_tenant_external_id_idx ON user_profiles (tenant_id, external_id)
+    WHERE external_id IS NOT NULL;
+CREATE INDEX user_profiles_tenant_created_at_idx ON user_profiles (tenant_id, created_at, user_id);
+
+COMMIT;
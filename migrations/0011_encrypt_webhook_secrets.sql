@@ -0,0 +1,15 @@
+-- Envelope encryption of webhook secrets, like the logins of
+-- 0006_encrypt_logins.sql. With encryption enabled secret is NULL and
+-- secret_ciphertext holds the secret encrypted with the data key
+-- encryption_key_id. Existing secrets are encrypted by
+-- `go run ./cmd/rotatekeys`.
+
+BEGIN;
+
+ALTER TABLE webhook_subscriptions
+    ALTER COLUMN secret DROP NOT NULL,
+    ADD COLUMN secret_ciphertext bytea,
+    ADD COLUMN encryption_key_id integer REFERENCES encryption_keys (id),
+    ADD CONSTRAINT webhook_subscriptions_secret_check CHECK (secret IS NOT NULL OR secret_ciphertext IS NOT NULL);
+
+COMMIT;
@@ -0,0 +1,11 @@
+-- The auth user type each token was issued for, kept across refreshes, so
+-- forward auth tells proxies the type the user logged in as rather than
+-- every type linked to the user. Tokens issued before this migration have
+-- no type; their identity falls back to the user's type when the user has
+-- logins of a single type.
+
+BEGIN;
+
+ALTER TABLE tokens ADD COLUMN auth_user_type text;
+
+COMMIT;
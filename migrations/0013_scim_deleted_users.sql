@@ -0,0 +1,10 @@
+-- Users deleted by their SCIM tenant. Their profile is kept, marked deleted,
+-- so they are no longer found by the tenant while their login, and with it
+-- their userName and externalId, stays taken: a deleted user can not log in
+-- again as a new user.
+
+BEGIN;
+
+ALTER TABLE user_profiles ADD COLUMN deleted_at timestamptz;
+
+COMMIT;
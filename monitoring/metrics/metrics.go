@@ -14,19 +14,59 @@ var (
 		Name: "http_request_status_code",
 		Help: "Status codes returned by the API",
 	},
-		[]string{"status_code", "operation_name"},
+		[]string{"status_code", "operation_name", "caller"},
 	)
 	timeToProcessRequest = prometheus.NewHistogram(prometheus.HistogramOpts{
 		Name:    "http_request_duration",
 		Help:    "Time spent processing requests",
 		Buckets: []float64{.005, .01, .025, .05, .075, .1, .25, .5, .75, 1.0, 2.5, 5.0, 7.5, 10.0, math.Inf(1)},
 	})
+	grpcRequestsReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "grpc_request_status_code",
+		Help: "Status codes returned by the gRPC API",
+	},
+		[]string{"code", "method", "caller"},
+	)
+	timeToProcessGRPCRequest = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "grpc_request_duration",
+		Help:    "Time spent processing gRPC requests",
+		Buckets: []float64{.005, .01, .025, .05, .075, .1, .25, .5, .75, 1.0, 2.5, 5.0, 7.5, 10.0, math.Inf(1)},
+	},
+		[]string{"method"},
+	)
+	outboxEventsPublished = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_events_published_total",
+		Help: "Events published from the outbox, redelivered ones included",
+	},
+		[]string{"type", "redelivery"},
+	)
+	outboxPublishFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_publish_failures_total",
+		Help: "Failed attempts to publish events from the outbox",
+	},
+		[]string{"type"},
+	)
+	outboxPublishDelay = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "outbox_publish_delay_seconds",
+		Help:    "Time from writing an event to the outbox to publishing it",
+		Buckets: []float64{.01, .05, .1, .25, .5, 1.0, 2.5, 5.0, 10.0, 30.0, 60.0, 300.0, math.Inf(1)},
+	})
+	outboxPending = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "outbox_events_pending",
+		Help: "Events in the outbox waiting to be published",
+	})
 )
 
 // RegisterPrometheusCollectors tells prometheus to set up collectors.
 func RegisterPrometheusCollectors() {
 	prometheus.MustRegister(requestsReceived)
 	prometheus.MustRegister(timeToProcessRequest)
+	prometheus.MustRegister(grpcRequestsReceived)
+	prometheus.MustRegister(timeToProcessGRPCRequest)
+	prometheus.MustRegister(outboxEventsPublished)
+	prometheus.MustRegister(outboxPublishFailures)
+	prometheus.MustRegister(outboxPublishDelay)
+	prometheus.MustRegister(outboxPending)
 }
 
 // ObserveTimeToProcess records the time spent processing an operation.
@@ -34,7 +74,33 @@ func ObserveTimeToProcess(operation string, t float64) {
 	timeToProcessRequest.Observe(t)
 }
 
-// ReceivedRequest records the status code returned for each request.
-func ReceivedRequest(statusCode int, operationName string) {
-	requestsReceived.WithLabelValues(strconv.Itoa(statusCode), operationName).Inc()
+// ReceivedRequest records the status code returned for each request and the
+// service that made it.
+func ReceivedRequest(statusCode int, operationName, caller string) {
+	requestsReceived.WithLabelValues(strconv.Itoa(statusCode), operationName, caller).Inc()
+}
+
+// ReceivedGRPCRequest records the status code returned for a gRPC request,
+// the time spent processing it and the service that made it.
+func ReceivedGRPCRequest(code, method, caller string, t float64) {
+	grpcRequestsReceived.WithLabelValues(code, method, caller).Inc()
+	timeToProcessGRPCRequest.WithLabelValues(method).Observe(t)
+}
+
+// PublishedEvent records an event published from the outbox, attempts being
+// the failed attempts before, and how long after it was written.
+func PublishedEvent(eventType string, attempts int, delay float64) {
+	outboxEventsPublished.WithLabelValues(eventType, strconv.FormatBool(attempts > 0)).Inc()
+	outboxPublishDelay.Observe(delay)
+}
+
+// FailedToPublishEvent records a failed attempt to publish an event from the
+// outbox.
+func FailedToPublishEvent(eventType string) {
+	outboxPublishFailures.WithLabelValues(eventType).Inc()
+}
+
+// PendingEvents records the number of events waiting in the outbox.
+func PendingEvents(n int) {
+	outboxPending.Set(float64(n))
 }
@@ -0,0 +1,4 @@
+// Package authv1 is the generated code of the gRPC API in auth.proto.
+package authv1
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative auth.proto
@@ -0,0 +1,73 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+)
+
+// Error is a SCIM error, sent with the error schema of RFC 7644 section
+// 3.12.
+type Error struct {
+	Status int
+	// Type is the scimType of the error, if it has one.
+	Type   string
+	Detail string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Detail + ": " + e.Err.Error()
+	}
+	return e.Detail
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Wrap returns a copy of the error wrapping err as internal detail.
+func (e *Error) Wrap(err error) *Error {
+	return &Error{
+		Status: e.Status,
+		Type:   e.Type,
+		Detail: e.Detail,
+		Err:    err,
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Schemas []string `json:"schemas"`
+		Status  string   `json:"status"`
+		Type    string   `json:"scimType,omitempty"`
+		Detail  string   `json:"detail,omitempty"`
+	}{
+		Schemas: []string{SchemaError},
+		Status:  strconv.Itoa(e.Status),
+		Type:    e.Type,
+		Detail:  e.Detail,
+	})
+}
+
+var (
+	ErrNotFound     = &Error{Status: http.StatusNotFound, Detail: "user not found"}
+	ErrUniqueness   = &Error{Status: http.StatusConflict, Type: "uniqueness", Detail: "userName or externalId is taken"}
+	ErrUnauthorized = &Error{Status: http.StatusUnauthorized, Detail: "missing or invalid bearer token"}
+	ErrInternal     = &Error{Status: http.StatusInternalServerError, Detail: http.StatusText(http.StatusInternalServerError)}
+)
+
+// InvalidSyntax returns the error of a request body that can not be parsed.
+func InvalidSyntax(detail string) *Error {
+	return &Error{Status: http.StatusBadRequest, Type: "invalidSyntax", Detail: detail}
+}
+
+func invalidValue(detail string) *Error {
+	return &Error{Status: http.StatusBadRequest, Type: "invalidValue", Detail: detail}
+}
+
+func invalidFilter(detail string) *Error {
+	return &Error{Status: http.StatusBadRequest, Type: "invalidFilter", Detail: detail}
+}
@@ -0,0 +1,119 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Filter is a filter of the users listed. Nil fields match any user.
+type Filter struct {
+	UserName   *string
+	ExternalID *string
+	Active     *bool
+}
+
+// ParseFilter parses the filters directories send before provisioning a
+// user: eq comparisons of userName, externalId or active joined by and, like
+// `userName eq "jane@example.com" and active eq true`. Other filters are
+// rejected with invalidFilter.
+func ParseFilter(filter string) (Filter, error) {
+	var f Filter
+
+	rest := strings.TrimSpace(filter)
+	if rest == "" {
+		return f, nil
+	}
+
+	for {
+		var attr, op string
+		attr, rest = nextWord(rest)
+		op, rest = nextWord(rest)
+		if !strings.EqualFold(op, "eq") {
+			return f, invalidFilter(fmt.Sprintf("operator %q is not supported, only eq", op))
+		}
+
+		value, remaining, err := nextValue(rest)
+		if err != nil {
+			return f, err
+		}
+		rest = remaining
+
+		switch attributeName(attr) {
+		case "username":
+			s, ok := value.(string)
+			if !ok {
+				return f, invalidFilter("userName must be compared with a string")
+			}
+			f.UserName = &s
+		case "externalid":
+			s, ok := value.(string)
+			if !ok {
+				return f, invalidFilter("externalId must be compared with a string")
+			}
+			f.ExternalID = &s
+		case "active":
+			b, ok := value.(bool)
+			if !ok {
+				return f, invalidFilter("active must be compared with true or false")
+			}
+			f.Active = &b
+		default:
+			return f, invalidFilter(fmt.Sprintf("attribute %q can not be filtered", attr))
+		}
+
+		if rest = strings.TrimSpace(rest); rest == "" {
+			return f, nil
+		}
+
+		var and string
+		and, rest = nextWord(rest)
+		if !strings.EqualFold(and, "and") {
+			return f, invalidFilter(fmt.Sprintf("%q is not supported, only and", and))
+		}
+	}
+}
+
+// attributeName returns the lowercase name of an attribute of the user
+// schema, without the schema URN it may be prefixed with.
+func attributeName(path string) string {
+	name := strings.ToLower(strings.TrimSpace(path))
+	return strings.TrimPrefix(name, strings.ToLower(SchemaUser)+":")
+}
+
+func nextWord(s string) (word, rest string) {
+	s = strings.TrimLeft(s, " ")
+	word, rest, _ = strings.Cut(s, " ")
+	return word, rest
+}
+
+// nextValue parses the JSON string or boolean at the start of s.
+func nextValue(s string) (interface{}, string, error) {
+	s = strings.TrimLeft(s, " ")
+
+	if !strings.HasPrefix(s, `"`) {
+		word, rest := nextWord(s)
+		switch strings.ToLower(word) {
+		case "true":
+			return true, rest, nil
+		case "false":
+			return false, rest, nil
+		}
+		return nil, "", invalidFilter(fmt.Sprintf("value %q is not a string or boolean", word))
+	}
+
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			var value string
+			if err := json.Unmarshal([]byte(s[:i+1]), &value); err != nil {
+				return nil, "", invalidFilter("malformed string " + s[:i+1])
+			}
+			return value, s[i+1:], nil
+		}
+	}
+
+	return nil, "", invalidFilter("unterminated string")
+}
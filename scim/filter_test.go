@@ -0,0 +1,56 @@
+package scim
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParseFilter(t *testing.T) {
+	userName := "jane@acme.kz"
+	quoted := `jane "j" doe`
+	externalID := "00u1"
+	active := true
+
+	tests := []struct {
+		filter   string
+		want     Filter
+		wantType string
+	}{
+		{filter: "", want: Filter{}},
+		{filter: `userName eq "jane@acme.kz"`, want: Filter{UserName: &userName}},
+		{filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName EQ "jane@acme.kz"`, want: Filter{UserName: &userName}},
+		{filter: `userName eq "jane \"j\" doe"`, want: Filter{UserName: &quoted}},
+		{
+			filter: `externalId eq "00u1" and active eq True`,
+			want:   Filter{ExternalID: &externalID, Active: &active},
+		},
+		{filter: `userName co "jane"`, wantType: "invalidFilter"},
+		{filter: `userName eq "jane@acme.kz" or active eq true`, wantType: "invalidFilter"},
+		{filter: `emails eq "jane@acme.kz"`, wantType: "invalidFilter"},
+		{filter: `userName eq true`, wantType: "invalidFilter"},
+		{filter: `active eq "true"`, wantType: "invalidFilter"},
+		{filter: `userName eq jane`, wantType: "invalidFilter"},
+		{filter: `userName eq "jane`, wantType: "invalidFilter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			got, err := ParseFilter(tt.filter)
+			if tt.wantType != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.Type != tt.wantType {
+					t.Errorf("ParseFilter() error = %v, want %s", err, tt.wantType)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilter() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,157 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// PatchRequest is the body of a PATCH request.
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// PatchOperation is an operation of a PATCH request.
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// Apply applies the operations to u, in order. add and replace set the
+// attribute at the path, or the attributes of the value without a path;
+// remove clears the attribute at the path. Attributes the profile does not
+// keep are ignored, so directories may send all the attributes they map.
+func (p *PatchRequest) Apply(u *User) error {
+	if !hasSchema(p.Schemas, SchemaPatchOp) {
+		return InvalidSyntax("schemas must list " + SchemaPatchOp)
+	}
+	if len(p.Operations) == 0 {
+		return invalidValue("Operations is required")
+	}
+
+	for _, op := range p.Operations {
+		switch strings.ToLower(op.Op) {
+		case "add", "replace":
+			if op.Path == "" {
+				var values map[string]json.RawMessage
+				if err := json.Unmarshal(op.Value, &values); err != nil {
+					return invalidValue("value must be an object when there is no path")
+				}
+				for path, value := range values {
+					if err := setAttribute(u, path, value); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := setAttribute(u, op.Path, op.Value); err != nil {
+				return err
+			}
+		case "remove":
+			if err := removeAttribute(u, op.Path); err != nil {
+				return err
+			}
+		default:
+			return invalidValue(fmt.Sprintf("op %q is not add, replace or remove", op.Op))
+		}
+	}
+
+	return nil
+}
+
+func setAttribute(u *User, path string, value json.RawMessage) error {
+	var err error
+
+	switch attributeName(path) {
+	case "username":
+		var userName string
+		if userName, err = stringValue(path, value); err == nil {
+			if userName = strings.TrimSpace(userName); userName == "" {
+				return invalidValue("userName is required")
+			}
+			u.UserName = userName
+		}
+	case "externalid":
+		var externalID string
+		externalID, err = stringValue(path, value)
+		u.ExternalID = strings.TrimSpace(externalID)
+	case "displayname":
+		u.DisplayName, err = stringValue(path, value)
+	case "name.givenname":
+		u.GivenName, err = stringValue(path, value)
+	case "name.familyname":
+		u.FamilyName, err = stringValue(path, value)
+	case "name":
+		var name Name
+		if err := json.Unmarshal(value, &name); err != nil {
+			return invalidValue("name must be an object")
+		}
+		u.GivenName, u.FamilyName = name.GivenName, name.FamilyName
+	case "active":
+		u.Active, err = boolValue(value)
+	}
+
+	return err
+}
+
+func removeAttribute(u *User, path string) error {
+	switch attributeName(path) {
+	case "":
+		return &Error{Status: http.StatusBadRequest, Type: "noTarget", Detail: "remove needs a path"}
+	case "username", "active":
+		return &Error{Status: http.StatusBadRequest, Type: "mutability", Detail: path + " can not be removed"}
+	case "externalid":
+		u.ExternalID = ""
+	case "displayname":
+		u.DisplayName = ""
+	case "name.givenname":
+		u.GivenName = ""
+	case "name.familyname":
+		u.FamilyName = ""
+	case "name":
+		u.GivenName, u.FamilyName = "", ""
+	}
+
+	return nil
+}
+
+func stringValue(path string, value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", invalidValue(path + " must be a string")
+	}
+	return s, nil
+}
+
+// boolValue parses a boolean, also sent as "True" or "False" by some
+// directories.
+func boolValue(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err == nil {
+		switch strings.ToLower(s) {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		}
+	}
+
+	return false, invalidValue("active must be a boolean")
+}
+
+func hasSchema(schemas []string, schema string) bool {
+	for _, s := range schemas {
+		if s == schema {
+			return true
+		}
+	}
+	return false
+}
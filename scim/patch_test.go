@@ -0,0 +1,107 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func TestPatchRequestApply(t *testing.T) {
+	jane := User{
+		UserName:    "jane@acme.kz",
+		ExternalID:  "00u1",
+		GivenName:   "Jane",
+		FamilyName:  "Doe",
+		DisplayName: "Jane Doe",
+		Active:      true,
+	}
+
+	tests := []struct {
+		name     string
+		body     string
+		want     User
+		wantType string
+	}{
+		{
+			name: "replace with a path",
+			body: `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "replace", "path": "name.givenName", "value": "Janet"}]}`,
+			want: User{UserName: "jane@acme.kz", ExternalID: "00u1", GivenName: "Janet", FamilyName: "Doe", DisplayName: "Jane Doe", Active: true},
+		},
+		{
+			name: "replace without a path",
+			body: `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "Replace", "value": {"active": "False", "userName": " janet@acme.kz ", "title": "CEO"}}]}`,
+			want: User{UserName: "janet@acme.kz", ExternalID: "00u1", GivenName: "Jane", FamilyName: "Doe", DisplayName: "Jane Doe"},
+		},
+		{
+			name: "add a name",
+			body: `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "add", "path": "name", "value": {"givenName": "J", "familyName": "D"}}]}`,
+			want: User{UserName: "jane@acme.kz", ExternalID: "00u1", GivenName: "J", FamilyName: "D", DisplayName: "Jane Doe", Active: true},
+		},
+		{
+			name: "remove",
+			body: `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "remove", "path": "externalId"}, {"op": "remove", "path": "name"}]}`,
+			want: User{UserName: "jane@acme.kz", DisplayName: "Jane Doe", Active: true},
+		},
+		{
+			name:     "missing schema",
+			body:     `{"Operations": [{"op": "replace", "path": "displayName", "value": "J"}]}`,
+			wantType: "invalidSyntax",
+		},
+		{
+			name:     "no operations",
+			body:     `{"schemas": ["` + SchemaPatchOp + `"]}`,
+			wantType: "invalidValue",
+		},
+		{
+			name:     "unknown op",
+			body:     `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "move", "path": "displayName"}]}`,
+			wantType: "invalidValue",
+		},
+		{
+			name:     "empty userName",
+			body:     `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "replace", "path": "userName", "value": " "}]}`,
+			wantType: "invalidValue",
+		},
+		{
+			name:     "invalid active",
+			body:     `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "replace", "path": "active", "value": "yes"}]}`,
+			wantType: "invalidValue",
+		},
+		{
+			name:     "remove userName",
+			body:     `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "remove", "path": "userName"}]}`,
+			wantType: "mutability",
+		},
+		{
+			name:     "remove without a path",
+			body:     `{"schemas": ["` + SchemaPatchOp + `"], "Operations": [{"op": "remove"}]}`,
+			wantType: "noTarget",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p PatchRequest
+			if err := json.Unmarshal([]byte(tt.body), &p); err != nil {
+				t.Fatal(err)
+			}
+
+			u := jane
+			err := p.Apply(&u)
+			if tt.wantType != "" {
+				var scimErr *Error
+				if !errors.As(err, &scimErr) || scimErr.Type != tt.wantType {
+					t.Errorf("Apply() error = %v, want %s", err, tt.wantType)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Apply() error = %v", err)
+			}
+			if u != tt.want {
+				t.Errorf("Apply() = %+v, want %+v", u, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,205 @@
+// Package scim provisions the users of enterprise tenants from their
+// directories with SCIM 2.0 (RFC 7643, RFC 7644).
+//
+// A tenant provisions users of one auth user type. The userName of a user is
+// their login of that type, stored in user_ids with AuthMethod, so the
+// user gets the same user id when they later log in with it. The rest of
+// the user is kept in their profile. Deactivated users are blocked with
+// BlockReason: their tokens are revoked and new ones are refused until they
+// are activated again. Deleted users are blocked the same way and are no
+// longer found.
+package scim
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Schemas of the resources and messages.
+const (
+	SchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// ContentType is the content type of SCIM requests and responses.
+const ContentType = "application/scim+json"
+
+// AuthMethod is the auth method of the logins of provisioned users.
+const AuthMethod = "scim"
+
+// BlockReason is the reason deactivated users are blocked for. Activating a
+// user only unblocks users blocked for it.
+const BlockReason = "scim_deactivated"
+
+const (
+	// DefaultCount is the number of users listed when the request does not
+	// say, and MaxCount the most listed at once.
+	DefaultCount = 100
+	MaxCount     = 1000
+)
+
+// Tenant is a directory provisioning users of an auth user type.
+type Tenant struct {
+	ID           int64
+	Name         string
+	AuthUserType string
+}
+
+// User is a provisioned user.
+type User struct {
+	// ID is the user id.
+	ID           string
+	ExternalID   string
+	UserName     string
+	GivenName    string
+	FamilyName   string
+	DisplayName  string
+	Active       bool
+	Created      time.Time
+	LastModified time.Time
+}
+
+// Store is an interface for the provisioned users of tenants. User ids of
+// users provisioned by another tenant are not found.
+type Store interface {
+	// CreateSCIMUser provisions a new user. Returns ErrUniqueness if the
+	// userName or the externalId is taken.
+	CreateSCIMUser(ctx context.Context, tenant *Tenant, u User) (*User, error)
+	// SCIMUser returns ErrNotFound for unknown users.
+	SCIMUser(ctx context.Context, tenant *Tenant, id string) (*User, error)
+	// SCIMUsers returns a page of the users matching filter, oldest first,
+	// and how many match in all. startIndex is 1-based.
+	SCIMUsers(ctx context.Context, tenant *Tenant, filter Filter, startIndex, count int) ([]User, int, error)
+	// UpdateSCIMUser applies update to the user, read in the same
+	// transaction, blocking or unblocking them as they end up active.
+	// Returns ErrNotFound for unknown users, the error of update if it
+	// fails and ErrUniqueness if the new userName or externalId is taken.
+	UpdateSCIMUser(ctx context.Context, tenant *Tenant, id string, update func(u *User) error) (*User, error)
+	// DeleteSCIMUser deletes the user: they are blocked like deactivated
+	// users and no longer found. Returns ErrNotFound for unknown users.
+	DeleteSCIMUser(ctx context.Context, tenant *Tenant, id string) error
+}
+
+// TenantFetcher is an interface for getting an enabled tenant by the hash of
+// its token. Returns nil if there is no such tenant.
+type TenantFetcher interface {
+	GetSCIMTenantByTokenHash(ctx context.Context, tokenHash string) (*Tenant, error)
+}
+
+// TenantCreator is an interface for registering a tenant.
+type TenantCreator interface {
+	// CreateSCIMTenant registers a tenant, or replaces the token and auth
+	// user type of the tenant with the same name.
+	CreateSCIMTenant(ctx context.Context, t Tenant, tokenHash string) (*Tenant, error)
+}
+
+// HashToken returns the hash of a tenant token, which is what we store.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateToken generates a new random tenant token.
+func GenerateToken() (string, error) {
+	token := make([]byte, 32)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("error generating scim token: %w", err)
+	}
+
+	return "scim_" + base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Name is the name attribute of a user.
+type Name struct {
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+	Formatted  string `json:"formatted,omitempty"`
+}
+
+// Meta is the meta attribute of a resource.
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location"`
+}
+
+// Resource is the representation of a user. Attributes of requests the
+// profile does not keep are ignored.
+type Resource struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// Resource returns the representation of the user, found at location.
+func (u *User) Resource(location string) Resource {
+	active := u.Active
+	r := Resource{
+		Schemas:     []string{SchemaUser},
+		ID:          u.ID,
+		ExternalID:  u.ExternalID,
+		UserName:    u.UserName,
+		DisplayName: u.DisplayName,
+		Active:      &active,
+		Meta: &Meta{
+			ResourceType: "User",
+			Created:      u.Created,
+			LastModified: u.LastModified,
+			Location:     location,
+		},
+	}
+
+	if u.GivenName != "" || u.FamilyName != "" {
+		r.Name = &Name{
+			GivenName:  u.GivenName,
+			FamilyName: u.FamilyName,
+			Formatted:  strings.TrimSpace(u.GivenName + " " + u.FamilyName),
+		}
+	}
+
+	return r
+}
+
+// User returns the user a create request asks for. Users are active unless
+// the request says otherwise.
+func (r *Resource) User() (User, error) {
+	u := User{
+		ExternalID:  strings.TrimSpace(r.ExternalID),
+		UserName:    strings.TrimSpace(r.UserName),
+		DisplayName: r.DisplayName,
+		Active:      r.Active == nil || *r.Active,
+	}
+	if r.Name != nil {
+		u.GivenName = r.Name.GivenName
+		u.FamilyName = r.Name.FamilyName
+	}
+
+	if u.UserName == "" {
+		return u, invalidValue("userName is required")
+	}
+
+	return u, nil
+}
+
+// ListResponse is a page of users.
+type ListResponse struct {
+	Schemas      []string   `json:"schemas"`
+	TotalResults int        `json:"totalResults"`
+	StartIndex   int        `json:"startIndex"`
+	ItemsPerPage int        `json:"itemsPerPage"`
+	Resources    []Resource `json:"Resources"`
+}
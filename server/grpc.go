@@ -0,0 +1,52 @@
+package server
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"gitlab.com/route-kz/auth-api/caller"
+	authv1 "gitlab.com/route-kz/auth-api/proto/auth/v1"
+	"gitlab.com/route-kz/auth-api/server/internal/grpcserver"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+)
+
+// setupGRPC - Sets up the gRPC server with the auth service, which every
+// call of is authorized like the internal HTTP routes and the token calls
+// of are rate limited like the token routes, and the health service, which
+// is open to anyone.
+func (s *Server) setupGRPC() {
+	sa := &middleware.ServiceAuth{
+		DB:           s.DB,
+		Certificates: caller.NewCertificateIdentities(s.Config.TLSClientIdentities),
+		Required:     s.Config.ServiceAuthRequired,
+	}
+
+	opts := []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(
+			grpcserver.TraceMetrics,
+			grpcserver.ServiceAuth(sa),
+			grpcserver.RateLimit(&middleware.RateLimit{Limiter: s.Redis, Config: s.Config}, "CreateToken", "RefreshToken"),
+		),
+	}
+	if s.TLS != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(s.TLS.TLSConfig("h2"))))
+	}
+
+	s.GRPC = grpc.NewServer(opts...)
+
+	authService := &grpcserver.AuthService{
+		DB:            s.DB,
+		AuthUserTypes: s.Config.AuthUserTypes,
+		AuthMethods:   s.Config.AuthMethods,
+	}
+	if s.Config.TokenAuthCodeRequired {
+		authService.AuthCodes = s.Redis
+	}
+	authv1.RegisterAuthServiceServer(s.GRPC, authService)
+
+	s.GRPCHealth = health.NewServer()
+	s.GRPCHealth.SetServingStatus(authv1.AuthService_ServiceDesc.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(s.GRPC, s.GRPCHealth)
+}
@@ -0,0 +1,81 @@
+package grpcserver
+
+import (
+	"errors"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"gitlab.com/route-kz/auth-api/client"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// codeStatuses are the gRPC codes of domain errors, the counterparts of the
+// HTTP statuses of the handler package.
+var codeStatuses = map[user.ErrorCode]codes.Code{
+	user.CodeInvalidCredentials: codes.Unauthenticated,
+	user.CodeInvalidToken:       codes.NotFound,
+	user.CodeTokenExpired:       codes.Unauthenticated,
+	user.CodeUserBlocked:        codes.PermissionDenied,
+	user.CodeValidationFailed:   codes.InvalidArgument,
+	user.CodeLoginTaken:         codes.AlreadyExists,
+	user.CodeLoginAlreadyLinked: codes.AlreadyExists,
+	user.CodeLoginNotFound:      codes.NotFound,
+	user.CodeLastLogin:          codes.FailedPrecondition,
+	user.CodeInvalidCode:        codes.InvalidArgument,
+	user.CodeLoginCoolingDown:   codes.FailedPrecondition,
+	user.CodeUnauthorized:       codes.Unauthenticated,
+	user.CodeForbidden:          codes.PermissionDenied,
+	user.CodeRateLimited:        codes.ResourceExhausted,
+	user.CodeBadRequest:         codes.InvalidArgument,
+	user.CodeNotFound:           codes.NotFound,
+	user.CodeRequestTooLarge:    codes.ResourceExhausted,
+	user.CodeInternal:           codes.Internal,
+}
+
+// toStatus turns an error returned by the service into a gRPC status error.
+// Like the HTTP API, domain errors are sent with their code, in the reason
+// of an ErrorInfo detail, and message; any other error is logged and sent
+// as a generic internal error.
+func toStatus(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+
+	code := user.CodeInternal
+	message := "internal error"
+	var fields map[string]string
+
+	var domainErr *user.Error
+	if errors.As(err, &domainErr) {
+		code = domainErr.Code
+		message = domainErr.Message
+		fields = domainErr.Fields
+	}
+
+	grpcCode, ok := codeStatuses[code]
+	if !ok {
+		grpcCode = codes.Unknown
+	}
+
+	if grpcCode == codes.Internal || grpcCode == codes.Unknown {
+		log.WithField("code", grpcCode.String()).Error(err.Error())
+	}
+
+	st, detailErr := status.New(grpcCode, message).WithDetails(&errdetails.ErrorInfo{
+		Reason:   string(code),
+		Domain:   client.Namespace,
+		Metadata: fields,
+	})
+	if detailErr != nil {
+		return status.Error(grpcCode, message)
+	}
+
+	return st.Err()
+}
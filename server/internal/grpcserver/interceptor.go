@@ -0,0 +1,260 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"gitlab.com/route-kz/auth-api/monitoring/metrics"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+const anonymousCaller = "anonymous"
+
+// healthService is the prefix of the methods of the standard health
+// service, which load balancers and orchestrators call without credentials.
+const healthService = "/grpc.health.v1.Health/"
+
+type metricsCallerKey struct{}
+
+// isHealthCheck tells whether a call is to the health service, which is
+// neither authenticated nor rate limited.
+func isHealthCheck(info *grpc.UnaryServerInfo) bool {
+	return strings.HasPrefix(info.FullMethod, healthService)
+}
+
+// methodName returns the name of the method of a call, which is also the
+// name of the matching HTTP route, e.g. "Identity".
+func methodName(info *grpc.UnaryServerInfo) string {
+	return path.Base(info.FullMethod)
+}
+
+// TraceMetrics is the unary interceptor equivalent of the TraceMetrics HTTP
+// middleware: it continues the trace of the caller, sent in the metadata,
+// records the status code and duration of the call, and turns the errors of
+// the service into gRPC statuses.
+func TraceMetrics(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	method := methodName(info)
+	start := time.Now()
+
+	span, ctx := initRootSpan(ctx, method)
+	defer span.Finish()
+	ext.SpanKindRPCServer.Set(span)
+
+	// The caller is only known once ServiceAuth ran, so hand it a place to
+	// put it.
+	callerName := anonymousCaller
+	ctx = context.WithValue(ctx, metricsCallerKey{}, &callerName)
+
+	resp, err := handler(ctx, req)
+
+	var domainErr *user.Error
+	if errors.As(err, &domainErr) {
+		span.SetTag("error.code", string(domainErr.Code))
+	}
+	if err != nil {
+		span.LogFields(otlog.Error(err))
+	}
+
+	err = toStatus(err)
+
+	code := status.Code(err)
+	span.SetTag("grpc.code", code.String())
+	if code == codes.Internal || code == codes.Unknown {
+		ext.Error.Set(span, true)
+	}
+
+	metrics.ReceivedGRPCRequest(code.String(), method, callerName, time.Since(start).Seconds())
+
+	return resp, err
+}
+
+func initRootSpan(ctx context.Context, operationName string) (opentracing.Span, context.Context) {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	carrier := opentracing.TextMapCarrier{}
+	for key, values := range md {
+		if len(values) > 0 {
+			carrier[key] = values[0]
+		}
+	}
+
+	wireContext, err := opentracing.GlobalTracer().Extract(opentracing.TextMap, carrier)
+	if err != nil {
+		return opentracing.StartSpanFromContext(ctx, operationName)
+	}
+
+	return opentracing.StartSpanFromContext(ctx, operationName, opentracing.ChildOf(wireContext))
+}
+
+// ServiceAuth returns a unary interceptor authenticating and authorizing the
+// calling service like the ServiceAuth HTTP middleware, by its verified
+// client certificate or the "x-api-key" metadata, and the name of the method
+// as the route. Health checks pass through.
+func ServiceAuth(sa *middleware.ServiceAuth) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthCheck(info) {
+			return handler(ctx, req)
+		}
+
+		cert := verifiedCertificate(ctx)
+		apiKey := apiKey(ctx)
+		if !sa.HasCredentials(cert, apiKey) {
+			if sa.Required {
+				return nil, &user.Error{Code: user.CodeUnauthorized, Message: "missing client certificate or api key"}
+			}
+
+			return handler(ctx, req)
+		}
+
+		c, err := sa.Authenticate(ctx, cert, apiKey)
+		if err != nil {
+			log.Errorf("error getting caller in service auth interceptor: %s", err)
+			return nil, err
+		}
+
+		if c == nil {
+			return nil, &user.Error{Code: user.CodeUnauthorized, Message: "unknown caller"}
+		}
+
+		if name, ok := ctx.Value(metricsCallerKey{}).(*string); ok {
+			*name = c.Name
+		}
+
+		if !c.Allowed(methodName(info)) {
+			return nil, &user.Error{Code: user.CodeForbidden, Message: "caller may not call this method"}
+		}
+
+		return handler(middleware.ContextWithCaller(ctx, c), req)
+	}
+}
+
+// loginRequest is a request carrying a login, like CreateTokenRequest.
+type loginRequest interface {
+	GetLogin() string
+	GetAuthUserType() string
+}
+
+// RateLimit returns a unary interceptor limiting the calls of methods like
+// the RateLimit HTTP middleware limits the matching routes, with the same
+// limits and lockouts. Calls of other methods pass through. It runs after
+// ServiceAuth, to limit per calling service.
+func RateLimit(rl *middleware.RateLimit, methods ...string) grpc.UnaryServerInterceptor {
+	limited := make(map[string]bool, len(methods))
+	for _, m := range methods {
+		limited[m] = true
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		method := methodName(info)
+		if !limited[method] || isHealthCheck(info) {
+			return handler(ctx, req)
+		}
+
+		request := middleware.LimitedRequest{
+			Route:  method,
+			IP:     rl.ClientIP(peerAddr(ctx), metadataValues(ctx, "x-forwarded-for")),
+			Client: callerID(ctx),
+		}
+		if r, ok := req.(loginRequest); ok {
+			request.Login = r.GetLogin()
+			request.AuthUserType = r.GetAuthUserType()
+		}
+
+		admission := rl.Admit(ctx, request)
+		if admission.RetryAfter > 0 {
+			retryAfter := strconv.Itoa(int(math.Ceil(admission.RetryAfter.Seconds())))
+			_ = grpc.SetHeader(ctx, metadata.Pairs("retry-after", retryAfter))
+			return nil, &user.Error{Code: user.CodeRateLimited, Message: admission.Reason}
+		}
+
+		resp, err := handler(admission.Context, req)
+		rl.Finish(admission, err == nil)
+
+		return resp, err
+	}
+}
+
+// callerID returns the calling service, or else the "x-client-id" metadata,
+// like the client of the RateLimit HTTP middleware.
+func callerID(ctx context.Context) string {
+	if c := middleware.CallerFromContext(ctx); c != nil {
+		return c.Name
+	}
+
+	if id := metadataValue(ctx, "x-client-id"); id != "" {
+		return id
+	}
+
+	return "unknown"
+}
+
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+
+	return p.Addr.String()
+}
+
+func metadataValue(ctx context.Context, key string) string {
+	if values := metadataValues(ctx, key); len(values) > 0 {
+		return values[0]
+	}
+
+	return ""
+}
+
+func metadataValues(ctx context.Context, key string) []string {
+	md, _ := metadata.FromIncomingContext(ctx)
+	return md.Get(key)
+}
+
+func verifiedCertificate(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0]
+}
+
+func apiKey(ctx context.Context) string {
+	if key := metadataValue(ctx, "x-api-key"); key != "" {
+		return key
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	const prefix = "apikey "
+	for _, header := range md.Get("authorization") {
+		if len(header) >= len(prefix) && strings.EqualFold(header[:len(prefix)], prefix) {
+			return strings.TrimSpace(header[len(prefix):])
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,219 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+
+	"gitlab.com/route-kz/auth-api/caller"
+	"gitlab.com/route-kz/auth-api/config"
+	authv1 "gitlab.com/route-kz/auth-api/proto/auth/v1"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+type fakeLimiter struct {
+	lockedOut map[string]bool
+	allowed   []string
+	failures  []string
+	resets    []string
+}
+
+func (l *fakeLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	l.allowed = append(l.allowed, key)
+	return true, 0, nil
+}
+
+func (l *fakeLimiter) LockedOut(ctx context.Context, key string) (time.Duration, error) {
+	if l.lockedOut[key] {
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+func (l *fakeLimiter) RecordFailure(ctx context.Context, key string, threshold int, failureWindow, base, max time.Duration) (time.Duration, error) {
+	l.failures = append(l.failures, key)
+	return 0, nil
+}
+
+func (l *fakeLimiter) ResetFailures(ctx context.Context, key string) error {
+	l.resets = append(l.resets, key)
+	return nil
+}
+
+// fakeCallers is a caller.Fetcher over callers by API key.
+type fakeCallers map[string]*caller.Caller
+
+func (f fakeCallers) GetCallerByAPIKeyHash(ctx context.Context, apiKeyHash string) (*caller.Caller, error) {
+	for key, c := range f {
+		if caller.HashAPIKey(key) == apiKeyHash {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f fakeCallers) GetCallerByName(ctx context.Context, name string) (*caller.Caller, error) {
+	return nil, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	const loginKey = "login:client:+77011234567"
+
+	tests := []struct {
+		name         string
+		method       string
+		req          interface{}
+		forwardedFor []string
+		lockedOut    map[string]bool
+		handlerErr   error
+		failed       bool
+		wantErr      user.ErrorCode
+		wantLimits   []string
+		wantFailures []string
+		wantResets   []string
+	}{
+		{
+			name:       "token issued",
+			method:     "CreateToken",
+			req:        &authv1.CreateTokenRequest{Login: "87011234567", AuthUserType: "client"},
+			wantLimits: []string{"CreateToken:ip:192.0.2.1", "CreateToken:client:billing", "CreateToken:" + loginKey},
+			wantResets: []string{loginKey + ":ip:192.0.2.1"},
+		},
+		{
+			name:         "unknown token counts as a failure of the ip",
+			method:       "RefreshToken",
+			req:          &authv1.RefreshTokenRequest{Token: "unknown"},
+			handlerErr:   user.ErrInvalidToken,
+			failed:       true,
+			wantErr:      user.CodeInvalidToken,
+			wantLimits:   []string{"RefreshToken:ip:192.0.2.1", "RefreshToken:client:billing"},
+			wantFailures: []string{"ip:192.0.2.1"},
+		},
+		{
+			name:      "locked out login",
+			method:    "CreateToken",
+			req:       &authv1.CreateTokenRequest{Login: "+77011234567", AuthUserType: "client"},
+			lockedOut: map[string]bool{loginKey + ":ip:192.0.2.1": true},
+			wantErr:   user.CodeRateLimited,
+		},
+		{
+			name:         "ip from the entry of the proxy in x-forwarded-for",
+			method:       "RefreshToken",
+			req:          &authv1.RefreshTokenRequest{Token: "token"},
+			forwardedFor: []string{"10.0.0.1, 203.0.113.7"},
+			wantLimits:   []string{"RefreshToken:ip:203.0.113.7", "RefreshToken:client:billing"},
+			wantResets:   []string{"ip:203.0.113.7"},
+		},
+		{
+			name:   "other methods are not limited",
+			method: "Identity",
+			req:    &authv1.IdentityRequest{Token: "token"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &fakeLimiter{lockedOut: tt.lockedOut}
+			interceptor := RateLimit(&middleware.RateLimit{Limiter: limiter, Config: &config.Config{
+				PhoneDefaultRegion:         "KZ",
+				RateLimitTrustForwardedFor: true,
+				RateLimitTrustedProxies:    1,
+			}}, "CreateToken", "RefreshToken")
+
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}})
+			ctx = middleware.ContextWithCaller(ctx, &caller.Caller{Name: "billing"})
+			if tt.forwardedFor != nil {
+				ctx = metadata.NewIncomingContext(ctx, metadata.MD{"x-forwarded-for": tt.forwardedFor})
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: "/auth.v1.AuthService/" + tt.method}
+
+			_, err := interceptor(ctx, tt.req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				if tt.failed {
+					middleware.FailedAttempt(ctx)
+				}
+				return nil, tt.handlerErr
+			})
+
+			var domainErr *user.Error
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("interceptor error = %v", err)
+			case tt.wantErr != "" && (!errors.As(err, &domainErr) || domainErr.Code != tt.wantErr):
+				t.Errorf("interceptor error = %v, want %s", err, tt.wantErr)
+			}
+
+			if strings.Join(limiter.allowed, ",") != strings.Join(tt.wantLimits, ",") {
+				t.Errorf("limits = %v, want %v", limiter.allowed, tt.wantLimits)
+			}
+			if strings.Join(limiter.failures, ",") != strings.Join(tt.wantFailures, ",") {
+				t.Errorf("failures = %v, want %v", limiter.failures, tt.wantFailures)
+			}
+			if strings.Join(limiter.resets, ",") != strings.Join(tt.wantResets, ",") {
+				t.Errorf("resets = %v, want %v", limiter.resets, tt.wantResets)
+			}
+		})
+	}
+}
+
+func TestServiceAuth(t *testing.T) {
+	sa := &middleware.ServiceAuth{DB: fakeCallers{"billing-key": {Name: "billing", Routes: []string{"Identity"}}}, Required: true}
+	interceptor := ServiceAuth(sa)
+
+	tests := []struct {
+		name       string
+		fullMethod string
+		apiKey     string
+		wantErr    user.ErrorCode
+		wantCaller string
+	}{
+		{"health check without credentials", "/grpc.health.v1.Health/Check", "", "", ""},
+		{"health watch without credentials", "/grpc.health.v1.Health/Watch", "", "", ""},
+		{"method without credentials", "/auth.v1.AuthService/Identity", "", user.CodeUnauthorized, ""},
+		{"method with an api key", "/auth.v1.AuthService/Identity", "billing-key", "", "billing"},
+		{"method the caller may not call", "/auth.v1.AuthService/PersonalData", "billing-key", user.CodeForbidden, ""},
+		{"unknown api key", "/auth.v1.AuthService/Identity", "unknown-key", user.CodeUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.apiKey != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-api-key", tt.apiKey))
+			}
+			info := &grpc.UnaryServerInfo{FullMethod: tt.fullMethod}
+
+			var gotCaller string
+			called := false
+			_, err := interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				called = true
+				if c := middleware.CallerFromContext(ctx); c != nil {
+					gotCaller = c.Name
+				}
+				return nil, nil
+			})
+
+			var domainErr *user.Error
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Fatalf("interceptor error = %v", err)
+			case tt.wantErr != "" && (!errors.As(err, &domainErr) || domainErr.Code != tt.wantErr):
+				t.Fatalf("interceptor error = %v, want %s", err, tt.wantErr)
+			}
+
+			if called != (tt.wantErr == "") {
+				t.Errorf("handler called = %v, want %v", called, tt.wantErr == "")
+			}
+			if gotCaller != tt.wantCaller {
+				t.Errorf("caller = %q, want %q", gotCaller, tt.wantCaller)
+			}
+		})
+	}
+}
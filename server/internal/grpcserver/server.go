@@ -0,0 +1,147 @@
+// Package grpcserver provides the gRPC API, served from the same user
+// interfaces as the HTTP API.
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	authv1 "gitlab.com/route-kz/auth-api/proto/auth/v1"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// Store is an interface for everything the gRPC API needs from the database.
+type Store interface {
+	user.IDFetcherTokenCreator
+	user.TokenRefresher
+	user.IDFetcher
+	user.PersonalDataFetcher
+	user.TokenRevoker
+}
+
+// AuthService implements authv1.AuthServiceServer.
+type AuthService struct {
+	authv1.UnimplementedAuthServiceServer
+
+	DB            Store
+	AuthUserTypes []string
+	AuthMethods   []string
+	// AuthCodes check the auth codes sent to logins by POST
+	// /api/v1/tokens/codes. Without them the auth codes are not checked.
+	AuthCodes user.CodeIssuer
+}
+
+// CreateToken issues a token like POST /api/v1/tokens, with the same rate
+// limits when the RateLimit interceptor is set up.
+func (s *AuthService) CreateToken(ctx context.Context, req *authv1.CreateTokenRequest) (*authv1.TokenResponse, error) {
+	payload := user.CreateTokenPayload{
+		Login:        req.GetLogin(),
+		AuthUserType: req.GetAuthUserType(),
+		AuthCode:     req.GetAuthCode(),
+		AuthMethod:   req.GetAuthMethod(),
+	}
+
+	if err := payload.Validate(s.AuthUserTypes, s.AuthMethods); err != nil {
+		return nil, err
+	}
+
+	if s.AuthCodes != nil {
+		err := user.CheckAuthCode(ctx, s.DB, s.AuthCodes, payload)
+		if errors.Is(err, user.ErrInvalidCode) {
+			middleware.FailedAttempt(ctx)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token, err := user.IssueToken(ctx, s.DB, payload)
+	if err != nil {
+		return nil, fmt.Errorf("error issuing token in create token rpc: %w", err)
+	}
+
+	return &authv1.TokenResponse{Token: token}, nil
+}
+
+// RefreshToken exchanges a token for a new one like POST
+// /api/v1/refresh-tokens.
+func (s *AuthService) RefreshToken(ctx context.Context, req *authv1.RefreshTokenRequest) (*authv1.TokenResponse, error) {
+	token, err := user.RefreshToken(ctx, s.DB, req.GetToken())
+	if errors.Is(err, user.ErrInvalidToken) {
+		middleware.FailedAttempt(ctx)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error refreshing token in refresh token rpc: %w", err)
+	}
+
+	return &authv1.TokenResponse{Token: token}, nil
+}
+
+// Identity returns the user of a token like GET /api/v1/tokens, except that
+// unknown tokens are an error.
+func (s *AuthService) Identity(ctx context.Context, req *authv1.IdentityRequest) (*authv1.IdentityResponse, error) {
+	userID, err := s.DB.GetUserID(ctx, req.GetToken())
+	if err != nil {
+		return nil, fmt.Errorf("error getting user id in identity rpc: %w", err)
+	}
+
+	if userID == "" {
+		return nil, user.ErrInvalidToken
+	}
+
+	return &authv1.IdentityResponse{UserId: userID}, nil
+}
+
+// PersonalData returns the personal data of a user like GET
+// /api/v1/personal-data.
+func (s *AuthService) PersonalData(ctx context.Context, req *authv1.PersonalDataRequest) (*authv1.PersonalDataResponse, error) {
+	personalData, err := s.DB.FetchPersonalData(ctx, req.GetUserId())
+	if err != nil {
+		return nil, fmt.Errorf("error getting personal data in personal data rpc: %w", err)
+	}
+
+	resp := &authv1.PersonalDataResponse{
+		UserId:        personalData.UserID,
+		PhoneNumber:   personalData.PhoneNumber,
+		PhoneVerified: personalData.PhoneVerified,
+		Email:         personalData.Email,
+		EmailVerified: personalData.EmailVerified,
+		Username:      personalData.Username,
+		Logins:        make([]*authv1.Login, 0, len(personalData.Logins)),
+	}
+
+	for _, login := range personalData.Logins {
+		l := &authv1.Login{
+			Login:        login.Login,
+			Type:         string(login.Type),
+			AuthUserType: login.AuthUserType,
+			AuthMethod:   login.AuthMethod,
+			Verified:     login.Verified,
+		}
+		if login.VerifiedAt != nil {
+			l.VerifiedAt = timestamppb.New(*login.VerifiedAt)
+		}
+		resp.Logins = append(resp.Logins, l)
+	}
+
+	return resp, nil
+}
+
+// RevokeTokens revokes all the tokens of a user, like the revoke_tokens
+// command.
+func (s *AuthService) RevokeTokens(ctx context.Context, req *authv1.RevokeTokensRequest) (*authv1.RevokeTokensResponse, error) {
+	if req.GetUserId() == "" {
+		return nil, user.ErrValidationFailed.WithFields(map[string]string{"user_id": "is required"})
+	}
+
+	revoked, err := s.DB.RevokeTokens(ctx, req.GetUserId())
+	if err != nil {
+		return nil, fmt.Errorf("error revoking tokens in revoke tokens rpc: %w", err)
+	}
+
+	return &authv1.RevokeTokensResponse{Revoked: int32(revoked)}, nil
+}
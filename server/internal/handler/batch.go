@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// identityBatchPayload is the body of IdentityBatch requests.
+type identityBatchPayload struct {
+	Tokens []string `json:"tokens"`
+}
+
+// personalDataBatchPayload is the body of PersonalDataBatch requests.
+type personalDataBatchPayload struct {
+	UserIDs []string `json:"user_ids"`
+}
+
+// IdentityBatch is a handler that gets the user ids of many tokens at once.
+//
+//	POST /api/v1/tokens/batch
+//	Responds: 200, 400, 413, 500
+//	Body:
+//		{"tokens": ["token", ...]}
+//
+// The response has one result per token, in the order of the request, with
+// found set to false for tokens that do not exist.
+func IdentityBatch(
+	db user.BatchIDFetcher,
+	maxSize int,
+	maxBytes int64,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var payload identityBatchPayload
+		if err := decodeBatch(w, r, &payload, func() int { return len(payload.Tokens) }, maxSize, maxBytes); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
+			return
+		}
+
+		userIDs, err := db.GetUserIDs(ctx, payload.Tokens)
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error getting user ids in identity batch handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		type result struct {
+			Token  string `json:"token"`
+			Found  bool   `json:"found"`
+			UserID string `json:"user_id,omitempty"`
+		}
+
+		results := make([]result, 0, len(payload.Tokens))
+		for _, token := range payload.Tokens {
+			userID, found := userIDs[token]
+			results = append(results, result{
+				Token:  token,
+				Found:  found,
+				UserID: userID,
+			})
+		}
+
+		respondJSON(w, r, http.StatusOK, struct {
+			Results []result `json:"results"`
+		}{
+			Results: results,
+		})
+	}
+}
+
+// PersonalDataBatch is a handler that gets the personal data of many users
+// at once.
+//
+//	POST /api/v1/personal-data/batch
+//	Responds: 200, 400, 413, 500
+//	Body:
+//		{"user_ids": ["user id", ...]}
+//
+// The response has one result per user id, in the order of the request, with
+// found set to false for users that do not exist.
+func PersonalDataBatch(
+	db user.BatchPersonalDataFetcher,
+	maxSize int,
+	maxBytes int64,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var payload personalDataBatchPayload
+		if err := decodeBatch(w, r, &payload, func() int { return len(payload.UserIDs) }, maxSize, maxBytes); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
+			return
+		}
+
+		personalData, err := db.FetchPersonalDataBatch(ctx, payload.UserIDs)
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error getting personal data in personal data batch handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		type result struct {
+			UserID       string             `json:"user_id"`
+			Found        bool               `json:"found"`
+			PersonalData *user.PersonalData `json:"personal_data,omitempty"`
+		}
+
+		results := make([]result, 0, len(payload.UserIDs))
+		for _, userID := range payload.UserIDs {
+			data, found := personalData[userID]
+			results = append(results, result{
+				UserID:       userID,
+				Found:        found,
+				PersonalData: data,
+			})
+		}
+
+		respondJSON(w, r, http.StatusOK, struct {
+			Results []result `json:"results"`
+		}{
+			Results: results,
+		})
+	}
+}
+
+// decodeBatch decodes a batch request body of at most maxBytes, like
+// decodeJSON, and checks that the batch is not empty and not larger than
+// maxSize.
+func decodeBatch(w http.ResponseWriter, r *http.Request, payload interface{}, size func() int, maxSize int, maxBytes int64) error {
+	if err := decodeJSON(w, r, payload, maxBytes); err != nil {
+		return err
+	}
+
+	if size() == 0 {
+		return &user.Error{Code: user.CodeValidationFailed, Message: "batch is empty"}
+	}
+
+	if size() > maxSize {
+		return &user.Error{
+			Code:    user.CodeValidationFailed,
+			Message: fmt.Sprintf("batch has %d items, the maximum is %d", size(), maxSize),
+		}
+	}
+
+	return nil
+}
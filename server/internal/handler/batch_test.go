@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// fakeBatch is a user.BatchIDFetcher and user.BatchPersonalDataFetcher over
+// maps of tokens and personal data.
+type fakeBatch struct {
+	userIDs      map[string]string
+	personalData map[string]*user.PersonalData
+}
+
+func (f *fakeBatch) GetUserIDs(ctx context.Context, tokens []string) (map[string]string, error) {
+	found := make(map[string]string)
+	for _, token := range tokens {
+		if userID, ok := f.userIDs[token]; ok {
+			found[token] = userID
+		}
+	}
+	return found, nil
+}
+
+func (f *fakeBatch) FetchPersonalDataBatch(ctx context.Context, userIDs []string) (map[string]*user.PersonalData, error) {
+	found := make(map[string]*user.PersonalData)
+	for _, userID := range userIDs {
+		if data, ok := f.personalData[userID]; ok {
+			found[userID] = data
+		}
+	}
+	return found, nil
+}
+
+func TestIdentityBatch(t *testing.T) {
+	db := &fakeBatch{userIDs: map[string]string{"a": "1", "b": "2"}}
+	h := IdentityBatch(db, 3, 64)
+
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   user.ErrorCode
+		wantBody   string
+	}{
+		{
+			name:       "in the order of the request",
+			body:       `{"tokens": ["b", "unknown", "a"]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"results": [{"token": "b", "found": true, "user_id": "2"}, {"token": "unknown", "found": false}, {"token": "a", "found": true, "user_id": "1"}]}`,
+		},
+		{"empty", `{"tokens": []}`, http.StatusBadRequest, user.CodeValidationFailed, ""},
+		{"too many", `{"tokens": ["a", "b", "c", "d"]}`, http.StatusBadRequest, user.CodeValidationFailed, ""},
+		{"unknown field", `{"tokens": ["a"], "user_ids": ["1"]}`, http.StatusBadRequest, user.CodeValidationFailed, ""},
+		{"trailing data", `{"tokens": ["a"]} {"tokens": ["b"]}`, http.StatusBadRequest, user.CodeValidationFailed, ""},
+		{"too large", `{"tokens": ["` + strings.Repeat("a", 64) + `"]}`, http.StatusRequestEntityTooLarge, user.CodeRequestTooLarge, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens/batch", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+
+			if tt.wantCode != "" {
+				var body struct {
+					Error user.ErrorCode `json:"error"`
+				}
+				if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Error != tt.wantCode {
+					t.Errorf("error = %q, want %q", body.Error, tt.wantCode)
+				}
+			}
+
+			if tt.wantBody != "" {
+				var got, want interface{}
+				if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+					t.Fatal(err)
+				}
+				if err := json.Unmarshal([]byte(tt.wantBody), &want); err != nil {
+					t.Fatal(err)
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("body = %s, want %s", w.Body, tt.wantBody)
+				}
+			}
+		})
+	}
+}
+
+func TestPersonalDataBatch(t *testing.T) {
+	jane := &user.PersonalData{UserID: "1", Email: "jane@example.com", Logins: []user.Login{}}
+	db := &fakeBatch{personalData: map[string]*user.PersonalData{"1": jane}}
+	h := PersonalDataBatch(db, 10, 1<<10)
+
+	r := httptest.NewRequest(http.MethodPost, "/api/v1/personal-data/batch", strings.NewReader(`{"user_ids": ["2", "1"]}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d: %s", w.Code, http.StatusOK, w.Body)
+	}
+
+	var body struct {
+		Results []struct {
+			UserID       string             `json:"user_id"`
+			Found        bool               `json:"found"`
+			PersonalData *user.PersonalData `json:"personal_data"`
+		} `json:"results"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.Results) != 2 {
+		t.Fatalf("results = %d, want 2", len(body.Results))
+	}
+	if got := body.Results[0]; got.UserID != "2" || got.Found || got.PersonalData != nil {
+		t.Errorf("results[0] = %+v, want user 2 not found", got)
+	}
+	if got := body.Results[1]; got.UserID != "1" || !got.Found || !reflect.DeepEqual(got.PersonalData, jane) {
+		t.Errorf("results[1] = %+v, want user 1 with %+v", got, *jane)
+	}
+}
@@ -0,0 +1,238 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// ChangeLogin is a handler that starts replacing a login of the
+// authenticated user, e.g. when the user got a new phone number. It sends a
+// verification code to the new login and another one to the old login.
+//
+//	POST /api/v1/logins/change
+//	Responds: 202, 400, 401, 404, 409, 500
+//	Body:
+//		type ChangeLoginPayload struct {
+//			OldLogin     string `json:"old_login"`
+//			NewLogin     string `json:"new_login"`
+//			AuthMethod   string `json:"auth_method"`
+//			AuthUserType string `json:"auth_user_type,omitempty"`
+//		}
+//
+// The change is made once the codes are sent to POST /api/v1/logins/change/verify.
+func ChangeLogin(
+	db user.LoginChanger,
+	codes user.CodeIssuer,
+	sender user.CodeSender,
+	authMethods []string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := middleware.UserIDFromContext(ctx)
+
+		var payload user.ChangeLoginPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.OldLogin == "" || payload.NewLogin == "" {
+			handleError(
+				w,
+				r,
+				user.ErrValidationFailed.Wrap(fmt.Errorf("error decoding change login payload: %v", err)),
+				http.StatusBadRequest,
+				true,
+			)
+			return
+		}
+
+		oldLogin, newLogin, err := loginChange(ctx, db, userID, payload.OldLogin, payload.NewLogin, payload.AuthMethod, payload.AuthUserType, authMethods)
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		codesToSend := []struct {
+			purpose user.VerificationPurpose
+			login   user.Login
+		}{
+			{user.VerificationPurposeChangeLogin, newLogin},
+			{user.VerificationPurposeConfirmLoginChange, oldLogin},
+		}
+
+		for _, c := range codesToSend {
+			code, err := codes.IssueCode(ctx, codeKey(c.purpose, userID, c.login.Login))
+			if err != nil {
+				handleError(
+					w,
+					r,
+					fmt.Errorf("error issuing code in change login handler: %w", err),
+					http.StatusInternalServerError,
+					true,
+				)
+				return
+			}
+
+			if err := sender.SendCode(ctx, c.purpose, userID, c.login, code); err != nil {
+				handleError(
+					w,
+					r,
+					fmt.Errorf("error sending code in change login handler: %w", err),
+					http.StatusInternalServerError,
+					true,
+				)
+				return
+			}
+		}
+
+		respondJSON(w, r, http.StatusAccepted, struct {
+			OldLogin string     `json:"old_login"`
+			NewLogin user.Login `json:"new_login"`
+		}{
+			OldLogin: oldLogin.Login,
+			NewLogin: newLogin,
+		})
+	}
+}
+
+// VerifyChangeLogin is a handler that finishes a login change started by
+// ChangeLogin. The code sent to the new login is required. If the code sent
+// to the old login is given too, the login is replaced right away; otherwise
+// the change is scheduled and the old login is told about it, so the change
+// can still be cancelled during the grace period.
+//
+//	POST /api/v1/logins/change/verify
+//	Responds: 200, 400, 401, 404, 409, 500
+//	Body:
+//		type VerifyChangeLoginPayload struct {
+//			OldLogin     string `json:"old_login"`
+//			NewLogin     string `json:"new_login"`
+//			AuthMethod   string `json:"auth_method"`
+//			AuthUserType string `json:"auth_user_type,omitempty"`
+//			NewCode      string `json:"new_code"`
+//			OldCode      string `json:"old_code"`
+//		}
+func VerifyChangeLogin(
+	db user.LoginChanger,
+	codes user.CodeIssuer,
+	sender user.CodeSender,
+	authMethods []string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := middleware.UserIDFromContext(ctx)
+
+		var payload user.VerifyChangeLoginPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.OldLogin == "" || payload.NewLogin == "" {
+			handleError(
+				w,
+				r,
+				user.ErrValidationFailed.Wrap(fmt.Errorf("error decoding verify change login payload: %v", err)),
+				http.StatusBadRequest,
+				true,
+			)
+			return
+		}
+
+		oldLogin, newLogin, err := loginChange(ctx, db, userID, payload.OldLogin, payload.NewLogin, payload.AuthMethod, payload.AuthUserType, authMethods)
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		// Both codes are checked at once, so a mistyped old code does not use
+		// up the new one.
+		toCheck := map[string]string{
+			codeKey(user.VerificationPurposeChangeLogin, userID, newLogin.Login): payload.NewCode,
+		}
+		if payload.OldCode != "" {
+			toCheck[codeKey(user.VerificationPurposeConfirmLoginChange, userID, oldLogin.Login)] = payload.OldCode
+		}
+
+		ok, err := codes.CheckCodes(ctx, toCheck)
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error checking codes in verify change login handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		if !ok {
+			middleware.FailedAttempt(ctx)
+			handleError(w, r, user.ErrInvalidCode, http.StatusInternalServerError, false)
+			return
+		}
+
+		var change *user.LoginChange
+		if payload.OldCode != "" {
+			change, err = db.ChangeLogin(ctx, userID, oldLogin.Login, newLogin)
+		} else {
+			change, err = db.ScheduleLoginChange(ctx, userID, oldLogin.Login, newLogin)
+			if err == nil {
+				err = sender.SendCode(ctx, user.VerificationPurposeLoginChangeScheduled, userID, oldLogin, "")
+			}
+		}
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		respondJSON(w, r, http.StatusOK, change)
+	}
+}
+
+// CancelLoginChange is a handler that cancels a scheduled login change of
+// the authenticated user.
+//
+//	DELETE /api/v1/logins/change
+//	Responds: 204, 401, 404, 500
+//	Query Parameters:
+//		new_login: The new login of the change to cancel
+func CancelLoginChange(
+	db user.LoginChanger,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		err := db.CancelLoginChange(ctx, middleware.UserIDFromContext(ctx), r.URL.Query().Get("new_login"))
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// loginChange finds the old login among the logins of the user and builds
+// the new login of the same auth user type, making sure nobody else owns it.
+// Without authUserType the old login must be linked with a single auth user
+// type. The new login has the auth method of the old one unless another is
+// given.
+func loginChange(
+	ctx context.Context,
+	db user.LoginChanger,
+	userID, oldLogin, newLogin, authMethod, authUserType string,
+	authMethods []string,
+) (user.Login, user.Login, error) {
+	login, err := unlinkedLogin(ctx, db, userID, oldLogin, authUserType)
+	if err != nil {
+		return user.Login{}, user.Login{}, err
+	}
+
+	if authMethod == "" {
+		authMethod = login.AuthMethod
+	}
+
+	linked, err := newLinkedLogin(ctx, db, userID, newLogin, authMethod, login.AuthUserType, authMethods)
+	if err != nil {
+		return user.Login{}, user.Login{}, err
+	}
+
+	return login, linked, nil
+}
@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// fakeChanger is a user.LoginChanger over the logins of a single user, with
+// the logins of other users in owners.
+type fakeChanger struct {
+	user.LoginChanger
+	logins    []user.Login
+	owners    map[string]string
+	changed   []user.Login
+	scheduled []user.Login
+}
+
+func (f *fakeChanger) NormalizeLogin(login, authMethod string) string {
+	return user.Normalizer{DefaultRegion: "KZ"}.Normalize(login, authMethod)
+}
+
+func (f *fakeChanger) FetchLogins(ctx context.Context, userID string) ([]user.Login, error) {
+	return f.logins, nil
+}
+
+func (f *fakeChanger) GetUserIDByLogin(ctx context.Context, login, authUserType string) (string, error) {
+	return f.owners[login+"/"+authUserType], nil
+}
+
+func (f *fakeChanger) CheckLoginAvailable(ctx context.Context, login, authUserType string) error {
+	return nil
+}
+
+func (f *fakeChanger) ChangeLogin(ctx context.Context, userID, oldLogin string, newLogin user.Login) (*user.LoginChange, error) {
+	f.changed = append(f.changed, newLogin)
+	return &user.LoginChange{UserID: userID, OldLogin: oldLogin, NewLogin: newLogin, Applied: true}, nil
+}
+
+func (f *fakeChanger) ScheduleLoginChange(ctx context.Context, userID, oldLogin string, newLogin user.Login) (*user.LoginChange, error) {
+	f.scheduled = append(f.scheduled, newLogin)
+	return &user.LoginChange{UserID: userID, OldLogin: oldLogin, NewLogin: newLogin}, nil
+}
+
+// fakeCodes is a user.CodeIssuer accepting the codes in valid.
+type fakeCodes struct {
+	valid map[string]string
+}
+
+func (f *fakeCodes) IssueCode(ctx context.Context, key string) (string, error) {
+	return f.valid[key], nil
+}
+
+func (f *fakeCodes) CheckCode(ctx context.Context, key, code string) (bool, error) {
+	return f.valid[key] == code, nil
+}
+
+func (f *fakeCodes) CheckCodes(ctx context.Context, codes map[string]string) (bool, error) {
+	for key, code := range codes {
+		if f.valid[key] != code {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// fakeSender is a user.CodeSender recording what it sends.
+type fakeSender struct {
+	sent []user.VerificationPurpose
+}
+
+func (f *fakeSender) SendCode(ctx context.Context, purpose user.VerificationPurpose, userID string, login user.Login, code string) error {
+	f.sent = append(f.sent, purpose)
+	return nil
+}
+
+// fakeUsers is a user.IDFetcher with a token per user id.
+type fakeUsers map[string]string
+
+func (f fakeUsers) GetUserID(ctx context.Context, token string) (string, error) {
+	return f[token], nil
+}
+
+func TestLoginChange(t *testing.T) {
+	db := &fakeChanger{
+		logins: []user.Login{
+			{Login: "+77011234567", AuthUserType: "client", AuthMethod: "sms"},
+			{Login: "+77011234567", AuthUserType: "driver", AuthMethod: "sms"},
+			{Login: "jane@example.com", AuthUserType: "client", AuthMethod: "email"},
+		},
+		owners: map[string]string{"+77017654321/driver": "other"},
+	}
+
+	tests := []struct {
+		name         string
+		oldLogin     string
+		newLogin     string
+		authUserType string
+		want         user.Login
+		wantErr      error
+	}{
+		{
+			name:     "login of a single type",
+			oldLogin: "jane@example.com",
+			newLogin: "Jane.Doe@example.com",
+			want:     user.Login{Login: "jane.doe@example.com", Type: user.LoginTypeEmail, AuthUserType: "client", AuthMethod: "email"},
+		},
+		{
+			name:         "login of several types",
+			oldLogin:     "8 701 123 45 67",
+			newLogin:     "8 701 765 43 21",
+			authUserType: "client",
+			want:         user.Login{Login: "+77017654321", Type: user.LoginTypePhone, AuthUserType: "client", AuthMethod: "sms"},
+		},
+		{
+			name:     "login of several types without a type",
+			oldLogin: "+77011234567",
+			newLogin: "+77017654321",
+			wantErr:  user.ErrValidationFailed,
+		},
+		{
+			name:         "new login taken for the type",
+			oldLogin:     "+77011234567",
+			newLogin:     "+77017654321",
+			authUserType: "driver",
+			wantErr:      user.ErrLoginTaken,
+		},
+		{
+			name:     "unknown old login",
+			oldLogin: "john@example.com",
+			newLogin: "jane.doe@example.com",
+			wantErr:  user.ErrLoginNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, got, err := loginChange(context.Background(), db, "user", tt.oldLogin, tt.newLogin, "", tt.authUserType, nil)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("loginChange() error = %v, want %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("loginChange() new login = %+v, want %+v", got, tt.want)
+			}
+			if old.AuthUserType != got.AuthUserType {
+				t.Errorf("loginChange() old login type = %q, want %q", old.AuthUserType, got.AuthUserType)
+			}
+		})
+	}
+}
+
+func TestVerifyChangeLogin(t *testing.T) {
+	newKey := codeKey(user.VerificationPurposeChangeLogin, "user", "jane.doe@example.com")
+	oldKey := codeKey(user.VerificationPurposeConfirmLoginChange, "user", "jane@example.com")
+	codes := &fakeCodes{valid: map[string]string{newKey: "1111", oldKey: "2222"}}
+
+	tests := []struct {
+		name          string
+		body          string
+		wantStatus    int
+		wantChanged   int
+		wantScheduled int
+	}{
+		{
+			name:        "both codes",
+			body:        `{"old_login": "jane@example.com", "new_login": "jane.doe@example.com", "new_code": "1111", "old_code": "2222"}`,
+			wantStatus:  http.StatusOK,
+			wantChanged: 1,
+		},
+		{
+			name:          "new code only",
+			body:          `{"old_login": "jane@example.com", "new_login": "jane.doe@example.com", "new_code": "1111"}`,
+			wantStatus:    http.StatusOK,
+			wantScheduled: 1,
+		},
+		{
+			name:       "wrong old code",
+			body:       `{"old_login": "jane@example.com", "new_login": "jane.doe@example.com", "new_code": "1111", "old_code": "0000"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "missing new login",
+			body:       `{"old_login": "jane@example.com", "new_code": "1111"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeChanger{logins: []user.Login{
+				{Login: "jane@example.com", AuthUserType: "client", AuthMethod: "email"},
+			}}
+			sender := &fakeSender{}
+			ua := &middleware.UserAuth{DB: fakeUsers{"token": "user"}}
+			h := ua.Middleware(VerifyChangeLogin(db, codes, sender, nil))
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/logins/change/verify", strings.NewReader(tt.body))
+			r.Header.Set("Authorization", "Bearer token")
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if len(db.changed) != tt.wantChanged || len(db.scheduled) != tt.wantScheduled {
+				t.Errorf("changed %d and scheduled %d, want %d and %d", len(db.changed), len(db.scheduled), tt.wantChanged, tt.wantScheduled)
+			}
+			if tt.wantScheduled > 0 && (len(sender.sent) != 1 || sender.sent[0] != user.VerificationPurposeLoginChangeScheduled) {
+				t.Errorf("sent %v, want the scheduled change message", sender.sent)
+			}
+		})
+	}
+}
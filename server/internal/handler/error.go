@@ -1,42 +1,115 @@
 package handler
 
 import (
-	"encoding/json"
 	"errors"
 	"net/http"
 
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+
 	"gitlab.com/route-kz/auth-api/client"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
 
 	log "github.com/sirupsen/logrus"
 )
 
+// codeStatuses are the HTTP status codes of domain errors.
+var codeStatuses = map[user.ErrorCode]int{
+	user.CodeInvalidCredentials: http.StatusUnauthorized,
+	user.CodeInvalidToken:       http.StatusBadRequest,
+	user.CodeTokenExpired:       http.StatusUnauthorized,
+	user.CodeUserBlocked:        http.StatusForbidden,
+	user.CodeValidationFailed:   http.StatusBadRequest,
+	user.CodeLoginTaken:         http.StatusConflict,
+	user.CodeLoginAlreadyLinked: http.StatusConflict,
+	user.CodeLoginNotFound:      http.StatusNotFound,
+	user.CodeLastLogin:          http.StatusConflict,
+	user.CodeInvalidCode:        http.StatusBadRequest,
+	user.CodeLoginCoolingDown:   http.StatusConflict,
+	user.CodeUnauthorized:       http.StatusUnauthorized,
+	user.CodeForbidden:          http.StatusForbidden,
+	user.CodeRateLimited:        http.StatusTooManyRequests,
+	user.CodeBadRequest:         http.StatusBadRequest,
+	user.CodeNotFound:           http.StatusNotFound,
+	user.CodeMethodNotAllowed:   http.StatusMethodNotAllowed,
+	user.CodeRequestTooLarge:    http.StatusRequestEntityTooLarge,
+	user.CodeInternal:           http.StatusInternalServerError,
+}
+
+// NotFound is a handler for requests to routes that do not exist.
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	handleError(w, r, &user.Error{Code: user.CodeNotFound, Message: "route not found"}, http.StatusNotFound, false)
+}
+
+// MethodNotAllowed is a handler for requests to routes that exist, but not
+// with the method of the request.
+func MethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	handleError(w, r, &user.Error{Code: user.CodeMethodNotAllowed, Message: "method not allowed"}, http.StatusMethodNotAllowed, false)
+}
+
+// handleError responds with the error envelope of client.ErrorCodeWrapper,
+// or with problem details on the v2 API.
+//
+// Domain errors (user.Error) are sent with their code and message and the
+// status matching the code. ErrorCodeWrapper errors are propagated as they
+// are. Any other error is sent with statusCode and a generic message. The
+// full error only goes to the logs and the trace of the request.
 func handleError(
 	w http.ResponseWriter,
+	r *http.Request,
 	err error,
 	statusCode int,
 	shouldLog bool,
 ) {
-	if shouldLog {
-		log.Error(err.Error())
+	var errorCodeWrapper client.ErrorCodeWrapper
+	var domainErr *user.Error
+
+	switch {
+	case errors.As(err, &errorCodeWrapper):
+		w.Header().Add("X-preserve-error", "1")
+	case errors.As(err, &domainErr):
+		if status, ok := codeStatuses[domainErr.Code]; ok {
+			statusCode = status
+		}
+		errorCodeWrapper = client.ErrorCodeWrapper{
+			Err:        err,
+			StatusCode: statusCode,
+			ResponseBody: client.ErrorCodeResponseBody{
+				Error:   string(domainErr.Code),
+				Message: domainErr.Message,
+				Fields:  domainErr.Fields,
+			},
+		}
+	default:
+		code := user.CodeBadRequest
+		if statusCode >= http.StatusInternalServerError {
+			code = user.CodeInternal
+		}
+		errorCodeWrapper = client.ErrorCodeWrapper{
+			Err:        err,
+			StatusCode: statusCode,
+			ResponseBody: client.ErrorCodeResponseBody{
+				Error:   string(code),
+				Message: http.StatusText(statusCode),
+			},
+		}
 	}
 
-	errorBody, _ := json.Marshal(struct {
-		Error string `json:"error"`
-	}{
-		Error: err.Error(), // Change to something generic if this API is publicly exposed
-	})
+	statusCode = errorCodeWrapper.StatusCode
 
-	var errorCodeWrapper client.ErrorCodeWrapper
-	if errors.As(err, &errorCodeWrapper) {
-		w.Header().Add("X-preserve-error", "1")
+	if shouldLog || statusCode >= http.StatusInternalServerError {
+		log.WithField("status", statusCode).Error(err.Error())
+	}
 
-		statusCode = errorCodeWrapper.StatusCode
-		errorBody, err = errorCodeWrapper.GetResponseBody()
-		if err != nil {
-			log.Error(err.Error())
+	if span := opentracing.SpanFromContext(r.Context()); span != nil {
+		span.SetTag("error.code", errorCodeWrapper.ResponseBody.Error)
+		span.LogFields(otlog.Error(err))
+		if statusCode >= http.StatusInternalServerError {
+			ext.Error.Set(span, true)
 		}
 	}
 
-	w.WriteHeader(statusCode)
-	w.Write(errorBody)
+	middleware.WriteError(w, r, errorCodeWrapper)
 }
@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/client"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// anonymousUserType in a forward auth policy lets requests without a token
+// through.
+const anonymousUserType = "anonymous"
+
+// forwardAuthPolicy is the auth user types allowed to make requests with a
+// method (any if empty) to paths starting with pathPrefix.
+type forwardAuthPolicy struct {
+	method     string
+	pathPrefix string
+	userTypes  []string
+	anonymous  bool
+}
+
+// ForwardAuth is a handler that authenticates requests for reverse proxies,
+// like nginx auth_request, Traefik ForwardAuth or Envoy ext_authz.
+//
+//	ANY /forward-auth{path}
+//	Responds: 200, 401, 403, 500
+//	Headers:
+//		Authorization: Bearer <token> of the forwarded request
+//		X-Forwarded-Method or X-Original-Method: method of the forwarded request
+//		X-Forwarded-Uri or X-Original-URI: URI of the forwarded request
+//
+// Without the forwarded headers, the method of the request and the path after
+// /forward-auth are taken, as sent by Envoy. Policies are keyed by
+// "[METHOD ]<path prefix>" and list the auth user types allowed; the longest
+// matching prefix applies and requests matching none only need a valid
+// token.
+//
+// Forwarded paths are cleaned before matching, and paths with dot-segments
+// are refused with a 403: proxies like nginx forward $request_uri as sent,
+// so "/public/../admin/" would match a "/public/" policy and still reach
+// "/admin/" on backends that resolve it.
+//
+// On success the response has the X-User-Id, X-User-Type and X-Auth-Method
+// headers: the auth user type the token was issued for, which policies are
+// checked against, and the auth methods of the user's logins. It may be
+// cached by the proxy for cacheTTL.
+func ForwardAuth(
+	db user.TokenIdentityFetcher,
+	policies map[string][]string,
+	cacheTTL time.Duration,
+) http.HandlerFunc {
+	parsed := parseForwardAuthPolicies(policies)
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		method, path, ok := forwardedRequest(r)
+		if !ok {
+			// Dot-segments are resolved by backends but not by the proxy,
+			// so they could walk out of the path a policy was matched on,
+			// and a path we can not parse can not be matched at all.
+			handleError(w, r, &user.Error{Code: user.CodeForbidden, Message: "forwarded path not allowed"}, http.StatusForbidden, false)
+			return
+		}
+		policy := matchForwardAuthPolicy(parsed, method, path)
+
+		token := middleware.BearerToken(r)
+		if token == "" {
+			if policy != nil && policy.anonymous {
+				respondForwardAuth(w, nil, cacheTTL)
+				return
+			}
+
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			handleError(w, r, &user.Error{Code: user.CodeUnauthorized, Message: "missing bearer token"}, http.StatusUnauthorized, false)
+			return
+		}
+
+		identity, err := db.GetTokenIdentity(ctx, token)
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error getting token identity in forward auth handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		if identity == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			// Like the user auth middleware, and unlike the token routes,
+			// respond to invalid tokens with a 401, which proxies expect.
+			middleware.WriteError(w, r, client.ErrorCodeWrapper{
+				StatusCode: http.StatusUnauthorized,
+				ResponseBody: client.ErrorCodeResponseBody{
+					Error:   string(user.CodeInvalidToken),
+					Message: user.ErrInvalidToken.Message,
+				},
+			})
+			return
+		}
+
+		if policy != nil && len(policy.userTypes) > 0 && !anyOf([]string{identity.AuthUserType}, policy.userTypes) {
+			handleError(w, r, &user.Error{Code: user.CodeForbidden, Message: "user type not allowed"}, http.StatusForbidden, false)
+			return
+		}
+
+		respondForwardAuth(w, identity, cacheTTL)
+	}
+}
+
+// respondForwardAuth lets a forwarded request through, as the user of
+// identity if it is authenticated.
+func respondForwardAuth(w http.ResponseWriter, identity *user.TokenIdentity, cacheTTL time.Duration) {
+	h := w.Header()
+	if identity != nil {
+		h.Set("X-User-Id", identity.UserID)
+		h.Set("X-User-Type", identity.AuthUserType)
+		h.Set("X-Auth-Method", strings.Join(identity.AuthMethods, ","))
+	}
+
+	// The response depends on the token and the forwarded request only, and
+	// the proxy is its only reader, so it may keep it for a while.
+	if cacheTTL > 0 {
+		h.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(cacheTTL.Seconds())))
+		h.Del("Pragma")
+	}
+	h.Set("Vary", "Authorization, X-Forwarded-Method, X-Forwarded-Uri, X-Original-Method, X-Original-URI")
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// forwardedRequest returns the method and the cleaned path of the request a
+// proxy asks to authenticate, not ok if the path can not be
+// matched safely.
+func forwardedRequest(r *http.Request) (method, cleanPath string, ok bool) {
+	method = firstHeader(r, "X-Forwarded-Method", "X-Original-Method")
+	if method == "" {
+		method = r.Method
+	}
+
+	uri := firstHeader(r, "X-Forwarded-Uri", "X-Original-URI")
+	if uri == "" {
+		uri = mux.Vars(r)["path"]
+	}
+
+	cleanPath, ok = cleanForwardedPath(uri)
+	return strings.ToUpper(method), cleanPath, ok
+}
+
+// cleanForwardedPath returns the decoded path of a forwarded URI with
+// repeated slashes collapsed, not ok if the URI is invalid or the path has
+// "." or ".." segments, which are checked after decoding so "%2e%2e" is
+// refused too.
+func cleanForwardedPath(uri string) (string, bool) {
+	if uri == "" {
+		return "/", true
+	}
+
+	u, err := url.ParseRequestURI(uri)
+	if err != nil {
+		return "", false
+	}
+	if u.Path == "" {
+		return "/", true
+	}
+
+	for _, segment := range strings.Split(u.Path, "/") {
+		if segment == "." || segment == ".." {
+			return "", false
+		}
+	}
+
+	cleaned := path.Clean("/" + u.Path)
+	// path.Clean drops the trailing slash, which "/admin/" policies rely on
+	// to not match "/administrators".
+	if strings.HasSuffix(u.Path, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, true
+}
+
+func firstHeader(r *http.Request, names ...string) string {
+	for _, name := range names {
+		if value := r.Header.Get(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// parseForwardAuthPolicies parses policies keyed by "[METHOD ]<path prefix>",
+// ordered so that the first matching one is the most specific.
+func parseForwardAuthPolicies(policies map[string][]string) []forwardAuthPolicy {
+	parsed := make([]forwardAuthPolicy, 0, len(policies))
+	for key, userTypes := range policies {
+		policy := forwardAuthPolicy{pathPrefix: key}
+		if method, prefix, ok := strings.Cut(key, " "); ok {
+			policy.method = strings.ToUpper(method)
+			policy.pathPrefix = strings.TrimSpace(prefix)
+		}
+
+		for _, userType := range userTypes {
+			if userType == anonymousUserType {
+				policy.anonymous = true
+			} else {
+				policy.userTypes = append(policy.userTypes, userType)
+			}
+		}
+
+		parsed = append(parsed, policy)
+	}
+
+	sort.Slice(parsed, func(i, j int) bool {
+		if len(parsed[i].pathPrefix) != len(parsed[j].pathPrefix) {
+			return len(parsed[i].pathPrefix) > len(parsed[j].pathPrefix)
+		}
+		return parsed[i].method > parsed[j].method
+	})
+
+	return parsed
+}
+
+// matchForwardAuthPolicy returns the policy of a request, nil if there is
+// none.
+func matchForwardAuthPolicy(policies []forwardAuthPolicy, method, path string) *forwardAuthPolicy {
+	for i, policy := range policies {
+		if policy.method != "" && policy.method != method {
+			continue
+		}
+		if strings.HasPrefix(path, policy.pathPrefix) {
+			return &policies[i]
+		}
+	}
+	return nil
+}
+
+// anyOf tells whether any of values is one of allowed.
+func anyOf(values, allowed []string) bool {
+	for _, value := range values {
+		for _, a := range allowed {
+			if value == a {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,174 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// fakeIdentities is a user.TokenIdentityFetcher over a map of tokens.
+type fakeIdentities map[string]*user.TokenIdentity
+
+func (f fakeIdentities) GetTokenIdentity(ctx context.Context, token string) (*user.TokenIdentity, error) {
+	return f[token], nil
+}
+
+func TestMatchForwardAuthPolicy(t *testing.T) {
+	policies := parseForwardAuthPolicies(map[string][]string{
+		"/admin/":       {"staff"},
+		"/admin/users/": {"staff", "support"},
+		"POST /orders":  {"client", "business"},
+		"/orders":       {"client"},
+		"GET /public/":  {anonymousUserType},
+	})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantPrefix string
+		wantMethod string
+	}{
+		{"prefix", "GET", "/admin/settings", "/admin/", ""},
+		{"longest prefix", "GET", "/admin/users/1", "/admin/users/", ""},
+		{"method", "POST", "/orders/1", "/orders", "POST"},
+		{"other method", "GET", "/orders/1", "/orders", ""},
+		{"anonymous", "GET", "/public/logo.png", "/public/", "GET"},
+		{"anonymous for another method", "POST", "/public/logo.png", "", ""},
+		{"no policy", "GET", "/trips", "", ""},
+		{"prefix with trailing slash", "GET", "/administrators", "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy := matchForwardAuthPolicy(policies, tt.method, tt.path)
+			if tt.wantPrefix == "" {
+				if policy != nil {
+					t.Errorf("matchForwardAuthPolicy() = %+v, want nil", *policy)
+				}
+				return
+			}
+
+			if policy == nil {
+				t.Fatalf("matchForwardAuthPolicy() = nil, want %s %s", tt.wantMethod, tt.wantPrefix)
+			}
+			if policy.pathPrefix != tt.wantPrefix || policy.method != tt.wantMethod {
+				t.Errorf("matchForwardAuthPolicy() = %s %s, want %s %s", policy.method, policy.pathPrefix, tt.wantMethod, tt.wantPrefix)
+			}
+		})
+	}
+}
+
+func TestParseForwardAuthPolicies(t *testing.T) {
+	policies := parseForwardAuthPolicies(map[string][]string{
+		"get /public/": {anonymousUserType, "client"},
+	})
+
+	if len(policies) != 1 {
+		t.Fatalf("parseForwardAuthPolicies() = %d policies, want 1", len(policies))
+	}
+
+	policy := policies[0]
+	if policy.method != "GET" || policy.pathPrefix != "/public/" {
+		t.Errorf("policy = %s %s, want GET /public/", policy.method, policy.pathPrefix)
+	}
+	if !policy.anonymous {
+		t.Errorf("policy.anonymous = false, want true")
+	}
+	if len(policy.userTypes) != 1 || policy.userTypes[0] != "client" {
+		t.Errorf("policy.userTypes = %v, want [client]", policy.userTypes)
+	}
+}
+
+func TestCleanForwardedPath(t *testing.T) {
+	tests := []struct {
+		uri    string
+		want   string
+		wantOK bool
+	}{
+		{"", "/", true},
+		{"/admin/users?page=2", "/admin/users", true},
+		{"/admin/", "/admin/", true},
+		{"//admin/", "/admin/", true},
+		{"/public//../admin/", "", false},
+		{"/public/../admin/x", "", false},
+		{"/public/./logo.png", "", false},
+		{"/public/%2e%2e/admin/x", "", false},
+		{"/public/%2E%2E/admin/x", "", false},
+		{"/public/..foo", "/public/..foo", true},
+		{"/%61dmin/", "/admin/", true},
+		{"/admin/%zz", "", false},
+		{"admin/", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.uri, func(t *testing.T) {
+			got, ok := cleanForwardedPath(tt.uri)
+			if got != tt.want || ok != tt.wantOK {
+				t.Errorf("cleanForwardedPath() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestForwardAuth(t *testing.T) {
+	db := fakeIdentities{
+		"staff-token": {
+			UserID:        "staff",
+			AuthUserType:  "staff",
+			AuthUserTypes: []string{"client", "staff"},
+			AuthMethods:   []string{"email", "sms"},
+		},
+		"client-token": {
+			UserID:        "client",
+			AuthUserType:  "client",
+			AuthUserTypes: []string{"client", "staff"},
+			AuthMethods:   []string{"sms"},
+		},
+	}
+	h := ForwardAuth(db, map[string][]string{
+		"/admin/":      {"staff"},
+		"GET /public/": {anonymousUserType},
+	}, 0)
+
+	tests := []struct {
+		name         string
+		token        string
+		uri          string
+		wantStatus   int
+		wantUserType string
+	}{
+		{"token of the type", "staff-token", "/admin/users", http.StatusOK, "staff"},
+		{"token of another type of the user", "client-token", "/admin/users", http.StatusForbidden, ""},
+		{"no policy", "client-token", "/trips", http.StatusOK, "client"},
+		{"unknown token", "unknown", "/trips", http.StatusUnauthorized, ""},
+		{"anonymous", "", "/public/logo.png", http.StatusOK, ""},
+		{"no token", "", "/trips", http.StatusUnauthorized, ""},
+		{"dot-segments out of an anonymous policy", "", "/public/../admin/users", http.StatusForbidden, ""},
+		{"encoded dot-segments", "client-token", "/public/%2e%2e/admin/users", http.StatusForbidden, ""},
+		{"repeated slashes", "client-token", "//admin/users", http.StatusForbidden, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/forward-auth", nil)
+			r.Header.Set("X-Original-Method", http.MethodGet)
+			r.Header.Set("X-Original-URI", tt.uri)
+			if tt.token != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if got := w.Header().Get("X-User-Type"); got != tt.wantUserType {
+				t.Errorf("X-User-Type = %q, want %q", got, tt.wantUserType)
+			}
+		})
+	}
+}
@@ -0,0 +1,335 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// Logins is a handler that lists the logins linked to the authenticated user.
+//
+//	GET /api/v1/logins
+//	Responds: 200, 401, 500
+func Logins(
+	db user.LoginsFetcher,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		logins, err := db.FetchLogins(ctx, middleware.UserIDFromContext(ctx))
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error fetching logins in logins handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		respondJSON(w, r, http.StatusOK, struct {
+			Logins []user.Login `json:"logins"`
+		}{
+			Logins: logins,
+		})
+	}
+}
+
+// LinkLogin is a handler that starts linking a new login to the
+// authenticated user by sending a verification code to the login.
+//
+//	POST /api/v1/logins
+//	Responds: 202, 400, 401, 409, 500
+//	Body:
+//		type LinkLoginPayload struct {
+//			Login        string `json:"login"`
+//			AuthMethod   string `json:"auth_method"`
+//			AuthUserType string `json:"auth_user_type,omitempty"`
+//		}
+//
+// The login gets the auth user type of the user's logins, or AuthUserType,
+// one of them, which is required if the user has logins of several types.
+// The login is linked once the code is sent to POST /api/v1/logins/verify.
+// The auth method must be one of authMethods whose logins can receive the
+// code, so subjects of external identity providers can not be linked.
+func LinkLogin(
+	db user.LoginLinker,
+	codes user.CodeIssuer,
+	sender user.CodeSender,
+	authMethods []string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := middleware.UserIDFromContext(ctx)
+
+		var payload user.LinkLoginPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Login == "" {
+			handleError(
+				w,
+				r,
+				user.ErrValidationFailed.Wrap(fmt.Errorf("error decoding link login payload: %v", err)),
+				http.StatusBadRequest,
+				true,
+			)
+			return
+		}
+
+		login, err := newLinkedLogin(ctx, db, userID, payload.Login, payload.AuthMethod, payload.AuthUserType, authMethods)
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		code, err := codes.IssueCode(ctx, linkCodeKey(userID, login))
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error issuing code in link login handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		if err := sender.SendCode(ctx, user.VerificationPurposeLinkLogin, userID, login, code); err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error sending code in link login handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		respondJSON(w, r, http.StatusAccepted, login)
+	}
+}
+
+// VerifyLinkLogin is a handler that links a login to the authenticated
+// user given the verification code sent by LinkLogin.
+//
+//	POST /api/v1/logins/verify
+//	Responds: 201, 400, 401, 409, 500
+//	Body:
+//		type VerifyLinkLoginPayload struct {
+//			Login        string `json:"login"`
+//			AuthMethod   string `json:"auth_method"`
+//			AuthUserType string `json:"auth_user_type,omitempty"`
+//			Code         string `json:"code"`
+//		}
+func VerifyLinkLogin(
+	db user.LoginLinker,
+	codes user.CodeIssuer,
+	authMethods []string,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := middleware.UserIDFromContext(ctx)
+
+		var payload user.VerifyLinkLoginPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil || payload.Login == "" {
+			handleError(
+				w,
+				r,
+				user.ErrValidationFailed.Wrap(fmt.Errorf("error decoding verify link login payload: %v", err)),
+				http.StatusBadRequest,
+				true,
+			)
+			return
+		}
+
+		login, err := newLinkedLogin(ctx, db, userID, payload.Login, payload.AuthMethod, payload.AuthUserType, authMethods)
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		ok, err := codes.CheckCode(ctx, linkCodeKey(userID, login), payload.Code)
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error checking code in verify link login handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		if !ok {
+			middleware.FailedAttempt(ctx)
+			handleError(w, r, user.ErrInvalidCode, http.StatusInternalServerError, false)
+			return
+		}
+
+		if err := db.LinkLogin(ctx, userID, login); err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		login.Verified = true
+		respondJSON(w, r, http.StatusCreated, login)
+	}
+}
+
+// UnlinkLogin is a handler that removes a login from the authenticated user.
+// The last login of a user can not be removed.
+//
+//	DELETE /api/v1/logins
+//	Responds: 204, 400, 401, 404, 409, 500
+//	Query Parameters:
+//		login: The login to unlink
+//		auth_user_type: The auth user type of the login, required only if
+//			the user has the login with several auth user types
+func UnlinkLogin(
+	db user.LoginLinker,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		userID := middleware.UserIDFromContext(ctx)
+		query := r.URL.Query()
+
+		login, err := unlinkedLogin(ctx, db, userID, query.Get("login"), query.Get("auth_user_type"))
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		if err := db.UnlinkLogin(ctx, userID, login.Login, login.AuthUserType); err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// unlinkedLogin finds the login to unlink or replace among the logins of the
+// user, as typed or normalized by the auth method of each. Without authUserType the
+// login must be linked with a single auth user type.
+func unlinkedLogin(
+	ctx context.Context,
+	db user.LoginLinker,
+	userID, login, authUserType string,
+) (user.Login, error) {
+	logins, err := db.FetchLogins(ctx, userID)
+	if err != nil {
+		return user.Login{}, err
+	}
+
+	var matches []user.Login
+	for _, l := range logins {
+		if l.Login != login && l.Login != db.NormalizeLogin(login, l.AuthMethod) {
+			continue
+		}
+		if authUserType == "" || l.AuthUserType == authUserType {
+			matches = append(matches, l)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return user.Login{}, user.ErrLoginNotFound
+	case 1:
+		return matches[0], nil
+	default:
+		return user.Login{}, user.ErrValidationFailed.WithFields(map[string]string{
+			"auth_user_type": "is required, the login is linked with several auth user types",
+		})
+	}
+}
+
+// newLinkedLogin builds the normalized login to link to the user and makes
+// sure it is not linked to anyone yet and can be verified with a code. The
+// login gets authUserType, which must be the auth user type of one of the
+// user's logins; without it the user's logins must all be of one type.
+func newLinkedLogin(
+	ctx context.Context,
+	db user.LoginLinker,
+	userID, login, authMethod, authUserType string,
+	authMethods []string,
+) (user.Login, error) {
+	if err := user.ValidateLinkAuthMethod(authMethod, authMethods); err != nil {
+		return user.Login{}, err
+	}
+
+	logins, err := db.FetchLogins(ctx, userID)
+	if err != nil {
+		return user.Login{}, err
+	}
+
+	if len(logins) == 0 {
+		return user.Login{}, fmt.Errorf("user %s has no logins", userID)
+	}
+
+	authUserType, err = linkedAuthUserType(logins, authUserType)
+	if err != nil {
+		return user.Login{}, err
+	}
+
+	login = db.NormalizeLogin(login, authMethod)
+
+	linked := user.Login{
+		Login:        login,
+		Type:         user.ClassifyLogin(login, authMethod),
+		AuthUserType: authUserType,
+		AuthMethod:   authMethod,
+	}
+
+	owner, err := db.GetUserIDByLogin(ctx, linked.Login, linked.AuthUserType)
+	if err != nil {
+		return user.Login{}, err
+	}
+
+	switch owner {
+	case "":
+		if err := db.CheckLoginAvailable(ctx, linked.Login, linked.AuthUserType); err != nil {
+			return user.Login{}, err
+		}
+		return linked, nil
+	case userID:
+		return user.Login{}, user.ErrLoginAlreadyLinked
+	default:
+		return user.Login{}, user.ErrLoginTaken
+	}
+}
+
+// linkedAuthUserType returns the auth user type of a login linked to the
+// user of logins: authUserType if one of the logins has it, or the type of
+// all the logins.
+func linkedAuthUserType(logins []user.Login, authUserType string) (string, error) {
+	if authUserType != "" {
+		for _, l := range logins {
+			if l.AuthUserType == authUserType {
+				return authUserType, nil
+			}
+		}
+		return "", user.ErrValidationFailed.WithFields(map[string]string{
+			"auth_user_type": "must be the auth user type of one of the user's logins",
+		})
+	}
+
+	for _, l := range logins[1:] {
+		if l.AuthUserType != logins[0].AuthUserType {
+			return "", user.ErrValidationFailed.WithFields(map[string]string{
+				"auth_user_type": "is required, the user has logins of several auth user types",
+			})
+		}
+	}
+	return logins[0].AuthUserType, nil
+}
+
+func linkCodeKey(userID string, login user.Login) string {
+	return codeKey(user.VerificationPurposeLinkLogin, userID, login.Login)
+}
+
+func codeKey(purpose user.VerificationPurpose, userID, login string) string {
+	return fmt.Sprintf("%s:%s:%s", purpose, userID, login)
+}
@@ -0,0 +1,120 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// fakeLinker is a user.LoginLinker over the logins of a single user.
+type fakeLinker struct {
+	user.LoginLinker
+	logins []user.Login
+}
+
+func (f *fakeLinker) NormalizeLogin(login, authMethod string) string {
+	return user.Normalizer{DefaultRegion: "KZ"}.Normalize(login, authMethod)
+}
+
+func (f *fakeLinker) FetchLogins(ctx context.Context, userID string) ([]user.Login, error) {
+	return f.logins, nil
+}
+
+func TestUnlinkedLogin(t *testing.T) {
+	db := &fakeLinker{logins: []user.Login{
+		{Login: "+77011234567", AuthUserType: "client", AuthMethod: "sms"},
+		{Login: "+77011234567", AuthUserType: "driver", AuthMethod: "sms"},
+		{Login: "jane@example.com", AuthUserType: "client", AuthMethod: "email"},
+	}}
+
+	tests := []struct {
+		name         string
+		login        string
+		authUserType string
+		want         user.Login
+		wantErr      error
+	}{
+		{
+			name:  "as stored",
+			login: "jane@example.com",
+			want:  db.logins[2],
+		},
+		{
+			name:  "as typed",
+			login: " Jane@Example.com",
+			want:  db.logins[2],
+		},
+		{
+			name:         "with auth user type",
+			login:        "8 701 123 45 67",
+			authUserType: "driver",
+			want:         db.logins[1],
+		},
+		{
+			name:    "several auth user types",
+			login:   "+77011234567",
+			wantErr: user.ErrValidationFailed,
+		},
+		{
+			name:         "other auth user type",
+			login:        "jane@example.com",
+			authUserType: "driver",
+			wantErr:      user.ErrLoginNotFound,
+		},
+		{
+			name:    "not linked",
+			login:   "john@example.com",
+			wantErr: user.ErrLoginNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := unlinkedLogin(context.Background(), db, "user", tt.login, tt.authUserType)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("unlinkedLogin() error = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unlinkedLogin() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("unlinkedLogin() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLinkedAuthUserType(t *testing.T) {
+	client := user.Login{Login: "+77011234567", AuthUserType: "client", AuthMethod: "sms"}
+	driver := user.Login{Login: "+77011234567", AuthUserType: "driver", AuthMethod: "sms"}
+
+	tests := []struct {
+		name         string
+		logins       []user.Login
+		authUserType string
+		want         string
+		wantErr      error
+	}{
+		{"single type", []user.Login{client}, "", "client", nil},
+		{"type of a login", []user.Login{client, driver}, "driver", "driver", nil},
+		{"several types", []user.Login{driver, client}, "", "", user.ErrValidationFailed},
+		{"type of no login", []user.Login{client}, "driver", "", user.ErrValidationFailed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := linkedAuthUserType(tt.logins, tt.authUserType)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("linkedAuthUserType() error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("linkedAuthUserType() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -2,63 +2,76 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
 	"gitlab.com/route-kz/auth-api/user"
 )
 
 // CreateToken is a handler that creates tokens identifying the user.
 //
 //	POST /api/v1/tokens
-//	Responds: 200, 400, 500
+//	Responds: 200, 400, 403, 409, 413, 429, 500
 //	Body:
-//		type createTokenPayload struct {
-//			AuthUserType    string `json:"userType"`
-//			AuthCode    string `json:"authCode"`
-//			Login string `json:"objectId"`
+//		type CreateTokenPayload struct {
+//			Login        string `json:"login"`
+//			AuthUserType string `json:"auth_user_type"`
+//			AuthCode     string `json:"auth_code"`
+//			AuthMethod   string `json:"auth_method"`
 //		}
 //
 // The handler will get the data about the user and create a token
 // that can be exchanged to get data about the user. It will also
 // create a user id for the user if it does not exist.
+//
+// Bodies larger than maxBytes or with unknown fields are rejected. Invalid
+// fields are reported one by one in the fields of the error.
+//
+// With codes the auth code must be the one CreateLoginCode sent to the
+// login; a wrong one is a failed attempt towards a lockout of the login.
+// Without them the auth code is not checked.
+//
+// Publishes user.created for new users, user.login and token.issued.
 func CreateToken(
 	db user.IDFetcherTokenCreator,
+	codes user.CodeIssuer,
+	authUserTypes, authMethods []string,
+	maxBytes int64,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		// Decode request body
+		// Decode and validate request body
 		var payload user.CreateTokenPayload
-		err := json.NewDecoder(r.Body).Decode(&payload)
-		if err != nil {
-			handleError(
-				w,
-				fmt.Errorf("error decoding create token payload: %w", err),
-				http.StatusBadRequest,
-				true,
-			)
+		if err := decodeJSON(w, r, &payload, maxBytes); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
 			return
 		}
 
-		// Get (or create, if this is a new user) the  user id for the user
-		userID, err := db.GetOrCreateUserID(ctx, payload)
-		if err != nil {
-			handleError(
-				w,
-				fmt.Errorf("error getting or creating a user id in create token handler: %w", err),
-				http.StatusInternalServerError,
-				true,
-			)
+		if err := payload.Validate(authUserTypes, authMethods); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
 			return
 		}
 
-		// Create token
-		token, err := db.CreateToken(ctx, userID)
+		if codes != nil {
+			err := user.CheckAuthCode(ctx, db, codes, payload)
+			if errors.Is(err, user.ErrInvalidCode) {
+				middleware.FailedAttempt(ctx)
+			}
+			if err != nil {
+				handleError(w, r, err, http.StatusInternalServerError, true)
+				return
+			}
+		}
+
+		token, err := user.IssueToken(ctx, db, payload)
 		if err != nil {
 			handleError(
 				w,
-				fmt.Errorf("error creating token in create token handler: %w", err),
+				r,
+				fmt.Errorf("error issuing token in create token handler: %w", err),
 				http.StatusInternalServerError,
 				true,
 			)
@@ -74,6 +87,7 @@ func CreateToken(
 		if err != nil {
 			handleError(
 				w,
+				r,
 				fmt.Errorf("error marshalling token in create token handler: %w", err),
 				http.StatusInternalServerError,
 				true,
@@ -86,42 +100,94 @@ func CreateToken(
 	}
 }
 
-// RefreshToken is a handler that refresh tokens
-func RefreshToken(
-	db user.TokenRefresher,
+// CreateLoginCode is a handler that sends an auth code to a login, for
+// CreateToken to check.
+//
+//	POST /api/v1/tokens/codes
+//	Responds: 202, 400, 413, 429, 500
+//	Body:
+//		type LoginCodePayload struct {
+//			Login        string `json:"login"`
+//			AuthUserType string `json:"auth_user_type"`
+//			AuthMethod   string `json:"auth_method"`
+//		}
+//
+// The code is sent whether or not a user has the login, so the response
+// tells nothing about it.
+func CreateLoginCode(
+	db user.LoginNormalizer,
+	codes user.CodeIssuer,
+	sender user.CodeSender,
+	authUserTypes, authMethods []string,
+	maxBytes int64,
 ) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 
-		token := r.URL.Query().Get("token")
-		// Get the user ID and remove token
-		userID, err := db.GetUserIDRemoveToken(ctx, token)
+		var payload user.LoginCodePayload
+		if err := decodeJSON(w, r, &payload, maxBytes); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
+			return
+		}
+
+		if err := payload.Validate(authUserTypes, authMethods); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
+			return
+		}
+
+		normalized := db.NormalizeLogin(payload.Login, payload.AuthMethod)
+		login := user.Login{
+			Login:        normalized,
+			Type:         user.ClassifyLogin(normalized, payload.AuthMethod),
+			AuthUserType: payload.AuthUserType,
+			AuthMethod:   payload.AuthMethod,
+		}
+
+		code, err := codes.IssueCode(ctx, user.LoginCodeKey(login.AuthUserType, login.Login))
 		if err != nil {
 			handleError(
 				w,
-				fmt.Errorf("error getting user id in refresh-token handler: %w", err),
+				r,
+				fmt.Errorf("error issuing code in create login code handler: %w", err),
 				http.StatusInternalServerError,
 				true,
 			)
 			return
 		}
 
-		if userID == "" {
+		if err := sender.SendCode(ctx, user.VerificationPurposeLogin, "", login, code); err != nil {
 			handleError(
 				w,
-				fmt.Errorf("invalid token"),
-				http.StatusBadRequest,
+				r,
+				fmt.Errorf("error sending code in create login code handler: %w", err),
+				http.StatusInternalServerError,
 				true,
 			)
 			return
 		}
 
-		// Create new token
-		newToken, err := db.CreateToken(ctx, userID)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// RefreshToken is a handler that refresh tokens. Publishes token.refreshed.
+func RefreshToken(
+	db user.TokenRefresher,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		token := r.URL.Query().Get("token")
+
+		newToken, err := user.RefreshToken(ctx, db, token)
+		if errors.Is(err, user.ErrInvalidToken) {
+			middleware.FailedAttempt(ctx)
+		}
 		if err != nil {
 			handleError(
 				w,
-				fmt.Errorf("error creating token in create refresh-token handler: %w", err),
+				r,
+				fmt.Errorf("error refreshing token in refresh-token handler: %w", err),
 				http.StatusInternalServerError,
 				true,
 			)
@@ -137,6 +203,7 @@ func RefreshToken(
 		if err != nil {
 			handleError(
 				w,
+				r,
 				fmt.Errorf("error marshalling token in refresh-token handler: %w", err),
 				http.StatusInternalServerError,
 				true,
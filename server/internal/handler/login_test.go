@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/config"
+	"gitlab.com/route-kz/auth-api/event"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// fakeTokens is a user.IDFetcherTokenCreator creating a token per call.
+type fakeTokens struct {
+	tokens []string
+}
+
+func (f *fakeTokens) NormalizeLogin(login, authMethod string) string {
+	return user.Normalizer{DefaultRegion: "KZ"}.Normalize(login, authMethod)
+}
+
+func (f *fakeTokens) GetOrCreateUserID(ctx context.Context, payload user.CreateTokenPayload) (string, bool, error) {
+	return "user", false, nil
+}
+
+func (f *fakeTokens) CreateToken(ctx context.Context, userID, authUserType string, events ...event.Event) (string, error) {
+	f.tokens = append(f.tokens, userID+"/"+authUserType)
+	return "token", nil
+}
+
+// fakeLimiter is a middleware.Limiter allowing everything and recording the
+// failed attempts.
+type fakeLimiter struct {
+	failures []string
+}
+
+func (l *fakeLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+func (l *fakeLimiter) LockedOut(ctx context.Context, key string) (time.Duration, error) {
+	return 0, nil
+}
+
+func (l *fakeLimiter) RecordFailure(ctx context.Context, key string, threshold int, failureWindow, base, max time.Duration) (time.Duration, error) {
+	l.failures = append(l.failures, key)
+	return 0, nil
+}
+
+func (l *fakeLimiter) ResetFailures(ctx context.Context, key string) error {
+	return nil
+}
+
+// issuedCodes is a fakeCodes storing the codes it issues.
+type issuedCodes struct {
+	*fakeCodes
+}
+
+func (c *issuedCodes) IssueCode(ctx context.Context, key string) (string, error) {
+	c.valid[key] = "1234"
+	return "1234", nil
+}
+
+func TestCreateToken(t *testing.T) {
+	key := user.LoginCodeKey("client", "+77011234567")
+
+	tests := []struct {
+		name         string
+		requireCode  bool
+		body         string
+		wantStatus   int
+		wantFailures int
+	}{
+		{
+			name:       "code not required",
+			body:       `{"login": "+77011234567", "auth_user_type": "client"}`,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:        "code sent to the login",
+			requireCode: true,
+			body:        `{"login": "8 701 123 45 67", "auth_user_type": "client", "auth_code": "1234"}`,
+			wantStatus:  http.StatusOK,
+		},
+		{
+			name:         "wrong code",
+			requireCode:  true,
+			body:         `{"login": "+77011234567", "auth_user_type": "client", "auth_code": "0000"}`,
+			wantStatus:   http.StatusBadRequest,
+			wantFailures: 1,
+		},
+		{
+			name:         "code sent for another auth user type",
+			requireCode:  true,
+			body:         `{"login": "+77011234567", "auth_user_type": "driver", "auth_code": "1234"}`,
+			wantStatus:   http.StatusBadRequest,
+			wantFailures: 1,
+		},
+		{
+			name:        "missing code",
+			requireCode: true,
+			body:        `{"login": "+77011234567", "auth_user_type": "client"}`,
+			wantStatus:  http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeTokens{}
+			var codes user.CodeIssuer
+			if tt.requireCode {
+				codes = &fakeCodes{valid: map[string]string{key: "1234"}}
+			}
+			limiter := &fakeLimiter{}
+			rl := &middleware.RateLimit{Limiter: limiter, Config: &config.Config{PhoneDefaultRegion: "KZ"}}
+
+			router := mux.NewRouter()
+			router.Handle("/api/v1/tokens", rl.Middleware(CreateToken(db, codes, nil, nil, 1<<10))).Name("CreateToken")
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if len(limiter.failures) != tt.wantFailures {
+				t.Errorf("failures = %v, want %d", limiter.failures, tt.wantFailures)
+			}
+
+			wantTokens := 0
+			if tt.wantStatus == http.StatusOK {
+				wantTokens = 1
+			}
+			if len(db.tokens) != wantTokens {
+				t.Errorf("tokens = %v, want %d", db.tokens, wantTokens)
+			}
+		})
+	}
+}
+
+func TestCreateLoginCode(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantKey    string
+	}{
+		{
+			name:       "phone",
+			body:       `{"login": "8 701 123 45 67", "auth_user_type": "client", "auth_method": "sms"}`,
+			wantStatus: http.StatusAccepted,
+			wantKey:    user.LoginCodeKey("client", "+77011234567"),
+		},
+		{
+			name:       "unknown auth user type",
+			body:       `{"login": "+77011234567", "auth_user_type": "staff"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "auth code",
+			body:       `{"login": "+77011234567", "auth_user_type": "client", "auth_code": "1234"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			codes := &fakeCodes{valid: map[string]string{}}
+			sender := &fakeSender{}
+			h := CreateLoginCode(&fakeTokens{}, &issuedCodes{fakeCodes: codes}, sender, []string{"client", "driver"}, nil, 1<<10)
+
+			r := httptest.NewRequest(http.MethodPost, "/api/v1/tokens/codes", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantKey == "" {
+				if len(sender.sent) > 0 {
+					t.Errorf("sent %v, want nothing", sender.sent)
+				}
+				return
+			}
+
+			if _, ok := codes.valid[tt.wantKey]; !ok {
+				t.Errorf("codes = %v, want one for %s", codes.valid, tt.wantKey)
+			}
+			if len(sender.sent) != 1 || sender.sent[0] != user.VerificationPurposeLogin {
+				t.Errorf("sent %v, want a login code", sender.sent)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package handler
+
+import "net/http"
+
+// OpenAPI is a handler that serves the OpenAPI document of the API.
+//
+//	GET /openapi.json
+//	Responds: 200
+func OpenAPI(document []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(document)
+	}
+}
@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/server/internal/openapi"
+	"gitlab.com/route-kz/auth-api/user"
+	"gitlab.com/route-kz/auth-api/webhook"
+)
+
+func TestRequestBodiesMatchPayloads(t *testing.T) {
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	// The payloads the handlers decode the request bodies into, by route.
+	payloads := map[string]interface{}{
+		"CreateToken":       user.CreateTokenPayload{},
+		"CreateLoginCode":   user.LoginCodePayload{},
+		"IdentityBatch":     identityBatchPayload{},
+		"PersonalDataBatch": personalDataBatchPayload{},
+		"CreateWebhook":     webhook.CreatePayload{},
+		"LinkLogin":         user.LinkLoginPayload{},
+		"VerifyLinkLogin":   user.VerifyLinkLoginPayload{},
+		"ChangeLogin":       user.ChangeLoginPayload{},
+		"VerifyChangeLogin": user.VerifyChangeLoginPayload{},
+	}
+
+	for _, op := range spec.Operations() {
+		if _, ok := payloads[op.ID]; !ok && spec.RequestSchema(op) != nil {
+			t.Errorf("operation %s has a request body, but no payload to check it against", op.ID)
+		}
+	}
+
+	for route, payload := range payloads {
+		t.Run(route, func(t *testing.T) {
+			op := spec.Operation(route)
+			if op == nil {
+				t.Fatalf("Operation(%q) = nil", route)
+			}
+
+			schema := spec.RequestSchema(op)
+			if schema == nil {
+				t.Fatalf("RequestSchema() = nil")
+			}
+
+			properties := make([]string, 0, len(schema.Properties))
+			for name := range schema.Properties {
+				properties = append(properties, name)
+			}
+			sort.Strings(properties)
+
+			fields := make([]string, 0, len(properties))
+			for name := range jsonFields(reflect.TypeOf(payload)) {
+				fields = append(fields, name)
+			}
+			sort.Strings(fields)
+
+			if !reflect.DeepEqual(properties, fields) {
+				t.Errorf("schema properties = %v, payload fields = %v", properties, fields)
+			}
+
+			for _, name := range schema.Required {
+				if _, ok := schema.Properties[name]; !ok {
+					t.Errorf("required property %s is not a property", name)
+				}
+			}
+		})
+	}
+}
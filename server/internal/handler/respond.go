@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// respondJSON marshals body and writes it with the status code.
+func respondJSON(w http.ResponseWriter, r *http.Request, statusCode int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		handleError(
+			w,
+			r,
+			fmt.Errorf("error marshalling response: %w", err),
+			http.StatusInternalServerError,
+			true,
+		)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(response)
+}
+
+var errTrailingData = errors.New("unexpected data after the JSON value")
+
+// unknownFieldsError is returned for the fields of a request body that the
+// payload has no field for.
+type unknownFieldsError struct {
+	fields []string
+}
+
+func (e *unknownFieldsError) Error() string {
+	return "unknown fields " + strings.Join(e.fields, ", ")
+}
+
+// decodeJSON decodes a request body of at most maxBytes into v, rejecting
+// unknown fields and anything after the JSON value.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}, maxBytes int64) error {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes))
+
+	var body json.RawMessage
+	err := decoder.Decode(&body)
+	if err == nil && decoder.More() {
+		err = errTrailingData
+	}
+	if err == nil {
+		err = json.Unmarshal(body, v)
+	}
+	if err == nil {
+		err = checkFields(body, v)
+	}
+
+	if err == nil {
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &user.Error{
+			Code:    user.CodeRequestTooLarge,
+			Message: fmt.Sprintf("request body must be at most %d bytes", maxBytes),
+			Err:     err,
+		}
+	}
+
+	return decodeError(err)
+}
+
+// decodeError describes a JSON decoding error without echoing the body back.
+func decodeError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var unknownErr *unknownFieldsError
+
+	validationErr := &user.Error{Code: user.CodeValidationFailed, Err: err}
+
+	switch {
+	case errors.As(err, &syntaxErr):
+		validationErr.Message = fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		validationErr.Message = user.ErrValidationFailed.Message
+		validationErr.Fields = map[string]string{typeErr.Field: "must be a " + typeErr.Type.String()}
+	case errors.Is(err, errTrailingData):
+		validationErr.Message = "request body must be a single JSON value"
+	case errors.Is(err, io.EOF):
+		validationErr.Message = "request body is empty"
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		validationErr.Message = "malformed JSON"
+	case errors.As(err, &unknownErr):
+		validationErr.Message = user.ErrValidationFailed.Message
+		validationErr.Fields = make(map[string]string, len(unknownErr.fields))
+		for _, field := range unknownErr.fields {
+			validationErr.Fields[field] = "is not allowed"
+		}
+	default:
+		validationErr.Message = "request body is not valid"
+	}
+
+	return validationErr
+}
+
+// checkFields returns an unknownFieldsError if the JSON object body has
+// fields the struct v points to has not. Like encoding/json, it matches
+// field names case-insensitively.
+func checkFields(body json.RawMessage, v interface{}) error {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(body, &object); err != nil {
+		return nil
+	}
+
+	known := jsonFields(t)
+
+	var unknown []string
+	for name := range object {
+		if !known[strings.ToLower(name)] {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+
+	sort.Strings(unknown)
+	return &unknownFieldsError{fields: unknown}
+}
+
+// jsonFields returns the lower-cased JSON names of the fields of struct t,
+// including the ones of embedded structs.
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if f.Anonymous && name == "" {
+			ft := f.Type
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				for embedded := range jsonFields(ft) {
+					fields[embedded] = true
+				}
+				continue
+			}
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[strings.ToLower(name)] = true
+	}
+	return fields
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+func TestDecodeJSON(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        string
+		maxBytes    int64
+		want        user.CreateTokenPayload
+		wantCode    user.ErrorCode
+		wantMessage string
+		wantFields  map[string]string
+	}{
+		{
+			name: "valid",
+			body: `{"login":"jane@example.com","auth_user_type":"client"}`,
+			want: user.CreateTokenPayload{Login: "jane@example.com", AuthUserType: "client"},
+		},
+		{
+			name: "field names match case-insensitively",
+			body: `{"Login":"jane@example.com"}`,
+			want: user.CreateTokenPayload{Login: "jane@example.com"},
+		},
+		{
+			name:        "unknown fields",
+			body:        `{"login":"jane@example.com","password":"secret","admin":true}`,
+			wantCode:    user.CodeValidationFailed,
+			wantMessage: user.ErrValidationFailed.Message,
+			wantFields:  map[string]string{"admin": "is not allowed", "password": "is not allowed"},
+		},
+		{
+			name:        "wrong type",
+			body:        `{"login":42}`,
+			wantCode:    user.CodeValidationFailed,
+			wantMessage: user.ErrValidationFailed.Message,
+			wantFields:  map[string]string{"login": "must be a string"},
+		},
+		{
+			name:        "malformed",
+			body:        `{"login":}`,
+			wantCode:    user.CodeValidationFailed,
+			wantMessage: "malformed JSON at offset 10",
+		},
+		{
+			name:        "trailing data",
+			body:        `{"login":"jane@example.com"} {}`,
+			wantCode:    user.CodeValidationFailed,
+			wantMessage: "request body must be a single JSON value",
+		},
+		{
+			name:        "empty",
+			body:        ``,
+			wantCode:    user.CodeValidationFailed,
+			wantMessage: "request body is empty",
+		},
+		{
+			name:        "too large",
+			body:        `{"login":"jane@example.com"}`,
+			maxBytes:    8,
+			wantCode:    user.CodeRequestTooLarge,
+			wantMessage: "request body must be at most 8 bytes",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxBytes := tt.maxBytes
+			if maxBytes == 0 {
+				maxBytes = 1 << 10
+			}
+
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+
+			var got user.CreateTokenPayload
+			err := decodeJSON(w, r, &got, maxBytes)
+
+			if tt.wantCode == "" {
+				if err != nil {
+					t.Fatalf("decodeJSON() error = %v", err)
+				}
+				if got != tt.want {
+					t.Errorf("decodeJSON() = %+v, want %+v", got, tt.want)
+				}
+				return
+			}
+
+			var domainErr *user.Error
+			if !errors.As(err, &domainErr) {
+				t.Fatalf("decodeJSON() error = %v, want a *user.Error", err)
+			}
+			if domainErr.Code != tt.wantCode || domainErr.Message != tt.wantMessage {
+				t.Errorf("decodeJSON() error = %s %q, want %s %q", domainErr.Code, domainErr.Message, tt.wantCode, tt.wantMessage)
+			}
+			if !reflect.DeepEqual(domainErr.Fields, tt.wantFields) {
+				t.Errorf("decodeJSON() fields = %v, want %v", domainErr.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/opentracing/opentracing-go"
+	"github.com/opentracing/opentracing-go/ext"
+	otlog "github.com/opentracing/opentracing-go/log"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/scim"
+	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+)
+
+// CreateSCIMUser is a handler that provisions a user of the tenant.
+//
+//	POST /scim/v2/Users
+//	Responds: 201, 400, 401, 409, 413, 500
+//	Body: a SCIM User resource with at least userName
+func CreateSCIMUser(
+	db scim.Store,
+	maxBytes int64,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenant := middleware.SCIMTenantFromContext(ctx)
+
+		var resource scim.Resource
+		if err := decodeSCIM(w, r, &resource, maxBytes); err != nil {
+			handleSCIMError(w, r, err)
+			return
+		}
+
+		u, err := resource.User()
+		if err != nil {
+			handleSCIMError(w, r, err)
+			return
+		}
+
+		created, err := db.CreateSCIMUser(ctx, tenant, u)
+		if err != nil {
+			handleSCIMError(w, r, fmt.Errorf("error creating user in create scim user handler: %w", err))
+			return
+		}
+
+		location := scimUserLocation(r, created.ID)
+		w.Header().Set("Location", location)
+		respondSCIM(w, r, http.StatusCreated, created.Resource(location))
+	}
+}
+
+// SCIMUsers is a handler that lists the users of the tenant.
+//
+//	GET /scim/v2/Users
+//	Responds: 200, 400, 401, 500
+//	Query:
+//		filter: e.g. userName eq "jane@example.com", see scim.ParseFilter
+//		startIndex: 1-based index of the first user, default 1
+//		count: number of users, default scim.DefaultCount, at most scim.MaxCount
+func SCIMUsers(
+	db scim.Store,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenant := middleware.SCIMTenantFromContext(ctx)
+		query := r.URL.Query()
+
+		filter, err := scim.ParseFilter(query.Get("filter"))
+		if err != nil {
+			handleSCIMError(w, r, err)
+			return
+		}
+
+		// Out of range values are clamped, as RFC 7644 section 3.4.2.4 asks.
+		startIndex := queryInt(query.Get("startIndex"), 1)
+		if startIndex < 1 {
+			startIndex = 1
+		}
+		count := queryInt(query.Get("count"), scim.DefaultCount)
+		if count < 0 {
+			count = 0
+		}
+		if count > scim.MaxCount {
+			count = scim.MaxCount
+		}
+
+		users, total, err := db.SCIMUsers(ctx, tenant, filter, startIndex, count)
+		if err != nil {
+			handleSCIMError(w, r, fmt.Errorf("error listing users in scim users handler: %w", err))
+			return
+		}
+
+		resources := make([]scim.Resource, 0, len(users))
+		for i := range users {
+			resources = append(resources, users[i].Resource(scimUserLocation(r, users[i].ID)))
+		}
+
+		respondSCIM(w, r, http.StatusOK, scim.ListResponse{
+			Schemas:      []string{scim.SchemaListResponse},
+			TotalResults: total,
+			StartIndex:   startIndex,
+			ItemsPerPage: len(resources),
+			Resources:    resources,
+		})
+	}
+}
+
+// SCIMUser is a handler that gets a user of the tenant.
+//
+//	GET /scim/v2/Users/{id}
+//	Responds: 200, 401, 404, 500
+func SCIMUser(
+	db scim.Store,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenant := middleware.SCIMTenantFromContext(ctx)
+
+		u, err := db.SCIMUser(ctx, tenant, mux.Vars(r)["id"])
+		if err != nil {
+			handleSCIMError(w, r, fmt.Errorf("error getting user in scim user handler: %w", err))
+			return
+		}
+
+		respondSCIM(w, r, http.StatusOK, u.Resource(scimUserLocation(r, u.ID)))
+	}
+}
+
+// PatchSCIMUser is a handler that updates a user of the tenant. Setting
+// active to false deactivates the user: they are blocked and their tokens
+// are revoked.
+//
+//	PATCH /scim/v2/Users/{id}
+//	Responds: 200, 400, 401, 404, 409, 413, 500
+//	Body: a SCIM PatchOp message, see scim.PatchRequest
+func PatchSCIMUser(
+	db scim.Store,
+	maxBytes int64,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenant := middleware.SCIMTenantFromContext(ctx)
+
+		var patch scim.PatchRequest
+		if err := decodeSCIM(w, r, &patch, maxBytes); err != nil {
+			handleSCIMError(w, r, err)
+			return
+		}
+
+		updated, err := db.UpdateSCIMUser(ctx, tenant, mux.Vars(r)["id"], patch.Apply)
+		if err != nil {
+			handleSCIMError(w, r, fmt.Errorf("error updating user in patch scim user handler: %w", err))
+			return
+		}
+
+		respondSCIM(w, r, http.StatusOK, updated.Resource(scimUserLocation(r, updated.ID)))
+	}
+}
+
+// ReplaceSCIMUser is a handler that replaces a user of the tenant with the
+// resource sent: attributes it leaves out are cleared, and active is true
+// unless it says otherwise. Deactivating the user blocks them and revokes
+// their tokens, like PatchSCIMUser.
+//
+//	PUT /scim/v2/Users/{id}
+//	Responds: 200, 400, 401, 404, 409, 413, 500
+//	Body: a SCIM User resource with at least userName
+func ReplaceSCIMUser(
+	db scim.Store,
+	maxBytes int64,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenant := middleware.SCIMTenantFromContext(ctx)
+
+		var resource scim.Resource
+		if err := decodeSCIM(w, r, &resource, maxBytes); err != nil {
+			handleSCIMError(w, r, err)
+			return
+		}
+
+		u, err := resource.User()
+		if err != nil {
+			handleSCIMError(w, r, err)
+			return
+		}
+
+		updated, err := db.UpdateSCIMUser(ctx, tenant, mux.Vars(r)["id"], func(old *scim.User) error {
+			*old = u
+			return nil
+		})
+		if err != nil {
+			handleSCIMError(w, r, fmt.Errorf("error updating user in replace scim user handler: %w", err))
+			return
+		}
+
+		respondSCIM(w, r, http.StatusOK, updated.Resource(scimUserLocation(r, updated.ID)))
+	}
+}
+
+// DeleteSCIMUser is a handler that deletes a user of the tenant: they are
+// blocked, their tokens are revoked and the tenant no longer finds them, as
+// RFC 7644 section 3.6 asks. Their login stays taken, so they can not log in
+// again as a new user.
+//
+//	DELETE /scim/v2/Users/{id}
+//	Responds: 204, 401, 404, 500
+func DeleteSCIMUser(
+	db scim.Store,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		tenant := middleware.SCIMTenantFromContext(ctx)
+
+		if err := db.DeleteSCIMUser(ctx, tenant, mux.Vars(r)["id"]); err != nil {
+			handleSCIMError(w, r, fmt.Errorf("error deleting user in delete scim user handler: %w", err))
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// decodeSCIM decodes a request body of at most maxBytes into v. Unlike
+// decodeJSON it allows unknown fields, as directories send every attribute
+// they map.
+func decodeSCIM(w http.ResponseWriter, r *http.Request, v interface{}, maxBytes int64) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBytes)).Decode(v)
+	if err == nil {
+		return nil
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &scim.Error{
+			Status: http.StatusRequestEntityTooLarge,
+			Detail: fmt.Sprintf("request body must be at most %d bytes", maxBytes),
+			Err:    err,
+		}
+	}
+
+	return scim.InvalidSyntax("request body is not a valid SCIM message").Wrap(err)
+}
+
+// respondSCIM marshals body and writes it with the status code.
+func respondSCIM(w http.ResponseWriter, r *http.Request, statusCode int, body interface{}) {
+	response, err := json.Marshal(body)
+	if err != nil {
+		handleSCIMError(w, r, fmt.Errorf("error marshalling response: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(statusCode)
+	_, _ = w.Write(response)
+}
+
+// handleSCIMError responds with the SCIM error err is, or with a 500 for
+// any other error. Like handleError, the full error only goes to the logs
+// and the trace of the request.
+func handleSCIMError(w http.ResponseWriter, r *http.Request, err error) {
+	var scimErr *scim.Error
+	if !errors.As(err, &scimErr) {
+		scimErr = scim.ErrInternal.Wrap(err)
+	}
+
+	if scimErr.Status >= http.StatusInternalServerError {
+		log.WithField("status", scimErr.Status).Error(err.Error())
+	}
+
+	if span := opentracing.SpanFromContext(r.Context()); span != nil {
+		span.LogFields(otlog.Error(err))
+		if scimErr.Status >= http.StatusInternalServerError {
+			ext.Error.Set(span, true)
+		}
+	}
+
+	middleware.WriteSCIMError(w, scimErr)
+}
+
+// scimUserLocation returns the URL of a user, on the host and under the
+// path prefix the request was made to.
+func scimUserLocation(r *http.Request, id string) string {
+	scheme := "http"
+	if r.TLS != nil || strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https") {
+		scheme = "https"
+	}
+
+	host := r.Header.Get("X-Forwarded-Host")
+	if host == "" {
+		host = r.Host
+	}
+
+	path := r.URL.Path
+	if i := strings.LastIndex(path, "/Users"); i >= 0 {
+		path = path[:i+len("/Users")]
+	}
+
+	return scheme + "://" + host + path + "/" + id
+}
+
+// queryInt parses an integer query parameter, def if it is missing or not
+// an integer.
+func queryInt(value string, def int) int {
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return def
+	}
+	return i
+}
@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/scim"
+)
+
+// fakeSCIMStore is a scim.Store over the users of a single tenant. Deleted
+// users are kept, inactive, but not found.
+type fakeSCIMStore struct {
+	scim.Store
+	users   map[string]scim.User
+	deleted map[string]bool
+}
+
+func (f *fakeSCIMStore) SCIMUser(ctx context.Context, tenant *scim.Tenant, id string) (*scim.User, error) {
+	u, ok := f.users[id]
+	if !ok || f.deleted[id] {
+		return nil, scim.ErrNotFound
+	}
+	return &u, nil
+}
+
+func (f *fakeSCIMStore) UpdateSCIMUser(ctx context.Context, tenant *scim.Tenant, id string, update func(u *scim.User) error) (*scim.User, error) {
+	u, err := f.SCIMUser(ctx, tenant, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := update(u); err != nil {
+		return nil, err
+	}
+	u.ID = id
+	f.users[id] = *u
+	return u, nil
+}
+
+func (f *fakeSCIMStore) DeleteSCIMUser(ctx context.Context, tenant *scim.Tenant, id string) error {
+	u, err := f.SCIMUser(ctx, tenant, id)
+	if err != nil {
+		return err
+	}
+	u.Active = false
+	f.users[id] = *u
+	f.deleted[id] = true
+	return nil
+}
+
+func newSCIMRouter(db scim.Store) *mux.Router {
+	r := mux.NewRouter()
+	r.HandleFunc("/scim/v2/Users/{id}", SCIMUser(db)).Methods(http.MethodGet)
+	r.HandleFunc("/scim/v2/Users/{id}", PatchSCIMUser(db, 1<<20)).Methods(http.MethodPatch)
+	r.HandleFunc("/scim/v2/Users/{id}", ReplaceSCIMUser(db, 1<<20)).Methods(http.MethodPut)
+	r.HandleFunc("/scim/v2/Users/{id}", DeleteSCIMUser(db)).Methods(http.MethodDelete)
+	return r
+}
+
+func TestReplaceSCIMUser(t *testing.T) {
+	tests := []struct {
+		name       string
+		id         string
+		body       string
+		wantStatus int
+		want       scim.User
+	}{
+		{
+			name:       "replace",
+			id:         "1",
+			body:       `{"schemas": ["` + scim.SchemaUser + `"], "userName": "janet@acme.kz", "name": {"givenName": "Janet"}}`,
+			wantStatus: http.StatusOK,
+			want:       scim.User{ID: "1", UserName: "janet@acme.kz", GivenName: "Janet", Active: true},
+		},
+		{
+			name:       "deactivate",
+			id:         "1",
+			body:       `{"userName": "jane@acme.kz", "externalId": "00u1", "active": false}`,
+			wantStatus: http.StatusOK,
+			want:       scim.User{ID: "1", UserName: "jane@acme.kz", ExternalID: "00u1"},
+		},
+		{
+			name:       "missing userName",
+			id:         "1",
+			body:       `{"displayName": "Jane"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "unknown user",
+			id:         "2",
+			body:       `{"userName": "jane@acme.kz"}`,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &fakeSCIMStore{
+				users: map[string]scim.User{
+					"1": {ID: "1", UserName: "jane@acme.kz", ExternalID: "00u1", DisplayName: "Jane Doe", Active: true},
+				},
+				deleted: map[string]bool{},
+			}
+
+			r := httptest.NewRequest(http.MethodPut, "/scim/v2/Users/"+tt.id, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			newSCIMRouter(db).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if tt.wantStatus == http.StatusOK && db.users[tt.id] != tt.want {
+				t.Errorf("user = %+v, want %+v", db.users[tt.id], tt.want)
+			}
+		})
+	}
+}
+
+func TestDeleteSCIMUser(t *testing.T) {
+	db := &fakeSCIMStore{
+		users: map[string]scim.User{
+			"1": {ID: "1", UserName: "jane@acme.kz", Active: true},
+		},
+		deleted: map[string]bool{},
+	}
+
+	patch := `{"schemas": ["` + scim.SchemaPatchOp + `"], "Operations": [{"op": "replace", "path": "active", "value": true}]}`
+
+	tests := []struct {
+		name       string
+		method     string
+		id         string
+		body       string
+		wantStatus int
+	}{
+		{"delete", http.MethodDelete, "1", "", http.StatusNoContent},
+		{"get deleted", http.MethodGet, "1", "", http.StatusNotFound},
+		{"activate deleted", http.MethodPatch, "1", patch, http.StatusNotFound},
+		{"replace deleted", http.MethodPut, "1", `{"userName": "jane@acme.kz"}`, http.StatusNotFound},
+		{"delete again", http.MethodDelete, "1", "", http.StatusNotFound},
+		{"unknown user", http.MethodDelete, "2", "", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/scim/v2/Users/"+tt.id, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			newSCIMRouter(db).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+		})
+	}
+
+	if db.users["1"].Active {
+		t.Errorf("deleted user is active")
+	}
+}
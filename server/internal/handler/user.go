@@ -28,6 +28,7 @@ func Identity(
 		if err != nil {
 			handleError(
 				w,
+				r,
 				fmt.Errorf("error getting user id in identity handler: %w", err),
 				http.StatusInternalServerError,
 				true,
@@ -44,6 +45,7 @@ func Identity(
 		if err != nil {
 			handleError(
 				w,
+				r,
 				fmt.Errorf("error marshalling user id in identity handler: %w", err),
 				http.StatusInternalServerError,
 				true,
@@ -56,6 +58,66 @@ func Identity(
 	}
 }
 
+// TokenIdentity is a handler that gets the user of a token with the auth
+// user type the token was issued for and the auth user types and methods of
+// the user's logins, for services authorizing users by them without fetching
+// their personal data. The user id is empty
+// for unknown tokens, like in Identity.
+//
+//	GET /api/v1/tokens/identity
+//	Responds: 200, 500
+//	Query Parameters:
+//		token: The token to get the identity of
+func TokenIdentity(
+	db user.TokenIdentityFetcher,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		identity, err := db.GetTokenIdentity(ctx, r.URL.Query().Get("token"))
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error getting token identity in token identity handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		response := struct {
+			UserID        string   `json:"user_id"`
+			AuthUserType  string   `json:"auth_user_type"`
+			AuthUserTypes []string `json:"auth_user_types"`
+			AuthMethods   []string `json:"auth_methods"`
+		}{
+			AuthUserTypes: []string{},
+			AuthMethods:   []string{},
+		}
+		if identity != nil {
+			response.UserID = identity.UserID
+			response.AuthUserType = identity.AuthUserType
+			if identity.AuthUserTypes != nil {
+				response.AuthUserTypes = identity.AuthUserTypes
+			}
+			if identity.AuthMethods != nil {
+				response.AuthMethods = identity.AuthMethods
+			}
+		}
+
+		respondJSON(w, r, http.StatusOK, response)
+	}
+}
+
+// PersonalData is a handler that gets the personal data of a user: the
+// primary phone number and email and all logins linked to the user, each
+// with its type and verification status.
+//
+//	GET /api/v1/personal-data
+//	Responds: 200, 404, 500
+//	Query Parameters:
+//		userId: The id of the user
 func PersonalData(
 	db user.PersonalDataFetcher,
 ) http.HandlerFunc {
@@ -67,6 +129,7 @@ func PersonalData(
 		if err != nil {
 			handleError(
 				w,
+				r,
 				fmt.Errorf("error getting personal data: %w", err),
 				http.StatusInternalServerError,
 				true,
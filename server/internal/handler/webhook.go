@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/webhook"
+)
+
+// webhookDeliveriesLimit is how many deliveries WebhookDeliveries returns.
+const webhookDeliveriesLimit = 100
+
+// CreateWebhook is a handler that subscribes an endpoint to events.
+//
+//	POST /api/v1/webhooks
+//	Responds: 201, 400, 413, 500
+//	Body:
+//		type CreatePayload struct {
+//			Name       string   `json:"name"`
+//			URL        string   `json:"url"`
+//			EventTypes []string `json:"event_types"`
+//		}
+//
+// The response has the secret of the signatures, which is not shown again.
+func CreateWebhook(
+	db webhook.Manager,
+	allowInsecure bool,
+	maxBytes int64,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		var payload webhook.CreatePayload
+		if err := decodeJSON(w, r, &payload, maxBytes); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
+			return
+		}
+
+		if err := payload.Validate(allowInsecure); err != nil {
+			handleError(w, r, err, http.StatusBadRequest, false)
+			return
+		}
+
+		subscription, err := db.CreateWebhook(ctx, webhook.Subscription{
+			Name:       payload.Name,
+			URL:        payload.URL,
+			EventTypes: payload.EventTypes,
+		})
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error creating webhook in create webhook handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		respondJSON(w, r, http.StatusCreated, subscription)
+	}
+}
+
+// Webhooks is a handler that lists the subscriptions.
+//
+//	GET /api/v1/webhooks
+//	Responds: 200, 500
+func Webhooks(
+	db webhook.Manager,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		subscriptions, err := db.Webhooks(r.Context())
+		if err != nil {
+			handleError(
+				w,
+				r,
+				fmt.Errorf("error getting webhooks in webhooks handler: %w", err),
+				http.StatusInternalServerError,
+				true,
+			)
+			return
+		}
+
+		respondJSON(w, r, http.StatusOK, struct {
+			Webhooks []webhook.Subscription `json:"webhooks"`
+		}{
+			Webhooks: subscriptions,
+		})
+	}
+}
+
+// Webhook is a handler that gets a subscription.
+//
+//	GET /api/v1/webhooks/{id}
+//	Responds: 200, 404, 500
+func Webhook(
+	db webhook.Manager,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := webhookID(r)
+		if err != nil {
+			handleError(w, r, err, http.StatusNotFound, false)
+			return
+		}
+
+		subscription, err := db.Webhook(r.Context(), id)
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		respondJSON(w, r, http.StatusOK, subscription)
+	}
+}
+
+// DeleteWebhook is a handler that deletes a subscription and its deliveries.
+//
+//	DELETE /api/v1/webhooks/{id}
+//	Responds: 204, 404, 500
+func DeleteWebhook(
+	db webhook.Manager,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := webhookID(r)
+		if err != nil {
+			handleError(w, r, err, http.StatusNotFound, false)
+			return
+		}
+
+		if err := db.DeleteWebhook(r.Context(), id); err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// EnableWebhook is a handler that enables a subscription that was disabled
+// because its deliveries kept failing.
+//
+//	POST /api/v1/webhooks/{id}/enable
+//	Responds: 204, 404, 500
+func EnableWebhook(
+	db webhook.Manager,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := webhookID(r)
+		if err != nil {
+			handleError(w, r, err, http.StatusNotFound, false)
+			return
+		}
+
+		if err := db.EnableWebhook(r.Context(), id); err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// WebhookDeliveries is a handler that gets the last deliveries of a
+// subscription, newest first, with the log of their attempts.
+//
+//	GET /api/v1/webhooks/{id}/deliveries
+//	Responds: 200, 404, 500
+func WebhookDeliveries(
+	db webhook.Manager,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, err := webhookID(r)
+		if err != nil {
+			handleError(w, r, err, http.StatusNotFound, false)
+			return
+		}
+
+		deliveries, err := db.WebhookDeliveries(r.Context(), id, webhookDeliveriesLimit)
+		if err != nil {
+			handleError(w, r, err, http.StatusInternalServerError, false)
+			return
+		}
+
+		respondJSON(w, r, http.StatusOK, struct {
+			Deliveries []webhook.DeliveryLog `json:"deliveries"`
+		}{
+			Deliveries: deliveries,
+		})
+	}
+}
+
+func webhookID(r *http.Request) (int64, error) {
+	id, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		return 0, webhook.ErrNotFound.Wrap(err)
+	}
+	return id, nil
+}
@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// corsAllowedHeaders are the request headers browser clients may send.
+var corsAllowedHeaders = []string{
+	"Authorization",
+	"Content-Type",
+	"X-Client-Id",
+	csrfHeader,
+}
+
+// corsExposedHeaders are the response headers browser clients may read.
+var corsExposedHeaders = []string{
+	"Retry-After",
+}
+
+// CORS is the configuration for the middleware letting browser clients
+// served from other origins call the API.
+type CORS struct {
+	// Origins maps the allowed origins to the client they belong to.
+	Origins          map[string]string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// NewCORS returns a CORS middleware for the origins of each client.
+func NewCORS(clientOrigins map[string][]string, allowCredentials bool, maxAge time.Duration) *CORS {
+	origins := make(map[string]string)
+	for client, clientOrigins := range clientOrigins {
+		for _, origin := range clientOrigins {
+			origins[strings.TrimRight(origin, "/")] = client
+		}
+	}
+
+	return &CORS{
+		Origins:          origins,
+		AllowCredentials: allowCredentials,
+		MaxAge:           maxAge,
+	}
+}
+
+// Middleware adds the CORS headers to the responses to allowed origins and
+// answers their preflight requests. Requests from an allowed origin without
+// an X-Client-Id header are attributed to the client of the origin.
+// It has to wrap the router, as preflight requests match no route.
+func (c *CORS) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+
+		client, ok := c.Origins[origin]
+		if !ok {
+			// Without the CORS headers the browser does not let the page
+			// read the response.
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		if c.AllowCredentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			w.Header().Set("Access-Control-Allow-Methods", strings.Join([]string{
+				http.MethodGet,
+				http.MethodPost,
+				http.MethodDelete,
+			}, ", "))
+			w.Header().Set("Access-Control-Allow-Headers", strings.Join(corsAllowedHeaders, ", "))
+			if c.MaxAge > 0 {
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Access-Control-Expose-Headers", strings.Join(corsExposedHeaders, ", "))
+
+		if r.Header.Get("X-Client-Id") == "" {
+			r.Header.Set("X-Client-Id", client)
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
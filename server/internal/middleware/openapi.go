@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/client"
+	"gitlab.com/route-kz/auth-api/server/internal/openapi"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// OpenAPIValidation is the configuration for the middleware validating
+// requests, and in strict mode responses, against the OpenAPI document.
+type OpenAPIValidation struct {
+	Spec *openapi.Spec
+	// Strict also validates responses. Responses the document does not
+	// describe are replaced by a 500, so handlers drifting from the document
+	// are noticed in tests and staging.
+	Strict bool
+	// MaxBytes is the largest request body read.
+	MaxBytes int64
+}
+
+// Middleware rejects requests to documented routes that do not match the
+// document with a validation_failed error listing the invalid fields.
+func (ov *OpenAPIValidation) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := mux.CurrentRoute(r)
+		if route == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		op := ov.Spec.Operation(route.GetName())
+		if op == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, ov.MaxBytes))
+		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				writeError(w, r, http.StatusRequestEntityTooLarge, user.CodeRequestTooLarge, "request body too large")
+				return
+			}
+			writeError(w, r, http.StatusBadRequest, user.CodeBadRequest, "error reading request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+
+		if fields := ov.Spec.ValidateRequest(op, r, mux.Vars(r), body); fields != nil {
+			WriteError(w, r, client.ErrorCodeWrapper{
+				StatusCode: http.StatusBadRequest,
+				ResponseBody: client.ErrorCodeResponseBody{
+					Error:   string(user.CodeValidationFailed),
+					Message: user.ErrValidationFailed.Message,
+					Fields:  fields,
+				},
+			})
+			return
+		}
+
+		if !ov.Strict {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		rec := &responseRecorder{header: make(http.Header), status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		if err := ov.Spec.ValidateResponse(op, rec.status, rec.header.Get("Content-Type"), rec.body.Bytes()); err != nil {
+			log.WithField("operation", op.ID).Errorf("response does not match the openapi document: %s", err)
+			writeError(w, r, http.StatusInternalServerError, user.CodeInternal, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		for key, values := range rec.header {
+			w.Header()[key] = values
+		}
+		w.WriteHeader(rec.status)
+		_, _ = w.Write(rec.body.Bytes())
+	})
+}
+
+// responseRecorder keeps a response until it is validated.
+type responseRecorder struct {
+	header      http.Header
+	status      int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+func (rr *responseRecorder) Header() http.Header {
+	return rr.header
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+	if !rr.wroteHeader {
+		rr.status = status
+		rr.wroteHeader = true
+	}
+}
+
+func (rr *responseRecorder) Write(b []byte) (int, error) {
+	rr.wroteHeader = true
+	return rr.body.Write(b)
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+	"github.com/uber/jaeger-client-go"
+
+	"gitlab.com/route-kz/auth-api/client"
+)
+
+const problemDetailsContextKey contextKey = "problem_details"
+
+// ProblemDetails makes the errors of a router be sent as RFC 7807 problem
+// details instead of the v1 error body.
+func ProblemDetails(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), problemDetailsContextKey, true)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// WriteError responds with the error in the format of the API the request
+// was made to: problem details for routers using ProblemDetails and the
+// ErrorCodeWrapper body otherwise.
+func WriteError(w http.ResponseWriter, r *http.Request, e client.ErrorCodeWrapper) {
+	contentType := "application/json"
+
+	var (
+		body []byte
+		err  error
+	)
+	if problemDetails, _ := r.Context().Value(problemDetailsContextKey).(bool); problemDetails {
+		contentType = client.ProblemContentType
+		body, err = e.GetProblem(r.URL.Path, traceID(r.Context()))
+	} else {
+		body, err = e.GetResponseBody()
+	}
+	if err != nil {
+		log.Error(err.Error())
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(e.StatusCode)
+	_, _ = w.Write(body)
+}
+
+// traceID returns the id of the trace of the request, if it is traced.
+func traceID(ctx context.Context) string {
+	span := opentracing.SpanFromContext(ctx)
+	if span == nil {
+		return ""
+	}
+
+	if sc, ok := span.Context().(jaeger.SpanContext); ok {
+		return sc.TraceID().String()
+	}
+
+	return ""
+}
@@ -0,0 +1,286 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/config"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// maxPeekedBody is how much of a request body RateLimit reads to find the
+// login in it.
+const maxPeekedBody = 64 << 10
+
+const attemptContextKey contextKey = "attempt"
+
+// attempt is where a handler marks a request as a failed attempt.
+type attempt struct {
+	failed bool
+}
+
+// Limiter is an interface for sliding window rate limits and lockouts after
+// repeated failures.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error)
+	LockedOut(ctx context.Context, key string) (time.Duration, error)
+	RecordFailure(ctx context.Context, key string, threshold int, failureWindow, base, max time.Duration) (time.Duration, error)
+	ResetFailures(ctx context.Context, key string) error
+}
+
+// RateLimit is the configuration for the middleware throttling token
+// issuance per login, per IP and per client, and locking logins out after
+// repeated failed attempts.
+type RateLimit struct {
+	Limiter Limiter
+	Config  *config.Config
+}
+
+// Middleware answers 429 with a Retry-After header when a limit is exceeded
+// or the login is locked out. Only requests a handler marked with
+// FailedAttempt, i.e. with a wrong code or token, count as failed attempts;
+// a successful response resets the failures. Failures are counted per login
+// and IP (or per IP, for requests without a login), so nobody can lock the
+// owner of a login out by failing on purpose.
+func (rl *RateLimit) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		login, authUserType := peekLogin(r)
+
+		admission := rl.Admit(r.Context(), LimitedRequest{
+			Route:        mux.CurrentRoute(r).GetName(),
+			IP:           rl.ClientIP(r.RemoteAddr, r.Header.Values("X-Forwarded-For")),
+			Client:       clientID(r),
+			Login:        login,
+			AuthUserType: authUserType,
+		})
+		if admission.RetryAfter > 0 {
+			tooManyRequests(w, r, admission.RetryAfter, admission.Reason)
+			return
+		}
+
+		crw := customResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(&crw, r.WithContext(admission.Context))
+
+		rl.Finish(admission, crw.status < http.StatusBadRequest)
+	})
+}
+
+// LimitedRequest is what RateLimit limits a request by.
+type LimitedRequest struct {
+	// Route is the name of the route or gRPC method, which each limit is
+	// kept for.
+	Route string
+	IP    string
+	// Client is the calling client, see clientID.
+	Client string
+	// Login and AuthUserType are empty for requests without a login.
+	Login        string
+	AuthUserType string
+}
+
+// Admission is the outcome of checking a request with Admit.
+type Admission struct {
+	// RetryAfter is how long to wait when the request exceeds a limit or
+	// its login is locked out, and Reason why. It is zero for admitted
+	// requests.
+	RetryAfter time.Duration
+	Reason     string
+	// Context is the context to handle an admitted request with, for the
+	// handler to mark a FailedAttempt in.
+	Context context.Context
+
+	failureKey string
+	attempt    *attempt
+}
+
+// Admit checks a request against the lockout of its login and IP and the
+// rate limits per IP, per client and per login. Admitted requests are handled
+// with Admission.Context and passed to Finish afterwards.
+func (rl *RateLimit) Admit(ctx context.Context, req LimitedRequest) Admission {
+	login := req.Login
+	loginKey := ""
+	failureKey := "ip:" + req.IP
+	if login != "" {
+		// Count the ways of typing the same login as one login.
+		login = user.Normalizer{DefaultRegion: rl.Config.PhoneDefaultRegion}.Normalize(login, "")
+		loginKey = fmt.Sprintf("login:%s:%s", req.AuthUserType, login)
+		failureKey = loginKey + ":ip:" + req.IP
+	}
+
+	lockedOut, err := rl.Limiter.LockedOut(ctx, failureKey)
+	if err != nil {
+		// Don't lock everybody out when Redis is down.
+		log.Errorf("error checking lockout: %s", err)
+	}
+	if lockedOut > 0 {
+		return Admission{RetryAfter: lockedOut, Reason: "too many failed attempts"}
+	}
+
+	limits := []struct {
+		key  string
+		rate config.Rate
+	}{
+		{"ip:" + req.IP, rl.Config.RateLimitPerIP},
+		{"client:" + req.Client, rl.Config.RateLimitPerClient},
+	}
+
+	if login != "" {
+		rate, ok := rl.Config.RateLimitPerLoginByUserType[req.AuthUserType]
+		if !ok {
+			rate = rl.Config.RateLimitPerLogin
+		}
+		limits = append(limits, struct {
+			key  string
+			rate config.Rate
+		}{loginKey, rate})
+	}
+
+	for _, limit := range limits {
+		allowed, retryAfter, err := rl.Limiter.Allow(ctx, req.Route+":"+limit.key, limit.rate.Limit, limit.rate.Window)
+		if err != nil {
+			log.Errorf("error checking rate limit: %s", err)
+			continue
+		}
+
+		if !allowed {
+			return Admission{RetryAfter: retryAfter, Reason: "rate limit exceeded"}
+		}
+	}
+
+	a := &attempt{}
+	return Admission{
+		Context:    context.WithValue(ctx, attemptContextKey, a),
+		failureKey: failureKey,
+		attempt:    a,
+	}
+}
+
+// Finish records how an admitted request went: a FailedAttempt counts
+// towards a lockout, a success resets the failures.
+func (rl *RateLimit) Finish(admission Admission, succeeded bool) {
+	ctx := admission.Context
+
+	var err error
+	switch {
+	case admission.attempt.failed:
+		_, err = rl.Limiter.RecordFailure(
+			ctx,
+			admission.failureKey,
+			rl.Config.LockoutThreshold,
+			rl.Config.LockoutFailureWindow,
+			rl.Config.LockoutBase,
+			rl.Config.LockoutMax,
+		)
+	case succeeded:
+		err = rl.Limiter.ResetFailures(ctx, admission.failureKey)
+	}
+	if err != nil {
+		log.Errorf("error recording attempt: %s", err)
+	}
+}
+
+// FailedAttempt marks the request as a failed attempt for RateLimit to count
+// towards a lockout. Handlers call it when a code or token is wrong, and not
+// for malformed or invalid requests.
+func FailedAttempt(ctx context.Context) {
+	if a, ok := ctx.Value(attemptContextKey).(*attempt); ok {
+		a.failed = true
+	}
+}
+
+// ClientIP returns the IP of the client from the remote address of a
+// request, or from its X-Forwarded-For header values if we trust them. Each
+// proxy appends the address it got the request from, so the client is the
+// entry added by the outermost of the trusted proxies, counted from the
+// right; entries left of it are whatever the client sent.
+func (rl *RateLimit) ClientIP(remoteAddr string, forwardedFor []string) string {
+	if rl.Config.RateLimitTrustForwardedFor {
+		var entries []string
+		for _, value := range forwardedFor {
+			for _, entry := range strings.Split(value, ",") {
+				if entry = strings.TrimSpace(entry); entry != "" {
+					entries = append(entries, entry)
+				}
+			}
+		}
+
+		if len(entries) > 0 {
+			hops := rl.Config.RateLimitTrustedProxies
+			if hops < 1 {
+				hops = 1
+			}
+			if hops > len(entries) {
+				hops = len(entries)
+			}
+
+			return entries[len(entries)-hops]
+		}
+	}
+
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+
+	return host
+}
+
+// clientID returns the client making the request: the authenticated caller
+// or else the X-Client-Id header.
+func clientID(r *http.Request) string {
+	if c := CallerFromContext(r.Context()); c != nil {
+		return c.Name
+	}
+
+	if id := r.Header.Get("X-Client-Id"); id != "" {
+		return id
+	}
+
+	return "unknown"
+}
+
+// peekLogin reads the login and auth user type from a JSON request body,
+// leaving the body for the handler to read again.
+func peekLogin(r *http.Request) (string, string) {
+	if r.Body == nil {
+		return "", ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxPeekedBody))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil {
+		return "", ""
+	}
+
+	var payload struct {
+		Login        string `json:"login"`
+		NewLogin     string `json:"new_login"`
+		AuthUserType string `json:"auth_user_type"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return "", ""
+	}
+
+	if payload.NewLogin != "" {
+		return payload.NewLogin, payload.AuthUserType
+	}
+
+	return payload.Login, payload.AuthUserType
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, retryAfter time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	writeError(w, r, http.StatusTooManyRequests, user.CodeRateLimited, message)
+}
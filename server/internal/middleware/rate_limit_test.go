@@ -0,0 +1,206 @@
+package middleware
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/config"
+)
+
+type fakeLimiter struct {
+	lockedOut map[string]bool
+	failures  []string
+	resets    []string
+}
+
+func (l *fakeLimiter) Allow(ctx context.Context, key string, limit int, window time.Duration) (bool, time.Duration, error) {
+	return true, 0, nil
+}
+
+func (l *fakeLimiter) LockedOut(ctx context.Context, key string) (time.Duration, error) {
+	if l.lockedOut[key] {
+		return time.Minute, nil
+	}
+	return 0, nil
+}
+
+func (l *fakeLimiter) RecordFailure(ctx context.Context, key string, threshold int, failureWindow, base, max time.Duration) (time.Duration, error) {
+	l.failures = append(l.failures, key)
+	return 0, nil
+}
+
+func (l *fakeLimiter) ResetFailures(ctx context.Context, key string) error {
+	l.resets = append(l.resets, key)
+	return nil
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	const (
+		body       = `{"login":"jane@example.com","auth_user_type":"client"}`
+		failureKey = "login:client:jane@example.com:ip:192.0.2.1"
+	)
+
+	tests := []struct {
+		name         string
+		body         string
+		lockedOut    map[string]bool
+		handler      http.HandlerFunc
+		wantStatus   int
+		wantFailures []string
+		wantResets   []string
+	}{
+		{
+			name: "wrong code counts as a failure of the login from the ip",
+			body: body,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				FailedAttempt(r.Context())
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			wantStatus:   http.StatusBadRequest,
+			wantFailures: []string{failureKey},
+		},
+		{
+			name: "invalid request does not count",
+			body: body,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name: "success resets the failures",
+			body: body,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			},
+			wantStatus: http.StatusCreated,
+			wantResets: []string{failureKey},
+		},
+		{
+			name: "request without a login counts against the ip",
+			body: `{}`,
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				FailedAttempt(r.Context())
+				w.WriteHeader(http.StatusUnauthorized)
+			},
+			wantStatus:   http.StatusUnauthorized,
+			wantFailures: []string{"ip:192.0.2.1"},
+		},
+		{
+			name:      "lockout from another ip does not apply",
+			body:      body,
+			lockedOut: map[string]bool{"login:client:jane@example.com:ip:192.0.2.2": true},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusCreated)
+			},
+			wantStatus: http.StatusCreated,
+			wantResets: []string{failureKey},
+		},
+		{
+			name:      "locked out login",
+			body:      body,
+			lockedOut: map[string]bool{failureKey: true},
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				t.Error("handler called for a locked out login")
+			},
+			wantStatus: http.StatusTooManyRequests,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := &fakeLimiter{lockedOut: tt.lockedOut}
+			rl := &RateLimit{Limiter: limiter, Config: &config.Config{}}
+
+			router := mux.NewRouter()
+			router.Handle("/tokens", rl.Middleware(tt.handler)).Name("CreateToken")
+
+			r := httptest.NewRequest(http.MethodPost, "/tokens", strings.NewReader(tt.body))
+			r.RemoteAddr = "192.0.2.1:1234"
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if strings.Join(limiter.failures, ",") != strings.Join(tt.wantFailures, ",") {
+				t.Errorf("failures = %v, want %v", limiter.failures, tt.wantFailures)
+			}
+			if strings.Join(limiter.resets, ",") != strings.Join(tt.wantResets, ",") {
+				t.Errorf("resets = %v, want %v", limiter.resets, tt.wantResets)
+			}
+		})
+	}
+}
+
+func TestPeekLogin(t *testing.T) {
+	tests := []struct {
+		name             string
+		body             string
+		wantLogin        string
+		wantAuthUserType string
+	}{
+		{"login", `{"login":"jane@example.com","auth_user_type":"driver"}`, "jane@example.com", "driver"},
+		{"new login wins", `{"login":"old@example.com","new_login":"new@example.com"}`, "new@example.com", ""},
+		{"not json", `login=jane`, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+
+			login, authUserType := peekLogin(r)
+			if login != tt.wantLogin || authUserType != tt.wantAuthUserType {
+				t.Errorf("peekLogin() = %q, %q, want %q, %q", login, authUserType, tt.wantLogin, tt.wantAuthUserType)
+			}
+
+			// The body is left for the handler.
+			rest, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(rest) != tt.body {
+				t.Errorf("body = %q, want %q", rest, tt.body)
+			}
+		})
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	tests := []struct {
+		name         string
+		trust        bool
+		proxies      int
+		forwardedFor []string
+		want         string
+	}{
+		{"remote address", false, 1, []string{"203.0.113.7"}, "192.0.2.1"},
+		{"no header", true, 1, nil, "192.0.2.1"},
+		{"added by the proxy", true, 1, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed entries are skipped", true, 1, []string{"10.0.0.1, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed header line", true, 1, []string{"10.0.0.1", "203.0.113.7"}, "203.0.113.7"},
+		{"two proxies", true, 2, []string{"10.0.0.1, 203.0.113.7, 198.51.100.2"}, "203.0.113.7"},
+		{"fewer entries than proxies", true, 3, []string{"203.0.113.7, 198.51.100.2"}, "203.0.113.7"},
+		{"empty entries", true, 1, []string{"203.0.113.7, "}, "203.0.113.7"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := &RateLimit{Config: &config.Config{
+				RateLimitTrustForwardedFor: tt.trust,
+				RateLimitTrustedProxies:    tt.proxies,
+			}}
+
+			if got := rl.ClientIP("192.0.2.1:1234", tt.forwardedFor); got != tt.want {
+				t.Errorf("ClientIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/scim"
+)
+
+const scimTenantContextKey contextKey = "scim_tenant"
+
+// SCIMAuth is the configuration for the middleware authenticating the
+// directories of tenants calling the SCIM routes by their bearer token.
+type SCIMAuth struct {
+	DB scim.TenantFetcher
+}
+
+// Middleware rejects requests without the "Authorization: Bearer <token>"
+// header of an enabled tenant and puts the tenant into the request context.
+func (sa *SCIMAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := BearerToken(r)
+		if token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			WriteSCIMError(w, scim.ErrUnauthorized)
+			return
+		}
+
+		tenant, err := sa.DB.GetSCIMTenantByTokenHash(r.Context(), scim.HashToken(token))
+		if err != nil {
+			log.Errorf("error getting tenant in scim auth middleware: %s", err)
+			WriteSCIMError(w, scim.ErrInternal)
+			return
+		}
+
+		if tenant == nil {
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			WriteSCIMError(w, scim.ErrUnauthorized)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), scimTenantContextKey, tenant)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// SCIMTenantFromContext returns the tenant authenticated by SCIMAuth.
+func SCIMTenantFromContext(ctx context.Context) *scim.Tenant {
+	tenant, _ := ctx.Value(scimTenantContextKey).(*scim.Tenant)
+	return tenant
+}
+
+// WriteSCIMError responds with a SCIM error.
+func WriteSCIMError(w http.ResponseWriter, err *scim.Error) {
+	body, _ := json.Marshal(err)
+
+	w.Header().Set("Content-Type", scim.ContentType)
+	w.WriteHeader(err.Status)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,104 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+const csrfHeader = "X-CSRF-Token"
+
+// SecurityHeaders is the configuration for the middleware adding the
+// standard security headers to every response.
+type SecurityHeaders struct {
+	HSTSMaxAge time.Duration
+}
+
+// Middleware adds the security headers. The API only serves JSON, so
+// responses may not be framed, sniffed or run as pages, and they are not
+// stored by caches, as most of them hold tokens or personal data.
+func (sh *SecurityHeaders) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h := w.Header()
+		if sh.HSTSMaxAge > 0 {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(sh.HSTSMaxAge.Seconds())))
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		h.Set("X-Frame-Options", "DENY")
+		h.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		h.Set("Referrer-Policy", "no-referrer")
+		h.Set("Cache-Control", "no-store")
+		h.Set("Pragma", "no-cache")
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// CSRF is the configuration for the middleware protecting requests
+// authenticated by the session cookie against cross-site request forgery,
+// with the double submit cookie pattern. Other cookies do not authenticate
+// anything, so requests with only those are left alone.
+type CSRF struct {
+	CookieName        string
+	SessionCookieName string
+}
+
+// Middleware hands out a CSRF token cookie, readable by the page, to safe
+// requests with the session cookie, and requires unsafe requests with the
+// session cookie to repeat the token in the X-CSRF-Token header. Another
+// site can make the browser send the cookies, but can not read them.
+func (c *CSRF) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(c.SessionCookieName); err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cookie, err := r.Cookie(c.CookieName)
+		token := ""
+		if err == nil {
+			token = cookie.Value
+		}
+
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			if token == "" {
+				c.setToken(w)
+			}
+		default:
+			if !validCSRFToken(token, r.Header.Get(csrfHeader)) {
+				writeError(w, r, http.StatusForbidden, user.CodeForbidden, "missing or invalid CSRF token")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *CSRF) setToken(w http.ResponseWriter) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		log.Errorf("error generating CSRF token: %s", err)
+		return
+	}
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(b),
+		Path:     "/",
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+func validCSRFToken(cookie, header string) bool {
+	return cookie != "" && subtle.ConstantTimeCompare([]byte(cookie), []byte(header)) == 1
+}
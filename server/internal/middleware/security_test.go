@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	csrf := &CSRF{CookieName: "csrf_token", SessionCookieName: "session"}
+
+	tests := []struct {
+		name       string
+		method     string
+		cookies    map[string]string
+		header     string
+		wantStatus int
+		wantCookie bool
+	}{
+		{
+			name:       "token request from a browser without a session",
+			method:     http.MethodPost,
+			cookies:    map[string]string{"_ga": "GA1.2.3"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "get without a session gets no cookie",
+			method:     http.MethodGet,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "get with a session gets a token",
+			method:     http.MethodGet,
+			cookies:    map[string]string{"session": "s"},
+			wantStatus: http.StatusOK,
+			wantCookie: true,
+		},
+		{
+			name:       "get with a session and a token",
+			method:     http.MethodGet,
+			cookies:    map[string]string{"session": "s", "csrf_token": "t"},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "session without a token",
+			method:     http.MethodPost,
+			cookies:    map[string]string{"session": "s"},
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "session with a wrong token",
+			method:     http.MethodDelete,
+			cookies:    map[string]string{"session": "s", "csrf_token": "t"},
+			header:     "other",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "session with the token",
+			method:     http.MethodPost,
+			cookies:    map[string]string{"session": "s", "csrf_token": "t"},
+			header:     "t",
+			wantStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, "/api/v1/tokens", nil)
+			for name, value := range tt.cookies {
+				r.AddCookie(&http.Cookie{Name: name, Value: value})
+			}
+			if tt.header != "" {
+				r.Header.Set(csrfHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+
+			csrf.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if gotCookie := w.Header().Get("Set-Cookie") != ""; gotCookie != tt.wantCookie {
+				t.Errorf("cookie set = %t, want %t", gotCookie, tt.wantCookie)
+			}
+		})
+	}
+}
@@ -0,0 +1,188 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/caller"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+const callerContextKey contextKey = "caller"
+
+// apiKeyCacheTTL is how long a looked up API key is trusted before it is
+// looked up again, so disabled keys stop working within this time.
+const apiKeyCacheTTL = time.Minute
+
+// ServiceAuth is the configuration for the middleware authenticating the
+// services calling internal routes by their client certificate or API key,
+// and authorizing them per route.
+type ServiceAuth struct {
+	DB caller.Fetcher
+	// Certificates maps verified client certificates to callers.
+	Certificates caller.CertificateIdentities
+	// Required rejects requests without an API key. Without it such requests
+	// are let through anonymously, which helps rolling out API keys.
+	Required bool
+
+	mu    sync.Mutex
+	cache map[string]cachedCaller
+}
+
+type cachedCaller struct {
+	caller  *caller.Caller
+	expires time.Time
+}
+
+// Middleware authenticates the caller by its verified client certificate or
+// else by the "X-Api-Key" header, checks that it may call the current route
+// and puts it into the request context.
+func (sa *ServiceAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		cert := verifiedCertificate(r)
+		apiKey := apiKey(r)
+		if !sa.HasCredentials(cert, apiKey) {
+			if sa.Required {
+				writeError(w, r, http.StatusUnauthorized, user.CodeUnauthorized, "missing client certificate or api key")
+				return
+			}
+
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		c, err := sa.Authenticate(ctx, cert, apiKey)
+		if err != nil {
+			log.Errorf("error getting caller in service auth middleware: %s", err)
+			writeError(w, r, http.StatusInternalServerError, user.CodeInternal, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		if c == nil {
+			writeError(w, r, http.StatusUnauthorized, user.CodeUnauthorized, "unknown caller")
+			return
+		}
+
+		setMetricsCaller(r.Context(), c.Name)
+
+		if !c.Allowed(mux.CurrentRoute(r).GetName()) {
+			writeError(w, r, http.StatusForbidden, user.CodeForbidden, "caller may not call this route")
+			return
+		}
+
+		ctx = ContextWithCaller(ctx, c)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// ContextWithCaller returns a context with the caller, for CallerFromContext.
+func ContextWithCaller(ctx context.Context, c *caller.Caller) context.Context {
+	return context.WithValue(ctx, callerContextKey, c)
+}
+
+// CallerFromContext returns the caller authenticated by ServiceAuth, or nil.
+func CallerFromContext(ctx context.Context) *caller.Caller {
+	c, _ := ctx.Value(callerContextKey).(*caller.Caller)
+	return c
+}
+
+// Authenticate returns the caller a verified client certificate belongs to,
+// or else the caller with the API key. Returns nil if there is no such
+// caller.
+func (sa *ServiceAuth) Authenticate(ctx context.Context, cert *x509.Certificate, apiKey string) (*caller.Caller, error) {
+	if cert != nil {
+		if name := sa.Certificates.CallerName(cert); name != "" {
+			return sa.getCaller("name:"+name, func() (*caller.Caller, error) {
+				return sa.DB.GetCallerByName(ctx, name)
+			})
+		}
+	}
+
+	if apiKey == "" {
+		return nil, nil
+	}
+
+	hash := caller.HashAPIKey(apiKey)
+	return sa.getCaller("key:"+hash, func() (*caller.Caller, error) {
+		return sa.DB.GetCallerByAPIKeyHash(ctx, hash)
+	})
+}
+
+// HasCredentials tells whether a request has a client certificate of a
+// caller or an API key to authenticate with.
+func (sa *ServiceAuth) HasCredentials(cert *x509.Certificate, apiKey string) bool {
+	return apiKey != "" || (cert != nil && sa.Certificates.CallerName(cert) != "")
+}
+
+// verifiedCertificate returns the verified client certificate of the
+// request, if any.
+func verifiedCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// getCaller returns the cached caller for key, or looks it up with fetch.
+func (sa *ServiceAuth) getCaller(key string, fetch func() (*caller.Caller, error)) (*caller.Caller, error) {
+	sa.mu.Lock()
+	cached, ok := sa.cache[key]
+	sa.mu.Unlock()
+
+	if ok && time.Now().Before(cached.expires) {
+		return cached.caller, nil
+	}
+
+	c, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+
+	sa.mu.Lock()
+	defer sa.mu.Unlock()
+
+	if sa.cache == nil {
+		sa.cache = make(map[string]cachedCaller)
+	}
+
+	// Drop expired entries now and then so unknown keys don't pile up.
+	if len(sa.cache) > 1000 {
+		for key, entry := range sa.cache {
+			if time.Now().After(entry.expires) {
+				delete(sa.cache, key)
+			}
+		}
+	}
+
+	sa.cache[key] = cachedCaller{
+		caller:  c,
+		expires: time.Now().Add(apiKeyCacheTTL),
+	}
+
+	return c, nil
+}
+
+func apiKey(r *http.Request) string {
+	if key := r.Header.Get("X-Api-Key"); key != "" {
+		return key
+	}
+
+	const prefix = "apikey "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(prefix):])
+}
@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/caller"
+)
+
+// fakeCallers is a caller.Fetcher over callers by name, with the names of
+// the callers per API key.
+type fakeCallers struct {
+	callers map[string]*caller.Caller
+	apiKeys map[string]string
+}
+
+func (f *fakeCallers) GetCallerByAPIKeyHash(ctx context.Context, apiKeyHash string) (*caller.Caller, error) {
+	for key, name := range f.apiKeys {
+		if caller.HashAPIKey(key) == apiKeyHash {
+			return f.callers[name], nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeCallers) GetCallerByName(ctx context.Context, name string) (*caller.Caller, error) {
+	return f.callers[name], nil
+}
+
+func TestServiceAuthMiddleware(t *testing.T) {
+	db := &fakeCallers{
+		callers: map[string]*caller.Caller{
+			"billing": {Name: "billing", Routes: []string{"Identity"}},
+			"support": {Name: "support", Routes: []string{caller.AllRoutes}},
+		},
+		apiKeys: map[string]string{
+			"billing-key": "billing",
+			"support-key": "support",
+		},
+	}
+	certificates := caller.NewCertificateIdentities(map[string]string{
+		"billing": "billing.internal",
+	})
+
+	tests := []struct {
+		name       string
+		required   bool
+		route      string
+		apiKey     string
+		authHeader string
+		certName   string
+		wantStatus int
+		wantCaller string
+	}{
+		{"api key", true, "Identity", "billing-key", "", "", http.StatusOK, "billing"},
+		{"api key in the authorization header", true, "Identity", "", "ApiKey billing-key", "", http.StatusOK, "billing"},
+		{"all routes", true, "PersonalData", "support-key", "", "", http.StatusOK, "support"},
+		{"route the caller may not call", true, "PersonalData", "billing-key", "", "", http.StatusForbidden, ""},
+		{"unknown api key", true, "Identity", "unknown-key", "", "", http.StatusUnauthorized, ""},
+		{"unknown api key when optional", false, "Identity", "unknown-key", "", "", http.StatusUnauthorized, ""},
+		{"no credentials when required", true, "Identity", "", "", "", http.StatusUnauthorized, ""},
+		{"no credentials when optional", false, "Identity", "", "", "", http.StatusOK, ""},
+		{"certificate", true, "Identity", "", "", "billing.internal", http.StatusOK, "billing"},
+		{"certificate before api key", true, "PersonalData", "support-key", "", "billing.internal", http.StatusForbidden, ""},
+		{"unmapped certificate falls back to api key", true, "PersonalData", "support-key", "", "unknown.internal", http.StatusOK, "support"},
+		{"unmapped certificate when required", true, "Identity", "", "", "unknown.internal", http.StatusUnauthorized, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sa := &ServiceAuth{DB: db, Certificates: certificates, Required: tt.required}
+
+			var gotCaller string
+			router := mux.NewRouter()
+			router.Use(sa.Middleware)
+			router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+				if c := CallerFromContext(r.Context()); c != nil {
+					gotCaller = c.Name
+				}
+			}).Name(tt.route)
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.apiKey != "" {
+				r.Header.Set("X-Api-Key", tt.apiKey)
+			}
+			if tt.authHeader != "" {
+				r.Header.Set("Authorization", tt.authHeader)
+			}
+			if tt.certName != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.certName}}
+				r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			if gotCaller != tt.wantCaller {
+				t.Errorf("caller = %q, want %q", gotCaller, tt.wantCaller)
+			}
+		})
+	}
+}
+
+func TestServiceAuthCachesCallers(t *testing.T) {
+	db := &fakeCallers{
+		callers: map[string]*caller.Caller{"billing": {Name: "billing"}},
+		apiKeys: map[string]string{"billing-key": "billing"},
+	}
+	sa := &ServiceAuth{DB: db}
+
+	if c, err := sa.Authenticate(context.Background(), nil, "billing-key"); err != nil || c == nil {
+		t.Fatalf("Authenticate() = %v, %v, want billing", c, err)
+	}
+
+	// A disabled key keeps working until the cached caller expires.
+	delete(db.apiKeys, "billing-key")
+	if c, err := sa.Authenticate(context.Background(), nil, "billing-key"); err != nil || c == nil {
+		t.Errorf("Authenticate() = %v, %v, want the cached billing", c, err)
+	}
+}
@@ -39,15 +39,36 @@ func (tm *TraceMetrics) MetricsMiddleware(next http.Handler) http.Handler {
 		crw := customResponseWriter{ResponseWriter: w}
 		start := time.Now()
 
-		next.ServeHTTP(&crw, r)
+		// The caller is only known once an auth middleware further down the
+		// chain ran, so hand it a place to put it.
+		mc := &metricsCaller{name: anonymousCaller}
+		ctx := context.WithValue(r.Context(), metricsCallerContextKey, mc)
+
+		next.ServeHTTP(&crw, r.WithContext(ctx))
 
 		duration := time.Since(start)
 
 		metrics.ObserveTimeToProcess(routeName, duration.Seconds())
-		metrics.ReceivedRequest(crw.status, routeName)
+		metrics.ReceivedRequest(crw.status, routeName, mc.name)
 	})
 }
 
+const (
+	metricsCallerContextKey contextKey = "metrics_caller"
+	anonymousCaller                    = "anonymous"
+)
+
+type metricsCaller struct {
+	name string
+}
+
+// setMetricsCaller sets the caller label of the request metrics.
+func setMetricsCaller(ctx context.Context, name string) {
+	if mc, ok := ctx.Value(metricsCallerContextKey).(*metricsCaller); ok {
+		mc.name = name
+	}
+}
+
 type customResponseWriter struct {
 	http.ResponseWriter
 	status int
@@ -0,0 +1,79 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+
+	"gitlab.com/route-kz/auth-api/client"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+type contextKey string
+
+const userIDContextKey contextKey = "user_id"
+
+// UserAuth is the configuration for the middleware authenticating end users
+// by the token they got from POST /api/v1/tokens.
+type UserAuth struct {
+	DB user.IDFetcher
+}
+
+// Middleware rejects requests without a valid "Authorization: Bearer <token>"
+// header and puts the id of the authenticated user into the request context.
+func (ua *UserAuth) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := BearerToken(r)
+		if token == "" {
+			writeError(w, r, http.StatusUnauthorized, user.CodeUnauthorized, "missing bearer token")
+			return
+		}
+
+		userID, err := ua.DB.GetUserID(r.Context(), token)
+		if err != nil {
+			log.Errorf("error getting user id in user auth middleware: %s", err)
+			writeError(w, r, http.StatusInternalServerError, user.CodeInternal, http.StatusText(http.StatusInternalServerError))
+			return
+		}
+
+		if userID == "" {
+			writeError(w, r, http.StatusUnauthorized, user.CodeInvalidToken, user.ErrInvalidToken.Message)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), userIDContextKey, userID)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// UserIDFromContext returns the id of the user authenticated by UserAuth.
+func UserIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value(userIDContextKey).(string)
+	return userID
+}
+
+// BearerToken returns the token of the "Authorization: Bearer <token>"
+// header of a request, or "" if it has none.
+func BearerToken(r *http.Request) string {
+	const prefix = "bearer "
+
+	header := r.Header.Get("Authorization")
+	if len(header) < len(prefix) || !strings.EqualFold(header[:len(prefix)], prefix) {
+		return ""
+	}
+
+	return strings.TrimSpace(header[len(prefix):])
+}
+
+// writeError responds with an error of the middleware.
+func writeError(w http.ResponseWriter, r *http.Request, statusCode int, code user.ErrorCode, message string) {
+	WriteError(w, r, client.ErrorCodeWrapper{
+		StatusCode: statusCode,
+		ResponseBody: client.ErrorCodeResponseBody{
+			Error:   string(code),
+			Message: message,
+		},
+	})
+}
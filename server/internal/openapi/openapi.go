@@ -0,0 +1,248 @@
+// Package openapi holds the OpenAPI document of the API and validates
+// requests and responses against it.
+//
+// The document is written by hand next to the handlers. CheckRoutes makes
+// sure it documents every route of the router, under the route's name and
+// with its method and path, and nothing else, so the server does not start
+// with a document that is out of date.
+package openapi
+
+import (
+	// Embeds the document.
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//go:embed openapi.json
+var document []byte
+
+// Document returns the OpenAPI document, as served to clients.
+func Document() []byte {
+	return document
+}
+
+// Spec is the part of the OpenAPI document requests and responses are
+// validated against.
+type Spec struct {
+	// operations are keyed by their operationId, which is the name of their
+	// route.
+	operations map[string]*Operation
+	schemas    map[string]*Schema
+}
+
+// Operation is an operation of the document.
+type Operation struct {
+	ID          string
+	Method      string
+	Path        string
+	Parameters  []*Parameter
+	RequestBody *RequestBody
+	Responses   map[string]*Response
+}
+
+// Parameter is a query or path parameter.
+type Parameter struct {
+	Ref      string  `json:"$ref"`
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the body of the requests of an operation.
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation.
+type Response struct {
+	Ref     string               `json:"$ref"`
+	Content map[string]MediaType `json:"content"`
+}
+
+// MediaType is the schema of a body of some content type.
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type rawOperation struct {
+	OperationID string               `json:"operationId"`
+	Parameters  []*Parameter         `json:"parameters"`
+	RequestBody *RequestBody         `json:"requestBody"`
+	Responses   map[string]*Response `json:"responses"`
+}
+
+type rawDocument struct {
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas    map[string]*Schema    `json:"schemas"`
+		Parameters map[string]*Parameter `json:"parameters"`
+		Responses  map[string]*Response  `json:"responses"`
+	} `json:"components"`
+}
+
+var methods = map[string]string{
+	"get":    http.MethodGet,
+	"post":   http.MethodPost,
+	"put":    http.MethodPut,
+	"patch":  http.MethodPatch,
+	"delete": http.MethodDelete,
+}
+
+// Load parses the document.
+func Load() (*Spec, error) {
+	var raw rawDocument
+	if err := json.Unmarshal(document, &raw); err != nil {
+		return nil, fmt.Errorf("error parsing openapi document: %w", err)
+	}
+
+	spec := &Spec{
+		operations: make(map[string]*Operation),
+		schemas:    raw.Components.Schemas,
+	}
+
+	for path, item := range raw.Paths {
+		var shared []*Parameter
+		if params, ok := item["parameters"]; ok {
+			if err := json.Unmarshal(params, &shared); err != nil {
+				return nil, fmt.Errorf("error parsing parameters of %s: %w", path, err)
+			}
+		}
+
+		for key, value := range item {
+			method, ok := methods[key]
+			if !ok {
+				continue
+			}
+
+			var op rawOperation
+			if err := json.Unmarshal(value, &op); err != nil {
+				return nil, fmt.Errorf("error parsing %s %s: %w", method, path, err)
+			}
+
+			if op.OperationID == "" {
+				return nil, fmt.Errorf("%s %s has no operationId", method, path)
+			}
+			if _, ok := spec.operations[op.OperationID]; ok {
+				return nil, fmt.Errorf("operationId %s is not unique", op.OperationID)
+			}
+
+			operation := &Operation{
+				ID:          op.OperationID,
+				Method:      method,
+				Path:        path,
+				RequestBody: op.RequestBody,
+				Responses:   make(map[string]*Response, len(op.Responses)),
+			}
+
+			for _, param := range append(shared, op.Parameters...) {
+				if param.Ref != "" {
+					name := strings.TrimPrefix(param.Ref, "#/components/parameters/")
+					if param = raw.Components.Parameters[name]; param == nil {
+						return nil, fmt.Errorf("%s: unknown parameter %s", op.OperationID, name)
+					}
+				}
+				operation.Parameters = append(operation.Parameters, param)
+			}
+
+			for status, response := range op.Responses {
+				if response.Ref != "" {
+					name := strings.TrimPrefix(response.Ref, "#/components/responses/")
+					if response = raw.Components.Responses[name]; response == nil {
+						return nil, fmt.Errorf("%s: unknown response %s", op.OperationID, name)
+					}
+				}
+				operation.Responses[status] = response
+			}
+
+			spec.operations[op.OperationID] = operation
+		}
+	}
+
+	return spec, nil
+}
+
+// Operation returns the operation of a route, nil if it is not documented.
+func (s *Spec) Operation(routeName string) *Operation {
+	return s.operations[routeName]
+}
+
+// Operations returns the operations of the document, sorted by name.
+func (s *Spec) Operations() []*Operation {
+	operations := make([]*Operation, 0, len(s.operations))
+	for _, op := range s.operations {
+		operations = append(operations, op)
+	}
+
+	sort.Slice(operations, func(i, j int) bool { return operations[i].ID < operations[j].ID })
+	return operations
+}
+
+// pathVariable matches the variables of mux path templates, with their
+// optional patterns.
+var pathVariable = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// CheckRoutes checks that the routes of the router under prefix (e.g.
+// "/api/v1") and the operations of the document match: each route is
+// documented by the operation named like it, with the same method and path,
+// and each operation is served.
+func (s *Spec) CheckRoutes(router *mux.Router, prefix string) error {
+	var problems []string
+	served := make(map[string]bool)
+
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil || !strings.HasPrefix(template, prefix) {
+			return nil
+		}
+
+		routeMethods, err := route.GetMethods()
+		if err != nil {
+			// Subrouters have no methods.
+			return nil
+		}
+
+		name := route.GetName()
+		path := pathVariable.ReplaceAllString(template, "{$1}")
+
+		op := s.operations[name]
+		if op == nil {
+			problems = append(problems, fmt.Sprintf("route %s (%s %s) is not documented", name, strings.Join(routeMethods, ","), path))
+			return nil
+		}
+
+		served[name] = true
+		if op.Path != path || len(routeMethods) != 1 || routeMethods[0] != op.Method {
+			problems = append(problems, fmt.Sprintf(
+				"route %s is %s %s, documented as %s %s",
+				name, strings.Join(routeMethods, ","), path, op.Method, op.Path,
+			))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error walking routes: %w", err)
+	}
+
+	for name, op := range s.operations {
+		if !served[name] {
+			problems = append(problems, fmt.Sprintf("operation %s (%s %s) is not served", name, op.Method, op.Path))
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("document does not match the routes: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,335 @@
+package openapi
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is the subset of the OpenAPI schema object the document uses.
+type Schema struct {
+	Ref                  string                `json:"$ref"`
+	Type                 string                `json:"type"`
+	Format               string                `json:"format"`
+	Nullable             bool                  `json:"nullable"`
+	Enum                 []interface{}         `json:"enum"`
+	Required             []string              `json:"required"`
+	Properties           map[string]*Schema    `json:"properties"`
+	AdditionalProperties *AdditionalProperties `json:"additionalProperties"`
+	Items                *Schema               `json:"items"`
+	MinItems             *int                  `json:"minItems"`
+	MaxItems             *int                  `json:"maxItems"`
+	MinLength            *int                  `json:"minLength"`
+	MaxLength            *int                  `json:"maxLength"`
+}
+
+// AdditionalProperties is either false, forbidding properties that are not
+// listed, or the schema of those properties.
+type AdditionalProperties struct {
+	Forbidden bool
+	Schema    *Schema
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (ap *AdditionalProperties) UnmarshalJSON(data []byte) error {
+	var allowed bool
+	if err := json.Unmarshal(data, &allowed); err == nil {
+		ap.Forbidden = !allowed
+		return nil
+	}
+
+	return json.Unmarshal(data, &ap.Schema)
+}
+
+// ValidateRequest checks the parameters and the body of a request. pathVars
+// are the variables of the path. Returns what is wrong with each invalid
+// parameter or body field, nil if the request is valid.
+func (s *Spec) ValidateRequest(op *Operation, r *http.Request, pathVars map[string]string, body []byte) map[string]string {
+	fields := make(map[string]string)
+	query := r.URL.Query()
+
+	for _, param := range op.Parameters {
+		var value string
+		var present bool
+		switch param.In {
+		case "query":
+			value, present = query.Get(param.Name), query.Has(param.Name)
+		case "path":
+			value, present = pathVars[param.Name]
+		default:
+			continue
+		}
+
+		if !present || value == "" {
+			if param.Required {
+				fields[param.Name] = "is required"
+			}
+			continue
+		}
+
+		if param.Schema != nil {
+			s.validate(param.Schema, parameterValue(param.Schema, value), param.Name, fields)
+		}
+	}
+
+	if op.RequestBody != nil {
+		switch schema := jsonSchema(op.RequestBody.Content); {
+		case len(bytes.TrimSpace(body)) == 0:
+			if op.RequestBody.Required {
+				fields["body"] = "is required"
+			}
+		case schema != nil:
+			value, err := decode(body)
+			if err != nil {
+				fields["body"] = "must be valid JSON"
+				break
+			}
+			s.validate(schema, value, "", fields)
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil
+	}
+	return fields
+}
+
+// ValidateResponse checks that a response is documented by the operation and
+// its body matches the schema of its content type.
+func (s *Spec) ValidateResponse(op *Operation, statusCode int, contentType string, body []byte) error {
+	response, ok := op.Responses[strconv.Itoa(statusCode)]
+	if !ok {
+		if response, ok = op.Responses["default"]; !ok {
+			return fmt.Errorf("status %d is not documented", statusCode)
+		}
+	}
+
+	if len(response.Content) == 0 {
+		if len(bytes.TrimSpace(body)) > 0 {
+			return fmt.Errorf("status %d has a body, but none is documented", statusCode)
+		}
+		return nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	content, ok := response.Content[mediaType]
+	if !ok {
+		return fmt.Errorf("content type %q of status %d is not documented", contentType, statusCode)
+	}
+
+	if content.Schema == nil {
+		return nil
+	}
+
+	value, err := decode(body)
+	if err != nil {
+		return fmt.Errorf("body of status %d is not valid JSON: %w", statusCode, err)
+	}
+
+	fields := make(map[string]string)
+	s.validate(content.Schema, value, "", fields)
+	if len(fields) > 0 {
+		problems := make([]string, 0, len(fields))
+		for field, problem := range fields {
+			problems = append(problems, field+" "+problem)
+		}
+		return fmt.Errorf("body of status %d does not match the schema: %s", statusCode, strings.Join(problems, ", "))
+	}
+
+	return nil
+}
+
+// validate checks value against schema, adding what is wrong to fields under
+// the path of the value.
+func (s *Spec) validate(schema *Schema, value interface{}, path string, fields map[string]string) {
+	if schema.Ref != "" {
+		name := strings.TrimPrefix(schema.Ref, "#/components/schemas/")
+		if schema = s.schemas[name]; schema == nil {
+			fields[fieldName(path)] = "has an undocumented schema " + name
+			return
+		}
+	}
+
+	if value == nil {
+		if !schema.Nullable {
+			fields[fieldName(path)] = "must not be null"
+		}
+		return
+	}
+
+	if len(schema.Enum) > 0 && !inEnum(schema.Enum, value) {
+		fields[fieldName(path)] = "must be one of " + enumList(schema.Enum)
+		return
+	}
+
+	switch schema.Type {
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fields[fieldName(path)] = "must be an object"
+			return
+		}
+		s.validateObject(schema, object, path, fields)
+	case "array":
+		array, ok := value.([]interface{})
+		if !ok {
+			fields[fieldName(path)] = "must be an array"
+			return
+		}
+		if schema.MinItems != nil && len(array) < *schema.MinItems {
+			fields[fieldName(path)] = fmt.Sprintf("must have at least %d items", *schema.MinItems)
+		}
+		if schema.MaxItems != nil && len(array) > *schema.MaxItems {
+			fields[fieldName(path)] = fmt.Sprintf("must have at most %d items", *schema.MaxItems)
+		}
+		if schema.Items != nil {
+			for i, item := range array {
+				s.validate(schema.Items, item, fmt.Sprintf("%s[%d]", path, i), fields)
+			}
+		}
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fields[fieldName(path)] = "must be a string"
+			return
+		}
+		switch {
+		case schema.MinLength != nil && len(str) < *schema.MinLength:
+			if *schema.MinLength == 1 {
+				fields[fieldName(path)] = "is required"
+			} else {
+				fields[fieldName(path)] = fmt.Sprintf("must be at least %d characters", *schema.MinLength)
+			}
+		case schema.MaxLength != nil && len(str) > *schema.MaxLength:
+			fields[fieldName(path)] = fmt.Sprintf("must be at most %d characters", *schema.MaxLength)
+		case schema.Format == "date-time":
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				fields[fieldName(path)] = "must be a date-time"
+			}
+		}
+	case "integer":
+		number, ok := value.(json.Number)
+		if _, err := number.Int64(); !ok || err != nil {
+			fields[fieldName(path)] = "must be an integer"
+		}
+	case "number":
+		number, ok := value.(json.Number)
+		if _, err := number.Float64(); !ok || err != nil {
+			fields[fieldName(path)] = "must be a number"
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fields[fieldName(path)] = "must be a boolean"
+		}
+	}
+}
+
+func (s *Spec) validateObject(schema *Schema, object map[string]interface{}, path string, fields map[string]string) {
+	for _, name := range schema.Required {
+		if _, ok := object[name]; !ok {
+			fields[fieldName(join(path, name))] = "is required"
+		}
+	}
+
+	for name, value := range object {
+		if property, ok := schema.Properties[name]; ok {
+			s.validate(property, value, join(path, name), fields)
+			continue
+		}
+
+		switch ap := schema.AdditionalProperties; {
+		case ap == nil:
+		case ap.Forbidden:
+			fields[fieldName(join(path, name))] = "is not allowed"
+		case ap.Schema != nil:
+			s.validate(ap.Schema, value, join(path, name), fields)
+		}
+	}
+}
+
+// parameterValue converts the value of a parameter to what JSON decoding
+// would give, for the schema to check it.
+func parameterValue(schema *Schema, value string) interface{} {
+	switch schema.Type {
+	case "integer", "number":
+		return json.Number(value)
+	case "boolean":
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return value
+}
+
+// RequestSchema returns the schema of the JSON body of the requests of an
+// operation, its reference resolved, or nil if there is none.
+func (s *Spec) RequestSchema(op *Operation) *Schema {
+	if op.RequestBody == nil {
+		return nil
+	}
+
+	schema := jsonSchema(op.RequestBody.Content)
+	if schema != nil && schema.Ref != "" {
+		return s.schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+
+	return schema
+}
+
+// jsonSchema returns the schema of the JSON content, nil if there is none.
+func jsonSchema(content map[string]MediaType) *Schema {
+	if mediaType, ok := content["application/json"]; ok {
+		return mediaType.Schema
+	}
+	return nil
+}
+
+func decode(body []byte) (interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+func inEnum(enum []interface{}, value interface{}) bool {
+	for _, e := range enum {
+		if fmt.Sprint(e) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
+
+func enumList(enum []interface{}) string {
+	values := make([]string, 0, len(enum))
+	for _, e := range enum {
+		values = append(values, fmt.Sprint(e))
+	}
+	return strings.Join(values, ", ")
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// fieldName is the name of the field at path in errors, "body" for the
+// whole body.
+func fieldName(path string) string {
+	if path == "" {
+		return "body"
+	}
+	return path
+}
@@ -0,0 +1,191 @@
+package openapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func loadSpec(t *testing.T) *Spec {
+	t.Helper()
+
+	spec, err := Load()
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return spec
+}
+
+func TestValidateRequest(t *testing.T) {
+	spec := loadSpec(t)
+
+	tests := []struct {
+		name     string
+		op       string
+		target   string
+		pathVars map[string]string
+		body     string
+		want     map[string]string
+	}{
+		{
+			name: "valid body",
+			op:   "CreateToken",
+			body: `{"login": "+77011234567", "auth_user_type": "client", "auth_method": "sms"}`,
+		},
+		{
+			name: "missing body",
+			op:   "CreateToken",
+			want: map[string]string{"body": "is required"},
+		},
+		{
+			name: "invalid JSON",
+			op:   "CreateToken",
+			body: `{"login": `,
+			want: map[string]string{"body": "must be valid JSON"},
+		},
+		{
+			name: "missing, empty and unknown fields",
+			op:   "CreateToken",
+			body: `{"login": "", "extra": true}`,
+			want: map[string]string{
+				"login":          "is required",
+				"auth_user_type": "is required",
+				"extra":          "is not allowed",
+			},
+		},
+		{
+			name: "wrong type",
+			op:   "CreateToken",
+			body: `{"login": 7011234567, "auth_user_type": "client"}`,
+			want: map[string]string{"login": "must be a string"},
+		},
+		{
+			name: "too long",
+			op:   "CreateToken",
+			body: `{"login": "` + strings.Repeat("a", 255) + `", "auth_user_type": "client"}`,
+			want: map[string]string{"login": "must be at most 254 characters"},
+		},
+		{
+			name: "too few items",
+			op:   "IdentityBatch",
+			body: `{"tokens": []}`,
+			want: map[string]string{"tokens": "must have at least 1 items"},
+		},
+		{
+			name: "invalid item",
+			op:   "IdentityBatch",
+			body: `{"tokens": ["a", 1]}`,
+			want: map[string]string{"tokens[1]": "must be a string"},
+		},
+		{
+			name:   "query parameter",
+			op:     "Identity",
+			target: "/api/v1/tokens?token=abc",
+		},
+		{
+			name:   "missing query parameter",
+			op:     "Identity",
+			target: "/api/v1/tokens?token=",
+			want:   map[string]string{"token": "is required"},
+		},
+		{
+			name:     "path parameter",
+			op:       "Webhook",
+			pathVars: map[string]string{"id": "12"},
+		},
+		{
+			name:     "invalid path parameter",
+			op:       "Webhook",
+			pathVars: map[string]string{"id": "abc"},
+			want:     map[string]string{"id": "must be an integer"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := spec.Operation(tt.op)
+			if op == nil {
+				t.Fatalf("Operation(%q) = nil", tt.op)
+			}
+
+			target := tt.target
+			if target == "" {
+				target = "/"
+			}
+			r := httptest.NewRequest(http.MethodPost, target, nil)
+
+			got := spec.ValidateRequest(op, r, tt.pathVars, []byte(tt.body))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ValidateRequest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateResponse(t *testing.T) {
+	spec := loadSpec(t)
+
+	tests := []struct {
+		name        string
+		op          string
+		status      int
+		contentType string
+		body        string
+		wantErr     bool
+	}{
+		{
+			name:        "documented response",
+			op:          "Identity",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"user_id": "1"}`,
+		},
+		{
+			name:        "default error response",
+			op:          "Identity",
+			status:      http.StatusInternalServerError,
+			contentType: "application/json; charset=utf-8",
+			body:        `{"namespace": "auth-api", "error": "internal", "message": "Internal Server Error"}`,
+		},
+		{
+			name:        "body not matching the schema",
+			op:          "TokenIdentity",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"user_id": "1", "auth_user_types": "client"}`,
+			wantErr:     true,
+		},
+		{
+			name:        "undocumented content type",
+			op:          "Identity",
+			status:      http.StatusOK,
+			contentType: "text/plain",
+			body:        `1`,
+			wantErr:     true,
+		},
+		{
+			name:        "invalid JSON",
+			op:          "Identity",
+			status:      http.StatusOK,
+			contentType: "application/json",
+			body:        `{"user_id": `,
+			wantErr:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			op := spec.Operation(tt.op)
+			if op == nil {
+				t.Fatalf("Operation(%q) = nil", tt.op)
+			}
+
+			err := spec.ValidateResponse(op, tt.status, tt.contentType, []byte(tt.body))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ValidateResponse() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
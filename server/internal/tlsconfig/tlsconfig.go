@@ -0,0 +1,176 @@
+// Package tlsconfig provides a TLS configuration whose certificate and client
+// CA bundle are reloaded from files when they change on disk.
+package tlsconfig
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reloader holds the server certificate and the client CA bundle loaded from
+// files, and reloads them when the files change.
+type Reloader struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+	// RequireClientCert rejects connections without a client certificate
+	// signed by the client CA. Without it client certificates are only
+	// verified when given, and routes needing them check them, like the
+	// internal routes with service auth. Only require them on listeners
+	// that serve no end users.
+	RequireClientCert bool
+
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+	clientCA *x509.CertPool
+	modTimes map[string]time.Time
+}
+
+// Load loads the certificate and client CA bundle. It must be called once
+// before the TLS config is used.
+func (rl *Reloader) Load() error {
+	cert, err := tls.LoadX509KeyPair(rl.CertFile, rl.KeyFile)
+	if err != nil {
+		return fmt.Errorf("error loading tls certificate: %w", err)
+	}
+
+	var clientCA *x509.CertPool
+	if rl.ClientCAFile != "" {
+		pem, err := os.ReadFile(rl.ClientCAFile)
+		if err != nil {
+			return fmt.Errorf("error reading client ca bundle: %w", err)
+		}
+
+		clientCA = x509.NewCertPool()
+		if !clientCA.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in client ca bundle %s", rl.ClientCAFile)
+		}
+	}
+
+	modTimes, err := rl.currentModTimes()
+	if err != nil {
+		return err
+	}
+
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	rl.cert = &cert
+	rl.clientCA = clientCA
+	rl.modTimes = modTimes
+
+	return nil
+}
+
+// Run checks the files every interval and reloads them when they changed,
+// until ctx is done. A failed reload keeps the previous certificates.
+func (rl *Reloader) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			changed, err := rl.changed()
+			if err != nil {
+				log.Error(err.Error())
+				continue
+			}
+
+			if !changed {
+				continue
+			}
+
+			if err := rl.Load(); err != nil {
+				log.Errorf("error reloading tls files, keeping the previous ones: %s", err)
+				continue
+			}
+
+			log.Info("Reloaded tls certificate and client ca bundle")
+		}
+	}
+}
+
+// TLSConfig returns the TLS config to serve with, negotiating the ALPN
+// protocols nextProtos (e.g. "h2" and "http/1.1" for HTTP/2 with a fallback).
+// Every handshake uses the latest loaded certificate and client CA bundle.
+func (rl *Reloader) TLSConfig(nextProtos ...string) *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: nextProtos,
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			rl.mu.RLock()
+			defer rl.mu.RUnlock()
+
+			return rl.cert, nil
+		},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			rl.mu.RLock()
+			defer rl.mu.RUnlock()
+
+			// The config returned here replaces the one of the server for
+			// the handshake, so it needs the protocols too, or HTTP/2 and
+			// gRPC clients enforcing ALPN fail.
+			config := &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				NextProtos:   nextProtos,
+				Certificates: []tls.Certificate{*rl.cert},
+			}
+
+			if rl.clientCA != nil {
+				config.ClientCAs = rl.clientCA
+				config.ClientAuth = tls.VerifyClientCertIfGiven
+				if rl.RequireClientCert {
+					config.ClientAuth = tls.RequireAndVerifyClientCert
+				}
+			}
+
+			return config, nil
+		},
+	}
+}
+
+func (rl *Reloader) changed() (bool, error) {
+	modTimes, err := rl.currentModTimes()
+	if err != nil {
+		return false, err
+	}
+
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	for file, modTime := range modTimes {
+		if !modTime.Equal(rl.modTimes[file]) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func (rl *Reloader) currentModTimes() (map[string]time.Time, error) {
+	modTimes := make(map[string]time.Time)
+
+	for _, file := range []string{rl.CertFile, rl.KeyFile, rl.ClientCAFile} {
+		if file == "" {
+			continue
+		}
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, fmt.Errorf("error checking tls file: %w", err)
+		}
+		modTimes[file] = info.ModTime()
+	}
+
+	return modTimes, nil
+}
@@ -0,0 +1,233 @@
+package tlsconfig
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// writeCert writes a new self-signed certificate for commonName and its key
+// to certFile and keyFile.
+func writeCert(t *testing.T, certFile, keyFile, commonName string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: commonName},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// touch moves the modification time of the files forward, as filesystems
+// with a coarse resolution may not see a quick rewrite as a change.
+func touch(t *testing.T, files ...string) {
+	t.Helper()
+
+	later := time.Now().Add(time.Minute)
+	for _, file := range files {
+		if err := os.Chtimes(file, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func newReloader(t *testing.T) *Reloader {
+	t.Helper()
+
+	dir := t.TempDir()
+	rl := &Reloader{
+		CertFile:     filepath.Join(dir, "tls.crt"),
+		KeyFile:      filepath.Join(dir, "tls.key"),
+		ClientCAFile: filepath.Join(dir, "ca.crt"),
+	}
+	writeCert(t, rl.CertFile, rl.KeyFile, "server-1")
+	writeCert(t, rl.ClientCAFile, filepath.Join(dir, "ca.key"), "client-ca")
+
+	if err := rl.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	return rl
+}
+
+// servedName returns the common name of the certificate served for a new
+// handshake.
+func servedName(t *testing.T, config *tls.Config) string {
+	t.Helper()
+
+	cert, err := config.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.Subject.CommonName
+}
+
+func TestReloaderLoad(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		rl      *Reloader
+		write   map[string]string
+		wantErr bool
+	}{
+		{
+			name:    "missing certificate",
+			rl:      &Reloader{CertFile: filepath.Join(dir, "missing.crt"), KeyFile: filepath.Join(dir, "missing.key")},
+			wantErr: true,
+		},
+		{
+			name: "client ca bundle without certificates",
+			rl: &Reloader{
+				CertFile:     filepath.Join(dir, "tls.crt"),
+				KeyFile:      filepath.Join(dir, "tls.key"),
+				ClientCAFile: filepath.Join(dir, "empty-ca.crt"),
+			},
+			write:   map[string]string{"empty-ca.crt": "not a certificate"},
+			wantErr: true,
+		},
+		{
+			name: "without a client ca bundle",
+			rl:   &Reloader{CertFile: filepath.Join(dir, "tls.crt"), KeyFile: filepath.Join(dir, "tls.key")},
+		},
+	}
+
+	writeCert(t, filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), "server")
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for file, content := range tt.write {
+				if err := os.WriteFile(filepath.Join(dir, file), []byte(content), 0o600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err := tt.rl.Load()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloaderTLSConfig(t *testing.T) {
+	tests := []struct {
+		name              string
+		withoutClientCA   bool
+		requireClientCert bool
+		wantClientAuth    tls.ClientAuthType
+	}{
+		{"client certificates verified if given", false, false, tls.VerifyClientCertIfGiven},
+		{"client certificates required", false, true, tls.RequireAndVerifyClientCert},
+		{"without a client ca bundle", true, true, tls.NoClientCert},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rl := newReloader(t)
+			rl.RequireClientCert = tt.requireClientCert
+			if tt.withoutClientCA {
+				rl.ClientCAFile = ""
+				if err := rl.Load(); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			config, err := rl.TLSConfig("h2", "http/1.1").GetConfigForClient(&tls.ClientHelloInfo{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if config.ClientAuth != tt.wantClientAuth {
+				t.Errorf("ClientAuth = %v, want %v", config.ClientAuth, tt.wantClientAuth)
+			}
+			if (config.ClientCAs != nil) == tt.withoutClientCA {
+				t.Errorf("ClientCAs = %v, want a pool %v", config.ClientCAs, !tt.withoutClientCA)
+			}
+			if want := []string{"h2", "http/1.1"}; !reflect.DeepEqual(config.NextProtos, want) {
+				t.Errorf("NextProtos = %v, want %v", config.NextProtos, want)
+			}
+			if len(config.Certificates) != 1 {
+				t.Errorf("Certificates = %d, want 1", len(config.Certificates))
+			}
+		})
+	}
+}
+
+func TestReloaderReload(t *testing.T) {
+	rl := newReloader(t)
+	config := rl.TLSConfig()
+
+	if changed, err := rl.changed(); err != nil || changed {
+		t.Fatalf("changed() = %v, %v, want false", changed, err)
+	}
+
+	// A broken certificate keeps the previous one.
+	if err := os.WriteFile(rl.CertFile, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, rl.CertFile)
+	if changed, err := rl.changed(); err != nil || !changed {
+		t.Fatalf("changed() = %v, %v, want true", changed, err)
+	}
+	if err := rl.Load(); err == nil {
+		t.Fatal("Load() error = nil, want an error for a broken certificate")
+	}
+	if got := servedName(t, config); got != "server-1" {
+		t.Errorf("served certificate = %q, want server-1", got)
+	}
+
+	// A renewed certificate is picked up by Run.
+	writeCert(t, rl.CertFile, rl.KeyFile, "server-2")
+	touch(t, rl.CertFile, rl.KeyFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go rl.Run(ctx, 10*time.Millisecond)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, config) != "server-2" {
+		if time.Now().After(deadline) {
+			t.Fatal("renewed certificate not served after 5s")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
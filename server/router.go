@@ -4,27 +4,158 @@ import (
 	"fmt"
 	"net/http"
 
+	"gitlab.com/route-kz/auth-api/caller"
+	"gitlab.com/route-kz/auth-api/scim"
 	"gitlab.com/route-kz/auth-api/server/internal/handler"
 	"gitlab.com/route-kz/auth-api/server/internal/middleware"
+	"gitlab.com/route-kz/auth-api/server/internal/openapi"
+	"gitlab.com/route-kz/auth-api/user"
 
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-const v1API string = "/api/v1"
+const (
+	v1API string = "/api/v1"
+	// v2API serves the same routes as v1, with errors sent as RFC 7807
+	// problem details.
+	v2API string = "/api/v2"
+	// scimAPI serves the SCIM 2.0 routes directories provision users with.
+	scimAPI string = "/scim/v2"
+
+	openAPIValidationOff      = "off"
+	openAPIValidationRequests = "requests"
+	openAPIValidationStrict   = "strict"
+)
 
 // setupRoutes - the root route function.
 func (s *Server) setupRoutes() {
 	s.Router.Handle("/metrics", promhttp.Handler()).Name("Metrics")
 	s.Router.HandleFunc("/_healthz", handler.Healthz).Methods(http.MethodGet).Name("Health")
+	s.Router.HandleFunc("/openapi.json", handler.OpenAPI(openapi.Document())).Methods(http.MethodGet).Name("OpenAPI")
+
+	// Reverse proxies forward the method and headers of the requests they
+	// authenticate, so the route takes any method and no CSRF protection.
+	forwardAuth := s.Router.NewRoute().Subrouter()
+	forwardAuth.HandleFunc("/forward-auth{path:(?:/.*)?}", handler.ForwardAuth(s.DB, s.Config.ForwardAuthPolicies, s.Config.ForwardAuthCacheTTL)).Name("ForwardAuth")
+	addTracingAndMetrics(forwardAuth)
+
+	// The SCIM routes have their own errors and tenant auth, and are not in
+	// the OpenAPI document.
+	scimRouter := s.Router.PathPrefix(scimAPI).Subrouter()
+	scimRouter.HandleFunc("/Users", handler.CreateSCIMUser(s.DB, s.Config.RequestMaxBytes)).Methods(http.MethodPost).Name("CreateSCIMUser")
+	scimRouter.HandleFunc("/Users", handler.SCIMUsers(s.DB)).Methods(http.MethodGet).Name("SCIMUsers")
+	scimRouter.HandleFunc("/Users/{id}", handler.SCIMUser(s.DB)).Methods(http.MethodGet).Name("SCIMUser")
+	scimRouter.HandleFunc("/Users/{id}", handler.PatchSCIMUser(s.DB, s.Config.RequestMaxBytes)).Methods(http.MethodPatch).Name("PatchSCIMUser")
+	scimRouter.HandleFunc("/Users/{id}", handler.ReplaceSCIMUser(s.DB, s.Config.RequestMaxBytes)).Methods(http.MethodPut).Name("ReplaceSCIMUser")
+	scimRouter.HandleFunc("/Users/{id}", handler.DeleteSCIMUser(s.DB)).Methods(http.MethodDelete).Name("DeleteSCIMUser")
+	addTracingAndMetrics(scimRouter)
+	addSCIMAuth(scimRouter, s.DB)
+
+	v1 := s.Router.PathPrefix(v1API).Subrouter()
+	s.setupAPI(v1)
+
+	v2 := s.Router.PathPrefix(v2API).Subrouter()
+	v2.Use(middleware.ProblemDetails)
+	v2.NotFoundHandler = middleware.ProblemDetails(http.HandlerFunc(handler.NotFound))
+	v2.MethodNotAllowedHandler = middleware.ProblemDetails(http.HandlerFunc(handler.MethodNotAllowed))
+	s.setupAPI(v2)
+
+	// CORS and the security headers also apply to requests matching no
+	// route, like preflight requests, so they wrap the whole router.
+	cors := middleware.NewCORS(s.Config.CORSAllowedOrigins, s.Config.CORSAllowCredentials, s.Config.CORSMaxAge)
+	sh := &middleware.SecurityHeaders{HSTSMaxAge: s.Config.HSTSMaxAge}
+	s.HTTP.Handler = sh.Middleware(cors.Middleware(s.Router))
+}
+
+// setupAPI - Sets up the routes of a version of the API.
+func (s *Server) setupAPI(api *mux.Router) {
+	rl := &middleware.RateLimit{Limiter: s.Redis, Config: s.Config}
+
+	// Auth codes are only checked once the clients ask for them.
+	var authCodes user.CodeIssuer
+	if s.Config.TokenAuthCodeRequired {
+		authCodes = s.Redis
+	}
+
+	tokenAPI := api.NewRoute().Subrouter()
+	tokenAPI.HandleFunc("/tokens", handler.CreateToken(s.DB, authCodes, s.Config.AuthUserTypes, s.Config.AuthMethods, s.Config.RequestMaxBytes)).Methods(http.MethodPost).Name(fmt.Sprintf("CreateToken"))
+	tokenAPI.HandleFunc("/tokens/codes", handler.CreateLoginCode(s.DB, s.Redis, s.Nats, s.Config.AuthUserTypes, s.Config.AuthMethods, s.Config.RequestMaxBytes)).Methods(http.MethodPost).Name("CreateLoginCode")
+	tokenAPI.HandleFunc("/refresh-tokens", handler.RefreshToken(s.DB)).Methods(http.MethodPost).Name(fmt.Sprintf("RefreshToken"))
+
+	internalAPI := api.NewRoute().Subrouter()
+	internalAPI.HandleFunc("/tokens", handler.Identity(s.DB)).Methods(http.MethodGet).Name("Identity")
+	internalAPI.HandleFunc("/tokens/identity", handler.TokenIdentity(s.DB)).Methods(http.MethodGet).Name("TokenIdentity")
+	internalAPI.HandleFunc("/personal-data", handler.PersonalData(s.DB)).Methods(http.MethodGet).Name("PersonalData")
+	internalAPI.HandleFunc("/tokens/batch", handler.IdentityBatch(s.DB, s.Config.BatchMaxSize, s.Config.RequestMaxBytes)).Methods(http.MethodPost).Name("IdentityBatch")
+	internalAPI.HandleFunc("/personal-data/batch", handler.PersonalDataBatch(s.DB, s.Config.BatchMaxSize, s.Config.RequestMaxBytes)).Methods(http.MethodPost).Name("PersonalDataBatch")
 
-	api := s.Router.PathPrefix(v1API).Subrouter()
-	api.HandleFunc("/tokens", handler.CreateToken(s.DB)).Methods(http.MethodPost).Name(fmt.Sprintf("CreateToken"))
-	api.HandleFunc("/refresh-tokens", handler.RefreshToken(s.DB)).Methods(http.MethodPost).Name(fmt.Sprintf("RefreshToken"))
-	api.HandleFunc("/tokens", handler.Identity(s.DB)).Methods(http.MethodGet).Name("Identity")
-	api.HandleFunc("/personal-data", handler.PersonalData(s.DB)).Methods(http.MethodGet).Name("PersonalData")
+	internalAPI.HandleFunc("/webhooks", handler.Webhooks(s.DB)).Methods(http.MethodGet).Name("Webhooks")
+	internalAPI.HandleFunc("/webhooks", handler.CreateWebhook(s.DB, s.Config.WebhookAllowInsecure, s.Config.RequestMaxBytes)).Methods(http.MethodPost).Name("CreateWebhook")
+	internalAPI.HandleFunc("/webhooks/{id:[0-9]+}", handler.Webhook(s.DB)).Methods(http.MethodGet).Name("Webhook")
+	internalAPI.HandleFunc("/webhooks/{id:[0-9]+}", handler.DeleteWebhook(s.DB)).Methods(http.MethodDelete).Name("DeleteWebhook")
+	internalAPI.HandleFunc("/webhooks/{id:[0-9]+}/enable", handler.EnableWebhook(s.DB)).Methods(http.MethodPost).Name("EnableWebhook")
+	internalAPI.HandleFunc("/webhooks/{id:[0-9]+}/deliveries", handler.WebhookDeliveries(s.DB)).Methods(http.MethodGet).Name("WebhookDeliveries")
+
+	userAPI := api.NewRoute().Subrouter()
+	userAPI.HandleFunc("/logins", handler.Logins(s.DB)).Methods(http.MethodGet).Name("Logins")
+	userAPI.HandleFunc("/logins", handler.LinkLogin(s.DB, s.Redis, s.Nats, s.Config.AuthMethods)).Methods(http.MethodPost).Name("LinkLogin")
+	userAPI.Handle("/logins/verify", rl.Middleware(handler.VerifyLinkLogin(s.DB, s.Redis, s.Config.AuthMethods))).Methods(http.MethodPost).Name("VerifyLinkLogin")
+	userAPI.HandleFunc("/logins", handler.UnlinkLogin(s.DB)).Methods(http.MethodDelete).Name("UnlinkLogin")
+	userAPI.HandleFunc("/logins/change", handler.ChangeLogin(s.DB, s.Redis, s.Nats, s.Config.AuthMethods)).Methods(http.MethodPost).Name("ChangeLogin")
+	userAPI.Handle("/logins/change/verify", rl.Middleware(handler.VerifyChangeLogin(s.DB, s.Redis, s.Nats, s.Config.AuthMethods))).Methods(http.MethodPost).Name("VerifyChangeLogin")
+	userAPI.HandleFunc("/logins/change", handler.CancelLoginChange(s.DB)).Methods(http.MethodDelete).Name("CancelLoginChange")
 
 	addTracingAndMetrics(api)
+	if s.Config.SessionCookieName != "" {
+		addCSRF(api, s.Config.CSRFCookieName, s.Config.SessionCookieName)
+	}
+	tokenAPI.Use(rl.Middleware)
+	addServiceAuth(internalAPI, s.DB, caller.NewCertificateIdentities(s.Config.TLSClientIdentities), s.Config.ServiceAuthRequired)
+	addUserAuth(userAPI, s.DB)
+
+	// Requests are validated once authenticated, so unauthenticated callers
+	// learn nothing about the fields of internal routes.
+	if s.Config.OpenAPIValidation != openAPIValidationOff {
+		for _, r := range []*mux.Router{tokenAPI, internalAPI, userAPI} {
+			addOpenAPIValidation(r, s.OpenAPI, s.Config.OpenAPIValidation == openAPIValidationStrict, s.Config.RequestMaxBytes)
+		}
+	}
+}
+
+// addServiceAuth - Requires the requests to a router to be made by a
+// service with a client certificate or API key allowing it to call the route.
+func addServiceAuth(r *mux.Router, db caller.Fetcher, certificates caller.CertificateIdentities, required bool) {
+	sa := &middleware.ServiceAuth{DB: db, Certificates: certificates, Required: required}
+	r.Use(sa.Middleware)
+}
+
+// addUserAuth - Requires the requests to a router to be made by an
+// authenticated user.
+func addUserAuth(r *mux.Router, db user.IDFetcher) {
+	ua := middleware.UserAuth{DB: db}
+	r.Use(ua.Middleware)
+}
+
+// addSCIMAuth - Requires the requests to a router to be made by the
+// directory of a tenant.
+func addSCIMAuth(r *mux.Router, db scim.TenantFetcher) {
+	sa := &middleware.SCIMAuth{DB: db}
+	r.Use(sa.Middleware)
+}
+
+// addOpenAPIValidation - Validates the requests to a router, and in strict
+// mode the responses, against the OpenAPI document.
+func addOpenAPIValidation(r *mux.Router, spec *openapi.Spec, strict bool, maxBytes int64) {
+	ov := &middleware.OpenAPIValidation{Spec: spec, Strict: strict, MaxBytes: maxBytes}
+	r.Use(ov.Middleware)
+}
+
+// addCSRF - Protects the requests to a router authenticated by cookies
+// against cross-site request forgery.
+func addCSRF(r *mux.Router, cookieName, sessionCookieName string) {
+	csrf := &middleware.CSRF{CookieName: cookieName, SessionCookieName: sessionCookieName}
+	r.Use(csrf.Middleware)
 }
 
 // addTracingAndMetrics - Adds tracing and metrics to a router.
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"gitlab.com/route-kz/auth-api/client/database"
+	"gitlab.com/route-kz/auth-api/config"
+	"gitlab.com/route-kz/auth-api/server/internal/openapi"
+)
+
+func newTestServer(t *testing.T, cfg *config.Config) *Server {
+	t.Helper()
+
+	spec, err := openapi.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{
+		Config:  cfg,
+		DB:      &database.Client{},
+		Router:  mux.NewRouter(),
+		OpenAPI: spec,
+	}
+	s.HTTP = &http.Server{Handler: s.Router}
+	s.setupRoutes()
+
+	return s
+}
+
+func TestRoutesMatchOpenAPI(t *testing.T) {
+	s := newTestServer(t, &config.Config{OpenAPIValidation: openAPIValidationRequests})
+
+	if err := s.OpenAPI.CheckRoutes(s.Router, v1API); err != nil {
+		t.Errorf("CheckRoutes() error = %v", err)
+	}
+}
+
+func TestValidationAfterAuth(t *testing.T) {
+	s := newTestServer(t, &config.Config{
+		OpenAPIValidation:   openAPIValidationRequests,
+		ServiceAuthRequired: true,
+		RequestMaxBytes:     1 << 20,
+	})
+
+	tests := []struct {
+		name   string
+		method string
+		target string
+		body   string
+	}{
+		{"internal route missing a parameter", http.MethodGet, v1API + "/tokens", ""},
+		{"internal route with an invalid body", http.MethodPost, v1API + "/tokens/batch", `{"extra": true}`},
+		{"user route with an invalid body", http.MethodPost, v1API + "/logins", `{"extra": true}`},
+		{"v2 route", http.MethodGet, v2API + "/tokens", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			s.HTTP.Handler.ServeHTTP(w, r)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("status = %d, want %d: %s", w.Code, http.StatusUnauthorized, w.Body)
+			}
+		})
+	}
+}
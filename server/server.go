@@ -2,45 +2,77 @@
 //
 // The server holds all the clients it needs and they should be set up in the Create method.
 //
-// The HTTP routes and middleware are set up in the setupRouter method.
+// The HTTP routes and middleware are set up in the setupRouter method, the
+// gRPC services in the setupGRPC method.
 package server
 
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 
+	"gitlab.com/route-kz/auth-api/client"
 	"gitlab.com/route-kz/auth-api/client/database"
+	"gitlab.com/route-kz/auth-api/client/nats"
+	"gitlab.com/route-kz/auth-api/client/redis"
 	"gitlab.com/route-kz/auth-api/config"
 	"gitlab.com/route-kz/auth-api/monitoring/metrics"
 	"gitlab.com/route-kz/auth-api/monitoring/trace"
+	"gitlab.com/route-kz/auth-api/server/internal/openapi"
+	"gitlab.com/route-kz/auth-api/server/internal/tlsconfig"
+	"gitlab.com/route-kz/auth-api/webhook"
 
 	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
 )
 
 // Server holds the HTTP server, router, config and all clients.
 type Server struct {
 	Config *config.Config
 	DB     *database.Client
+	Redis  *redis.Client
+	Nats   *nats.Client
 	HTTP   *http.Server
 	Router *mux.Router
+	// TLS is set when the server terminates TLS itself.
+	TLS *tlsconfig.Reloader
+	// OpenAPI is the OpenAPI document of the routes.
+	OpenAPI *openapi.Spec
+	// GRPC is set when the gRPC API is served, on GRPCPort.
+	GRPC       *grpc.Server
+	GRPCHealth *health.Server
 }
 
 // Create sets up the HTTP server, router and all clients.
 // Returns an error if an error occurs.
 func (s *Server) Create(ctx context.Context, config *config.Config) error {
 	metrics.RegisterPrometheusCollectors()
+	client.Namespace = config.ErrorNamespace
 
 	var dbClient database.Client
 	if err := dbClient.Init(ctx, config); err != nil {
 		return fmt.Errorf("database client: %w", err)
 	}
 
+	var redisClient redis.Client
+	if err := redisClient.Init(ctx, config); err != nil {
+		return fmt.Errorf("redis client: %w", err)
+	}
+
+	var natsClient nats.Client
+	if err := natsClient.Init(ctx, config); err != nil {
+		return fmt.Errorf("nats client: %w", err)
+	}
+
 	s.DB = &dbClient
+	s.Redis = &redisClient
+	s.Nats = &natsClient
 	s.Config = config
 	s.Router = mux.NewRouter()
 	s.HTTP = &http.Server{
@@ -48,8 +80,43 @@ func (s *Server) Create(ctx context.Context, config *config.Config) error {
 		Handler: s.Router,
 	}
 
+	if config.TLSCertFile != "" {
+		s.TLS = &tlsconfig.Reloader{
+			CertFile:          config.TLSCertFile,
+			KeyFile:           config.TLSKeyFile,
+			ClientCAFile:      config.TLSClientCAFile,
+			RequireClientCert: config.TLSRequireClientCert,
+		}
+
+		if err := s.TLS.Load(); err != nil {
+			return fmt.Errorf("tls: %w", err)
+		}
+
+		s.HTTP.TLSConfig = s.TLS.TLSConfig("h2", "http/1.1")
+	}
+
+	switch config.OpenAPIValidation {
+	case openAPIValidationOff, openAPIValidationRequests, openAPIValidationStrict:
+	default:
+		return fmt.Errorf("invalid OPENAPI_VALIDATION %q", config.OpenAPIValidation)
+	}
+
+	spec, err := openapi.Load()
+	if err != nil {
+		return fmt.Errorf("openapi: %w", err)
+	}
+	s.OpenAPI = spec
+
 	s.setupRoutes()
 
+	if err := s.OpenAPI.CheckRoutes(s.Router, v1API); err != nil {
+		return fmt.Errorf("openapi: %w", err)
+	}
+
+	if config.GRPCPort != "" {
+		s.setupGRPC()
+	}
+
 	return nil
 }
 
@@ -65,8 +132,62 @@ func (s *Server) Serve(ctx context.Context) error {
 
 	defer closer.Close()
 
+	workersCtx, stopWorkers := context.WithCancel(ctx)
+	defer stopWorkers()
+
+	go s.DB.RunTokenPartitionMaintenance(workersCtx, s.Config.TokensPartitionMaintenanceEvery)
+	go s.DB.RunLoginChangeApplier(workersCtx, s.Config.LoginChangeApplyEvery)
+	go s.DB.RunOutboxRelay(workersCtx, s.Nats, s.Config.OutboxRelayEvery)
+
+	webhooks := &webhook.Dispatcher{
+		Queue:       s.DB,
+		Client:      webhook.NewClient(s.Config.WebhookTimeout, s.Config.WebhookAllowedNetworks),
+		BatchSize:   s.Config.WebhookBatchSize,
+		MaxAttempts: s.Config.WebhookMaxAttempts,
+		RetryBase:   s.Config.WebhookRetryBase,
+		RetryMax:    s.Config.WebhookRetryMax,
+	}
+	go webhooks.Run(workersCtx, s.Config.WebhookDispatchEvery)
+
+	if s.Config.NatsCommandStream != "" {
+		err := s.Nats.ConsumeCommands(workersCtx, nats.CommandConfig{
+			Subjects: map[nats.Command]string{
+				nats.CommandRevokeTokens: s.Config.NatsCommandRevokeTokensSubject,
+				nats.CommandBlockUser:    s.Config.NatsCommandBlockUserSubject,
+				nats.CommandUnblockUser:  s.Config.NatsCommandUnblockUserSubject,
+			},
+			Stream:            s.Config.NatsCommandStream,
+			Durable:           s.Config.NatsCommandDurable,
+			MaxDeliver:        s.Config.NatsCommandMaxDeliver,
+			AckWait:           s.Config.NatsCommandAckWait,
+			DeadLetterSubject: s.Config.NatsCommandDeadLetterSubject,
+		}, s.DB)
+		if err != nil {
+			return fmt.Errorf("nats commands: %w", err)
+		}
+	}
+
+	if s.Config.DatabasePasswordFile != "" {
+		go s.DB.RunPasswordReload(workersCtx, s.Config.DatabasePasswordFile, s.Config.DatabasePasswordReloadEvery)
+	}
+
+	if s.GRPC != nil {
+		listener, err := net.Listen("tcp", fmt.Sprintf(":%s", s.Config.GRPCPort))
+		if err != nil {
+			return fmt.Errorf("grpc listener: %w", err)
+		}
+
+		go func() {
+			if err := s.GRPC.Serve(listener); err != nil {
+				log.Errorf("unexpected grpc server error: %s", err)
+			}
+		}()
+
+		log.Infof("gRPC ready at: %s", s.Config.GRPCPort)
+	}
+
 	idleConnsClosed := make(chan struct{}) // this is used to signal that we can not exit
-	go func(ctx context.Context, s *http.Server) {
+	go func(ctx context.Context, s *Server) {
 		stop := make(chan os.Signal, 1)
 		signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
 
@@ -74,16 +195,30 @@ func (s *Server) Serve(ctx context.Context) error {
 
 		log.Info("Shutdown signal received")
 
-		if err := s.Shutdown(ctx); err != nil {
+		if s.GRPC != nil {
+			s.GRPCHealth.Shutdown()
+			s.GRPC.GracefulStop()
+		}
+
+		if err := s.HTTP.Shutdown(ctx); err != nil {
 			log.Error(err.Error())
 		}
 
 		close(idleConnsClosed) // call close to say we can now exit the function
-	}(ctx, s.HTTP)
+	}(ctx, s)
 
 	log.Infof("Ready at: %s", s.Config.Port)
 
-	if err := s.HTTP.ListenAndServe(); err != http.ErrServerClosed {
+	if s.TLS != nil {
+		go s.TLS.Run(workersCtx, s.Config.TLSReloadEvery)
+
+		// The certificate comes from the TLS config, so no files are given here.
+		err = s.HTTP.ListenAndServeTLS("", "")
+	} else {
+		err = s.HTTP.ListenAndServe()
+	}
+
+	if err != http.ErrServerClosed {
 		return fmt.Errorf("unexpected server error: %w", err)
 	}
 	<-idleConnsClosed // this will block until close is called
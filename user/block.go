@@ -0,0 +1,22 @@
+package user
+
+import "context"
+
+// TokenRevoker is an interface for revoking all the tokens of a user.
+type TokenRevoker interface {
+	RevokeTokens(ctx context.Context, userID string) (int, error)
+}
+
+// Blocker is an interface for blocking users, which revokes their tokens
+// and keeps them from getting new ones, and unblocking them.
+type Blocker interface {
+	BlockUser(ctx context.Context, userID, reason string) error
+	UnblockUser(ctx context.Context, userID string) error
+}
+
+// AccountCommander is an interface for the commands other services send
+// about the accounts of users.
+type AccountCommander interface {
+	TokenRevoker
+	Blocker
+}
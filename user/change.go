@@ -0,0 +1,50 @@
+package user
+
+import (
+	"context"
+	"time"
+)
+
+// ChangeLoginPayload is the body of a request to replace a login of the
+// authenticated user with a new one of the same auth user type.
+// AuthUserType is required only if the user has the old login with several
+// auth user types.
+type ChangeLoginPayload struct {
+	OldLogin     string `json:"old_login"`
+	NewLogin     string `json:"new_login"`
+	AuthMethod   string `json:"auth_method"`
+	AuthUserType string `json:"auth_user_type,omitempty"`
+}
+
+// VerifyChangeLoginPayload is the body of a request to finish a login
+// change. NewCode is required; with a valid OldCode the change is applied
+// right away, without it after a grace period.
+type VerifyChangeLoginPayload struct {
+	OldLogin     string `json:"old_login"`
+	NewLogin     string `json:"new_login"`
+	AuthMethod   string `json:"auth_method"`
+	AuthUserType string `json:"auth_user_type,omitempty"`
+	NewCode      string `json:"new_code"`
+	OldCode      string `json:"old_code"`
+}
+
+// LoginChange is a verified change of a user's login.
+type LoginChange struct {
+	UserID   string    `json:"user_id"`
+	OldLogin string    `json:"old_login"`
+	NewLogin Login     `json:"new_login"`
+	ApplyAt  time.Time `json:"apply_at"`
+	Applied  bool      `json:"applied"`
+}
+
+// LoginChanger is an interface for replacing the login of a user. The
+// replaced login is kept in the login history of the user.
+type LoginChanger interface {
+	LoginLinker
+	// ChangeLogin replaces the login right away.
+	ChangeLogin(ctx context.Context, userID, oldLogin string, newLogin Login) (*LoginChange, error)
+	// ScheduleLoginChange replaces the login once the grace period is over.
+	ScheduleLoginChange(ctx context.Context, userID, oldLogin string, newLogin Login) (*LoginChange, error)
+	// CancelLoginChange cancels a scheduled login change.
+	CancelLoginChange(ctx context.Context, userID, newLogin string) error
+}
@@ -0,0 +1,95 @@
+package user
+
+import "errors"
+
+// ErrorCode is a stable, machine-readable code of a domain error, sent to
+// clients in error responses.
+type ErrorCode string
+
+const (
+	CodeInvalidCredentials ErrorCode = "invalid_credentials"
+	CodeInvalidToken       ErrorCode = "invalid_token"
+	CodeTokenExpired       ErrorCode = "token_expired"
+	CodeUserBlocked        ErrorCode = "user_blocked"
+	CodeValidationFailed   ErrorCode = "validation_failed"
+	CodeLoginTaken         ErrorCode = "login_taken"
+	CodeLoginAlreadyLinked ErrorCode = "login_already_linked"
+	CodeLoginNotFound      ErrorCode = "login_not_found"
+	CodeLastLogin          ErrorCode = "last_login"
+	CodeInvalidCode        ErrorCode = "invalid_code"
+	CodeLoginCoolingDown   ErrorCode = "login_cooling_down"
+	CodeUnauthorized       ErrorCode = "unauthorized"
+	CodeForbidden          ErrorCode = "forbidden"
+	CodeRateLimited        ErrorCode = "rate_limited"
+	CodeBadRequest         ErrorCode = "bad_request"
+	CodeNotFound           ErrorCode = "not_found"
+	CodeMethodNotAllowed   ErrorCode = "method_not_allowed"
+	CodeRequestTooLarge    ErrorCode = "request_too_large"
+	CodeInternal           ErrorCode = "internal_error"
+)
+
+// Error is a domain error. Its message is safe to show to clients; details
+// that are not go into the wrapped error, which only ends up in logs and
+// traces. Fields holds what is wrong with each invalid field of a request.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match domain errors by code, so wrapped or detailed
+// errors match the sentinel errors below.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if !errors.As(target, &t) {
+		return false
+	}
+	return t.Code == e.Code
+}
+
+// Wrap returns a copy of the domain error wrapping err as internal detail.
+func (e *Error) Wrap(err error) *Error {
+	return &Error{
+		Code:    e.Code,
+		Message: e.Message,
+		Fields:  e.Fields,
+		Err:     err,
+	}
+}
+
+// WithFields returns a copy of the domain error with errors of fields.
+func (e *Error) WithFields(fields map[string]string) *Error {
+	return &Error{
+		Code:    e.Code,
+		Message: e.Message,
+		Fields:  fields,
+		Err:     e.Err,
+	}
+}
+
+var (
+	// ErrInvalidCredentials is returned when a login or code is wrong.
+	ErrInvalidCredentials = &Error{Code: CodeInvalidCredentials, Message: "invalid credentials"}
+	// ErrInvalidToken is returned when a token does not exist.
+	ErrInvalidToken = &Error{Code: CodeInvalidToken, Message: "invalid token"}
+	// ErrTokenExpired is returned when a token is older than its lifetime.
+	ErrTokenExpired = &Error{Code: CodeTokenExpired, Message: "token expired"}
+	// ErrUserBlocked is returned when a blocked user tries to authenticate.
+	ErrUserBlocked = &Error{Code: CodeUserBlocked, Message: "user is blocked"}
+	// ErrUserNotFound is returned when a user does not exist.
+	ErrUserNotFound = &Error{Code: CodeNotFound, Message: "user not found"}
+	// ErrValidationFailed is returned when a request is invalid.
+	ErrValidationFailed = &Error{Code: CodeValidationFailed, Message: "validation failed"}
+)
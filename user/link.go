@@ -0,0 +1,133 @@
+package user
+
+import (
+	"context"
+	"strings"
+)
+
+var (
+	// ErrLoginTaken is returned when a login is already linked to another user.
+	ErrLoginTaken = &Error{Code: CodeLoginTaken, Message: "login is linked to another user"}
+	// ErrLoginAlreadyLinked is returned when a login is already linked to the user.
+	ErrLoginAlreadyLinked = &Error{Code: CodeLoginAlreadyLinked, Message: "login is already linked to the user"}
+	// ErrLoginNotFound is returned when a login is not linked to the user.
+	ErrLoginNotFound = &Error{Code: CodeLoginNotFound, Message: "login is not linked to the user"}
+	// ErrLastLogin is returned when unlinking the only login of a user.
+	ErrLastLogin = &Error{Code: CodeLastLogin, Message: "the last login of a user can not be unlinked"}
+	// ErrInvalidCode is returned when a verification code is wrong or expired.
+	ErrInvalidCode = &Error{Code: CodeInvalidCode, Message: "invalid or expired verification code"}
+	// ErrLoginCoolingDown is returned when a login was recently replaced by
+	// its user and can not be used by anyone else yet.
+	ErrLoginCoolingDown = &Error{Code: CodeLoginCoolingDown, Message: "login was recently released and can not be used yet"}
+)
+
+// VerificationPurpose is what a verification code is sent for.
+type VerificationPurpose string
+
+const (
+	// VerificationPurposeLogin codes are sent to a login to create a token
+	// with, as its auth code.
+	VerificationPurposeLogin     VerificationPurpose = "login"
+	VerificationPurposeLinkLogin VerificationPurpose = "link_login"
+	// VerificationPurposeChangeLogin codes are sent to the new login of a
+	// login change.
+	VerificationPurposeChangeLogin VerificationPurpose = "change_login"
+	// VerificationPurposeConfirmLoginChange codes are sent to the old login
+	// of a login change, so the change can be applied right away.
+	VerificationPurposeConfirmLoginChange VerificationPurpose = "confirm_login_change"
+	// VerificationPurposeLoginChangeScheduled messages tell the old login
+	// that it is going to be replaced. They carry no code.
+	VerificationPurposeLoginChangeScheduled VerificationPurpose = "login_change_scheduled"
+)
+
+// LinkLoginPayload is the body of a request to link a login to the
+// authenticated user. AuthUserType is one of the auth user types of the
+// user's logins, required only if the user has several.
+type LinkLoginPayload struct {
+	Login        string `json:"login"`
+	AuthMethod   string `json:"auth_method"`
+	AuthUserType string `json:"auth_user_type,omitempty"`
+}
+
+// VerifyLinkLoginPayload is the body of a request to finish linking a login
+// with the verification code that was sent to it.
+type VerifyLinkLoginPayload struct {
+	Login        string `json:"login"`
+	AuthMethod   string `json:"auth_method"`
+	AuthUserType string `json:"auth_user_type,omitempty"`
+	Code         string `json:"code"`
+}
+
+// ValidateLinkAuthMethod checks that logins of an auth method may be linked
+// or changed to: the method is one of authMethods, when some are configured,
+// and its logins can receive a verification code, which the subjects of
+// external identity providers can not.
+// Returns ErrValidationFailed with the problem with auth_method.
+func ValidateLinkAuthMethod(authMethod string, authMethods []string) error {
+	var problem string
+	switch {
+	case authMethod == "":
+		problem = "is required"
+	case len(authMethods) > 0 && !oneOf(authMethod, authMethods):
+		problem = "must be one of " + strings.Join(authMethods, ", ")
+	case externalAuthMethods[strings.ToLower(authMethod)]:
+		problem = "can not be verified with a code"
+	}
+
+	if problem != "" {
+		return ErrValidationFailed.WithFields(map[string]string{"auth_method": problem})
+	}
+
+	return nil
+}
+
+// LoginsFetcher is an interface for fetching all logins linked to a user.
+type LoginsFetcher interface {
+	FetchLogins(ctx context.Context, userID string) ([]Login, error)
+}
+
+// LoginOwnerFetcher is an interface for getting the user id a login is
+// linked to. Returns an empty user id if the login is not linked to anyone.
+type LoginOwnerFetcher interface {
+	GetUserIDByLogin(ctx context.Context, login, authUserType string) (string, error)
+}
+
+// LoginAvailabilityChecker is an interface for checking that a login that is
+// not linked to anyone may be used by a new owner.
+// Returns ErrLoginCoolingDown if it may not be used yet.
+type LoginAvailabilityChecker interface {
+	CheckLoginAvailable(ctx context.Context, login, authUserType string) error
+}
+
+// LoginNormalizer is an interface for bringing a login to its canonical
+// form before it is looked up or stored.
+type LoginNormalizer interface {
+	NormalizeLogin(login, authMethod string) string
+}
+
+// LoginLinker is an interface for linking and unlinking logins to users.
+type LoginLinker interface {
+	LoginNormalizer
+	LoginsFetcher
+	LoginOwnerFetcher
+	LoginAvailabilityChecker
+	LinkLogin(ctx context.Context, userID string, login Login) error
+	// UnlinkLogin removes the login of the auth user type from the user.
+	UnlinkLogin(ctx context.Context, userID, login, authUserType string) error
+}
+
+// CodeIssuer is an interface for issuing and checking verification codes.
+// The key identifies what is being verified, e.g. a user and a login.
+type CodeIssuer interface {
+	IssueCode(ctx context.Context, key string) (string, error)
+	// CheckCode checks a code and uses it up if it is right.
+	CheckCode(ctx context.Context, key, code string) (bool, error)
+	// CheckCodes checks codes keyed by key and uses them up only if they
+	// are all right.
+	CheckCodes(ctx context.Context, codes map[string]string) (bool, error)
+}
+
+// CodeSender is an interface for delivering a verification code to a login.
+type CodeSender interface {
+	SendCode(ctx context.Context, purpose VerificationPurpose, userID string, login Login, code string) error
+}
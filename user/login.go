@@ -1,6 +1,10 @@
 package user
 
-import "context"
+import (
+	"context"
+
+	"gitlab.com/route-kz/auth-api/event"
+)
 
 type CreateTokenPayload struct {
 	Login        string `json:"login"`
@@ -9,20 +13,32 @@ type CreateTokenPayload struct {
 	AuthMethod   string `json:"auth_method"`
 }
 
+// LoginCodePayload is the body of a request for an auth code sent to the
+// login, to create a token with.
+type LoginCodePayload struct {
+	Login        string `json:"login"`
+	AuthUserType string `json:"auth_user_type"`
+	AuthMethod   string `json:"auth_method"`
+}
+
 // IDFetcherCreator is an interface for getting a user id if that already
 // exists, or creating it if it does not. Stores user id -> object id.
+// created tells whether the user was created.
 type IDFetcherCreator interface {
-	GetOrCreateUserID(ctx context.Context, payload CreateTokenPayload) (string, error)
+	GetOrCreateUserID(ctx context.Context, payload CreateTokenPayload) (userID string, created bool, err error)
 }
 
-// TokenCreator is an interface for creating a token given a user id.
+// TokenCreator is an interface for creating a token given a user id. The
+// events are written along with the token and published once it is stored.
 type TokenCreator interface {
-	CreateToken(ctx context.Context, userID string) (string, error)
+	CreateToken(ctx context.Context, userID, authUserType string, events ...event.Event) (string, error)
 }
 
 // IDFetcherTokenCreator is an interface for getting or creating a user id
-// and creating a token for the user.
+// and creating a token for the user. Logins are normalized to check their
+// auth codes.
 type IDFetcherTokenCreator interface {
+	LoginNormalizer
 	IDFetcherCreator
 	TokenCreator
 }
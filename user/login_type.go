@@ -0,0 +1,84 @@
+package user
+
+import (
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// LoginType is the kind of identifier a login is.
+type LoginType string
+
+const (
+	LoginTypePhone    LoginType = "phone"
+	LoginTypeEmail    LoginType = "email"
+	LoginTypeUsername LoginType = "username"
+	// LoginTypeExternal is a subject issued by an external identity
+	// provider (Google, Apple, ...).
+	LoginTypeExternal LoginType = "external"
+)
+
+// externalAuthMethods are the auth methods whose logins are subjects of an
+// external identity provider rather than something the user typed in.
+var externalAuthMethods = map[string]bool{
+	"apple":    true,
+	"facebook": true,
+	"google":   true,
+	"oauth":    true,
+	"oidc":     true,
+	"telegram": true,
+}
+
+// Login is one identifier linked to a user.
+type Login struct {
+	Login        string     `json:"login"`
+	Type         LoginType  `json:"type"`
+	AuthUserType string     `json:"auth_user_type"`
+	AuthMethod   string     `json:"auth_method"`
+	Verified     bool       `json:"verified"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+}
+
+// ClassifyLogin figures out the type of a login from its format and the
+// auth method it was used with.
+func ClassifyLogin(login, authMethod string) LoginType {
+	if externalAuthMethods[strings.ToLower(authMethod)] {
+		return LoginTypeExternal
+	}
+
+	if isPhoneNumber(login) {
+		return LoginTypePhone
+	}
+
+	if isEmail(login) {
+		return LoginTypeEmail
+	}
+
+	return LoginTypeUsername
+}
+
+func isPhoneNumber(login string) bool {
+	digits := 0
+	for i, r := range login {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '+' && i == 0:
+		case r == ' ' || r == '-' || r == '(' || r == ')':
+		default:
+			return false
+		}
+	}
+
+	return digits >= 7 && digits <= 15
+}
+
+func isEmail(login string) bool {
+	address, err := mail.ParseAddress(login)
+	if err != nil || address.Address != login {
+		return false
+	}
+
+	at := strings.LastIndex(login, "@")
+	return strings.Contains(login[at+1:], ".")
+}
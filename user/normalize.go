@@ -0,0 +1,53 @@
+package user
+
+import (
+	"strings"
+
+	"github.com/nyaruka/phonenumbers"
+	"golang.org/x/net/idna"
+)
+
+// Normalizer brings logins to a canonical form, so that the same phone
+// number or email typed in different ways is the same login.
+type Normalizer struct {
+	// DefaultRegion is the region of phone numbers given without a country
+	// code, e.g. "KZ".
+	DefaultRegion string
+}
+
+// Normalize returns the canonical form of a login: phone numbers in E.164,
+// emails in lowercase with an ASCII (punycode) domain. Other logins are
+// only trimmed.
+func (n Normalizer) Normalize(login, authMethod string) string {
+	login = strings.TrimSpace(login)
+
+	switch ClassifyLogin(login, authMethod) {
+	case LoginTypePhone:
+		return n.normalizePhone(login)
+	case LoginTypeEmail:
+		return normalizeEmail(login)
+	}
+
+	return login
+}
+
+func (n Normalizer) normalizePhone(login string) string {
+	number, err := phonenumbers.Parse(login, n.DefaultRegion)
+	if err != nil || !phonenumbers.IsPossibleNumber(number) {
+		return login
+	}
+
+	return phonenumbers.Format(number, phonenumbers.E164)
+}
+
+func normalizeEmail(login string) string {
+	at := strings.LastIndex(login, "@")
+	local, domain := login[:at], login[at+1:]
+
+	ascii, err := idna.Lookup.ToASCII(domain)
+	if err != nil {
+		ascii = domain
+	}
+
+	return strings.ToLower(local) + "@" + strings.ToLower(ascii)
+}
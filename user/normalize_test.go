@@ -0,0 +1,59 @@
+package user
+
+import "testing"
+
+func TestClassifyLogin(t *testing.T) {
+	tests := []struct {
+		login      string
+		authMethod string
+		want       LoginType
+	}{
+		{"+7 701 123-45-67", "sms", LoginTypePhone},
+		{"87011234567", "", LoginTypePhone},
+		{"(701) 123 4567", "", LoginTypePhone},
+		{"123456", "", LoginTypeUsername},
+		{"1234567890123456", "", LoginTypeUsername},
+		{"7+011234567", "", LoginTypeUsername},
+		{"jane@example.com", "email", LoginTypeEmail},
+		{"Jane Doe <jane@example.com>", "", LoginTypeUsername},
+		{"jane@localhost", "", LoginTypeUsername},
+		{"jane", "password", LoginTypeUsername},
+		{"jane@example.com", "Google", LoginTypeExternal},
+		{"1234567890", "telegram", LoginTypeExternal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.login+"/"+tt.authMethod, func(t *testing.T) {
+			if got := ClassifyLogin(tt.login, tt.authMethod); got != tt.want {
+				t.Errorf("ClassifyLogin(%q, %q) = %q, want %q", tt.login, tt.authMethod, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizerNormalize(t *testing.T) {
+	n := Normalizer{DefaultRegion: "KZ"}
+
+	tests := []struct {
+		login      string
+		authMethod string
+		want       string
+	}{
+		{"+7 701 123-45-67", "sms", "+77011234567"},
+		{"8 (701) 123 45 67", "sms", "+77011234567"},
+		{"7011234567", "", "+77011234567"},
+		{"+1 (202) 555-0143", "", "+12025550143"},
+		{" Jane.Doe@Example.COM ", "email", "jane.doe@example.com"},
+		{"jane@bücher.example", "", "jane@xn--bcher-kva.example"},
+		{" Jane ", "password", "Jane"},
+		{"Jane@Example.com", "google", "Jane@Example.com"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.login, func(t *testing.T) {
+			if got := n.Normalize(tt.login, tt.authMethod); got != tt.want {
+				t.Errorf("Normalize(%q, %q) = %q, want %q", tt.login, tt.authMethod, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package user
+
+import (
+	"context"
+	"fmt"
+
+	"gitlab.com/route-kz/auth-api/event"
+)
+
+// LoginCodeKey returns the key of the auth code sent to a normalized login
+// to create a token for the auth user type with.
+func LoginCodeKey(authUserType, login string) string {
+	return fmt.Sprintf("%s:%s:%s", VerificationPurposeLogin, authUserType, login)
+}
+
+// CheckAuthCode checks the auth code of a validated payload against the code
+// sent to its login, using it up if it is right. Returns ErrInvalidCode if
+// it is wrong or expired.
+func CheckAuthCode(ctx context.Context, db LoginNormalizer, codes CodeIssuer, payload CreateTokenPayload) error {
+	if payload.AuthCode == "" {
+		return ErrValidationFailed.WithFields(map[string]string{"auth_code": "is required"})
+	}
+
+	login := db.NormalizeLogin(payload.Login, payload.AuthMethod)
+
+	ok, err := codes.CheckCode(ctx, LoginCodeKey(payload.AuthUserType, login), payload.AuthCode)
+	if err != nil {
+		return fmt.Errorf("error checking auth code: %w", err)
+	}
+
+	if !ok {
+		return ErrInvalidCode
+	}
+
+	return nil
+}
+
+// IssueToken issues a token for the login of a validated payload, creating
+// the user if nobody has the login yet. The user.login and token.issued
+// events are written with the token.
+func IssueToken(ctx context.Context, db IDFetcherTokenCreator, payload CreateTokenPayload) (string, error) {
+	// Get (or create, if this is a new user) the  user id for the user
+	userID, _, err := db.GetOrCreateUserID(ctx, payload)
+	if err != nil {
+		return "", fmt.Errorf("error getting or creating a user id: %w", err)
+	}
+
+	login, err := event.New(event.TypeUserLogin, userID, event.UserLogin{
+		AuthUserType: payload.AuthUserType,
+		AuthMethod:   payload.AuthMethod,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	issued, err := event.New(event.TypeTokenIssued, userID, event.TokenIssued{
+		AuthMethod: payload.AuthMethod,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	token, err := db.CreateToken(ctx, userID, payload.AuthUserType, login, issued)
+	if err != nil {
+		return "", fmt.Errorf("error creating token: %w", err)
+	}
+
+	return token, nil
+}
+
+// RefreshToken exchanges a token for a new one, with a token.refreshed
+// event. Returns ErrInvalidToken if the token does not exist.
+func RefreshToken(ctx context.Context, db TokenRefresher, token string) (string, error) {
+	// Get the user ID and remove token, keeping the type it was issued for
+	userID, authUserType, err := db.GetUserIDRemoveToken(ctx, token)
+	if err != nil {
+		return "", fmt.Errorf("error getting user id: %w", err)
+	}
+
+	if userID == "" {
+		return "", ErrInvalidToken
+	}
+
+	refreshed, err := event.New(event.TypeTokenRefreshed, userID, event.TokenRefreshed{})
+	if err != nil {
+		return "", err
+	}
+
+	newToken, err := db.CreateToken(ctx, userID, authUserType, refreshed)
+	if err != nil {
+		return "", fmt.Errorf("error creating token: %w", err)
+	}
+
+	return newToken, nil
+}
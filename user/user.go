@@ -7,15 +7,69 @@ type IDFetcher interface {
 	GetUserID(ctx context.Context, token string) (string, error)
 }
 
-// IDFetcherTokenRemover is an interface for getting a user id using a token.
+// IDFetcherTokenRemover is an interface for removing a token, getting its
+// user id and the auth user type it was issued for.
 type IDFetcherTokenRemover interface {
-	GetUserIDRemoveToken(ctx context.Context, token string) (string, error)
+	GetUserIDRemoveToken(ctx context.Context, token string) (userID, authUserType string, err error)
 }
 
+// TokenIdentity is who a token identifies: the user, with the auth user type
+// the token was issued for, empty if unknown, and the auth user types and
+// methods of all the user's logins.
+type TokenIdentity struct {
+	UserID        string
+	AuthUserType  string
+	AuthUserTypes []string
+	AuthMethods   []string
+}
+
+// TokenIdentityFetcher is an interface for getting the identity of a token.
+// Tokens that do not exist have no identity.
+type TokenIdentityFetcher interface {
+	GetTokenIdentity(ctx context.Context, token string) (*TokenIdentity, error)
+}
+
+// PersonalData is what we know about a user. PhoneNumber and Email are the
+// user's primary (preferably verified) phone number and email, Logins are all
+// identifiers linked to the user.
 type PersonalData struct {
-	UserID      string `json:"user_id"`
-	PhoneNumber string `json:"phone_number"`
-	Email       string `json:"email"`
+	UserID        string  `json:"user_id"`
+	PhoneNumber   string  `json:"phone_number"`
+	PhoneVerified bool    `json:"phone_verified"`
+	Email         string  `json:"email"`
+	EmailVerified bool    `json:"email_verified"`
+	Username      string  `json:"username,omitempty"`
+	Logins        []Login `json:"logins"`
+}
+
+// NewPersonalData builds the personal data of a user from the logins linked
+// to it.
+func NewPersonalData(userID string, logins []Login) *PersonalData {
+	personalData := &PersonalData{
+		UserID: userID,
+		Logins: logins,
+	}
+
+	for _, login := range logins {
+		switch login.Type {
+		case LoginTypePhone:
+			if personalData.PhoneNumber == "" || (login.Verified && !personalData.PhoneVerified) {
+				personalData.PhoneNumber = login.Login
+				personalData.PhoneVerified = login.Verified
+			}
+		case LoginTypeEmail:
+			if personalData.Email == "" || (login.Verified && !personalData.EmailVerified) {
+				personalData.Email = login.Login
+				personalData.EmailVerified = login.Verified
+			}
+		case LoginTypeUsername:
+			if personalData.Username == "" {
+				personalData.Username = login.Login
+			}
+		}
+	}
+
+	return personalData
 }
 
 // PersonalDataFetcher is an interface for fetching personal data about a
@@ -23,3 +77,16 @@ type PersonalData struct {
 type PersonalDataFetcher interface {
 	FetchPersonalData(ctx context.Context, userID string) (*PersonalData, error)
 }
+
+// BatchIDFetcher is an interface for getting the user ids of many tokens at
+// once. Tokens that are not found are left out of the returned map.
+type BatchIDFetcher interface {
+	GetUserIDs(ctx context.Context, tokens []string) (map[string]string, error)
+}
+
+// BatchPersonalDataFetcher is an interface for fetching the personal data of
+// many users at once. Users that are not found are left out of the returned
+// map.
+type BatchPersonalDataFetcher interface {
+	FetchPersonalDataBatch(ctx context.Context, userIDs []string) (map[string]*PersonalData, error)
+}
@@ -0,0 +1,71 @@
+package user
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Length limits of the fields of request payloads.
+const (
+	MaxLoginLength      = 254
+	MaxAuthCodeLength   = 512
+	MaxIdentifierLength = 64
+)
+
+// Validate trims the payload and checks it against the auth user types and
+// auth methods we accept, if any are configured. Their case is kept, as
+// other services compare them as they sent them.
+// Returns ErrValidationFailed with an error per invalid field.
+func (p *CreateTokenPayload) Validate(authUserTypes, authMethods []string) error {
+	p.Login = strings.TrimSpace(p.Login)
+	p.AuthUserType = strings.TrimSpace(p.AuthUserType)
+	p.AuthMethod = strings.TrimSpace(p.AuthMethod)
+
+	fields := map[string]string{}
+
+	switch {
+	case p.Login == "":
+		fields["login"] = "is required"
+	case len(p.Login) > MaxLoginLength:
+		fields["login"] = fmt.Sprintf("must be at most %d characters", MaxLoginLength)
+	}
+
+	switch {
+	case p.AuthUserType == "":
+		fields["auth_user_type"] = "is required"
+	case len(authUserTypes) > 0 && !oneOf(p.AuthUserType, authUserTypes):
+		fields["auth_user_type"] = "must be one of " + strings.Join(authUserTypes, ", ")
+	}
+
+	if p.AuthMethod != "" && len(authMethods) > 0 && !oneOf(p.AuthMethod, authMethods) {
+		fields["auth_method"] = "must be one of " + strings.Join(authMethods, ", ")
+	}
+
+	if len(p.AuthCode) > MaxAuthCodeLength {
+		fields["auth_code"] = fmt.Sprintf("must be at most %d characters", MaxAuthCodeLength)
+	}
+
+	if len(fields) > 0 {
+		return ErrValidationFailed.WithFields(fields)
+	}
+
+	return nil
+}
+
+// Validate trims the payload and checks it like CreateTokenPayload.Validate.
+func (p *LoginCodePayload) Validate(authUserTypes, authMethods []string) error {
+	payload := CreateTokenPayload{Login: p.Login, AuthUserType: p.AuthUserType, AuthMethod: p.AuthMethod}
+	err := payload.Validate(authUserTypes, authMethods)
+
+	p.Login, p.AuthUserType, p.AuthMethod = payload.Login, payload.AuthUserType, payload.AuthMethod
+	return err
+}
+
+func oneOf(value string, allowed []string) bool {
+	for _, a := range allowed {
+		if value == a {
+			return true
+		}
+	}
+	return false
+}
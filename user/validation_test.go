@@ -0,0 +1,82 @@
+package user
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCreateTokenPayloadValidate(t *testing.T) {
+	tests := []struct {
+		name          string
+		payload       CreateTokenPayload
+		authUserTypes []string
+		authMethods   []string
+		want          CreateTokenPayload
+		wantFields    map[string]string
+	}{
+		{
+			name:    "trimmed and case kept",
+			payload: CreateTokenPayload{Login: " jane@example.com ", AuthUserType: " Driver ", AuthMethod: "SMS"},
+			want:    CreateTokenPayload{Login: "jane@example.com", AuthUserType: "Driver", AuthMethod: "SMS"},
+		},
+		{
+			name:          "allowed",
+			payload:       CreateTokenPayload{Login: "jane@example.com", AuthUserType: "client", AuthMethod: "sms"},
+			authUserTypes: []string{"client", "driver"},
+			authMethods:   []string{"sms"},
+			want:          CreateTokenPayload{Login: "jane@example.com", AuthUserType: "client", AuthMethod: "sms"},
+		},
+		{
+			name:          "not allowed",
+			payload:       CreateTokenPayload{Login: "jane@example.com", AuthUserType: "Client", AuthMethod: "email"},
+			authUserTypes: []string{"client", "driver"},
+			authMethods:   []string{"sms"},
+			wantFields: map[string]string{
+				"auth_user_type": "must be one of client, driver",
+				"auth_method":    "must be one of sms",
+			},
+		},
+		{
+			name:    "missing",
+			payload: CreateTokenPayload{Login: " "},
+			wantFields: map[string]string{
+				"login":          "is required",
+				"auth_user_type": "is required",
+			},
+		},
+		{
+			name:    "too long",
+			payload: CreateTokenPayload{Login: string(make([]byte, MaxLoginLength+1)), AuthUserType: "client", AuthCode: string(make([]byte, MaxAuthCodeLength+1))},
+			wantFields: map[string]string{
+				"login":     "must be at most 254 characters",
+				"auth_code": "must be at most 512 characters",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.payload
+			err := p.Validate(tt.authUserTypes, tt.authMethods)
+
+			if tt.wantFields == nil {
+				if err != nil {
+					t.Fatalf("Validate() error = %v", err)
+				}
+				if p != tt.want {
+					t.Errorf("Validate() payload = %+v, want %+v", p, tt.want)
+				}
+				return
+			}
+
+			var domainErr *Error
+			if !errors.As(err, &domainErr) || domainErr.Code != CodeValidationFailed {
+				t.Fatalf("Validate() error = %v, want %s", err, CodeValidationFailed)
+			}
+			if !reflect.DeepEqual(domainErr.Fields, tt.wantFields) {
+				t.Errorf("Validate() fields = %v, want %v", domainErr.Fields, tt.wantFields)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// ErrAddressNotAllowed is returned when an endpoint resolves to an address
+// webhooks are not delivered to.
+var ErrAddressNotAllowed = errors.New("webhook endpoint address not allowed")
+
+// nonPublicNetworks are the networks, besides the loopback, private,
+// link-local, multicast and unspecified addresses, that are not reachable on
+// the internet and may hold internal services.
+var nonPublicNetworks = mustParseNetworks(
+	"0.0.0.0/8",     // "this" network
+	"100.64.0.0/10", // carrier-grade NAT
+	"192.0.0.0/24",  // IETF protocol assignments
+	"198.18.0.0/15", // benchmarking
+	"240.0.0.0/4",   // reserved, and the broadcast address
+)
+
+// NewClient returns the client to deliver webhooks with. It does not follow
+// redirects, so an endpoint can not send a delivery elsewhere, and it only
+// connects to public addresses or ones in allowedNetworks. The addresses are
+// checked once the host is resolved, so host names resolving to internal
+// addresses are refused too.
+func NewClient(timeout time.Duration, allowedNetworks []*net.IPNet) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   allowedAddress(allowedNetworks),
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would be the address checked, not the endpoint.
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+// allowedAddress returns a net.Dialer Control refusing to connect to
+// addresses that are not public, unless they are in allowedNetworks.
+func allowedAddress(allowedNetworks []*net.IPNet) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrAddressNotAllowed, address)
+		}
+
+		ip := net.ParseIP(host)
+		if ip == nil {
+			return fmt.Errorf("%w: %s", ErrAddressNotAllowed, address)
+		}
+
+		for _, n := range allowedNetworks {
+			if n.Contains(ip) {
+				return nil
+			}
+		}
+
+		if !isPublic(ip) {
+			return fmt.Errorf("%w: %s", ErrAddressNotAllowed, ip)
+		}
+
+		return nil
+	}
+}
+
+// isPublic tells whether an IP is reachable on the internet.
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, n := range nonPublicNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func mustParseNetworks(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+
+	return networks
+}
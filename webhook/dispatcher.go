@@ -0,0 +1,170 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/opentracing/opentracing-go"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// maxErrorBody is how much of the body of a failed response is kept in
+	// the delivery log.
+	maxErrorBody = 512
+	// claimMargin is how much longer than it takes to send them deliveries
+	// are claimed for, to record the attempts.
+	claimMargin = time.Minute
+	// defaultSendTimeout bounds the deliveries if Client has no timeout.
+	defaultSendTimeout = time.Minute
+)
+
+// Dispatcher sends the queued deliveries to the endpoints, retrying failed
+// ones with exponential backoff.
+type Dispatcher struct {
+	Queue Queue
+	// Client sends the deliveries one after another. Its Timeout bounds how
+	// long a batch takes, which the batch is claimed for.
+	Client *http.Client
+	// BatchSize is how many deliveries are claimed at once.
+	BatchSize int
+	// MaxAttempts is how many times a delivery is tried before it fails.
+	MaxAttempts int
+	// Failed attempts are retried after RetryBase, doubling up to RetryMax.
+	RetryBase time.Duration
+	RetryMax  time.Duration
+}
+
+// Run dispatches the due deliveries every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := d.Dispatch(ctx); err != nil {
+				log.Error(err.Error())
+			}
+		}
+	}
+}
+
+// Dispatch sends one batch of due deliveries and returns how many were sent.
+func (d *Dispatcher) Dispatch(ctx context.Context) (int, error) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "DispatchWebhooks")
+	defer span.Finish()
+
+	deliveries, err := d.Queue.ClaimWebhookDeliveries(ctx, d.BatchSize, d.claimFor())
+	if err != nil {
+		return 0, err
+	}
+
+	// The pending deliveries of a subscription disabled by an attempt are
+	// cancelled, so the rest of the batch skips them.
+	disabled := make(map[int64]bool)
+	sent := 0
+
+	for _, delivery := range deliveries {
+		if disabled[delivery.SubscriptionID] {
+			continue
+		}
+
+		attempt := d.send(ctx, delivery)
+		sent++
+
+		switch {
+		case attempt.Succeeded():
+			attempt.Status = StatusDelivered
+		case delivery.Attempts+1 >= d.MaxAttempts:
+			attempt.Status = StatusFailed
+		default:
+			attempt.Status = StatusPending
+			attempt.NextAttemptAt = attempt.At.Add(d.retryDelay(delivery.Attempts))
+		}
+
+		subscriptionDisabled, err := d.Queue.RecordWebhookAttempt(ctx, delivery, attempt)
+		if err != nil {
+			return sent, err
+		}
+		if subscriptionDisabled {
+			disabled[delivery.SubscriptionID] = true
+		}
+	}
+
+	return sent, nil
+}
+
+// claimFor returns how long a batch is claimed for: long enough to send
+// every delivery of it one after another until they time out.
+func (d *Dispatcher) claimFor() time.Duration {
+	return time.Duration(d.BatchSize)*d.sendTimeout() + claimMargin
+}
+
+// sendTimeout returns how long a delivery may take.
+func (d *Dispatcher) sendTimeout() time.Duration {
+	if d.Client.Timeout <= 0 {
+		return defaultSendTimeout
+	}
+	return d.Client.Timeout
+}
+
+// send posts a delivery to its endpoint.
+func (d *Dispatcher) send(ctx context.Context, delivery Delivery) (attempt Attempt) {
+	span, ctx := opentracing.StartSpanFromContext(ctx, "SendWebhook")
+	defer span.Finish()
+	span.SetTag("webhook.subscription_id", delivery.SubscriptionID)
+	span.SetTag("event.type", delivery.EventType)
+
+	attempt.At = time.Now()
+	defer func() {
+		attempt.DurationMS = time.Since(attempt.At).Milliseconds()
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, d.sendTimeout())
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "auth-api-webhooks")
+	req.Header.Set("X-Auth-Event-Id", delivery.EventID)
+	req.Header.Set("X-Auth-Event-Type", delivery.EventType)
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, attempt.At, delivery.Payload))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		attempt.Error = err.Error()
+		return attempt
+	}
+	defer resp.Body.Close()
+
+	attempt.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		attempt.Error = fmt.Sprintf("unexpected status %d: %s", resp.StatusCode, body)
+	}
+
+	return attempt
+}
+
+// retryDelay returns how long to wait before the next attempt of a delivery
+// that failed attempts+1 times.
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := float64(d.RetryBase) * math.Pow(2, float64(attempts))
+	if delay > float64(d.RetryMax) {
+		return d.RetryMax
+	}
+	return time.Duration(delay)
+}
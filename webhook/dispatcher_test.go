@@ -0,0 +1,168 @@
+package webhook
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// memoryQueue is a Queue in memory. It disables a subscription after
+// disableAfter failed attempts in a row, if set.
+type memoryQueue struct {
+	deliveries   []Delivery
+	claimedFor   time.Duration
+	attempts     []Attempt
+	disableAfter int
+	failures     map[int64]int
+}
+
+func (q *memoryQueue) ClaimWebhookDeliveries(ctx context.Context, limit int, claimFor time.Duration) ([]Delivery, error) {
+	q.claimedFor = claimFor
+	if len(q.deliveries) > limit {
+		return q.deliveries[:limit], nil
+	}
+	return q.deliveries, nil
+}
+
+func (q *memoryQueue) RecordWebhookAttempt(ctx context.Context, d Delivery, a Attempt) (bool, error) {
+	q.attempts = append(q.attempts, a)
+
+	if a.Succeeded() {
+		q.failures[d.SubscriptionID] = 0
+		return false, nil
+	}
+
+	q.failures[d.SubscriptionID]++
+	return q.disableAfter > 0 && q.failures[d.SubscriptionID] >= q.disableAfter, nil
+}
+
+func TestDispatch(t *testing.T) {
+	const secret = "whsec_test"
+	payload := []byte(`{"id":"1","type":"user.login"}`)
+
+	tests := []struct {
+		name         string
+		status       int
+		deliveries   int
+		attempts     int
+		disableAfter int
+		wantSent     int
+		wantStatus   DeliveryStatus
+		wantRetryIn  time.Duration
+	}{
+		{name: "delivered", status: http.StatusNoContent, deliveries: 1, wantSent: 1, wantStatus: StatusDelivered},
+		{name: "retried with backoff", status: http.StatusInternalServerError, deliveries: 1, attempts: 2, wantSent: 1, wantStatus: StatusPending, wantRetryIn: 40 * time.Second},
+		{name: "retry delay capped", status: http.StatusBadGateway, deliveries: 1, attempts: 12, wantSent: 1, wantStatus: StatusPending, wantRetryIn: time.Hour},
+		{name: "out of attempts", status: http.StatusInternalServerError, deliveries: 1, attempts: 19, wantSent: 1, wantStatus: StatusFailed},
+		{name: "redirects are failures", status: http.StatusFound, deliveries: 1, wantSent: 1, wantStatus: StatusPending, wantRetryIn: 10 * time.Second},
+		{name: "disabled subscription is skipped", status: http.StatusServiceUnavailable, deliveries: 3, disableAfter: 2, wantSent: 2, wantStatus: StatusPending, wantRetryIn: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received int
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				received++
+
+				body, err := io.ReadAll(r.Body)
+				if err != nil {
+					t.Error(err)
+				}
+				if err := Verify(secret, r.Header.Get(SignatureHeader), body, time.Minute, time.Now()); err != nil {
+					t.Errorf("Verify() error = %v", err)
+				}
+				if r.Header.Get("X-Auth-Event-Type") != "user.login" {
+					t.Errorf("X-Auth-Event-Type = %q, want user.login", r.Header.Get("X-Auth-Event-Type"))
+				}
+
+				if tt.status == http.StatusFound {
+					w.Header().Set("Location", "/elsewhere")
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			queue := &memoryQueue{disableAfter: tt.disableAfter, failures: map[int64]int{}}
+			for i := 0; i < tt.deliveries; i++ {
+				queue.deliveries = append(queue.deliveries, Delivery{
+					ID:             int64(i + 1),
+					SubscriptionID: 1,
+					URL:            server.URL,
+					Secret:         secret,
+					EventID:        "1",
+					EventType:      "user.login",
+					Payload:        payload,
+					Attempts:       tt.attempts,
+				})
+			}
+
+			client := server.Client()
+			client.Timeout = 10 * time.Second
+			client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			}
+
+			d := &Dispatcher{
+				Queue:       queue,
+				Client:      client,
+				BatchSize:   50,
+				MaxAttempts: 20,
+				RetryBase:   10 * time.Second,
+				RetryMax:    time.Hour,
+			}
+
+			sent, err := d.Dispatch(context.Background())
+			if err != nil {
+				t.Fatalf("Dispatch() error = %v", err)
+			}
+			if sent != tt.wantSent || received != tt.wantSent {
+				t.Errorf("Dispatch() = %d, endpoint received %d, want %d", sent, received, tt.wantSent)
+			}
+			if want := 50*10*time.Second + claimMargin; queue.claimedFor != want {
+				t.Errorf("claimed for %s, want %s", queue.claimedFor, want)
+			}
+
+			a := queue.attempts[0]
+			if a.Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", a.Status, tt.wantStatus)
+			}
+			if a.StatusCode != tt.status {
+				t.Errorf("status code = %d, want %d", a.StatusCode, tt.status)
+			}
+			if tt.wantStatus == StatusPending {
+				if retryIn := a.NextAttemptAt.Sub(a.At); retryIn != tt.wantRetryIn {
+					t.Errorf("retry in %s, want %s", retryIn, tt.wantRetryIn)
+				}
+			} else if !a.NextAttemptAt.IsZero() {
+				t.Errorf("next attempt at %s, want none", a.NextAttemptAt)
+			}
+		})
+	}
+}
+
+func TestDispatchUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	url := server.URL
+	server.Close()
+
+	queue := &memoryQueue{
+		deliveries: []Delivery{{ID: 1, SubscriptionID: 1, URL: url, Secret: "whsec_test", Payload: []byte(`{}`)}},
+		failures:   map[int64]int{},
+	}
+	d := &Dispatcher{Queue: queue, Client: &http.Client{}, BatchSize: 10, MaxAttempts: 3, RetryBase: time.Second, RetryMax: time.Minute}
+
+	if _, err := d.Dispatch(context.Background()); err != nil {
+		t.Fatalf("Dispatch() error = %v", err)
+	}
+
+	a := queue.attempts[0]
+	if a.StatusCode != 0 || a.Error == "" || a.Status != StatusPending {
+		t.Errorf("attempt = %+v, want a pending one with an error and no status code", a)
+	}
+	if want := 10*defaultSendTimeout + claimMargin; queue.claimedFor != want {
+		t.Errorf("claimed for %s without a client timeout, want %s", queue.claimedFor, want)
+	}
+}
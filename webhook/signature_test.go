@@ -0,0 +1,52 @@
+package webhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestVerify(t *testing.T) {
+	const secret = "whsec_test"
+	body := []byte(`{"id":"1","type":"user.login"}`)
+	sentAt := time.Unix(1_700_000_000, 0)
+	header := Sign(secret, sentAt, body)
+
+	tests := []struct {
+		name    string
+		secret  string
+		header  string
+		body    []byte
+		now     time.Time
+		wantErr error
+	}{
+		{"valid", secret, header, body, sentAt.Add(time.Minute), nil},
+		{"clock behind the sender", secret, header, body, sentAt.Add(-time.Minute), nil},
+		{"one of several signatures", secret, header + ",v1=00ff", body, sentAt, nil},
+		{"other secret", "whsec_other", header, body, sentAt, ErrInvalidSignature},
+		{"tampered body", secret, header, []byte(`{"id":"2","type":"user.login"}`), sentAt, ErrInvalidSignature},
+		{"too old", secret, header, body, sentAt.Add(6 * time.Minute), ErrExpiredSignature},
+		{"from the future", secret, header, body, sentAt.Add(-6 * time.Minute), ErrExpiredSignature},
+		{"no timestamp", secret, "v1=" + signature(secret, "1700000000", body), body, sentAt, ErrInvalidSignature},
+		{"no signature", secret, "t=1700000000", body, sentAt, ErrInvalidSignature},
+		{"empty", secret, "", body, sentAt, ErrInvalidSignature},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Verify(tt.secret, tt.header, tt.body, 5*time.Minute, tt.now)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSign(t *testing.T) {
+	got := Sign("secret", time.Unix(1_700_000_000, 0), []byte("body"))
+	// echo -n "1700000000.body" | openssl dgst -sha256 -hmac secret
+	want := "t=1700000000,v1=42ac6f0448c1d9c3e1e82b9726248f58fef84afffcbad5188246e96070e0ea46"
+	if got != want {
+		t.Errorf("Sign() = %q, want %q", got, want)
+	}
+}
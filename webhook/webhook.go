@@ -0,0 +1,184 @@
+// Package webhook delivers events to the HTTP endpoints of partners that
+// subscribed to them, signed with a secret of the subscription.
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"gitlab.com/route-kz/auth-api/event"
+	"gitlab.com/route-kz/auth-api/user"
+)
+
+// ErrNotFound is returned for subscriptions that do not exist.
+var ErrNotFound = &user.Error{Code: user.CodeNotFound, Message: "webhook not found"}
+
+// Subscription is an endpoint receiving the events of some types.
+type Subscription struct {
+	ID         int64     `json:"id"`
+	Name       string    `json:"name"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+	// Secret is the key of the signatures. It is only shown when the
+	// subscription is created.
+	Secret              string     `json:"secret,omitempty"`
+	ConsecutiveFailures int        `json:"consecutive_failures"`
+	DisabledAt          *time.Time `json:"disabled_at,omitempty"`
+	DisabledReason      string     `json:"disabled_reason,omitempty"`
+}
+
+// DeliveryStatus is the status of the delivery of an event to a
+// subscription.
+type DeliveryStatus string
+
+const (
+	StatusPending   DeliveryStatus = "pending"
+	StatusDelivered DeliveryStatus = "delivered"
+	// StatusFailed is the status of deliveries out of attempts.
+	StatusFailed DeliveryStatus = "failed"
+	// StatusCancelled is the status of the pending deliveries of a
+	// subscription when it is disabled.
+	StatusCancelled DeliveryStatus = "cancelled"
+)
+
+// Delivery is an event to deliver to a subscription.
+type Delivery struct {
+	ID             int64
+	SubscriptionID int64
+	URL            string
+	Secret         string
+	EventID        string
+	EventType      string
+	Payload        []byte
+	// Attempts is the number of attempts made before.
+	Attempts int
+}
+
+// Attempt is an attempt to deliver an event, and what to do next.
+type Attempt struct {
+	StatusCode int       `json:"status_code"`
+	Error      string    `json:"error,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	At         time.Time `json:"attempted_at"`
+	// Status is the status of the delivery after the attempt, and
+	// NextAttemptAt when to try again if it is pending.
+	Status        DeliveryStatus `json:"-"`
+	NextAttemptAt time.Time      `json:"-"`
+}
+
+// Succeeded tells whether the endpoint accepted the event.
+func (a Attempt) Succeeded() bool {
+	return a.Error == "" && a.StatusCode >= 200 && a.StatusCode < 300
+}
+
+// DeliveryLog is a delivery with its attempts, as shown to admins.
+type DeliveryLog struct {
+	ID            int64          `json:"id"`
+	EventID       string         `json:"event_id"`
+	EventType     string         `json:"event_type"`
+	Status        DeliveryStatus `json:"status"`
+	CreatedAt     time.Time      `json:"created_at"`
+	DeliveredAt   *time.Time     `json:"delivered_at,omitempty"`
+	NextAttemptAt *time.Time     `json:"next_attempt_at,omitempty"`
+	Attempts      []Attempt      `json:"attempts"`
+}
+
+// Manager is an interface for managing subscriptions. The methods taking an
+// id return ErrNotFound for unknown subscriptions.
+type Manager interface {
+	CreateWebhook(ctx context.Context, s Subscription) (*Subscription, error)
+	Webhooks(ctx context.Context) ([]Subscription, error)
+	Webhook(ctx context.Context, id int64) (*Subscription, error)
+	DeleteWebhook(ctx context.Context, id int64) error
+	EnableWebhook(ctx context.Context, id int64) error
+	WebhookDeliveries(ctx context.Context, id int64, limit int) ([]DeliveryLog, error)
+}
+
+// Queue is an interface for the queue of deliveries.
+type Queue interface {
+	// ClaimWebhookDeliveries returns up to limit due deliveries, which other
+	// dispatchers don't get for claimFor.
+	ClaimWebhookDeliveries(ctx context.Context, limit int, claimFor time.Duration) ([]Delivery, error)
+	// RecordWebhookAttempt logs an attempt, updates the delivery and
+	// disables the subscription if it keeps failing, which it returns.
+	RecordWebhookAttempt(ctx context.Context, d Delivery, a Attempt) (bool, error)
+}
+
+// GenerateSecret generates a new random secret for a subscription.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %w", err)
+	}
+
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// eventTypes are the event types subscriptions may subscribe to.
+var eventTypes = []event.Type{
+	event.TypeUserCreated,
+	event.TypeUserLogin,
+	event.TypeUserBlocked,
+	event.TypeTokenIssued,
+	event.TypeTokenRefreshed,
+	event.TypeTokenRevoked,
+}
+
+// CreatePayload is the body of a request to create a subscription.
+type CreatePayload struct {
+	Name       string   `json:"name"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+// Validate checks that the subscription has a name, an absolute HTTPS URL
+// (HTTP too if allowInsecure) and known event types.
+func (p *CreatePayload) Validate(allowInsecure bool) error {
+	fields := make(map[string]string)
+
+	p.Name = strings.TrimSpace(p.Name)
+	if p.Name == "" {
+		fields["name"] = "is required"
+	}
+
+	u, err := url.Parse(p.URL)
+	switch {
+	case p.URL == "":
+		fields["url"] = "is required"
+	case err != nil || u.Host == "":
+		fields["url"] = "must be an absolute URL"
+	case u.Scheme != "https" && !(allowInsecure && u.Scheme == "http"):
+		fields["url"] = "must be an https URL"
+	}
+
+	if len(p.EventTypes) == 0 {
+		fields["event_types"] = "is required"
+	}
+	for _, t := range p.EventTypes {
+		if !knownEventType(t) {
+			fields["event_types"] = fmt.Sprintf("%q is not an event type", t)
+			break
+		}
+	}
+
+	if len(fields) > 0 {
+		return user.ErrValidationFailed.WithFields(fields)
+	}
+
+	return nil
+}
+
+func knownEventType(t string) bool {
+	for _, known := range eventTypes {
+		if string(known) == t {
+			return true
+		}
+	}
+	return false
+}